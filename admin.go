@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// TableInfo is what operators want to know about a table, gathered from
+// DescribeTable, DescribeTimeToLive and DescribeContinuousBackups. Item
+// counts and sizes are the ones DynamoDB refreshes about every six hours.
+type TableInfo struct {
+	Name        string      `json:"name"`
+	Status      string      `json:"status"`
+	CreatedAt   *time.Time  `json:"createdAt,omitempty"`
+	ItemCount   int64       `json:"itemCount"`
+	SizeBytes   int64       `json:"sizeBytes"`
+	BillingMode string      `json:"billingMode"`
+	Throughput  *Throughput `json:"provisionedThroughput,omitempty"`
+	Indexes     []IndexInfo `json:"indexes,omitempty"`
+	// StreamViewType is empty if the table has no stream.
+	StreamViewType string `json:"streamViewType,omitempty"`
+	// Replicas are the regions of the replicas of a global table.
+	Replicas           []string `json:"replicas,omitempty"`
+	DeletionProtection bool     `json:"deletionProtection"`
+	// TTLStatus is ENABLED, DISABLED or in transition between the two.
+	TTLStatus    string `json:"ttlStatus"`
+	TTLAttribute string `json:"ttlAttribute,omitempty"`
+	// ContinuousBackups is ENABLED or DISABLED. PointInTimeRecovery is
+	// ENABLED if the table can be restored to any second within
+	// EarliestRestore to LatestRestore.
+	ContinuousBackups   string     `json:"continuousBackups"`
+	PointInTimeRecovery string     `json:"pointInTimeRecovery"`
+	EarliestRestore     *time.Time `json:"earliestRestore,omitempty"`
+	LatestRestore       *time.Time `json:"latestRestore,omitempty"`
+}
+
+// IndexInfo describes a global secondary index.
+type IndexInfo struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	ItemCount int64  `json:"itemCount"`
+	SizeBytes int64  `json:"sizeBytes"`
+	// Backfilling is set while DynamoDB populates a new index.
+	Backfilling bool        `json:"backfilling,omitempty"`
+	Throughput  *Throughput `json:"provisionedThroughput,omitempty"`
+}
+
+// Throughput is the provisioned capacity of a table or index.
+type Throughput struct {
+	ReadCapacityUnits  int64 `json:"readCapacityUnits"`
+	WriteCapacityUnits int64 `json:"writeCapacityUnits"`
+}
+
+// DescribeBookTable returns the TableInfo of tableName. It needs the
+// dynamodb:DescribeTable, DescribeTimeToLive and DescribeContinuousBackups
+// permissions.
+func DescribeBookTable(ctx context.Context, client *dynamodb.Client, tableName string) (*TableInfo, error) {
+	desc, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return nil, fmt.Errorf("describe table %s: %w", tableName, translateError(err))
+	}
+	table := desc.Table
+	if table == nil {
+		// Dry runs answer every call with an empty output.
+		return nil, fmt.Errorf("describe table %s: no table description returned", tableName)
+	}
+	info := &TableInfo{
+		Name:               aws.ToString(table.TableName),
+		Status:             string(table.TableStatus),
+		CreatedAt:          table.CreationDateTime,
+		ItemCount:          aws.ToInt64(table.ItemCount),
+		SizeBytes:          aws.ToInt64(table.TableSizeBytes),
+		BillingMode:        string(types.BillingModeProvisioned),
+		Throughput:         throughput(table.ProvisionedThroughput),
+		DeletionProtection: aws.ToBool(table.DeletionProtectionEnabled),
+	}
+	// Tables created with provisioned capacity may have no summary.
+	if table.BillingModeSummary != nil && table.BillingModeSummary.BillingMode != "" {
+		info.BillingMode = string(table.BillingModeSummary.BillingMode)
+	}
+	if info.BillingMode == string(types.BillingModePayPerRequest) {
+		info.Throughput = nil
+	}
+	for _, gsi := range table.GlobalSecondaryIndexes {
+		index := IndexInfo{
+			Name:        aws.ToString(gsi.IndexName),
+			Status:      string(gsi.IndexStatus),
+			ItemCount:   aws.ToInt64(gsi.ItemCount),
+			SizeBytes:   aws.ToInt64(gsi.IndexSizeBytes),
+			Backfilling: aws.ToBool(gsi.Backfilling),
+		}
+		if info.Throughput != nil {
+			index.Throughput = throughput(gsi.ProvisionedThroughput)
+		}
+		info.Indexes = append(info.Indexes, index)
+	}
+	if spec := table.StreamSpecification; spec != nil && aws.ToBool(spec.StreamEnabled) {
+		info.StreamViewType = string(spec.StreamViewType)
+	}
+	for _, replica := range table.Replicas {
+		info.Replicas = append(info.Replicas, aws.ToString(replica.RegionName))
+	}
+
+	ttl, err := client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return nil, fmt.Errorf("describe ttl of %s: %w", tableName, translateError(err))
+	}
+	if d := ttl.TimeToLiveDescription; d != nil {
+		info.TTLStatus = string(d.TimeToLiveStatus)
+		info.TTLAttribute = aws.ToString(d.AttributeName)
+	}
+
+	backups, err := client.DescribeContinuousBackups(ctx, &dynamodb.DescribeContinuousBackupsInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return nil, fmt.Errorf("describe continuous backups of %s: %w", tableName, translateError(err))
+	}
+	if d := backups.ContinuousBackupsDescription; d != nil {
+		info.ContinuousBackups = string(d.ContinuousBackupsStatus)
+		if pitr := d.PointInTimeRecoveryDescription; pitr != nil {
+			info.PointInTimeRecovery = string(pitr.PointInTimeRecoveryStatus)
+			info.EarliestRestore = pitr.EarliestRestorableDateTime
+			info.LatestRestore = pitr.LatestRestorableDateTime
+		}
+	}
+	return info, nil
+}
+
+func throughput(p *types.ProvisionedThroughputDescription) *Throughput {
+	if p == nil {
+		return nil
+	}
+	return &Throughput{ReadCapacityUnits: aws.ToInt64(p.ReadCapacityUnits), WriteCapacityUnits: aws.ToInt64(p.WriteCapacityUnits)}
+}
+
+// TableDescriber returns the TableInfo of the book table.
+type TableDescriber func(ctx context.Context) (*TableInfo, error)
+
+// AdminHandler serves the admin API:
+//
+//	GET /admin/table  the TableInfo of the book table
+//
+// It exposes details of the table's configuration, so it should only be
+// reachable by operators.
+func AdminHandler(describe TableDescriber) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/table" {
+			writeError(w, http.StatusNotFound, "not_found", "not found")
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		info, err := describe(r.Context())
+		if err != nil {
+			writeRepositoryError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// BookAge is a book and how long ago it was created.
+type BookAge struct {
+	Book *Book
+	// Age is the time since Book.CreatedAt. It is 0 unless AgeKnown.
+	Age time.Duration
+	// AgeKnown is false for books without a CreatedAt, which were stored
+	// before it existed and have not been backfilled.
+	AgeKnown bool
+}
+
+// GetByIdWithAge is GetById returning the age of the book too. DynamoDB
+// keeps no write time of items, so the age is computed from CreatedAt; see
+// BackfillCreatedAt for books stored without one.
+func (d *DynamoDbBookRepository) GetByIdWithAge(ctx context.Context, id int) (BookAge, error) {
+	book, err := d.GetById(ctx, id)
+	if err != nil {
+		return BookAge{}, err
+	}
+	return bookAge(book, d.now()), nil
+}
+
+// bookAge returns the age of book at now.
+func bookAge(book *Book, now time.Time) BookAge {
+	if book.CreatedAt.IsZero() {
+		return BookAge{Book: book}
+	}
+	return BookAge{Book: book, Age: max(now.Sub(book.CreatedAt), 0), AgeKnown: true}
+}
+
+// BackfillCreatedAt sets the CreatedAt of the books that have none to the
+// time returned by at, kept to the second. Books for which at returns false
+// are left without one. It is best-effort: the true creation times are
+// lost, so at can only estimate them, e.g. by the creation time of the
+// table, which no book predates.
+func (d *DynamoDbBookRepository) BackfillCreatedAt(ctx context.Context, at func(*Book) (time.Time, bool)) error {
+	unknown := &types.AttributeValueMemberN{Value: createdAtKey(time.Time{})}
+	return d.backfill(ctx, createdAtAttribute, unknown, func(book *Book) (any, bool) {
+		t, ok := at(book)
+		if !ok || t.IsZero() {
+			return nil, false
+		}
+		// As stored by bookCodec: Unix seconds.
+		return t.Unix(), true
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+func TestGetByIdWithAgeAndBackfillCreatedAt(t *testing.T) {
+	ctx := context.Background()
+	repo := newTableStub(t).repository()
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	repo.now = func() time.Time { return now }
+	created := now.Add(-36 * time.Hour)
+
+	if err := repo.Create(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", CreatedAt: created}); err != nil {
+		t.Fatal(err)
+	}
+	// Book 2 was stored before CreatedAt existed, book 3 without one since.
+	var codec bookCodec
+	old, err := codec.marshal(&Book{Id: 2, Name: "Emma", Author: "Jane Austen", Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(old, createdAtAttribute)
+	if _, err := repo.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(stubTable), Item: old}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Create(ctx, &Book{Id: 3, Name: "Ulysses", Author: "James Joyce"}); err != nil {
+		t.Fatal(err)
+	}
+
+	wantAge := func(id int, want time.Duration, known bool) {
+		t.Helper()
+		got, err := repo.GetByIdWithAge(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Book.Id != id || got.Age != want || got.AgeKnown != known {
+			t.Errorf("book %d: age %s, known %t, want %s, %t", id, got.Age, got.AgeKnown, want, known)
+		}
+	}
+	wantAge(1, 36*time.Hour, true)
+	wantAge(2, 0, false)
+	wantAge(3, 0, false)
+
+	tableCreated := now.Add(-30 * 24 * time.Hour)
+	var backfilled []int
+	err = repo.BackfillCreatedAt(ctx, func(b *Book) (time.Time, bool) {
+		backfilled = append(backfilled, b.Id)
+		return tableCreated, true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backfilled) != 2 {
+		t.Errorf("backfilled books %v, want 2 and 3", backfilled)
+	}
+	wantAge(1, 36*time.Hour, true)
+	wantAge(2, 30*24*time.Hour, true)
+	wantAge(3, 30*24*time.Hour, true)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// auditSeqAttribute is the sort key of the audit table, which is keyed by
+// book id: the time of the change, then a suffix unique to the change.
+const auditSeqAttribute = "seq"
+
+// auditTimeLayout formats the time in audit sort keys. It has a fixed width
+// so that the keys of a book sort chronologically.
+const auditTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// AuditRecord is an immutable entry of the audit log: one change of a book.
+type AuditRecord struct {
+	BookId int    `json:"bookId" dynamodbav:"id"`
+	Seq    string `json:"-" dynamodbav:"seq"`
+	// Time is when the change was made; for records written from the
+	// stream, to the second.
+	Time time.Time `json:"time" dynamodbav:"time"`
+	// Actor is who made the change, as given WithActor, or empty if unknown.
+	Actor  string     `json:"actor,omitempty" dynamodbav:"actor,omitempty"`
+	Action ChangeType `json:"action" dynamodbav:"action"`
+	// Before is the book before the change, nil for inserts. After is the
+	// book after it, nil for removals.
+	Before *Book `json:"before,omitempty" dynamodbav:"before,omitempty"`
+	After  *Book `json:"after,omitempty" dynamodbav:"after,omitempty"`
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose writes are audited as made by actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set with WithActor, if any.
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	return actor, ok && actor != ""
+}
+
+// auditTableName returns the name of the table holding the audit log of the
+// books in bookTable.
+func auditTableName(bookTable string) string {
+	return bookTable + "-audit"
+}
+
+// AuditLog reads and writes the audit log of a book table. Records are
+// written either along with each write by a RecordingRepository, or after
+// the fact by the AuditLog itself as the ChangeHandler of the table's stream.
+type AuditLog struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// NewAuditLog returns the audit log of the table of books.
+func NewAuditLog(books *DynamoDbBookRepository) *AuditLog {
+	return &AuditLog{client: books.client, table: auditTableName(books.tableName)}
+}
+
+// History returns up to limit changes of the book starting after cursor,
+// oldest first, and the cursor of the next page, which is empty after the
+// last one.
+func (l *AuditLog) History(ctx context.Context, bookID, limit int, cursor string) ([]*AuditRecord, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("%w: limit must be positive", ErrValidation)
+	}
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if startKey != nil {
+		// A cursor of another book's history would skip to an arbitrary
+		// point of this one.
+		if id, err := NumberKey(idAttribute).UnmarshalKey(startKey); err != nil || id != bookID {
+			return nil, "", fmt.Errorf("%w: cursor belongs to another book", ErrValidation)
+		}
+	}
+	result, err := l.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                aws.String(l.table),
+		KeyConditionExpression:   aws.String("#id = :id"),
+		ExpressionAttributeNames: map[string]string{"#id": idAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberN{Value: strconv.Itoa(bookID)},
+		},
+		ExclusiveStartKey: startKey,
+		Limit:             aws.Int32(int32(limit)),
+		ConsistentRead:    consistentRead(ctx, false),
+	})
+	if err != nil {
+		return nil, "", translateError(err)
+	}
+	records := []*AuditRecord{}
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &records); err != nil {
+		return nil, "", err
+	}
+	next, err := encodeCursor(result.LastEvaluatedKey)
+	return records, next, err
+}
+
+// HandleChange implements ChangeHandler, recording change in the log. It is
+// meant for the StreamDispatcher of the table's stream, whose records carry
+// both images; changes reported by a BookUseCase have no Before. Stream
+// records have no actor, and redelivered ones are recorded once.
+func (l *AuditLog) HandleChange(ctx context.Context, change BookChange) error {
+	at := change.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	suffix := change.SequenceNumber
+	if suffix == "" {
+		var err error
+		if suffix, err = randomSuffix(); err != nil {
+			return err
+		}
+	}
+	record := &AuditRecord{BookId: change.Id, Action: change.Type, Before: change.Old, After: change.New}
+	put, err := l.put(ctx, record, at, suffix)
+	if err != nil {
+		return err
+	}
+	_, err = l.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                put.TableName,
+		Item:                     put.Item,
+		ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]string{"#id": idAttribute},
+	})
+	err = translateError(err)
+	if isConflict(err) {
+		return nil // already recorded by an earlier delivery
+	}
+	return err
+}
+
+// RecordChange implements ChangeRecorder, so that a RecordingRepository
+// writes the audit record of every change in the transaction of the change.
+func (l *AuditLog) RecordChange(ctx context.Context, change BookChange) (*types.Put, error) {
+	suffix, err := randomSuffix()
+	if err != nil {
+		return nil, err
+	}
+	record := &AuditRecord{BookId: change.Id, Action: change.Type, Before: change.Old, After: change.New}
+	return l.put(ctx, record, change.Time, suffix)
+}
+
+// put completes record with the time, actor and sort key of the change and
+// returns the write of it.
+func (l *AuditLog) put(ctx context.Context, record *AuditRecord, at time.Time, suffix string) (*types.Put, error) {
+	record.Time = at.UTC()
+	record.Seq = record.Time.Format(auditTimeLayout) + "#" + suffix
+	record.Actor, _ = ActorFromContext(ctx)
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Put{TableName: aws.String(l.table), Item: item}, nil
+}
+
+// randomSuffix returns a sort key suffix for changes without a stream
+// sequence number.
+func randomSuffix() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate audit record id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// auditTableDefinition describes the audit table of bookTable: records are
+// keyed by book id and ordered by seq.
+func auditTableDefinition(bookTable string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(auditTableName(bookTable)),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(idAttribute), AttributeType: types.ScalarAttributeTypeN},
+			{AttributeName: aws.String(auditSeqAttribute), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(idAttribute), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(auditSeqAttribute), KeyType: types.KeyTypeRange},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
+
+// MigrateAudit creates the audit table of bookTable if needed. It is safe
+// to run repeatedly.
+func MigrateAudit(ctx context.Context, client *dynamodb.Client, bookTable string) error {
+	return migrateTable(ctx, client, auditTableDefinition(bookTable))
+}
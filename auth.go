@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// jwtLeeway is the clock skew tolerated when checking the expiry and
+// not-before times of a token.
+const jwtLeeway = 30 * time.Second
+
+// RoleLibrarian is the role DefaultAccessPolicy requires for deleting books.
+const RoleLibrarian = "librarian"
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Subject identifies the caller; writes are audited as made by it.
+	Subject string
+	Roles   []string
+}
+
+// HasRole reports whether p has any of roles.
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if slices.Contains(p.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context acting for p. Its writes are audited as
+// made by p's subject.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return WithActor(context.WithValue(ctx, principalKey{}, p), p.Subject)
+}
+
+// PrincipalFromContext returns the principal set with WithPrincipal, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// Authenticator turns the bearer token of a request into its principal.
+// Tokens it rejects fail with an error matching ErrUnauthenticated.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+// JWTAuthenticator validates JSON Web Tokens signed with HS256 by a shared
+// secret, such as those of an identity provider configured with a client
+// secret. The sub claim becomes the principal's subject and the roles claim,
+// or the cognito:groups claim of Cognito user pool tokens, its roles. Tokens
+// must carry an exp claim.
+type JWTAuthenticator struct {
+	secret []byte
+	// issuer and audience, if set, must match the iss and aud claims.
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// NewJWTAuthenticator returns an authenticator of tokens signed with
+// secret. A non-empty issuer or audience must match the token's claims.
+func NewJWTAuthenticator(secret []byte, issuer, audience string) *JWTAuthenticator {
+	return &JWTAuthenticator{secret: secret, issuer: issuer, audience: audience, now: time.Now}
+}
+
+// jwtClaims are the claims JWTAuthenticator reads.
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *int64      `json:"exp"`
+	NotBefore *int64      `json:"nbf"`
+	Roles     []string    `json:"roles"`
+	Groups    []string    `json:"cognito:groups"`
+}
+
+// jwtAudience is the aud claim, a single string or an array of them.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = jwtAudience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Authenticate implements Authenticator.
+func (j *JWTAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	// The algorithm is fixed, so tokens cannot downgrade it, e.g. to none.
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported token algorithm %q", ErrUnauthenticated, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token signature", ErrUnauthenticated)
+	}
+	mac := hmac.New(sha256.New, j.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("%w: invalid token signature", ErrUnauthenticated)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := j.now()
+	switch {
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+	case claims.ExpiresAt == nil:
+		return nil, fmt.Errorf("%w: token has no expiry", ErrUnauthenticated)
+	case now.After(time.Unix(*claims.ExpiresAt, 0).Add(jwtLeeway)):
+		return nil, fmt.Errorf("%w: token expired", ErrUnauthenticated)
+	case claims.NotBefore != nil && now.Before(time.Unix(*claims.NotBefore, 0).Add(-jwtLeeway)):
+		return nil, fmt.Errorf("%w: token not valid yet", ErrUnauthenticated)
+	case j.issuer != "" && claims.Issuer != j.issuer:
+		return nil, fmt.Errorf("%w: token issuer %q not accepted", ErrUnauthenticated, claims.Issuer)
+	case j.audience != "" && !slices.Contains(claims.Audience, j.audience):
+		return nil, fmt.Errorf("%w: token not meant for this audience", ErrUnauthenticated)
+	}
+	return &Principal{Subject: claims.Subject, Roles: append(claims.Roles, claims.Groups...)}, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	return nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer <token>"
+// header value.
+func bearerToken(authorization string) (string, bool) {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// authenticate returns ctx acting for the principal of the authorization
+// header value.
+func authenticate(ctx context.Context, auth Authenticator, authorization string) (context.Context, error) {
+	token, ok := bearerToken(authorization)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing bearer token", ErrUnauthenticated)
+	}
+	p, err := auth.Authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return WithPrincipal(ctx, p), nil
+}
+
+// Authenticate returns a handler that authenticates the bearer token of
+// every request to next, replying 401 to requests without a valid one. The
+// principal replaces the X-Actor header as the actor of the audit log.
+func Authenticate(auth Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := authenticate(r.Context(), auth, r.Header.Get("Authorization"))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="books"`)
+			writeError(w, http.StatusUnauthorized, "unauthenticated", err.Error())
+			return
+		}
+		r.Header.Del(actorHeader)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func unaryAuthInterceptor(auth Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticate(ctx, auth, grpcAuthorization(ctx))
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func streamAuthInterceptor(auth Authenticator) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), auth, grpcAuthorization(ss.Context()))
+		if err != nil {
+			return grpcError(err)
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// grpcAuthorization returns the authorization metadata of an incoming call.
+func grpcAuthorization(ctx context.Context) string {
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// authenticatedStream is a server stream whose context carries the
+// principal of the call.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// AccessPolicy maps use case operations, such as "Delete", to the roles
+// allowed to perform them: a principal needs any one of them. Operations
+// without an entry are open to every caller.
+type AccessPolicy map[string][]string
+
+// DefaultAccessPolicy lets only librarians delete books.
+var DefaultAccessPolicy = AccessPolicy{
+	"Delete": {RoleLibrarian},
+}
+
+// authorize checks that the principal of ctx may perform op. It fails with
+// ErrUnauthenticated if op needs a role and ctx has no principal, and with
+// ErrForbidden if the principal has none of the roles.
+func (p AccessPolicy) authorize(ctx context.Context, op string) error {
+	roles, ok := p[op]
+	if !ok {
+		return nil
+	}
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%w: %s requires an authenticated caller", ErrUnauthenticated, op)
+	}
+	if !principal.HasRole(roles...) {
+		return fmt.Errorf("%w: %s requires role %s", ErrForbidden, op, strings.Join(roles, " or "))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testJWTSecret = []byte("test secret")
+
+// signJWT returns a token of claims with the given alg header, signed with
+// HS256 by secret.
+func signJWT(t *testing.T, secret []byte, alg string, claims map[string]any) string {
+	t.Helper()
+	part := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := part(map[string]string{"alg": alg, "typ": "JWT"}) + "." + part(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// bearer returns the Authorization header of a valid token of subject with
+// roles.
+func bearer(t *testing.T, subject string, roles ...string) string {
+	claims := map[string]any{"sub": subject, "exp": time.Now().Add(time.Hour).Unix(), "roles": roles}
+	return "Bearer " + signJWT(t, testJWTSecret, "HS256", claims)
+}
+
+// wantProblem fails t unless rec holds a problem with status and code.
+func wantProblem(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status %d, want %d: %s", rec.Code, status, rec.Body)
+	}
+	var p Problem
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != code || p.Status != status {
+		t.Errorf("problem %+v, want %s", p, code)
+	}
+}
+
+func TestAuthenticateRepliesUnauthorizedToInvalidTokens(t *testing.T) {
+	expired := time.Now().Add(-time.Hour).Unix()
+	later := time.Now().Add(time.Hour).Unix()
+	for _, tc := range []struct {
+		name          string
+		authorization string
+	}{
+		{"no header", ""},
+		{"basic scheme", "Basic dXNlcjpwYXNz"},
+		{"malformed token", "Bearer not-a-token"},
+		{"wrong secret", "Bearer " + signJWT(t, []byte("other secret"), "HS256", map[string]any{"sub": "ann", "exp": later})},
+		{"other algorithm", "Bearer " + signJWT(t, testJWTSecret, "none", map[string]any{"sub": "ann", "exp": later})},
+		{"expired", "Bearer " + signJWT(t, testJWTSecret, "HS256", map[string]any{"sub": "ann", "exp": expired})},
+		{"no expiry", "Bearer " + signJWT(t, testJWTSecret, "HS256", map[string]any{"sub": "ann"})},
+		{"no subject", "Bearer " + signJWT(t, testJWTSecret, "HS256", map[string]any{"exp": later})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reached := false
+			handler := Authenticate(NewJWTAuthenticator(testJWTSecret, "", ""), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/books", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			wantProblem(t, rec, http.StatusUnauthorized, "unauthenticated")
+			if got := rec.Header().Get("WWW-Authenticate"); got == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+			if reached {
+				t.Error("the request reached the handler")
+			}
+		})
+	}
+}
+
+func TestDeleteRequiresTheLibrarianRole(t *testing.T) {
+	repo := NewMemoryBookRepository()
+	uc := NewBookUseCase(repo, WithAccessPolicy(DefaultAccessPolicy))
+	authenticated := Authenticate(NewJWTAuthenticator(testJWTSecret, "", ""), NewBookHandler(uc))
+	for _, tc := range []struct {
+		name          string
+		handler       http.Handler
+		authorization string
+		status        int
+		code          string
+	}{
+		{"without principal", NewBookHandler(uc), "", http.StatusUnauthorized, "unauthenticated"},
+		{"reader", authenticated, bearer(t, "ann", "reader"), http.StatusForbidden, "forbidden"},
+		{"librarian", authenticated, bearer(t, "bob", "reader", RoleLibrarian), http.StatusNoContent, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := repo.Upsert(context.Background(), &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodDelete, "/books/1", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rec, req)
+
+			if tc.code == "" {
+				if rec.Code != tc.status {
+					t.Fatalf("status %d, want %d: %s", rec.Code, tc.status, rec.Body)
+				}
+				if _, err := repo.GetById(context.Background(), 1); !errors.Is(err, ErrNotFound) {
+					t.Errorf("book after an allowed delete: got %v, want ErrNotFound", err)
+				}
+				return
+			}
+			wantProblem(t, rec, tc.status, tc.code)
+			if _, err := repo.GetById(context.Background(), 1); err != nil {
+				t.Errorf("book after a refused delete: %v", err)
+			}
+		})
+	}
+}
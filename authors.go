@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// AuthorBookRepository links books to the authors in an author table. The
+// relationship is one-to-many: a book stores its author's name, which is the
+// key of the book table's author index, so an author's books are a single
+// index Query away.
+type AuthorBookRepository struct {
+	books   *DynamoDbBookRepository
+	authors *Repository[Author]
+}
+
+func NewAuthorBookRepository(books *DynamoDbBookRepository, authors *Repository[Author]) *AuthorBookRepository {
+	return &AuthorBookRepository{books: books, authors: authors}
+}
+
+// GetAuthor returns the author with the given id, or ErrNotFound.
+func (r *AuthorBookRepository) GetAuthor(ctx context.Context, id int) (*Author, error) {
+	return r.authors.Get(ctx, AuthorKey(id))
+}
+
+// CreateBookWithAuthor creates book, linked to author, in one transaction.
+// With createAuthor the author is created too, failing with ErrConflict if it
+// exists, so neither is written without the other. Otherwise the author's
+// existence is checked in the same transaction, so the book cannot be linked
+// to an author deleted meanwhile.
+func (r *AuthorBookRepository) CreateBookWithAuthor(ctx context.Context, book *Book, author *Author, createAuthor bool) error {
+	book.Author = author.Name
+	book.Version = 1
+	bookItem, err := r.books.codec.marshal(book)
+	if err != nil {
+		return err
+	}
+
+	authorItem := types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+		TableName:                aws.String(r.authors.tableName),
+		Key:                      AuthorKey(author.Id),
+		ConditionExpression:      aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames: map[string]string{"#id": idAttribute},
+	}}
+	if createAuthor {
+		av, err := r.authors.schema.Marshal(author)
+		if err != nil {
+			return err
+		}
+		authorItem = types.TransactWriteItem{Put: &types.Put{
+			TableName:                aws.String(r.authors.tableName),
+			Item:                     av,
+			ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+			ExpressionAttributeNames: map[string]string{"#id": idAttribute},
+		}}
+	}
+	_, err = r.books.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			authorItem,
+			{Put: &types.Put{
+				TableName:                aws.String(r.books.tableName),
+				Item:                     bookItem,
+				ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+				ExpressionAttributeNames: map[string]string{"#id": idAttribute},
+			}},
+		},
+	})
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return translateError(err)
+	}
+	reasons := canceled.CancellationReasons
+	if len(reasons) != 2 {
+		return translateError(err)
+	}
+	if aws.ToString(reasons[1].Code) == "ConditionalCheckFailed" {
+		return ErrBookAlreadyExists
+	}
+	if aws.ToString(reasons[0].Code) == "ConditionalCheckFailed" {
+		// The author was created or deleted since it was read; a retry
+		// sees the new state.
+		return fmt.Errorf("%w: author %d changed concurrently", ErrConflict, author.Id)
+	}
+	return translateError(err)
+}
+
+// GetAuthorWithBooks returns an author and their books, read from the
+// author index. It returns ErrNotFound if the author does not exist.
+// Soft-deleted books are skipped unless the book repository includes them.
+func (r *AuthorBookRepository) GetAuthorWithBooks(ctx context.Context, id int) (*Author, []*Book, error) {
+	author, err := r.authors.Get(ctx, AuthorKey(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	books, err := r.books.GetByAuthor(ctx, author.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return author, r.books.visible(books), nil
+}
+
+// AuthorUseCase is the application layer over AuthorBookRepository.
+type AuthorUseCase struct {
+	repo *AuthorBookRepository
+}
+
+func NewAuthorUseCase(repo *AuthorBookRepository) *AuthorUseCase {
+	return &AuthorUseCase{repo: repo}
+}
+
+// CreateBookWithAuthor creates book by author. If an author with author.Id
+// exists, the book references it and takes its stored name; otherwise the
+// author is created from author, which then needs a name, together with the
+// book. It reports whether the author was created.
+func (uc *AuthorUseCase) CreateBookWithAuthor(ctx context.Context, book *Book, author *Author) (created bool, err error) {
+	ctx, span := startSpan(ctx, "AuthorUseCase.CreateBookWithAuthor")
+	defer endSpan(span, &err)
+	if author.Id <= 0 {
+		return false, FieldErrors{"author.id": "must be a positive integer"}
+	}
+	existing, err := uc.repo.GetAuthor(withReadOptions(ctx, []ReadOption{WithConsistentRead()}), author.Id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if err := validateAuthor(author); err != nil {
+			return false, err
+		}
+		created = true
+	case err != nil:
+		return false, err
+	default:
+		author = existing
+	}
+	book.Author = author.Name
+	if err := validateBook(book); err != nil {
+		return false, err
+	}
+	if err := uc.repo.CreateBookWithAuthor(ctx, book, author, created); err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// GetAuthorWithBooks returns an author and their books.
+func (uc *AuthorUseCase) GetAuthorWithBooks(ctx context.Context, id int, opts ...ReadOption) (author *Author, books []*Book, err error) {
+	ctx, span := startSpan(ctx, "AuthorUseCase.GetAuthorWithBooks")
+	defer endSpan(span, &err)
+	if id <= 0 {
+		return nil, nil, fmt.Errorf("%w: author id must be positive", ErrValidation)
+	}
+	return uc.repo.GetAuthorWithBooks(withReadOptions(ctx, opts), id)
+}
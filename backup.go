@@ -0,0 +1,161 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// SnapshotStore stores table snapshots as named objects.
+type SnapshotStore interface {
+	// Create returns a writer for a new snapshot named key. The snapshot is
+	// only complete once Close returns nil.
+	Create(ctx context.Context, key string) (io.WriteCloser, error)
+	// Open returns a reader of the snapshot named key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// snapshotKey returns the default name of a snapshot of table taken at t,
+// e.g. "book/20240131T120000Z.ndjson.gz".
+func snapshotKey(table string, t time.Time) string {
+	return path.Join(table, t.UTC().Format("20060102T150405Z")+".ndjson.gz")
+}
+
+// BackupBooks writes a snapshot of every book to store as gzipped NDJSON,
+// streamed as the table is read. It returns the number of books written.
+func (uc *BookUseCase) BackupBooks(ctx context.Context, store SnapshotStore, key string) (n int, err error) {
+	ctx, end := uc.begin(ctx, "BackupBooks")
+	defer end(&err)
+
+	w, err := store.Create(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	gz := gzip.NewWriter(w)
+	n, err = uc.ExportBooks(ctx, gz, FormatNDJSON)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		// Abandon the snapshot rather than complete a partial one.
+		if a, ok := w.(interface{ Abort(error) }); ok {
+			a.Abort(err)
+		}
+		w.Close()
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// RestoreBooks writes the books of the snapshot named key back with batched
+// writes, overwriting books with the same id. With dryRun the snapshot is
+// only read and validated. It returns the number of books restored, or that
+// would be.
+func (uc *BookUseCase) RestoreBooks(ctx context.Context, store SnapshotStore, key string, dryRun bool) (n int, err error) {
+	ctx, end := uc.begin(ctx, "RestoreBooks")
+	defer end(&err)
+
+	r, err := store.Open(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("snapshot %s: %w", key, err)
+	}
+	if !dryRun {
+		return uc.ImportBooks(ctx, gz, FormatNDJSON)
+	}
+
+	next, err := newBookDecoder(gz, FormatNDJSON)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		book, err := next()
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		uc.normalize(book)
+		if err := validateBook(book); err != nil {
+			return n, fmt.Errorf("book %d of snapshot: %w", n+1, err)
+		}
+		n++
+	}
+}
+
+// S3SnapshotStore keeps snapshots as objects in an S3 bucket. Snapshots are
+// uploaded in parts as they are written, so their size is not bounded by
+// memory.
+type S3SnapshotStore struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+}
+
+func NewS3SnapshotStore(cfg aws.Config, bucket string) *S3SnapshotStore {
+	client := s3.NewFromConfig(cfg)
+	return &S3SnapshotStore{client: client, uploader: manager.NewUploader(client), bucket: bucket}
+}
+
+// Create starts a multipart upload of key fed by the returned writer.
+func (s *S3SnapshotStore) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	u := &s3Upload{pw: pw, done: make(chan error, 1)}
+	go func() {
+		_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             aws.String(key),
+			Body:            pr,
+			ContentType:     aws.String("application/x-ndjson"),
+			ContentEncoding: aws.String("gzip"),
+		})
+		// Unblock the writer if the upload failed before reading everything.
+		pr.CloseWithError(err)
+		u.done <- err
+	}()
+	return u, nil
+}
+
+// Open downloads the object key.
+func (s *S3SnapshotStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("open snapshot s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return out.Body, nil
+}
+
+// s3Upload is the writer of a snapshot being uploaded.
+type s3Upload struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	return u.pw.Write(p)
+}
+
+// Abort makes the upload fail with err; the uploader then aborts the
+// multipart upload so no partial object is left.
+func (u *s3Upload) Abort(err error) {
+	u.pw.CloseWithError(err)
+}
+
+// Close completes the upload and returns its result.
+func (u *s3Upload) Close() error {
+	u.pw.Close()
+	return <-u.done
+}
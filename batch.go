@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDB limits on the number of items per batch request.
+const (
+	batchWriteLimit = 25
+	batchGetLimit   = 100
+)
+
+// WithBulkWorkers sets how many requests bulk operations, BatchCreate,
+// BatchGet and parallel scans, have in flight at once. More workers finish
+// sooner but consume capacity faster.
+func WithBulkWorkers(n int) RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.bulkWorkers = n
+	}
+}
+
+// BatchCreate writes books in chunks of 25 using BatchWriteItem, retrying
+// throttled requests and unprocessed items with exponential backoff. Unlike
+// Create, existing books with the same id are overwritten. Chunks are written
+// concurrently by the bulk workers, so if books holds the same id twice in
+// different chunks, either copy may win. BatchWriteItem cannot claim ISBNs,
+// so books with one are written first, each in a transaction claiming it.
+func (d *DynamoDbBookRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	var chunks [][]*Book
+	var chunk, withISBN []*Book
+	for _, book := range books {
+		if book.ISBN != "" {
+			withISBN = append(withISBN, book)
+			continue
+		}
+		if chunk = append(chunk, book); len(chunk) == batchWriteLimit {
+			chunks, chunk = append(chunks, chunk), nil
+		}
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	if err := ForEach(ctx, d.bulkWorkers, withISBN, func(ctx context.Context, book *Book) (int, error) {
+		if book.Version == 0 {
+			book.Version = 1
+		}
+		av, err := d.codec.marshal(book)
+		if err != nil {
+			return 0, err
+		}
+		if err := d.putBook(ctx, book, &types.Put{TableName: aws.String(d.tableName), Item: av}, ErrConflict); err != nil {
+			return 0, fmt.Errorf("book %d: %w", book.Id, err)
+		}
+		return 1, nil
+	}); err != nil {
+		return err
+	}
+	return ForEach(ctx, d.bulkWorkers, chunks, func(ctx context.Context, chunk []*Book) (int, error) {
+		requests := make([]types.WriteRequest, 0, len(chunk))
+		for _, book := range chunk {
+			if book.Version == 0 {
+				book.Version = 1
+			}
+			av, err := d.codec.marshal(book)
+			if err != nil {
+				return 0, err
+			}
+			requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: av}})
+		}
+		if err := d.batchWrite(ctx, requests); err != nil {
+			return 0, err
+		}
+		return len(chunk), nil
+	})
+}
+
+func (d *DynamoDbBookRepository) batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	pending := map[string][]types.WriteRequest{d.tableName: requests}
+	return d.batchRetry.run(ctx, "BatchWriteItem", func() (int, error) {
+		result, err := d.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+		if err != nil {
+			return len(pending[d.tableName]), translateError(err)
+		}
+		pending = result.UnprocessedItems
+		return len(pending[d.tableName]), nil
+	})
+}
+
+// BatchGet fetches the books with the given ids in chunks of 100 using
+// BatchGetItem, retrying throttled requests and unprocessed keys with
+// exponential backoff. The chunks are fetched concurrently by the bulk
+// workers; the first to fail cancels the others. Ids without a book, and
+// those of soft-deleted books unless the repository includes them, are
+// skipped; the books are returned in the order of their first id in ids.
+func (d *DynamoDbBookRepository) BatchGet(ctx context.Context, ids []int) ([]*Book, error) {
+	// BatchGetItem rejects requests that contain the same key twice.
+	seen := make(map[int]bool, len(ids))
+	unique := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	var chunks [][]int
+	for start := 0; start < len(unique); start += batchGetLimit {
+		end := start + batchGetLimit
+		if end > len(unique) {
+			end = len(unique)
+		}
+		chunks = append(chunks, unique[start:end])
+	}
+	var mu sync.Mutex
+	found := make(map[int]*Book, len(unique))
+	err := ForEach(ctx, d.bulkWorkers, chunks, func(ctx context.Context, chunk []int) (int, error) {
+		keys := make([]map[string]types.AttributeValue, 0, len(chunk))
+		for _, id := range chunk {
+			keys = append(keys, d.key.MarshalKey(id))
+		}
+		items, err := d.batchGet(ctx, keys)
+		if err != nil {
+			return 0, err
+		}
+		books, err := d.codec.unmarshalList(items)
+		if err != nil {
+			return 0, err
+		}
+		books = d.visible(books)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, book := range books {
+			found[book.Id] = book
+		}
+		return len(books), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	books := make([]*Book, 0, len(found))
+	for _, id := range unique {
+		if book, ok := found[id]; ok {
+			books = append(books, book)
+		}
+	}
+	return books, nil
+}
+
+func (d *DynamoDbBookRepository) batchGet(ctx context.Context, keys []map[string]types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	pending := map[string]types.KeysAndAttributes{d.tableName: {
+		Keys:           keys,
+		ConsistentRead: consistentRead(ctx, d.consistentReads),
+	}}
+	err := d.batchRetry.run(ctx, "BatchGetItem", func() (int, error) {
+		result, err := d.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: pending})
+		if err != nil {
+			return len(pending[d.tableName].Keys), translateError(err)
+		}
+		items = append(items, result.Responses[d.tableName]...)
+		pending = result.UnprocessedKeys
+		return len(pending[d.tableName].Keys), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
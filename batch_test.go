@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatchGetFetchesChunksConcurrently(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	var mu sync.Mutex
+	unprocessedOnce := map[int]bool{}
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			max := maxInFlight.Load()
+			if n <= max || maxInFlight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		items, unprocessed := []any{}, []any{}
+		for i, key := range decodeInput(t, input).RequestItems[stubTable].Keys {
+			id := keyID(t, key)
+			mu.Lock()
+			// The first key of every chunk comes back unprocessed once; it is
+			// an even id, so its book is only returned by the retry.
+			retry := i == 0 && !unprocessedOnce[id]
+			unprocessedOnce[id] = true
+			mu.Unlock()
+			switch {
+			case retry:
+				unprocessed = append(unprocessed, wireAttributes(t, NumberKey(idAttribute).MarshalKey(id)))
+			case id%2 == 0:
+				items = append(items, wireItem(t, &Book{Id: id, Name: "Book", Author: "Author", Version: 1}))
+			}
+		}
+		output := map[string]any{"Responses": map[string]any{stubTable: items}}
+		if len(unprocessed) > 0 {
+			output["UnprocessedKeys"] = map[string]any{stubTable: map[string]any{"Keys": unprocessed}}
+		}
+		return output, nil
+	})
+	repo := stub.repository(WithBulkWorkers(5), WithBatchBackoff(3, time.Millisecond, time.Millisecond))
+
+	ids := make([]int, 500)
+	for i := range ids {
+		ids[i] = 500 - i
+	}
+	books, err := repo.BatchGet(context.Background(), ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 250 {
+		t.Fatalf("got %d books, want the 250 with even ids", len(books))
+	}
+	for i, book := range books {
+		if want := 500 - 2*i; book.Id != want {
+			t.Fatalf("book %d has id %d, want %d: books must keep the order of ids", i, book.Id, want)
+		}
+	}
+	if got := len(stub.callsTo("BatchGetItem")); got != 10 {
+		t.Errorf("%d BatchGetItem calls, want 5 chunks plus 5 retries", got)
+	}
+	if max := maxInFlight.Load(); max < 2 || max > 5 {
+		t.Errorf("%d calls in flight at most, want 2 to 5", max)
+	}
+}
+
+func TestBatchGetStopsAtTheFirstFailedChunk(t *testing.T) {
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		return nil, &stubError{Type: "ResourceNotFoundException", Message: "no table"}
+	})
+	ids := make([]int, 300)
+	for i := range ids {
+		ids[i] = i + 1
+	}
+	_, err := stub.repository(WithBulkWorkers(1)).BatchGet(context.Background(), ids)
+	if err == nil {
+		t.Fatal("BatchGet succeeded, want the error of the failed chunk")
+	}
+	if got := len(stub.callsTo("BatchGetItem")); got != 1 {
+		t.Errorf("%d BatchGetItem calls, want 1: chunks after a failure must be skipped", got)
+	}
+}
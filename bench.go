@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// BenchmarkOptions sizes the benchmarks of Benchmark.
+type BenchmarkOptions struct {
+	// Items is how many books BatchCreate writes, and so how many books
+	// List reads.
+	Items int
+	// Ops is how many books Create and GetById each write and read.
+	Ops int
+	// Lists is how many times List reads the whole table.
+	Lists int
+}
+
+// DefaultBenchmarkOptions list a table of 10,000 books.
+var DefaultBenchmarkOptions = BenchmarkOptions{Items: 10000, Ops: 1000, Lists: 3}
+
+// BenchmarkResult is the average time and allocations of one operation of a
+// benchmark. Allocations are those of the whole process, SDK included.
+type BenchmarkResult struct {
+	Name        string `json:"name"`
+	Ops         int    `json:"ops"`
+	NsPerOp     int64  `json:"nsPerOp"`
+	AllocsPerOp int64  `json:"allocsPerOp"`
+	BytesPerOp  int64  `json:"bytesPerOp"`
+}
+
+// Benchmark measures BatchCreate, List, Create and GetById of d, in that
+// order, against its table, which must not exist: it is created first and
+// deleted afterwards. It is meant for DynamoDB Local, to compare the
+// repository options, e.g. WithConnectionPool, WithMarshalPooling and
+// WithKeyTemplates; numbers against DynamoDB itself are dominated by the
+// network.
+func (d *DynamoDbBookRepository) Benchmark(ctx context.Context, opts BenchmarkOptions) (results []BenchmarkResult, err error) {
+	if err := Migrate(ctx, d.client, d.tableName); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, deleteErr := d.client.DeleteTable(context.WithoutCancel(ctx), &dynamodb.DeleteTableInput{TableName: aws.String(d.tableName)})
+		var notFound *types.ResourceNotFoundException
+		if deleteErr != nil && !errors.As(deleteErr, &notFound) {
+			err = errors.Join(err, fmt.Errorf("delete table %s: %w", d.tableName, translateError(deleteErr)))
+		}
+	}()
+
+	books := make([]*Book, opts.Items)
+	for i := range books {
+		books[i] = benchmarkBook(i + 1)
+	}
+	benchmarks := []struct {
+		name string
+		ops  int
+		run  func(i int) error
+	}{
+		// BatchCreate is timed per book, although it writes them all at once.
+		{"BatchCreate", opts.Items, func(i int) error {
+			if i > 0 {
+				return nil
+			}
+			return d.BatchCreate(ctx, books)
+		}},
+		{"List", opts.Lists, func(int) error {
+			listed, err := d.List(ctx)
+			if err == nil && len(listed) != opts.Items {
+				err = fmt.Errorf("listed %d books, want %d", len(listed), opts.Items)
+			}
+			return err
+		}},
+		{"Create", opts.Ops, func(i int) error {
+			return d.Create(ctx, benchmarkBook(opts.Items+i+1))
+		}},
+		{"GetById", opts.Ops, func(i int) error {
+			_, err := d.GetById(ctx, opts.Items+i+1)
+			return err
+		}},
+	}
+	for _, b := range benchmarks {
+		result, err := measure(b.name, b.ops, b.run)
+		if err != nil {
+			return results, fmt.Errorf("benchmark %s: %w", b.name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// benchmarkBook returns the book with the given id Benchmark writes.
+func benchmarkBook(id int) *Book {
+	return &Book{
+		Id:        id,
+		Name:      fmt.Sprintf("Benchmark book %d", id),
+		Author:    fmt.Sprintf("Author %d", id%100),
+		Tags:      []string{"benchmark"},
+		Year:      1900 + id%125,
+		CreatedAt: time.Unix(int64(1_700_000_000+id), 0).UTC(),
+	}
+}
+
+// measure calls run ops times and returns the time taken and memory
+// allocated per call. It stops at the first error.
+func measure(name string, ops int, run func(i int) error) (BenchmarkResult, error) {
+	result := BenchmarkResult{Name: name, Ops: ops}
+	if ops <= 0 {
+		return result, nil
+	}
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < ops; i++ {
+		if err := run(i); err != nil {
+			return result, err
+		}
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	result.NsPerOp = elapsed.Nanoseconds() / int64(ops)
+	result.AllocsPerOp = int64(after.Mallocs-before.Mallocs) / int64(ops)
+	result.BytesPerOp = int64(after.TotalAlloc-before.TotalAlloc) / int64(ops)
+	return result, nil
+}
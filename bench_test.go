@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// benchmarkRepository is a repository the benchmarks run against.
+type benchmarkRepository struct {
+	name    string
+	newRepo func() BookRepository
+}
+
+// benchmarkRepositories returns the repositories the benchmarks compare: the
+// in-memory one and DynamoDbBookRepository against an in-process table
+// stub, which measures the repository and the SDK without the network,
+// without and with its tuning options.
+func benchmarkRepositories(b *testing.B) []benchmarkRepository {
+	return []benchmarkRepository{
+		{"memory", func() BookRepository { return NewMemoryBookRepository() }},
+		{"dynamodb/untuned", func() BookRepository { return newTableStub(b).repository() }},
+		{"dynamodb/tuned", func() BookRepository {
+			return newTableStub(b).repository(WithConnectionPool(64), WithMarshalPooling(), WithKeyTemplates())
+		}},
+	}
+}
+
+// seedBenchmark stores books 1 to n in repo.
+func seedBenchmark(b *testing.B, repo BookRepository, n int) {
+	b.Helper()
+	books := make([]*Book, n)
+	for i := range books {
+		books[i] = benchmarkBook(i + 1)
+	}
+	if err := repo.BatchCreate(context.Background(), books); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkCreate(b *testing.B) {
+	for _, r := range benchmarkRepositories(b) {
+		b.Run(r.name, func(b *testing.B) {
+			repo, ctx := r.newRepo(), context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := repo.Create(ctx, benchmarkBook(i+1)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetById(b *testing.B) {
+	const books = 1000
+	for _, r := range benchmarkRepositories(b) {
+		b.Run(r.name, func(b *testing.B) {
+			repo, ctx := r.newRepo(), context.Background()
+			seedBenchmark(b, repo, books)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.GetById(ctx, i%books+1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkList(b *testing.B) {
+	const books = 10000
+	for _, r := range benchmarkRepositories(b) {
+		b.Run(r.name, func(b *testing.B) {
+			repo, ctx := r.newRepo(), context.Background()
+			seedBenchmark(b, repo, books)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				listed, err := repo.List(ctx)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(listed) != books {
+					b.Fatalf("listed %d books, want %d", len(listed), books)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBatchCreate(b *testing.B) {
+	// A batch of 100 books takes four BatchWriteItem calls.
+	const batch = 100
+	for _, r := range benchmarkRepositories(b) {
+		b.Run(r.name, func(b *testing.B) {
+			repo, ctx := r.newRepo(), context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				books := make([]*Book, batch)
+				for j := range books {
+					books[j] = benchmarkBook(i*batch + j + 1)
+				}
+				b.StartTimer()
+				if err := repo.BatchCreate(ctx, books); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
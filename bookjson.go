@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// plainBook is Book without its JSON methods.
+type plainBook Book
+
+// bookID is a book id in JSON, read from a number or a decimal string. It is
+// written as a number, like the book ids of every other entity; clients of a
+// service with a SnowflakeGenerator must parse it without rounding, as its
+// ids exceed the integers a JavaScript number holds exactly.
+type bookID int
+
+func (id *bookID) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		var err error
+		if s, err = strconv.Unquote(s); err != nil {
+			return err
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("book id %s is not an integer", data)
+	}
+	*id = bookID(n)
+	return nil
+}
+
+// UnmarshalJSON reads a Book whose id is a number or a decimal string.
+// Unknown fields are ignored; see decodeBookJSON.
+func (b *Book) UnmarshalJSON(data []byte) error {
+	return decodeBookJSON(data, b, false)
+}
+
+// decodeBookJSON decodes data into book, rejecting unknown fields if strict.
+// A decoder's DisallowUnknownFields does not reach Book.UnmarshalJSON, so
+// callers reading books from clients decode them with this instead.
+func decodeBookJSON(data []byte, book *Book, strict bool) error {
+	v := struct {
+		*plainBook
+		Id *bookID `json:"id"`
+	}{(*plainBook)(book), (*bookID)(&book.Id)}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(&v)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// Defaults of BreakerPolicy.
+const (
+	defaultFailureThreshold = 3
+	defaultBreakerCooldown  = 30 * time.Second
+	defaultHalfOpenProbes   = 1
+)
+
+// BreakerPolicy configures a circuit breaker.
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures that open the
+	// breaker. Defaults to 3.
+	FailureThreshold int
+	// Cooldown is how long an open breaker rejects calls before letting
+	// probes through. Defaults to 30s.
+	Cooldown time.Duration
+	// HalfOpenProbes is the number of concurrent calls a breaker lets
+	// through once its cooldown has passed. Defaults to 1.
+	HalfOpenProbes int
+}
+
+// breakerState is the state of a circuitBreaker, as reported by the
+// dynamodb.circuit.state metric.
+type breakerState int64
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// circuitBreaker opens after threshold consecutive failures. Once open, it
+// rejects calls until cooldown has passed, then lets up to probes calls
+// through at a time: the breaker closes as soon as one succeeds and opens
+// again, for another cooldown, when one fails.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	probes    int
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  int
+}
+
+func newCircuitBreaker(policy BreakerPolicy) *circuitBreaker {
+	b := &circuitBreaker{threshold: policy.FailureThreshold, cooldown: policy.Cooldown, probes: policy.HalfOpenProbes}
+	if b.threshold <= 0 {
+		b.threshold = defaultFailureThreshold
+	}
+	if b.cooldown <= 0 {
+		b.cooldown = defaultBreakerCooldown
+	}
+	if b.probes <= 0 {
+		b.probes = defaultHalfOpenProbes
+	}
+	return b
+}
+
+// allow reports whether a call may go ahead. An allowed call must be
+// followed by reset, failure or release.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state() {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		if b.probing < b.probes {
+			b.probing++
+			return true
+		}
+	}
+	return false
+}
+
+// reset records a successful call or probe, closing the breaker.
+func (b *circuitBreaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.probing = 0, 0
+}
+
+// failure records a failed call or probe, opening the breaker, or opening
+// it again, when it reaches the threshold.
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = max(b.probing-1, 0)
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// release ends an inconclusive call, letting another probe through.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = max(b.probing-1, 0)
+}
+
+// untilProbe returns how long the cooldown of an open breaker has left, 0
+// once probes are let through.
+func (b *circuitBreaker) untilProbe() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(time.Until(b.openedAt.Add(b.cooldown)), 0)
+}
+
+func (b *circuitBreaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state()
+}
+
+// state returns the state of the breaker. b.mu must be held.
+func (b *circuitBreaker) state() breakerState {
+	switch {
+	case b.failures < b.threshold:
+		return breakerClosed
+	case time.Since(b.openedAt) < b.cooldown:
+		return breakerOpen
+	}
+	return breakerHalfOpen
+}
+
+// CircuitBreaking returns a middleware that stops calling the repository
+// while it is failing: after the policy's FailureThreshold of consecutive
+// calls fail with a network error, a timeout, a 5xx response or throttling,
+// calls are rejected with ErrCircuitOpen, without waiting and with the
+// time left of the cooldown, until the cooldown has passed and a probe call
+// succeeds. Other errors, such as
+// ErrNotFound or a failed condition, count as successes. The state of the
+// breaker is exported as the dynamodb.circuit.state gauge (0 closed, 1 open,
+// 2 half-open) and rejected calls are counted by dynamodb.circuit.rejected,
+// both tagged with the table. It fails only if the instruments cannot be
+// created.
+func CircuitBreaking(table string, policy BreakerPolicy) (RepositoryMiddleware, error) {
+	b := newCircuitBreaker(policy)
+	meter := otel.Meter(instrumentationName)
+	tableAttrs := metric.WithAttributes(semconv.DBSystemDynamoDB, semconv.AWSDynamoDBTableNames(table))
+	_, err := meter.Int64ObservableGauge("dynamodb.circuit.state",
+		metric.WithDescription("State of the repository circuit breaker: 0 closed, 1 open, 2 half-open."),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(b.current()), tableAttrs)
+			return nil
+		}))
+	if err != nil {
+		return nil, err
+	}
+	rejected, err := meter.Int64Counter("dynamodb.circuit.rejected",
+		metric.WithDescription("Number of repository operations rejected by the open circuit breaker."))
+	if err != nil {
+		return nil, err
+	}
+	return Around(func(ctx context.Context, op string, fn func(context.Context) error) error {
+		if !b.allow() {
+			rejected.Add(ctx, 1, metric.WithAttributes(repositoryAttributes(op, table)...))
+			return &retryAfterError{err: ErrCircuitOpen, after: b.untilProbe()}
+		}
+		err := fn(ctx)
+		switch {
+		case ctx.Err() != nil:
+			b.release()
+		case isUnreachable(err) || isThrottled(err) && !errors.Is(err, ErrRateLimited), errors.Is(err, ErrNoRegionAvailable):
+			b.failure()
+		default:
+			b.reset()
+		}
+		return err
+	}), nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BookCache stores books by id for CachedBookRepository. Implementations must
+// be safe for concurrent use; a Redis-backed one can be swapped in for the
+// in-process LRUCache.
+type BookCache interface {
+	Get(ctx context.Context, id int) (*Book, bool)
+	Set(ctx context.Context, book *Book)
+	Delete(ctx context.Context, id int)
+}
+
+// LRUCache is an in-process BookCache holding at most capacity books, each
+// for at most ttl. The least recently used book is evicted first.
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List // of *lruEntry, most recently used first
+	entries  map[int]*list.Element
+	now      func() time.Time
+}
+
+type lruEntry struct {
+	book    *Book
+	expires time.Time
+}
+
+func NewLRUCache(capacity int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  map[int]*list.Element{},
+		now:      time.Now,
+	}
+}
+
+// Get implements BookCache.
+func (c *LRUCache) Get(ctx context.Context, id int) (*Book, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if c.now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, id)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return copyBook(entry.book), true
+}
+
+// Set implements BookCache.
+func (c *LRUCache) Set(ctx context.Context, book *Book) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruEntry{book: copyBook(book), expires: c.now().Add(c.ttl)}
+	if el, ok := c.entries[book.Id]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[book.Id] = c.order.PushFront(entry)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).book.Id)
+	}
+}
+
+// Delete implements BookCache.
+func (c *LRUCache) Delete(ctx context.Context, id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[id]; ok {
+		c.order.Remove(el)
+		delete(c.entries, id)
+	}
+}
+
+// CachedBookRepository is a BookRepository decorator that serves GetById
+// from a BookCache, filling it on misses. Writes invalidate the affected
+// books rather than updating the cache, so a failed or conflicting write can
+// never leave stale data behind. Methods not overridden here pass through.
+//
+// WithListCache adds a cache of the result of List. The two caches are kept
+// coherent as follows: every write through the repository invalidates both
+// the book in the per-id cache and the whole list, as a write may change
+// which books the list holds; a GetById read from the store replaces the
+// book in the cached list, or removes it if the book is gone, but never adds
+// books to the list. List reads do not fill the per-id cache, so that a
+// listing does not evict the books being read one at a time.
+//
+// A read that misses the cache only stores what it read if no write
+// invalidated the book, or the list, while it was reading: otherwise it
+// could store a value older than the write. Reads asking for
+// WithConsistentRead bypass the cached values, but still fill the caches.
+type CachedBookRepository struct {
+	BookRepository
+	cache  BookCache
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	// fillMu orders the fills of the per-id cache with invalidations;
+	// fills holds the generation of the books being read from the store.
+	fillMu sync.Mutex
+	fills  map[int]*cacheFill
+
+	listTTL     time.Duration
+	listMu      sync.Mutex
+	list        []*Book // nil when not cached
+	listExpires time.Time
+	// listGen counts the invalidations of the list.
+	listGen uint64
+	now     func() time.Time
+}
+
+// cacheFill is the generation of a book some reads are filling the cache
+// with. Invalidating the book increments gen, so the reads that started
+// before do not store the book.
+type cacheFill struct {
+	gen     uint64
+	readers int
+}
+
+// CacheOption configures a CachedBookRepository.
+type CacheOption func(*CachedBookRepository)
+
+// WithListCache makes the repository also cache the result of List for ttl.
+func WithListCache(ttl time.Duration) CacheOption {
+	return func(c *CachedBookRepository) {
+		c.listTTL = ttl
+	}
+}
+
+func NewCachedBookRepository(next BookRepository, cache BookCache, opts ...CacheOption) *CachedBookRepository {
+	c := &CachedBookRepository{BookRepository: next, cache: cache, fills: map[int]*cacheFill{}, now: time.Now}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Caching returns a middleware that wraps repositories in a
+// CachedBookRepository backed by cache.
+func Caching(cache BookCache, opts ...CacheOption) RepositoryMiddleware {
+	return func(next BookRepository) BookRepository {
+		return NewCachedBookRepository(next, cache, opts...)
+	}
+}
+
+// Stats returns the number of GetById cache hits and misses so far.
+func (c *CachedBookRepository) Stats() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// GetById implements BookRepository.
+func (c *CachedBookRepository) GetById(ctx context.Context, id int) (*Book, error) {
+	if !strongRead(ctx) {
+		if book, ok := c.cache.Get(ctx, id); ok {
+			c.hits.Add(1)
+			return book, nil
+		}
+	}
+	c.misses.Add(1)
+	fill, gen := c.startFill(id)
+	book, err := c.BookRepository.GetById(ctx, id)
+	c.fillMu.Lock()
+	defer c.fillMu.Unlock()
+	if fill.readers--; fill.readers == 0 {
+		delete(c.fills, id)
+	}
+	// A write since the read started may have changed the book.
+	current := fill.gen == gen
+	if errors.Is(err, ErrNotFound) && current {
+		c.refreshListed(id, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// A partial book must not be served to later reads of the whole item.
+	if !partialRead(ctx) && current {
+		c.cache.Set(ctx, book)
+		c.refreshListed(id, book)
+	}
+	return book, nil
+}
+
+// startFill registers a read of the book with id from the store and
+// returns its fill and current generation.
+func (c *CachedBookRepository) startFill(id int) (*cacheFill, uint64) {
+	c.fillMu.Lock()
+	defer c.fillMu.Unlock()
+	fill, ok := c.fills[id]
+	if !ok {
+		fill = &cacheFill{}
+		c.fills[id] = fill
+	}
+	fill.readers++
+	return fill, fill.gen
+}
+
+// List implements BookRepository. Without WithListCache it passes through.
+func (c *CachedBookRepository) List(ctx context.Context) ([]*Book, error) {
+	if c.listTTL <= 0 || partialRead(ctx) {
+		return c.BookRepository.List(ctx)
+	}
+	c.listMu.Lock()
+	if c.list != nil && c.now().Before(c.listExpires) && !strongRead(ctx) {
+		books := copyBooks(c.list)
+		c.listMu.Unlock()
+		return books, nil
+	}
+	gen := c.listGen
+	c.listMu.Unlock()
+
+	books, err := c.BookRepository.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.listMu.Lock()
+	if c.listGen == gen {
+		c.list, c.listExpires = copyBooks(books), c.now().Add(c.listTTL)
+	}
+	c.listMu.Unlock()
+	return books, nil
+}
+
+// refreshListed replaces the book with the given id in the cached list by
+// book, or removes it if book is nil. A book not in the list is not added.
+func (c *CachedBookRepository) refreshListed(id int, book *Book) {
+	c.listMu.Lock()
+	defer c.listMu.Unlock()
+	for i, listed := range c.list {
+		if listed.Id != id {
+			continue
+		}
+		if book == nil {
+			c.list = slices.Delete(c.list, i, i+1)
+		} else {
+			c.list[i] = copyBook(book)
+		}
+		return
+	}
+}
+
+// invalidate drops the books with the given ids from the per-id cache and
+// the whole cached list, and keeps the reads in flight from storing them.
+func (c *CachedBookRepository) invalidate(ctx context.Context, ids ...int) {
+	c.fillMu.Lock()
+	defer c.fillMu.Unlock()
+	for _, id := range ids {
+		if fill, ok := c.fills[id]; ok {
+			fill.gen++
+		}
+		c.cache.Delete(ctx, id)
+	}
+	c.listMu.Lock()
+	c.list = nil
+	c.listGen++
+	c.listMu.Unlock()
+}
+
+// Create implements BookRepository.
+func (c *CachedBookRepository) Create(ctx context.Context, book *Book) error {
+	defer c.invalidate(ctx, book.Id)
+	return c.BookRepository.Create(ctx, book)
+}
+
+// Upsert implements BookRepository.
+func (c *CachedBookRepository) Upsert(ctx context.Context, book *Book) error {
+	defer c.invalidate(ctx, book.Id)
+	return c.BookRepository.Upsert(ctx, book)
+}
+
+// Update implements BookRepository.
+func (c *CachedBookRepository) Update(ctx context.Context, book *Book) error {
+	defer c.invalidate(ctx, book.Id)
+	return c.BookRepository.Update(ctx, book)
+}
+
+// Delete implements BookRepository.
+func (c *CachedBookRepository) Delete(ctx context.Context, id int) error {
+	defer c.invalidate(ctx, id)
+	return c.BookRepository.Delete(ctx, id)
+}
+
+// BatchCreate implements BookRepository.
+func (c *CachedBookRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	defer func() {
+		ids := make([]int, len(books))
+		for i, book := range books {
+			ids[i] = book.Id
+		}
+		c.invalidate(ctx, ids...)
+	}()
+	return c.BookRepository.BatchCreate(ctx, books)
+}
+
+// copyBooks returns copies of books.
+func copyBooks(books []*Book) []*Book {
+	copies := make([]*Book, len(books))
+	for i, book := range books {
+		copies[i] = copyBook(book)
+	}
+	return copies
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachedBookRepositoryKeepsCachesCoherent(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryBookRepository()
+	for _, book := range []*Book{
+		{Id: 1, Name: "Dune", Author: "Frank Herbert"},
+		{Id: 2, Name: "Emma", Author: "Jane Austen"},
+		{Id: 3, Name: "Ulysses", Author: "James Joyce"},
+	} {
+		if err := store.Create(ctx, book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cached := NewCachedBookRepository(store, NewLRUCache(10, time.Hour), WithListCache(time.Hour))
+	names := func() map[int]string {
+		t.Helper()
+		books, err := cached.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := map[int]string{}
+		for _, b := range books {
+			names[b.Id] = b.Name
+		}
+		return names
+	}
+	// changeBehind writes to the store without going through the cache, as
+	// another process would.
+	changeBehind := func(id int, name string) {
+		t.Helper()
+		book, err := store.GetById(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		book.Name = name
+		if err := store.Upsert(ctx, book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names() // fills the list cache
+	changeBehind(1, "Dune Messiah")
+	if got := names()[1]; got != "Dune" {
+		t.Fatalf("listed name %q, want the cached %q", got, "Dune")
+	}
+
+	// A GetById read from the store refreshes the book in both caches.
+	book, err := cached.GetById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Name != "Dune Messiah" {
+		t.Errorf("GetById name %q, want %q", book.Name, "Dune Messiah")
+	}
+	if got := names()[1]; got != "Dune Messiah" {
+		t.Errorf("listed name %q after GetById, want %q", got, "Dune Messiah")
+	}
+	if book, _ := cached.GetById(ctx, 1); book.Name != "Dune Messiah" {
+		t.Errorf("cached GetById name %q, want %q", book.Name, "Dune Messiah")
+	}
+	if hits, misses := cached.Stats(); hits != 1 || misses != 1 {
+		t.Errorf("%d hits and %d misses, want 1 of each", hits, misses)
+	}
+
+	// A GetById finding the book gone removes it from the list.
+	if err := store.Delete(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cached.GetById(ctx, 3); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetById of a deleted book = %v, want ErrNotFound", err)
+	}
+	if _, listed := names()[3]; listed {
+		t.Error("book 3 still listed after GetById found it deleted")
+	}
+
+	// A write through the repository invalidates both caches.
+	if _, err := cached.GetById(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	emma, err := store.GetById(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emma.Name = "Persuasion"
+	if err := cached.Update(ctx, emma); err != nil {
+		t.Fatal(err)
+	}
+	if book, _ := cached.GetById(ctx, 2); book.Name != "Persuasion" {
+		t.Errorf("GetById name %q after Update, want %q", book.Name, "Persuasion")
+	}
+	changeBehind(2, "Sanditon")
+	if got := names()[2]; got != "Sanditon" {
+		t.Errorf("listed name %q after Update, want the list re-read from the store", got)
+	}
+}
+
+// heldRepository holds the next GetById or List of the store after reading
+// it, until release is closed, so that a test can write in between.
+type heldRepository struct {
+	BookRepository
+	hold    atomic.Bool
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (h *heldRepository) wait() {
+	if h.hold.CompareAndSwap(true, false) {
+		h.read <- struct{}{}
+		<-h.release
+	}
+}
+
+func (h *heldRepository) GetById(ctx context.Context, id int) (*Book, error) {
+	book, err := h.BookRepository.GetById(ctx, id)
+	h.wait()
+	return book, err
+}
+
+func (h *heldRepository) List(ctx context.Context) ([]*Book, error) {
+	books, err := h.BookRepository.List(ctx)
+	h.wait()
+	return books, err
+}
+
+func TestCachedBookRepositoryDropsFillsRacingWithWrites(t *testing.T) {
+	ctx := context.Background()
+	for _, op := range []string{"GetById", "List"} {
+		t.Run(op, func(t *testing.T) {
+			store := NewMemoryBookRepository()
+			if err := store.Create(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+				t.Fatal(err)
+			}
+			held := &heldRepository{BookRepository: store, read: make(chan struct{}), release: make(chan struct{})}
+			cached := NewCachedBookRepository(held, NewLRUCache(10, time.Hour), WithListCache(time.Hour))
+			read := func() string {
+				t.Helper()
+				if op == "List" {
+					books, err := cached.List(ctx)
+					if err != nil || len(books) != 1 {
+						t.Fatalf("List = %v, %v, want book 1", books, err)
+					}
+					return books[0].Name
+				}
+				book, err := cached.GetById(ctx, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return book.Name
+			}
+
+			// The fill reads the book, then an update invalidates it before
+			// the fill stores what it read.
+			held.hold.Store(true)
+			filled := make(chan string)
+			go func() { filled <- read() }()
+			<-held.read
+			if err := cached.Update(ctx, &Book{Id: 1, Name: "Dune Messiah", Author: "Frank Herbert", Version: 1}); err != nil {
+				t.Fatal(err)
+			}
+			close(held.release)
+			if got := <-filled; got != "Dune" {
+				t.Errorf("the held read returned %q, want what it read, %q", got, "Dune")
+			}
+			if got := read(); got != "Dune Messiah" {
+				t.Errorf("%s after the update = %q, want %q: the racing fill stored a stale book", op, got, "Dune Messiah")
+			}
+		})
+	}
+}
+
+func TestCachedBookRepositoryBypassesTheCacheForConsistentReads(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryBookRepository()
+	if err := store.Create(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+		t.Fatal(err)
+	}
+	cached := NewCachedBookRepository(store, NewLRUCache(10, time.Hour), WithListCache(time.Hour))
+	if _, err := cached.GetById(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cached.List(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Written by another process, so the caches still hold the old name.
+	if err := store.Upsert(ctx, &Book{Id: 1, Name: "Dune Messiah", Author: "Frank Herbert", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	consistent := withReadOptions(ctx, []ReadOption{WithConsistentRead()})
+	if book, err := cached.GetById(consistent, 1); err != nil || book.Name != "Dune Messiah" {
+		t.Errorf("consistent GetById = %v, %v, want the stored name", book, err)
+	}
+	if books, err := cached.List(consistent); err != nil || len(books) != 1 || books[0].Name != "Dune Messiah" {
+		t.Errorf("consistent List = %v, %v, want the stored name", books, err)
+	}
+	// The consistent reads refreshed the caches for the reads after them.
+	if book, _ := cached.GetById(ctx, 1); book.Name != "Dune Messiah" {
+		t.Errorf("GetById after a consistent read = %q, want %q", book.Name, "Dune Messiah")
+	}
+}
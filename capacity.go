@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// capacityRecorder accumulates the capacity units consumed by the DynamoDB
+// calls made with a context carrying it.
+type capacityRecorder struct {
+	mu    sync.Mutex
+	units float64
+	// parent is the recorder of the enclosing context, which records the
+	// units too.
+	parent *capacityRecorder
+}
+
+func (r *capacityRecorder) add(units float64) {
+	r.mu.Lock()
+	r.units += units
+	r.mu.Unlock()
+	if r.parent != nil {
+		r.parent.add(units)
+	}
+}
+
+// Units returns the capacity units recorded so far.
+func (r *capacityRecorder) Units() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.units
+}
+
+type capacityRecorderKey struct{}
+
+// withCapacityRecorder returns a context whose DynamoDB calls request and
+// record their consumed capacity, also in the recorder ctx carries, if any.
+func withCapacityRecorder(ctx context.Context) (context.Context, *capacityRecorder) {
+	parent, _ := ctx.Value(capacityRecorderKey{}).(*capacityRecorder)
+	rec := &capacityRecorder{parent: parent}
+	return context.WithValue(ctx, capacityRecorderKey{}, rec), rec
+}
+
+// addConsumedCapacityMiddleware installs a middleware that, for calls whose
+// context carries a capacityRecorder, asks DynamoDB for the total consumed
+// capacity and records it. Other calls are left untouched.
+func addConsumedCapacityMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("RecordConsumedCapacity",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			rec, _ := ctx.Value(capacityRecorderKey{}).(*capacityRecorder)
+			if rec == nil {
+				return next.HandleInitialize(ctx, in)
+			}
+			requestConsumedCapacity(in.Parameters)
+			out, md, err := next.HandleInitialize(ctx, in)
+			if err == nil {
+				rec.add(consumedCapacity(out.Result))
+			}
+			return out, md, err
+		}), middleware.After)
+}
+
+// requestConsumedCapacity sets ReturnConsumedCapacity on operation inputs
+// that support it, unless the caller already chose a level. It reports
+// whether the operation consumes table capacity.
+func requestConsumedCapacity(params any) bool {
+	level := types.ReturnConsumedCapacityTotal
+	switch in := params.(type) {
+	case *dynamodb.GetItemInput:
+		if in.ReturnConsumedCapacity == "" {
+			in.ReturnConsumedCapacity = level
+		}
+	case *dynamodb.PutItemInput:
+		if in.ReturnConsumedCapacity == "" {
+			in.ReturnConsumedCapacity = level
+		}
+	case *dynamodb.UpdateItemInput:
+		if in.ReturnConsumedCapacity == "" {
+			in.ReturnConsumedCapacity = level
+		}
+	case *dynamodb.DeleteItemInput:
+		if in.ReturnConsumedCapacity == "" {
+			in.ReturnConsumedCapacity = level
+		}
+	case *dynamodb.QueryInput:
+		if in.ReturnConsumedCapacity == "" {
+			in.ReturnConsumedCapacity = level
+		}
+	case *dynamodb.ScanInput:
+		if in.ReturnConsumedCapacity == "" {
+			in.ReturnConsumedCapacity = level
+		}
+	case *dynamodb.BatchGetItemInput:
+		if in.ReturnConsumedCapacity == "" {
+			in.ReturnConsumedCapacity = level
+		}
+	case *dynamodb.BatchWriteItemInput:
+		if in.ReturnConsumedCapacity == "" {
+			in.ReturnConsumedCapacity = level
+		}
+	case *dynamodb.TransactWriteItemsInput:
+		if in.ReturnConsumedCapacity == "" {
+			in.ReturnConsumedCapacity = level
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// consumedCapacity returns the total capacity units reported in an
+// operation output, or 0 if it carries none.
+func consumedCapacity(result any) float64 {
+	total := 0.0
+	for _, c := range consumedCapacities(result) {
+		if c.CapacityUnits != nil {
+			total += *c.CapacityUnits
+		}
+	}
+	return total
+}
+
+// consumedCapacities returns the consumed capacity reported in an operation
+// output, one entry per table for batch and transaction operations.
+func consumedCapacities(result any) []types.ConsumedCapacity {
+	var caps []types.ConsumedCapacity
+	switch out := result.(type) {
+	case *dynamodb.GetItemOutput:
+		caps = singleCapacity(out.ConsumedCapacity)
+	case *dynamodb.PutItemOutput:
+		caps = singleCapacity(out.ConsumedCapacity)
+	case *dynamodb.UpdateItemOutput:
+		caps = singleCapacity(out.ConsumedCapacity)
+	case *dynamodb.DeleteItemOutput:
+		caps = singleCapacity(out.ConsumedCapacity)
+	case *dynamodb.QueryOutput:
+		caps = singleCapacity(out.ConsumedCapacity)
+	case *dynamodb.ScanOutput:
+		caps = singleCapacity(out.ConsumedCapacity)
+	case *dynamodb.BatchGetItemOutput:
+		caps = out.ConsumedCapacity
+	case *dynamodb.BatchWriteItemOutput:
+		caps = out.ConsumedCapacity
+	case *dynamodb.TransactWriteItemsOutput:
+		caps = out.ConsumedCapacity
+	}
+	return caps
+}
+
+func singleCapacity(c *types.ConsumedCapacity) []types.ConsumedCapacity {
+	if c == nil {
+		return nil
+	}
+	return []types.ConsumedCapacity{*c}
+}
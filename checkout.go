@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"dynamoDBExample/events"
+)
+
+// defaultLoanPeriod is how long a checkout lends a book for unless told
+// otherwise.
+const defaultLoanPeriod = 14 * 24 * time.Hour
+
+// checkoutWorkflow is the Workflow of the sagas of CheckoutBook.
+const checkoutWorkflow = "checkout"
+
+// sagaRetention is how long finished sagas are kept, to look into, before
+// TTL removes them.
+const sagaRetention = 7 * 24 * time.Hour
+
+// CheckoutStore holds the copies, loans and sagas of CheckoutBook.
+// DynamoDbBookRepository implements it. Every write must be idempotent, as
+// an interrupted saga repeats the step it was in when resumed.
+type CheckoutStore interface {
+	TakeCopy(ctx context.Context, bookID int, loanID string) error
+	PutBackCopy(ctx context.Context, bookID int, loanID string) error
+	CreateLoan(ctx context.Context, loan *Loan) error
+	DeleteLoan(ctx context.Context, loanID string) error
+	SaveSaga(ctx context.Context, saga *Saga) error
+	UnfinishedSagas(ctx context.Context, idleFor time.Duration) ([]*Saga, error)
+}
+
+// WithCheckout backs CheckoutBook with store, publishing a BookCheckedOut
+// event to publisher, if not nil, for every checkout. Without it
+// CheckoutBook fails with ErrCheckoutUnavailable.
+func WithCheckout(store CheckoutStore, publisher events.Publisher) BookUseCaseOption {
+	return func(uc *BookUseCase) {
+		uc.checkout = store
+		uc.checkoutEvents = publisher
+	}
+}
+
+// sagaStep is a step of a saga: do makes its write and undo, if not nil,
+// reverts it. Both must be safe to repeat, and undo to call when do failed
+// halfway or did not run at all.
+type sagaStep struct {
+	name string
+	do   func(ctx context.Context, saga *Saga) error
+	undo func(ctx context.Context, saga *Saga) error
+}
+
+// CheckoutBook lends a copy of the book to borrower for period, or
+// defaultLoanPeriod if 0. It is a saga of three steps, each saved in the
+// sagas table before the next starts: take an available copy, create the
+// loan, then publish a BookCheckedOut event. If a step fails, those done are
+// undone in reverse order and the error of the step is returned, e.g.
+// ErrNoCopyAvailable when every copy is on loan.
+//
+// If the saga cannot be saved or undone, the error says so and the saga is
+// left for ResumeCheckouts to finish: the checkout may then still succeed.
+func (uc *BookUseCase) CheckoutBook(ctx context.Context, bookID int, borrower string, period time.Duration) (loan *Loan, err error) {
+	ctx, end := uc.begin(ctx, "CheckoutBook")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "CheckoutBook"); err != nil {
+		return nil, err
+	}
+	if uc.checkout == nil {
+		return nil, ErrCheckoutUnavailable
+	}
+	if borrower == "" || len(borrower) > maxHolderLength {
+		return nil, fmt.Errorf("%w: borrower must be 1 to %d bytes", ErrValidation, maxHolderLength)
+	}
+	switch {
+	case period < 0:
+		return nil, fmt.Errorf("%w: loan period must be positive", ErrValidation)
+	case period == 0:
+		period = defaultLoanPeriod
+	}
+	if _, err := uc.repo.GetById(ctx, bookID); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("generate saga id: %w", err)
+	}
+	now := time.Now().UTC()
+	saga := &Saga{
+		Id:        hex.EncodeToString(id),
+		Workflow:  checkoutWorkflow,
+		Status:    SagaRunning,
+		BookId:    bookID,
+		Borrower:  borrower,
+		DueAt:     now.Add(period),
+		CreatedAt: now,
+	}
+	if err := uc.checkout.SaveSaga(ctx, saga); err != nil {
+		return nil, fmt.Errorf("start checkout: %w", err)
+	}
+	if err := uc.runSaga(ctx, saga, uc.checkoutSteps()); err != nil {
+		return nil, err
+	}
+	return sagaLoan(saga), nil
+}
+
+// checkoutSteps returns the steps of the sagas of CheckoutBook. The saga id
+// doubles as the loan id and the event id, so that repeated steps find what
+// they wrote before.
+func (uc *BookUseCase) checkoutSteps() []sagaStep {
+	return []sagaStep{
+		{
+			name: "take copy",
+			do: func(ctx context.Context, saga *Saga) error {
+				return uc.checkout.TakeCopy(ctx, saga.BookId, saga.Id)
+			},
+			undo: func(ctx context.Context, saga *Saga) error {
+				return uc.checkout.PutBackCopy(ctx, saga.BookId, saga.Id)
+			},
+		},
+		{
+			name: "create loan",
+			do: func(ctx context.Context, saga *Saga) error {
+				return uc.checkout.CreateLoan(ctx, sagaLoan(saga))
+			},
+			undo: func(ctx context.Context, saga *Saga) error {
+				return uc.checkout.DeleteLoan(ctx, saga.Id)
+			},
+		},
+		{
+			// A published event cannot be taken back; as the last step it
+			// is only undone if it failed, which there is nothing to undo.
+			name: "publish event",
+			do:   uc.publishCheckout,
+		},
+	}
+}
+
+// sagaLoan returns the loan made by the checkout saga.
+func sagaLoan(saga *Saga) *Loan {
+	return &Loan{Id: saga.Id, BookId: saga.BookId, Borrower: saga.Borrower, CreatedAt: saga.CreatedAt, DueAt: saga.DueAt}
+}
+
+// publishCheckout publishes the BookCheckedOut event of the saga, if the
+// use case has a publisher.
+func (uc *BookUseCase) publishCheckout(ctx context.Context, saga *Saga) error {
+	if uc.checkoutEvents == nil {
+		return nil
+	}
+	loan, err := json.Marshal(sagaLoan(saga))
+	if err != nil {
+		return err
+	}
+	return uc.checkoutEvents.Publish(ctx, events.Event{
+		ID:     saga.Id,
+		Type:   events.BookCheckedOut,
+		Time:   saga.CreatedAt,
+		BookID: saga.BookId,
+		Loan:   loan,
+	})
+}
+
+// runSaga advances saga through steps from its saved state, saving it after
+// every step, until it is finished. A step that fails makes the saga
+// compensate: the failed step, which may have been partly done, and the
+// steps before it are undone in reverse order. runSaga returns nil if the
+// saga completed, the error of the failed step if it was compensated, and
+// otherwise why it stopped unfinished.
+func (uc *BookUseCase) runSaga(ctx context.Context, saga *Saga, steps []sagaStep) error {
+	var cause error
+	if saga.Error != "" {
+		cause = errors.New(saga.Error)
+	}
+	for saga.Status == SagaRunning {
+		step := steps[saga.Step]
+		saga.Step++
+		if err := step.do(ctx, saga); err != nil {
+			cause = fmt.Errorf("checkout %s: %s: %w", saga.Id, step.name, err)
+			saga.Status, saga.Error = SagaCompensating, cause.Error()
+		} else if saga.Step == len(steps) {
+			saga.Status = SagaCompleted
+		}
+		if err := uc.saveSaga(ctx, saga); err != nil {
+			return errors.Join(cause, err)
+		}
+	}
+	for saga.Status == SagaCompensating {
+		if step := steps[saga.Step-1]; step.undo != nil {
+			if err := step.undo(ctx, saga); err != nil {
+				return errors.Join(cause, fmt.Errorf("compensate checkout %s: undo %s: %w", saga.Id, step.name, err))
+			}
+		}
+		if saga.Step--; saga.Step == 0 {
+			saga.Status = SagaCompensated
+		}
+		if err := uc.saveSaga(ctx, saga); err != nil {
+			return errors.Join(cause, err)
+		}
+	}
+	return cause
+}
+
+// saveSaga saves saga, setting its expiry once it is finished.
+func (uc *BookUseCase) saveSaga(ctx context.Context, saga *Saga) error {
+	if saga.Finished() {
+		expiresAt := time.Now().Add(sagaRetention)
+		saga.ExpiresAt = &expiresAt
+	}
+	if err := uc.checkout.SaveSaga(ctx, saga); err != nil {
+		return fmt.Errorf("save checkout %s: %w", saga.Id, err)
+	}
+	return nil
+}
+
+// ResumeCheckouts finishes the checkouts interrupted, e.g. by a crash, that
+// have not made progress for idleFor: running sagas go on from the step
+// they were in, compensating ones go on undoing. It returns how many it
+// finished; those it could not are left for the next call.
+func (uc *BookUseCase) ResumeCheckouts(ctx context.Context, idleFor time.Duration) (n int, err error) {
+	ctx, end := uc.begin(ctx, "ResumeCheckouts")
+	defer end(&err)
+	if uc.checkout == nil {
+		return 0, ErrCheckoutUnavailable
+	}
+	sagas, err := uc.checkout.UnfinishedSagas(ctx, idleFor)
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	for _, saga := range sagas {
+		if saga.Workflow != checkoutWorkflow {
+			continue
+		}
+		err := uc.runSaga(ctx, saga, uc.checkoutSteps())
+		if saga.Finished() {
+			n++
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	return n, errors.Join(errs...)
+}
+
+// RunCheckoutResumer calls ResumeCheckouts every interval until ctx is done,
+// resuming checkouts idle for as long. Failures are logged and retried at
+// the next interval.
+func (uc *BookUseCase) RunCheckoutResumer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n, err := uc.ResumeCheckouts(ctx, interval)
+		if err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "resume checkouts", "error", err)
+		}
+		if n > 0 {
+			slog.InfoContext(ctx, "resumed checkouts", "count", n)
+		}
+	}
+}
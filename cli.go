@@ -0,0 +1,1508 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"dynamoDBExample/config"
+	"dynamoDBExample/events"
+	"dynamoDBExample/lifecycle"
+	"dynamoDBExample/lock"
+	"dynamoDBExample/metrics"
+)
+
+const usage = `usage: dynamoDBExample [global flags] <command> [flags] [args]
+
+commands:
+  serve                       run the HTTP API, web UI (/ui), health probes and Prometheus metrics (/metrics)
+                              (and gRPC API with -grpc-addr)
+  books create                create a book
+  books get <id>|-isbn ISBN   show a book
+  books update <id>           change fields of a book
+  books delete [-soft] <id>   delete a book
+  books stock <id> <n>        add n copies of a book to those that can be checked out, or
+                              remove them if n is negative
+  books checkout -borrower B [-days N] <id>
+                              lend a copy of a book to B for N days (default 14)
+  books list [-sort name|author|created] [-order asc|desc]
+                              list all books, sorted if asked
+  books query [-limit N] <statement> [param...]
+                              run a PartiQL SELECT; integer params are numbers
+  books dedupe [-similarity S] [-merge]
+                              report books that look alike, or merge them into the lowest id
+  books import [file]         import books from a file or stdin
+  books export [file]         export all books to a file or stdout
+  books backup -bucket B      back up all books to S3 as gzipped NDJSON
+  books restore -bucket B -key K [-dry-run]
+                              restore books from an S3 backup
+  table describe              show the status, size, indexes, TTL and backups of the table
+  table upgrade [-rate N]     rewrite books stored in an older item version, at most N per second
+  table backfill-created [-at TIME]
+                              set the creation time of books stored without one to TIME (RFC
+                              3339), by default the creation time of the table, which no book
+                              predates; ages computed from it are upper bounds
+  table selftest              describe the table, then write, read and delete a temporary book,
+                              reporting which of these the credentials are allowed; fails if any
+                              is not
+  table backup [-name N]      take an on-demand backup of the table and wait until it is available
+  table export -bucket B [-prefix P] [-download FILE]
+                              export the table to S3 as of now and wait until it completes; with
+                              -download, convert the export to NDJSON in FILE ("-" for stdout);
+                              unlike books export or backup, both are consistent snapshots
+  table bench [-items N] [-ops N] [-workers N]
+                              benchmark the repository with and without its tuning options against
+                              a scratch table, e.g. in DynamoDB Local; needs DYNAMODB_ENDPOINT
+
+environment:
+  DATASTORE                   dynamodb (default) or postgres
+  DATABASE_URL                PostgreSQL connection string when DATASTORE=postgres
+  AWS_SECONDARY_REGION        region of the global table replica writes fail over to
+  EVENT_DELIVERY              sync, stream or outbox; serve relays the events of the outbox
+  AUTH_JWT_SECRET             HS256 secret of the bearer tokens serve requires; only librarians may delete
+  UPGRADE_ITEMS_ON_READ       true to write books read in an older item version back
+  WRITE_BUFFER_WINDOW         how long creates and updates wait to be batched into one BatchWriteItem
+  ROUTING_FILE                routes of the X-Book-Route header to tables, reloaded while serving
+  HTTP_MAX_IDLE_CONNS         idle connections kept open to DynamoDB; raise with BULK_WORKERS above 10
+  ID_GENERATOR, ID_NODE       snowflake to assign time-ordered ids to books created without one;
+                              every process creating books needs its own ID_NODE, 0 to 1023
+
+global flags:
+`
+
+// errUsage is returned for invalid command lines after usage has been printed.
+var errUsage = errors.New("invalid usage")
+
+// globalOptions are the settings shared by every command: the service
+// config, loaded from the file named by -config, the environment and the
+// global flags, plus the CLI's own flags.
+type globalOptions struct {
+	config.Config
+	output  string
+	otlp    bool
+	keyMode string
+	// capacity, if set, collects the capacity consumed by DynamoDB calls.
+	capacity *CapacityCollector
+	// dryRun, if set, records DynamoDB requests instead of sending them.
+	dryRun *DryRun
+}
+
+// envOr returns the value of the environment variable key, or def if unset.
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// runCLI parses args and runs the selected command, writing results to out.
+func runCLI(ctx context.Context, args []string, out io.Writer) error {
+	var g globalOptions
+	// flags receives the config flags; only those given on the command
+	// line override the loaded config.
+	var configPath string
+	flags := config.Default()
+	fs := flag.NewFlagSet("dynamoDBExample", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", os.Getenv(config.FileEnvVar), "JSON or YAML config file (env "+config.FileEnvVar+")")
+	fs.StringVar(&flags.Region, "region", flags.Region, "AWS region (env "+config.RegionEnvVar+")")
+	fs.StringVar(&flags.Table, "table", flags.Table, "book table name (env "+config.TableEnvVar+")")
+	fs.StringVar(&flags.Endpoint, "endpoint", flags.Endpoint, "custom DynamoDB endpoint, e.g. for dynamodb-local (env "+config.EndpointEnvVar+")")
+	fs.StringVar(&flags.LogLevel, "log-level", flags.LogLevel, "log level: debug, info, warn or error (env "+config.LogLevelEnvVar+")")
+	fs.StringVar(&flags.LogFormat, "log-format", flags.LogFormat, "log format: text or json (env "+config.LogFormatEnvVar+")")
+	fs.StringVar(&g.output, "output", envOr("BOOK_OUTPUT", "table"), "output format: table or json (env BOOK_OUTPUT)")
+	fs.StringVar(&g.keyMode, "key-mode", envOr("BOOK_KEY_MODE", string(KeyModeSimple)), "table key layout: simple (id) or composite (author, id) (env BOOK_KEY_MODE)")
+	fs.BoolVar(&g.otlp, "otlp", false, "export traces and metrics over OTLP/HTTP (configured via OTEL_EXPORTER_OTLP_* variables)")
+	costReport := fs.Bool("cost-report", false, "print the DynamoDB capacity consumed by the command and its estimated cost to stderr")
+	dryRun := fs.Bool("dry-run", false, "print the DynamoDB requests of the command to stderr as JSON instead of sending them")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "region":
+			cfg.Region = flags.Region
+		case "table":
+			cfg.Table = flags.Table
+		case "endpoint":
+			cfg.Endpoint = flags.Endpoint
+		case "log-level":
+			cfg.LogLevel = flags.LogLevel
+		case "log-format":
+			cfg.LogFormat = flags.LogFormat
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	g.Config = cfg
+	if g.output != "table" && g.output != "json" {
+		return fmt.Errorf("invalid output format %q", g.output)
+	}
+
+	logger, err := NewLogger(os.Stderr, g.LogLevel, g.LogFormat)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	if g.otlp {
+		shutdown, err := setupTelemetry(ctx)
+		if err != nil {
+			return fmt.Errorf("set up telemetry: %w", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), g.DrainTimeout.Duration)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				logger.Error("shut down telemetry", "error", err)
+			}
+		}()
+	}
+
+	rest := fs.Args()
+	if len(rest) == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	if *dryRun {
+		g.dryRun = NewDryRun(os.Stderr)
+	}
+	if *costReport {
+		g.capacity = NewCapacityCollector(DefaultPricing)
+		defer func() {
+			if err := printCostReport(os.Stderr, g.capacity.CostReport()); err != nil {
+				logger.Error("print cost report", "error", err)
+			}
+		}()
+	}
+	switch rest[0] {
+	case "serve":
+		return runServe(ctx, g, logger, rest[1:])
+	case "books":
+		return runBooks(ctx, g, logger, rest[1:], out)
+	case "table":
+		return runTable(ctx, g, logger, rest[1:], out)
+	}
+	fs.Usage()
+	return errUsage
+}
+
+// app holds the dependencies commands are built from.
+type app struct {
+	// repo is nil unless the datastore is DynamoDB in simple key mode. With
+	// a secondary region, it is the repository of the primary region.
+	repo *DynamoDbBookRepository
+	// regions routes the calls of the use case between the primary and the
+	// secondary region; nil without a secondary region.
+	regions *MultiRegionRepository
+	// routing sends the calls of the use case to the table of their route;
+	// nil without a routing file. repo is then the repository of the
+	// configured table, which commands other than serve use.
+	routing *RepositoryFactory
+	useCase *BookUseCase
+	// migrate creates or updates the schema of the selected datastore.
+	migrate func(ctx context.Context) error
+	// ready checks that the datastore is reachable, for /readyz.
+	ready ReadinessCheck
+	// describe reports the state of the DynamoDB table; nil for other
+	// datastores.
+	describe TableDescriber
+	// relay publishes the events of the outbox; nil unless events are
+	// delivered through it.
+	relay *OutboxRelay
+	// locker, if set, guards the jobs only one process may run at a time.
+	locker *lock.Locker
+	// registry holds the metrics serve exposes to Prometheus.
+	registry *metrics.Registry
+	close    func()
+}
+
+// errSimpleKeyOnly is returned by commands that need DynamoDbBookRepository.
+var errSimpleKeyOnly = errors.New("command requires the dynamodb datastore with -key-mode simple")
+
+// newApp builds the repository of the datastore selected by DATASTORE and
+// wires it, decorated, into a use case.
+func newApp(ctx context.Context, g globalOptions, logger *slog.Logger, opts ...BookUseCaseOption) (*app, error) {
+	a := &app{registry: metrics.NewRegistry(), close: func() {}}
+	var repo BookRepository
+	switch datastore := envOr(datastoreEnvVar, DatastoreDynamoDB); datastore {
+	case DatastoreDynamoDB:
+		var err error
+		if repo, err = a.useDynamoDB(ctx, g); err != nil {
+			return nil, err
+		}
+	case DatastorePostgres:
+		if g.dryRun != nil {
+			return nil, fmt.Errorf("-dry-run requires %s=%s", datastoreEnvVar, DatastoreDynamoDB)
+		}
+		if g.RoutingFile != "" {
+			return nil, fmt.Errorf("%s requires %s=%s", config.RoutingFileEnvVar, datastoreEnvVar, DatastoreDynamoDB)
+		}
+		url := os.Getenv(postgresURLEnvVar)
+		if url == "" {
+			return nil, fmt.Errorf("%s=%s requires %s", datastoreEnvVar, DatastorePostgres, postgresURLEnvVar)
+		}
+		pg, err := NewPostgresBookRepository(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		repo, a.migrate, a.close = pg, pg.Migrate, pg.Close
+		a.ready = pg.pool.Ping
+	default:
+		return nil, fmt.Errorf("unknown %s %q", datastoreEnvVar, datastore)
+	}
+
+	if a.routing != nil && (g.AuditLog != "" || publishesToOutbox(g) || g.SecondaryRegion != "") {
+		a.close()
+		return nil, fmt.Errorf("%s cannot be combined with %s, outbox delivery or %s",
+			config.RoutingFileEnvVar, config.AuditLogEnvVar, config.SecondaryRegionEnvVar)
+	}
+	if g.AuditLog != "" {
+		if a.repo == nil {
+			a.close()
+			return nil, fmt.Errorf("%s: %w", config.AuditLogEnvVar, errSimpleKeyOnly)
+		}
+		migrate := a.migrate
+		a.migrate = func(ctx context.Context) error {
+			if err := migrate(ctx); err != nil {
+				return err
+			}
+			return MigrateAudit(ctx, a.repo.client, g.Table)
+		}
+	}
+	if publishesToOutbox(g) {
+		if a.repo == nil {
+			a.close()
+			return nil, fmt.Errorf("%s=%s: %w", config.EventDeliveryEnvVar, config.EventDeliveryOutbox, errSimpleKeyOnly)
+		}
+		cfg, err := loadAWSConfig(ctx, g)
+		if err != nil {
+			a.close()
+			return nil, err
+		}
+		if a.relay, err = NewOutboxRelay(NewOutbox(a.repo), newEventPublisher(cfg, g.Config)); err != nil {
+			a.close()
+			return nil, err
+		}
+		migrate := a.migrate
+		a.migrate = func(ctx context.Context) error {
+			if err := migrate(ctx); err != nil {
+				return err
+			}
+			return MigrateOutbox(ctx, a.repo.client, g.Table)
+		}
+	}
+	if recorders := changeRecorders(g, a.repo); len(recorders) > 0 {
+		repo = NewRecordingRepository(a.repo, recorders...)
+	}
+
+	if g.SecondaryRegion != "" {
+		secondary, err := useSecondaryRegion(ctx, g)
+		if err != nil {
+			a.close()
+			return nil, err
+		}
+		policy := BreakerPolicy{Cooldown: g.BreakerCooldown.Duration, HalfOpenProbes: g.BreakerProbes}
+		a.regions = NewMultiRegionRepository(Region{Name: g.Region, Repo: repo, Ready: a.ready}, secondary, policy)
+		repo, a.ready = a.regions, a.regions.Ready
+	}
+
+	otelMetrics, err := Metrics(g.Table)
+	if err != nil {
+		a.close()
+		return nil, fmt.Errorf("instrument repository: %w", err)
+	}
+	promMetrics, err := Prometheus(a.registry, g.Table)
+	if err != nil {
+		a.close()
+		return nil, fmt.Errorf("instrument repository: %w", err)
+	}
+	requests, err := NewRequestMetrics(a.registry)
+	if err != nil {
+		a.close()
+		return nil, fmt.Errorf("instrument use case: %w", err)
+	}
+	opts = append([]BookUseCaseOption{WithImportWorkers(g.BulkWorkers), WithRequestMetrics(requests)}, opts...)
+	if g.IDGenerator == config.IDGeneratorSnowflake {
+		ids, err := NewSnowflakeGenerator(g.IDNode)
+		if err != nil {
+			a.close()
+			return nil, err
+		}
+		opts = append(opts, WithIDGenerator(ids))
+	}
+	if a.repo != nil && a.routing == nil {
+		// Idempotency records and loans are kept in the configured table
+		// only.
+		opts = append([]BookUseCaseOption{WithIdempotency(a.repo)}, opts...)
+		var publisher events.Publisher
+		if g.EventBus != "" || g.EventTopicARN != "" {
+			cfg, err := loadAWSConfig(ctx, g)
+			if err != nil {
+				a.close()
+				return nil, err
+			}
+			publisher = newEventPublisher(cfg, g.Config)
+		}
+		opts = append(opts, WithCheckout(a.repo, publisher))
+	}
+	if g.EventDelivery == config.EventDeliverySync && (g.EventBus != "" || g.EventTopicARN != "") {
+		cfg, err := loadAWSConfig(ctx, g)
+		if err != nil {
+			a.close()
+			return nil, err
+		}
+		opts = append(opts, WithChangeHandler(EventPublisher(newEventPublisher(cfg, g.Config))))
+	}
+	if g.SearchURL != "" {
+		index := NewOpenSearchIndex(g.SearchURL, g.SearchIndex)
+		opts = append(opts, WithSearch(index))
+		if g.SearchIndexing == config.SearchIndexingSync {
+			opts = append(opts, WithChangeHandler(index))
+		}
+		migrate := a.migrate
+		a.migrate = func(ctx context.Context) error {
+			if err := migrate(ctx); err != nil {
+				return err
+			}
+			return index.EnsureIndex(ctx)
+		}
+	}
+	mws := []RepositoryMiddleware{Logging(logger, g.Table), Tracing(g.Table), otelMetrics, promMetrics}
+	if g.BreakerThreshold > 0 {
+		// Inside metrics, so that rejected calls are counted as errors.
+		breaker, err := CircuitBreaking(g.Table, BreakerPolicy{
+			FailureThreshold: g.BreakerThreshold,
+			Cooldown:         g.BreakerCooldown.Duration,
+			HalfOpenProbes:   g.BreakerProbes,
+		})
+		if err != nil {
+			a.close()
+			return nil, fmt.Errorf("instrument circuit breaker: %w", err)
+		}
+		mws = append(mws, breaker)
+	}
+	if g.CoalesceWindow.Duration > 0 {
+		mws = append(mws, Coalescing(g.CoalesceWindow.Duration))
+	}
+	if window := g.WriteBufferWindow.Duration; window > 0 {
+		mws = append(mws, func(next BookRepository) BookRepository {
+			writer := NewBufferedWriter(next, window, logger)
+			closeRepo := a.close
+			a.close = func() {
+				// Commands cancel ctx on exit, so flush with a context of its own.
+				ctx, cancel := context.WithTimeout(context.Background(), g.ShutdownTimeout.Duration)
+				defer cancel()
+				if err := writer.Close(ctx); err != nil {
+					logger.Error("flush buffered writes", "error", err)
+				}
+				closeRepo()
+			}
+			return writer
+		})
+	}
+	if g.ReadRateLimit > 0 || g.WriteRateLimit > 0 {
+		// Inside logging and tracing, so that they include the time spent waiting.
+		mws = append(mws, RateLimit(RateLimits{
+			ReadsPerSecond:  g.ReadRateLimit,
+			WritesPerSecond: g.WriteRateLimit,
+			Mode:            RateLimitMode(g.RateLimitMode),
+		}))
+	}
+	faults := Faults{
+		Latency:          g.FaultLatency.Duration,
+		LatencyRate:      g.FaultLatencyRate,
+		ThrottleRate:     g.FaultThrottleRate,
+		ConditionRate:    g.FaultConditionRate,
+		PartialBatchRate: g.FaultPartialBatchRate,
+	}
+	if faults != (Faults{}) {
+		logger.Warn("injecting faults into repository calls", "faults", faults)
+		// Innermost, so that every other middleware sees the faults.
+		mws = append(mws, InjectFaults(faults))
+	}
+	repo = Chain(repo, mws...)
+	a.useCase = NewBookUseCase(repo, opts...)
+	return a, nil
+}
+
+// useDynamoDB loads the AWS configuration and builds the repository for the
+// selected key mode.
+func (a *app) useDynamoDB(ctx context.Context, g globalOptions) (BookRepository, error) {
+	cfg, err := loadAWSConfig(ctx, g)
+	if err != nil {
+		return nil, err
+	}
+	var clientOpts []func(*dynamodb.Options)
+	if g.Endpoint != "" {
+		clientOpts = append(clientOpts, endpointOption(g.Endpoint))
+	}
+	keyMode, err := ParseKeyMode(g.keyMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyMode == KeyModeComposite {
+		if g.RoutingFile != "" {
+			return nil, fmt.Errorf("%s: %w", config.RoutingFileEnvVar, errSimpleKeyOnly)
+		}
+		opts := []CompositeOption{WithCompositeClientOptions(clientOpts...), WithCompositeCallTimeout(g.CallTimeout.Duration)}
+		if g.capacity != nil {
+			opts = append(opts, WithCompositeCapacityCollector(g.capacity))
+		}
+		if g.dryRun != nil {
+			opts = append(opts, WithCompositeDryRun(g.dryRun))
+		}
+		composite := NewCompositeBookRepository(cfg, g.Table, opts...)
+		if a.locker, err = newLocker(composite.client, g.Table); err != nil {
+			return nil, err
+		}
+		a.migrate = func(ctx context.Context) error {
+			if err := MigrateComposite(ctx, composite.client, g.Table); err != nil {
+				return err
+			}
+			return MigrateLocks(ctx, composite.client, g.Table)
+		}
+		a.ready = tableReady(composite.client, g.Table)
+		a.describe = tableDescriber(composite.client, g.Table)
+		return composite, nil
+	}
+	opts := []RepositoryOption{
+		WithClientOptions(clientOpts...),
+		WithCallTimeout(g.CallTimeout.Duration),
+		WithBulkWorkers(g.BulkWorkers),
+		WithMarshalPooling(),
+		WithKeyTemplates(),
+	}
+	if g.MaxIdleConns > 0 {
+		opts = append(opts, WithConnectionPool(g.MaxIdleConns))
+	}
+	if g.capacity != nil {
+		opts = append(opts, WithCapacityCollector(g.capacity))
+	}
+	if g.dryRun != nil {
+		opts = append(opts, WithDryRun(g.dryRun))
+	}
+	if g.UpgradeItemsOnRead {
+		opts = append(opts, WithUpgradeOnRead())
+	}
+	a.repo = NewDynamoDBBookRepository(cfg, g.Table, opts...)
+	if a.locker, err = newLocker(a.repo.client, g.Table); err != nil {
+		return nil, err
+	}
+	a.ready = tableReady(a.repo.client, g.Table)
+	a.describe = tableDescriber(a.repo.client, g.Table)
+	a.migrate = func(ctx context.Context) error {
+		if err := Migrate(ctx, a.repo.client, g.Table); err != nil {
+			return err
+		}
+		if err := MigrateIdempotency(ctx, a.repo.client, g.Table); err != nil {
+			return err
+		}
+		if err := MigrateCounters(ctx, a.repo.client, g.Table); err != nil {
+			return err
+		}
+		if err := MigrateHolds(ctx, a.repo.client, g.Table); err != nil {
+			return err
+		}
+		if err := MigrateISBN(ctx, a.repo.client, g.Table); err != nil {
+			return err
+		}
+		if err := MigrateLoans(ctx, a.repo.client, g.Table); err != nil {
+			return err
+		}
+		if err := MigrateSagas(ctx, a.repo.client, g.Table); err != nil {
+			return err
+		}
+		return MigrateLocks(ctx, a.repo.client, g.Table)
+	}
+	if g.RoutingFile == "" {
+		return a.repo, nil
+	}
+	routing, err := config.LoadRouting(g.RoutingFile)
+	if err != nil {
+		return nil, err
+	}
+	// Routed tables are migrated like the configured one, with BOOK_TABLE.
+	a.routing, err = NewRepositoryFactory(routing, func(route config.Route) (BookRepository, error) {
+		cfg := cfg.Copy()
+		if route.Region != "" {
+			cfg.Region = route.Region
+		}
+		return NewDynamoDBBookRepository(cfg, route.Table, opts...), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewRoutedBookRepository(a.routing), nil
+}
+
+// useSecondaryRegion builds the repository of the global table replica in
+// the secondary region like useDynamoDB builds the primary's. Only the
+// primary table is migrated: replicas are created by adding their region to
+// the global table.
+func useSecondaryRegion(ctx context.Context, g globalOptions) (Region, error) {
+	if datastore := envOr(datastoreEnvVar, DatastoreDynamoDB); datastore != DatastoreDynamoDB {
+		return Region{}, fmt.Errorf("%s requires %s=%s", config.SecondaryRegionEnvVar, datastoreEnvVar, DatastoreDynamoDB)
+	}
+	g.Region = g.SecondaryRegion
+	secondary := &app{}
+	repo, err := secondary.useDynamoDB(ctx, g)
+	if err != nil {
+		return Region{}, err
+	}
+	if secondary.repo != nil {
+		if recorders := changeRecorders(g, secondary.repo); len(recorders) > 0 {
+			repo = NewRecordingRepository(secondary.repo, recorders...)
+		}
+	}
+	return Region{Name: g.Region, Repo: repo, Ready: secondary.ready}, nil
+}
+
+// publishesToOutbox reports whether g delivers events through the outbox.
+func publishesToOutbox(g globalOptions) bool {
+	return g.EventDelivery == config.EventDeliveryOutbox && (g.EventBus != "" || g.EventTopicARN != "")
+}
+
+// changeRecorders returns the recorders g configures for the changes of
+// books: the audit log if it is transactional and the outbox if events are
+// delivered through it. In a secondary region they write to the replicas of
+// the audit and outbox tables, which must be global tables too.
+func changeRecorders(g globalOptions, books *DynamoDbBookRepository) []ChangeRecorder {
+	var recorders []ChangeRecorder
+	if g.AuditLog == config.AuditLogTransactional {
+		recorders = append(recorders, NewAuditLog(books))
+	}
+	if publishesToOutbox(g) {
+		recorders = append(recorders, NewOutbox(books))
+	}
+	return recorders
+}
+
+func tableDescriber(client *dynamodb.Client, table string) TableDescriber {
+	return func(ctx context.Context) (*TableInfo, error) {
+		return DescribeBookTable(ctx, client, table)
+	}
+}
+
+func loadAWSConfig(ctx context.Context, g globalOptions) (aws.Config, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(g.Region))
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+	return cfg, nil
+}
+
+func runServe(ctx context.Context, g globalOptions, logger *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", g.HTTPAddr, "address the HTTP API listens on (env "+config.HTTPAddrEnvVar+")")
+	grpcAddr := fs.String("grpc-addr", g.GRPCAddr, "address the gRPC API listens on; empty disables it (env "+config.GRPCAddrEnvVar+")")
+	bootstrap := fs.Bool("bootstrap", false, "create or migrate the book table before serving")
+	readyTTL := fs.Duration("readiness-cache", defaultReadinessCacheTTL, "how long /readyz reuses a datastore check")
+	admin := fs.Bool("admin", false, "serve the admin API under /admin/ (DynamoDB only); keep it away from clients")
+	sweepHolds := fs.Duration("sweep-holds", 0, "how often to mark lapsed book holds expired, on one serving process at a time; 0 disables it (DynamoDB only)")
+	resumeCheckouts := fs.Duration("resume-checkouts", 0, "how often to resume checkouts interrupted for as long, on one serving process at a time; 0 disables it (DynamoDB only)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	changes := NewChangeBroadcaster()
+	opts := []BookUseCaseOption{WithChangeHandler(changes)}
+	auth := newAuthenticator(g)
+	if auth != nil {
+		opts = append(opts, WithAccessPolicy(DefaultAccessPolicy))
+	}
+	a, err := newApp(ctx, g, logger, opts...)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if *admin && a.describe == nil {
+		return fmt.Errorf("-admin requires %s=%s", datastoreEnvVar, DatastoreDynamoDB)
+	}
+	if *sweepHolds > 0 && a.repo == nil {
+		return fmt.Errorf("-sweep-holds: %w", errSimpleKeyOnly)
+	}
+	if *resumeCheckouts > 0 && a.useCase.checkout == nil {
+		return fmt.Errorf("-resume-checkouts: %w", errSimpleKeyOnly)
+	}
+	if *bootstrap {
+		if err := a.migrate(ctx); err != nil {
+			return fmt.Errorf("bootstrap table: %w", err)
+		}
+	}
+
+	health := NewHealthHandler(a.ready, *readyTTL)
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", health)
+	mux.Handle("/readyz", health)
+	mux.Handle("/openapi.json", OpenAPIHandler())
+	mux.Handle("/metrics", a.registry.Handler())
+	if *admin {
+		mux.Handle("/admin/", protect(auth, AdminHandler(a.describe)))
+	}
+	mux.Handle("/", http.TimeoutHandler(protect(auth, ValidateRequests(NewBookHandler(a.useCase))), g.RequestTimeout.Duration, `{"type":"about:blank","title":"Service Unavailable","status":503,"detail":"request timed out","code":"timeout"}`))
+	ui := http.TimeoutHandler(protect(auth, NewUIHandler(a.useCase)), g.RequestTimeout.Duration, "request timed out")
+	mux.Handle("/ui", ui)
+	mux.Handle("/ui/", ui)
+
+	// Telemetry is flushed by runCLI after the servers and workers stop.
+	lc := lifecycle.New(logger, g.DrainTimeout.Duration)
+	lc.Serve("http server", func(ctx context.Context) error {
+		return serveHTTP(ctx, *addr, mux, g.ShutdownTimeout.Duration)
+	})
+	if *grpcAddr != "" {
+		lc.Serve("grpc server", func(ctx context.Context) error {
+			return serveGRPC(ctx, *grpcAddr, NewGRPCServer(a.useCase, changes, logger, auth), g.ShutdownTimeout.Duration)
+		})
+	}
+	if a.relay != nil {
+		lc.Work("outbox relay", func(ctx context.Context) error {
+			a.relay.Run(ctx, g.OutboxPollInterval.Duration)
+			return nil
+		})
+	}
+	if a.regions != nil {
+		lc.Work("region prober", func(ctx context.Context) error {
+			a.regions.RunProbes(ctx, g.ProbeInterval.Duration)
+			return nil
+		})
+	}
+	if a.routing != nil {
+		lc.Work("routing reloader", func(ctx context.Context) error {
+			a.routing.Watch(ctx, FileRoutingSource(g.RoutingFile), g.RoutingReloadInterval.Duration, logger)
+			return nil
+		})
+	}
+	if *sweepHolds > 0 {
+		// Only the process holding the lock sweeps; the others take over
+		// if it stops.
+		lc.Work("hold sweeper", func(ctx context.Context) error {
+			a.locker.Lead(ctx, holdSweeperLock, *sweepHolds, func(ctx context.Context) {
+				a.repo.RunHoldSweeper(ctx, *sweepHolds)
+			})
+			return nil
+		})
+	}
+	if *resumeCheckouts > 0 {
+		lc.Work("checkout resumer", func(ctx context.Context) error {
+			a.locker.Lead(ctx, checkoutResumerLock, *resumeCheckouts, func(ctx context.Context) {
+				a.useCase.RunCheckoutResumer(ctx, *resumeCheckouts)
+			})
+			return nil
+		})
+	}
+	return lc.Run(ctx)
+}
+
+// newAuthenticator returns the authenticator of API requests configured by
+// AUTH_JWT_SECRET, or nil if authentication is disabled.
+func newAuthenticator(g globalOptions) Authenticator {
+	if g.AuthJWTSecret == "" {
+		return nil
+	}
+	return NewJWTAuthenticator([]byte(g.AuthJWTSecret), g.AuthIssuer, g.AuthAudience)
+}
+
+// protect makes h require authentication by auth, if it is not nil.
+func protect(auth Authenticator, h http.Handler) http.Handler {
+	if auth == nil {
+		return h
+	}
+	return Authenticate(auth, h)
+}
+
+func runBooks(ctx context.Context, g globalOptions, logger *slog.Logger, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errUsage
+	}
+	cmd, args := args[0], args[1:]
+	fs := flag.NewFlagSet("books "+cmd, flag.ContinueOnError)
+	var book Book
+	var format string
+	var segments int
+	var sorted bool
+	var flushSize int
+	var progress bool
+	var soft, dryRun bool
+	var bucket, key string
+	var limit int
+	var listOpts ListOptions
+	var similarity float64
+	var merge bool
+	var borrower string
+	var days int
+	switch cmd {
+	case "create":
+		fs.IntVar(&book.Id, "id", 0, "book id; omit it to have one assigned with "+config.IDGeneratorEnvVar)
+		fallthrough
+	case "update":
+		fs.StringVar(&book.Name, "name", "", "book name")
+		fs.StringVar(&book.Author, "author", "", "book author")
+		fs.StringVar(&book.ISBN, "isbn", "", "book ISBN-10 or ISBN-13")
+	case "export":
+		fs.IntVar(&segments, "segments", 1, "number of parallel scan segments; use more for large tables")
+		fs.BoolVar(&sorted, "sorted", false, "write the books sorted by id, holding them all in memory, so that exports of the same data are identical")
+		fallthrough
+	case "import":
+		if cmd == "import" {
+			fs.IntVar(&flushSize, "flush-size", batchWriteLimit, "number of books written per batch, at most 25")
+		}
+		fs.StringVar(&format, "format", "ndjson", "file format: csv or ndjson")
+		fs.BoolVar(&progress, "progress", false, "report the number of books transferred so far on stderr")
+	case "delete":
+		fs.BoolVar(&soft, "soft", false, "mark the book deleted and let the table TTL remove it later")
+	case "restore":
+		fs.BoolVar(&dryRun, "dry-run", false, "read and validate the backup without writing any book")
+		fallthrough
+	case "backup":
+		fs.StringVar(&bucket, "bucket", "", "S3 bucket of the backup")
+		fs.StringVar(&key, "key", "", "object key of the backup (backup default: <table>/<UTC time>.ndjson.gz)")
+	case "query":
+		fs.IntVar(&limit, "limit", 0, "stop after this many books; 0 means all")
+	case "list":
+		fs.StringVar((*string)(&listOpts.SortBy), "sort", "", "sort by name, author, created or year")
+		fs.StringVar((*string)(&listOpts.Order), "order", "", "sort order: asc or desc")
+	case "dedupe":
+		fs.Float64Var(&similarity, "similarity", DefaultNameSimilarity, "how alike, from 0 to 1, normalized names of the same author must be to count as duplicates")
+		fs.BoolVar(&merge, "merge", false, "merge every group into its lowest id; without it the groups are only reported")
+	case "get":
+		fs.StringVar(&book.ISBN, "isbn", "", "show the book with this ISBN instead of giving an id")
+	case "checkout":
+		fs.StringVar(&borrower, "borrower", "", "id of the borrower")
+		fs.IntVar(&days, "days", 0, "loan period in days; 0 means 14")
+	case "stock":
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return errUsage
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	var id int
+	byISBN := cmd == "get" && book.ISBN != ""
+	if byISBN && fs.NArg() != 0 {
+		return fmt.Errorf("books get: expected a book id or -isbn, not both")
+	}
+	if !byISBN && (cmd == "get" || cmd == "update" || cmd == "delete" || cmd == "checkout" || cmd == "stock") {
+		if cmd == "stock" && fs.NArg() != 2 {
+			return fmt.Errorf("books stock: expected a book id and a number of copies")
+		}
+		if cmd != "stock" && fs.NArg() != 1 {
+			return fmt.Errorf("books %s: expected exactly one book id", cmd)
+		}
+		n, err := strconv.Atoi(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("books %s: invalid book id %q", cmd, fs.Arg(0))
+		}
+		id = n
+	}
+	if cmd == "query" && fs.NArg() == 0 {
+		return fmt.Errorf("books query: expected a PartiQL statement")
+	}
+
+	if cmd == "import" || cmd == "export" {
+		if progress {
+			ctx = WithProgress(ctx, progressPrinter(os.Stderr, cmd+"ed %d books\n", time.Second))
+		}
+		var opts []TransferOption
+		if sorted {
+			opts = append(opts, WithSortedExport())
+		}
+		if cmd == "import" {
+			opts = append(opts, WithFlushSize(flushSize))
+		}
+		return runTransfer(ctx, g, logger, cmd, format, segments, opts, fs.Arg(0))
+	}
+	if cmd == "backup" || cmd == "restore" {
+		return runSnapshot(ctx, g, logger, cmd, bucket, key, dryRun)
+	}
+
+	a, err := newApp(ctx, g, logger)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	uc := a.useCase
+	switch cmd {
+	case "create":
+		if err := uc.createBook(ctx, &book, ""); err != nil {
+			return err
+		}
+		return printBooks(out, g.output, &book)
+	case "get":
+		var found *Book
+		if byISBN {
+			found, err = uc.GetByISBN(ctx, book.ISBN)
+		} else {
+			found, err = uc.GetById(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+		return printBooks(out, g.output, found)
+	case "update":
+		// Read consistently so the update does not start from a stale version.
+		current, err := uc.GetById(ctx, id, WithConsistentRead())
+		if err != nil {
+			return err
+		}
+		// Only change the fields given on the command line.
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "name":
+				current.Name = book.Name
+			case "author":
+				current.Author = book.Author
+			case "isbn":
+				current.ISBN = book.ISBN
+			}
+		})
+		if err := uc.Update(ctx, current); err != nil {
+			return err
+		}
+		return printBooks(out, g.output, current)
+	case "delete":
+		if soft {
+			if a.repo == nil {
+				return errSimpleKeyOnly
+			}
+			return a.repo.SoftDelete(ctx, id)
+		}
+		return uc.Delete(ctx, id)
+	case "query":
+		if a.repo == nil {
+			return errSimpleKeyOnly
+		}
+		books, err := queryPartiQL(ctx, a.repo, limit, fs.Arg(0), fs.Args()[1:])
+		if err != nil {
+			return err
+		}
+		return printBooks(out, g.output, books...)
+	case "dedupe":
+		return runDedupe(ctx, a, out, g.output, similarity, merge)
+	case "stock":
+		if a.repo == nil {
+			return errSimpleKeyOnly
+		}
+		n, err := strconv.Atoi(fs.Arg(1))
+		if err != nil {
+			return fmt.Errorf("books stock: invalid number of copies %q", fs.Arg(1))
+		}
+		if _, err := uc.GetById(ctx, id); err != nil {
+			return err
+		}
+		available, err := a.repo.AddCopies(ctx, id, n)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d copies of book %d available\n", available, id)
+		return nil
+	case "checkout":
+		loan, err := uc.CheckoutBook(ctx, id, borrower, time.Duration(days)*24*time.Hour)
+		if err != nil {
+			return err
+		}
+		return printLoan(out, g.output, loan)
+	default: // list
+		books, _, err := uc.List(ctx, listOpts)
+		if err != nil {
+			return err
+		}
+		return printBooks(out, g.output, books...)
+	}
+}
+
+// queryPartiQL runs stmt with the command-line params, reading pages until
+// limit books have been found or, if limit is 0, the result is exhausted.
+// Params that parse as integers are passed as numbers, others as strings.
+func queryPartiQL(ctx context.Context, repo *DynamoDbBookRepository, limit int, stmt string, args []string) ([]*Book, error) {
+	params := make([]any, len(args))
+	for i, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil {
+			params[i] = n
+		} else {
+			params[i] = arg
+		}
+	}
+	books := []*Book{}
+	cursor := ""
+	for {
+		page, next, err := repo.QueryPartiQLPage(ctx, stmt, limit-len(books), cursor, params...)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, page...)
+		if next == "" || limit > 0 && len(books) >= limit {
+			return books, nil
+		}
+		cursor = next
+	}
+}
+
+func runTable(ctx context.Context, g globalOptions, logger *slog.Logger, args []string, out io.Writer) error {
+	if len(args) == 0 || !slices.Contains([]string{"describe", "upgrade", "backfill-created", "selftest", "backup", "export", "bench"}, args[0]) {
+		fmt.Fprint(os.Stderr, usage)
+		return errUsage
+	}
+	cmd, args := args[0], args[1:]
+	fs := flag.NewFlagSet("table "+cmd, flag.ContinueOnError)
+	var rate float64
+	var createdAt string
+	var snapshot snapshotOptions
+	bench, workers := DefaultBenchmarkOptions, 32
+	switch cmd {
+	case "upgrade":
+		fs.Float64Var(&rate, "rate", 100, "maximum books rewritten per second; 0 means no limit")
+	case "backfill-created":
+		fs.StringVar(&createdAt, "at", "", "RFC 3339 creation time to set; defaults to the creation time of the table")
+	case "backup":
+		fs.StringVar(&snapshot.name, "name", "", "backup name; defaults to the table name and the time")
+		fs.DurationVar(&snapshot.poll, "poll", defaultSnapshotPollInterval, "how often to check whether the backup is done")
+	case "export":
+		fs.StringVar(&snapshot.bucket, "bucket", "", "S3 bucket to export to")
+		fs.StringVar(&snapshot.prefix, "prefix", "", "key prefix of the export in the bucket")
+		fs.StringVar(&snapshot.download, "download", "", "file to write the exported books to as NDJSON; - for stdout")
+		fs.DurationVar(&snapshot.poll, "poll", defaultSnapshotPollInterval, "how often to check whether the export is done")
+	case "bench":
+		fs.IntVar(&bench.Items, "items", bench.Items, "books written by BatchCreate and read by List")
+		fs.IntVar(&bench.Ops, "ops", bench.Ops, "books written by Create and read by GetById")
+		fs.IntVar(&workers, "workers", workers, "bulk workers of BatchCreate")
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 0 || rate < 0 || bench.Items < 0 || bench.Ops < 0 || workers < 1 || snapshot.poll < 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errUsage
+	}
+	if cmd == "bench" {
+		return runBench(ctx, g, bench, workers, out)
+	}
+	a, err := newApp(ctx, g, logger)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	if cmd == "backup" || cmd == "export" {
+		if a.repo == nil {
+			return errSimpleKeyOnly
+		}
+		return runTableSnapshot(ctx, g, a.repo, cmd, snapshot, out)
+	}
+	if cmd == "upgrade" {
+		if a.repo == nil {
+			return errSimpleKeyOnly
+		}
+		stats, err := a.repo.UpgradeItems(ctx, rate)
+		fmt.Fprintf(os.Stderr, "scanned %d books, upgraded %d to item version %d, %d changed meanwhile\n",
+			stats.Scanned, stats.Upgraded, currentItemVersion, stats.Changed)
+		return err
+	}
+	if cmd == "selftest" {
+		if a.repo == nil {
+			return errSimpleKeyOnly
+		}
+		return runSelfTest(ctx, a.repo, out)
+	}
+	if cmd == "backfill-created" {
+		if a.repo == nil {
+			return errSimpleKeyOnly
+		}
+		return runBackfillCreated(ctx, a, createdAt)
+	}
+	if a.describe == nil {
+		return fmt.Errorf("table describe requires %s=%s", datastoreEnvVar, DatastoreDynamoDB)
+	}
+	info, err := a.describe(ctx)
+	if err != nil {
+		return err
+	}
+	return printTableInfo(out, g.output, info)
+}
+
+// runSelfTest runs the self-test of repo and prints the outcome of each
+// step. It fails if a step was denied or the test was aborted.
+func runSelfTest(ctx context.Context, repo *DynamoDbBookRepository, out io.Writer) error {
+	report, err := repo.SelfTest(ctx)
+	var denied []string
+	for _, step := range []struct {
+		name string
+		ok   bool
+	}{
+		{"describe", report.Describe},
+		{"write", report.Write},
+		{"read", report.Read},
+		{"delete", report.Delete},
+	} {
+		switch deniedErr, isDenied := report.Denied[step.name]; {
+		case step.ok:
+			fmt.Fprintf(out, "%-9s ok\n", step.name)
+		case isDenied:
+			denied = append(denied, step.name)
+			fmt.Fprintf(out, "%-9s denied: %v\n", step.name, deniedErr)
+		default:
+			fmt.Fprintf(out, "%-9s not run\n", step.name)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("self-test aborted: %w", err)
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("self-test failed: %s denied", strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// runBackfillCreated sets the creation time of the books stored without one
+// to at, or to the creation time of the table if at is empty.
+func runBackfillCreated(ctx context.Context, a *app, at string) error {
+	var created time.Time
+	if at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return fmt.Errorf("table backfill-created: -at: %w", err)
+		}
+		created = t
+	} else {
+		info, err := a.describe(ctx)
+		if err != nil {
+			return err
+		}
+		if info.CreatedAt == nil {
+			return fmt.Errorf("table backfill-created: the table has no creation time; give -at")
+		}
+		created = *info.CreatedAt
+	}
+	n := 0
+	err := a.repo.BackfillCreatedAt(ctx, func(*Book) (time.Time, bool) {
+		n++
+		return created, true
+	})
+	fmt.Fprintf(os.Stderr, "set the creation time of %d books to %s\n", n, created.UTC().Format(time.RFC3339))
+	return err
+}
+
+// snapshotOptions are the flags of table backup and table export.
+type snapshotOptions struct {
+	name, bucket, prefix, download string
+	poll                           time.Duration
+}
+
+// runTableSnapshot takes an on-demand backup of the table, or exports it to
+// S3 and, with -download, converts the export to NDJSON.
+func runTableSnapshot(ctx context.Context, g globalOptions, repo *DynamoDbBookRepository, cmd string, opts snapshotOptions, out io.Writer) error {
+	if cmd == "backup" {
+		name := opts.name
+		if name == "" {
+			name = g.Table + "-" + time.Now().UTC().Format("20060102T150405Z")
+		}
+		fmt.Fprintf(os.Stderr, "backing up table %s as %s\n", g.Table, name)
+		backup, err := repo.BackupTable(ctx, name, opts.poll)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "backup %s is available, %d bytes\n", name, aws.ToInt64(backup.BackupSizeBytes))
+		fmt.Fprintln(out, aws.ToString(backup.BackupArn))
+		return nil
+	}
+	if opts.bucket == "" {
+		return fmt.Errorf("table export: -bucket is required")
+	}
+	fmt.Fprintf(os.Stderr, "exporting table %s to s3://%s/%s\n", g.Table, opts.bucket, opts.prefix)
+	export, err := repo.ExportTable(ctx, opts.bucket, opts.prefix, opts.poll)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "export completed, %d items, manifest s3://%s/%s\n",
+		aws.ToInt64(export.ItemCount), opts.bucket, aws.ToString(export.ExportManifest))
+	if opts.download == "" {
+		fmt.Fprintln(out, aws.ToString(export.ExportArn))
+		return nil
+	}
+	cfg, err := loadAWSConfig(ctx, g)
+	if err != nil {
+		return err
+	}
+	w := out
+	var file *os.File
+	if opts.download != "-" {
+		if file, err = os.Create(opts.download); err != nil {
+			return err
+		}
+		w = file
+	}
+	n, err := repo.ConvertExport(ctx, NewS3SnapshotStore(cfg, opts.bucket), export, w)
+	if file != nil {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	fmt.Fprintf(os.Stderr, "converted %d books\n", n)
+	return err
+}
+
+// benchTableSuffix names the scratch table of table bench after the
+// configured one.
+const benchTableSuffix = "-bench"
+
+// runBench runs the repository benchmarks twice, with the SDK defaults and
+// with the tuning options, and prints both side by side. It refuses to run
+// without a custom endpoint so that it does not create tables in an AWS
+// account by accident.
+func runBench(ctx context.Context, g globalOptions, opts BenchmarkOptions, workers int, out io.Writer) error {
+	if g.Endpoint == "" {
+		return fmt.Errorf("table bench requires %s, e.g. http://localhost:8000 for DynamoDB Local", config.EndpointEnvVar)
+	}
+	cfg, err := loadAWSConfig(ctx, g)
+	if err != nil {
+		return err
+	}
+	base := []RepositoryOption{
+		WithEndpoint(g.Endpoint),
+		WithCallTimeout(g.CallTimeout.Duration),
+		WithBulkWorkers(workers),
+	}
+	tuned := append(base[:len(base):len(base)], WithConnectionPool(workers), WithMarshalPooling(), WithKeyTemplates())
+	table := g.Table + benchTableSuffix
+	var runs [2][]BenchmarkResult
+	for i, repoOpts := range [][]RepositoryOption{base, tuned} {
+		fmt.Fprintf(os.Stderr, "benchmarking %s repository against table %s\n", []string{"default", "tuned"}[i], table)
+		if runs[i], err = NewDynamoDBBookRepository(cfg, table, repoOpts...).Benchmark(ctx, opts); err != nil {
+			return err
+		}
+	}
+	return printBenchmarks(out, g.output, runs[0], runs[1])
+}
+
+func printBenchmarks(out io.Writer, format string, before, after []BenchmarkResult) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string][]BenchmarkResult{"default": before, "tuned": after})
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "BENCHMARK\tOPS\tNS/OP\tTUNED NS/OP\tDELTA\tALLOCS/OP\tTUNED ALLOCS/OP\tB/OP\tTUNED B/OP\t")
+	for i, b := range before {
+		a := after[i]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%d\t%d\t%d\t%d\t\n", b.Name, b.Ops,
+			b.NsPerOp, a.NsPerOp, percentChange(b.NsPerOp, a.NsPerOp),
+			b.AllocsPerOp, a.AllocsPerOp, b.BytesPerOp, a.BytesPerOp)
+	}
+	return tw.Flush()
+}
+
+// percentChange formats the change from before to after, e.g. -12.5%.
+func percentChange(before, after int64) string {
+	if before == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", float64(after-before)/float64(before)*100)
+}
+
+// runTransfer imports books from, or exports them to, path. An empty path or
+// "-" means stdin or stdout. Imports into DynamoDB hold the import lock, so
+// that only one runs at a time. Exports with more than one segment use a
+// parallel scan. The number of books is reported on stderr so
+// that exports to stdout stay clean.
+func runTransfer(ctx context.Context, g globalOptions, logger *slog.Logger, cmd, format string, segments int, opts []TransferOption, path string) error {
+	f, err := ParseFormat(format)
+	if err != nil {
+		return err
+	}
+	a, err := newApp(ctx, g, logger)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	var n int
+	if cmd == "import" {
+		var r io.Reader = os.Stdin
+		if path != "" && path != "-" {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			r = file
+		}
+		importBooks := func(ctx context.Context) error {
+			var err error
+			n, err = a.useCase.ImportBooks(ctx, r, f, opts...)
+			return err
+		}
+		if a.locker == nil {
+			err = importBooks(ctx)
+		} else if err = a.locker.Do(ctx, importLock, importBooks); errors.Is(err, lock.ErrHeld) {
+			err = fmt.Errorf("another import is running: %w", err)
+		}
+	} else {
+		var w io.Writer = os.Stdout
+		var file *os.File
+		if path != "" && path != "-" {
+			if file, err = os.Create(path); err != nil {
+				return err
+			}
+			w = file
+		}
+		switch {
+		case segments <= 1:
+			n, err = a.useCase.ExportBooks(ctx, w, f, opts...)
+		case a.repo == nil:
+			err = errSimpleKeyOnly
+		default:
+			n, err = a.repo.ExportBooks(ctx, w, f, segments, opts...)
+		}
+		if file != nil {
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%sed %d books\n", cmd, n)
+	return err
+}
+
+// runSnapshot backs the books up to, or restores them from, the S3 object
+// key in bucket. The outcome is reported on stderr.
+func runSnapshot(ctx context.Context, g globalOptions, logger *slog.Logger, cmd, bucket, key string, dryRun bool) error {
+	if bucket == "" {
+		return fmt.Errorf("books %s: -bucket is required", cmd)
+	}
+	if key == "" {
+		if cmd == "restore" {
+			return fmt.Errorf("books restore: -key is required")
+		}
+		key = snapshotKey(g.Table, time.Now())
+	}
+	cfg, err := loadAWSConfig(ctx, g)
+	if err != nil {
+		return err
+	}
+	a, err := newApp(ctx, g, logger)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	store := NewS3SnapshotStore(cfg, bucket)
+	var n int
+	switch {
+	case cmd == "backup":
+		n, err = a.useCase.BackupBooks(ctx, store, key)
+		fmt.Fprintf(os.Stderr, "backed up %d books to s3://%s/%s\n", n, bucket, key)
+	case dryRun:
+		n, err = a.useCase.RestoreBooks(ctx, store, key, true)
+		fmt.Fprintf(os.Stderr, "dry run: would restore %d books from s3://%s/%s\n", n, bucket, key)
+	default:
+		n, err = a.useCase.RestoreBooks(ctx, store, key, false)
+		fmt.Fprintf(os.Stderr, "restored %d books from s3://%s/%s\n", n, bucket, key)
+	}
+	return err
+}
+
+// progressPrinter returns a ProgressFunc printing the count with format to w
+// at most once per interval.
+func progressPrinter(w io.Writer, format string, interval time.Duration) ProgressFunc {
+	var last time.Time
+	return func(done int) {
+		if now := time.Now(); now.Sub(last) >= interval {
+			last = now
+			fmt.Fprintf(w, format, done)
+		}
+	}
+}
+
+// dedupeResult is the outcome of `books dedupe` for one group.
+type dedupeResult struct {
+	DuplicateGroup
+	Merged bool   `json:"merged"`
+	Error  string `json:"error,omitempty"`
+}
+
+// runDedupe reports the groups of duplicate books and, with merge, merges
+// each into its book to keep. Groups failing to merge, e.g. because a book
+// changed meanwhile, are reported and leave the others unaffected.
+func runDedupe(ctx context.Context, a *app, out io.Writer, format string, similarity float64, merge bool) error {
+	if similarity <= 0 || similarity > 1 {
+		return fmt.Errorf("books dedupe: -similarity must be above 0 and at most 1")
+	}
+	if merge && a.repo == nil {
+		return errSimpleKeyOnly
+	}
+	dedupe := func(ctx context.Context) error {
+		books, _, err := a.useCase.List(ctx, ListOptions{}, WithConsistentRead())
+		if err != nil {
+			return err
+		}
+		groups := FindDuplicates(books, similarity)
+		results := make([]dedupeResult, len(groups))
+		failed := 0
+		for i, group := range groups {
+			results[i].DuplicateGroup = group
+			if !merge {
+				continue
+			}
+			if _, err := a.repo.MergeDuplicates(ctx, group); err != nil {
+				results[i].Error = err.Error()
+				failed++
+				continue
+			}
+			results[i].Merged = true
+		}
+		if err := printDuplicates(out, format, results); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d groups could not be merged", failed, len(groups))
+		}
+		return nil
+	}
+	if !merge || a.locker == nil {
+		return dedupe(ctx)
+	}
+	err := a.locker.Do(ctx, dedupeLock, dedupe)
+	if errors.Is(err, lock.ErrHeld) {
+		err = fmt.Errorf("another dedupe is running: %w", err)
+	}
+	return err
+}
+
+func printDuplicates(out io.Writer, format string, results []dedupeResult) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEEP\tID\tNAME\tAUTHOR\tACTION")
+	for _, r := range results {
+		action := "would merge"
+		switch {
+		case r.Merged:
+			action = "merged"
+		case r.Error != "":
+			action = "failed: " + r.Error
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\tkeep\n", r.Keep.Id, r.Keep.Id, r.Keep.Name, r.Keep.Author)
+		for _, b := range r.Duplicates {
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n", r.Keep.Id, b.Id, b.Name, b.Author, action)
+		}
+	}
+	return tw.Flush()
+}
+
+// printBooks writes books as an aligned table or as JSON. A single book is
+// printed as a JSON object, several as an array.
+func printBooks(out io.Writer, format string, books ...*Book) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if len(books) == 1 {
+			return enc.Encode(books[0])
+		}
+		return enc.Encode(books)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tAUTHOR\tVERSION\tTAGS")
+	for _, b := range books {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\n", b.Id, b.Name, b.Author, b.Version, strings.Join(b.Tags, ","))
+	}
+	return tw.Flush()
+}
+
+func printLoan(out io.Writer, format string, loan *Loan) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(loan)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LOAN\tBOOK\tBORROWER\tDUE")
+	fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", loan.Id, loan.BookId, loan.Borrower, loan.DueAt.Format(time.RFC3339))
+	return tw.Flush()
+}
+
+func printTableInfo(out io.Writer, format string, info *TableInfo) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "TABLE\t%s\n", info.Name)
+	fmt.Fprintf(tw, "STATUS\t%s\n", info.Status)
+	fmt.Fprintf(tw, "ITEMS\t%d\n", info.ItemCount)
+	fmt.Fprintf(tw, "SIZE\t%d bytes\n", info.SizeBytes)
+	billing := info.BillingMode
+	if t := info.Throughput; t != nil {
+		billing += fmt.Sprintf(" (%d RCU, %d WCU)", t.ReadCapacityUnits, t.WriteCapacityUnits)
+	}
+	fmt.Fprintf(tw, "BILLING\t%s\n", billing)
+	fmt.Fprintf(tw, "STREAM\t%s\n", orNone(info.StreamViewType))
+	fmt.Fprintf(tw, "REPLICAS\t%s\n", orNone(strings.Join(info.Replicas, ",")))
+	fmt.Fprintf(tw, "DELETION PROTECTION\t%t\n", info.DeletionProtection)
+	ttl := info.TTLStatus
+	if info.TTLAttribute != "" {
+		ttl += " on " + info.TTLAttribute
+	}
+	fmt.Fprintf(tw, "TTL\t%s\n", ttl)
+	fmt.Fprintf(tw, "CONTINUOUS BACKUPS\t%s\n", info.ContinuousBackups)
+	pitr := info.PointInTimeRecovery
+	if info.EarliestRestore != nil && info.LatestRestore != nil {
+		pitr += fmt.Sprintf(" (%s to %s)", info.EarliestRestore.UTC().Format(time.RFC3339), info.LatestRestore.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(tw, "POINT IN TIME RECOVERY\t%s\n", pitr)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(info.Indexes) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(out)
+	tw = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tSTATUS\tITEMS\tSIZE\tBACKFILLING")
+	for _, index := range info.Indexes {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%t\n", index.Name, index.Status, index.ItemCount, index.SizeBytes, index.Backfilling)
+	}
+	return tw.Flush()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
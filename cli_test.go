@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRunTableRejectsUnknownFlags(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, args := range [][]string{{"describe", "-bogus"}, {"upgrade", "-rate", "fast"}, {"selftest", "extra"}} {
+		err := runTable(context.Background(), globalOptions{}, logger, args, io.Discard)
+		if !errors.Is(err, errUsage) {
+			t.Errorf("table %v: got %v, want errUsage", args, err)
+		}
+	}
+}
+
+func TestRunSelfTestPrintsEveryStepAndFailsOnDenials(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		denied   string
+		wantErr  bool
+		wantLine string
+	}{
+		{"all granted", "", false, "write     ok"},
+		{"write denied", "PutItem", true, "write     denied: "},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+				switch op {
+				case tc.denied:
+					return nil, &stubError{Type: "AccessDeniedException", Message: "not authorized to perform dynamodb:" + op}
+				case "DescribeTable":
+					return map[string]any{"Table": map[string]any{"TableName": stubTable, "TableStatus": "ACTIVE"}}, nil
+				}
+				return nil, nil
+			})
+			var out strings.Builder
+			err := runSelfTest(context.Background(), stub.repository(), &out)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got %v, want an error: %t", err, tc.wantErr)
+			}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if len(lines) != 4 {
+				t.Fatalf("printed %q, want a line per step", out.String())
+			}
+			if !strings.HasPrefix(lines[1], tc.wantLine) {
+				t.Errorf("write step printed %q, want %q", lines[1], tc.wantLine)
+			}
+			for _, i := range []int{0, 2, 3} {
+				if !strings.HasSuffix(lines[i], " ok") {
+					t.Errorf("step printed %q, want ok", lines[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BatchLoader is a BookRepository decorator that coalesces the GetById calls
+// made within a short window into one BatchGet, so that concurrent requests
+// for the same or nearby books cost a single BatchGetItem. Each id is
+// fetched once per batch however many callers ask for it, and every caller
+// gets a copy of the book. Methods not overridden here pass through.
+//
+// Batched reads are eventually consistent unless the repository defaults
+// otherwise, so calls carrying ReadOptions bypass the loader. Soft-deleted
+// books are reported as ErrNotFound, like DynamoDbBookRepository.GetById
+// does by default.
+type BatchLoader struct {
+	BookRepository
+	window   time.Duration
+	maxBatch int
+	gets     atomic.Int64
+	batches  atomic.Int64
+
+	mu      sync.Mutex
+	pending *loadBatch
+}
+
+// loadBatch is a BatchGet being collected or run. Its results are set
+// before done is closed.
+type loadBatch struct {
+	ctx   context.Context
+	ids   []int
+	seen  map[int]bool
+	done  chan struct{}
+	books map[int]*Book
+	err   error
+}
+
+// NewBatchLoader returns a loader that waits up to window after the first
+// GetById of a batch for others to join it. A batch is sent early once it
+// holds the 100 ids of a BatchGetItem.
+func NewBatchLoader(next BookRepository, window time.Duration) *BatchLoader {
+	return &BatchLoader{BookRepository: next, window: window, maxBatch: batchGetLimit}
+}
+
+// Coalescing returns a middleware that wraps repositories in a BatchLoader
+// with the given window.
+func Coalescing(window time.Duration) RepositoryMiddleware {
+	return func(next BookRepository) BookRepository {
+		return NewBatchLoader(next, window)
+	}
+}
+
+// Stats returns the number of GetById calls served from batches and of
+// batches sent so far.
+func (l *BatchLoader) Stats() (gets, batches int64) {
+	return l.gets.Load(), l.batches.Load()
+}
+
+// GetById implements BookRepository. The batch runs with the context of its
+// first caller, without its cancellation, so that callers giving up do not
+// fail the others; each caller stops waiting when its own context is done.
+func (l *BatchLoader) GetById(ctx context.Context, id int) (*Book, error) {
+	if l.window <= 0 || ctx.Value(readPreferenceKey{}) != nil {
+		return l.BookRepository.GetById(ctx, id)
+	}
+	l.gets.Add(1)
+
+	l.mu.Lock()
+	b := l.pending
+	if b == nil {
+		b = &loadBatch{ctx: context.WithoutCancel(ctx), seen: map[int]bool{}, done: make(chan struct{})}
+		l.pending = b
+		time.AfterFunc(l.window, func() { l.flush(b) })
+	}
+	if !b.seen[id] {
+		b.seen[id] = true
+		b.ids = append(b.ids, id)
+	}
+	full := len(b.ids) >= l.maxBatch
+	l.mu.Unlock()
+	if full {
+		l.flush(b)
+	}
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	book, ok := b.books[id]
+	if !ok || book.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	return copyBook(book), nil
+}
+
+// flush sends b unless it has already been sent, which happens when it
+// fills up before its window ends.
+func (l *BatchLoader) flush(b *loadBatch) {
+	l.mu.Lock()
+	if l.pending != b {
+		l.mu.Unlock()
+		return
+	}
+	l.pending = nil
+	l.mu.Unlock()
+
+	go func() {
+		l.batches.Add(1)
+		books, err := l.BookRepository.BatchGet(b.ctx, b.ids)
+		b.books = make(map[int]*Book, len(books))
+		for _, book := range books {
+			b.books[book.Id] = book
+		}
+		b.err = err
+		close(b.done)
+	}()
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// publishedAtAttribute is the attribute Book.PublishedAt is stored in.
+const publishedAtAttribute = "publishedAt"
+
+// AttributeConverter changes how one attribute of a book is stored. ToItem
+// receives the attribute as attributevalue encoded the field and returns the
+// value to store, or nil to omit it; FromItem turns a stored value back into
+// one attributevalue can decode into the field.
+type AttributeConverter interface {
+	ToItem(av types.AttributeValue) (types.AttributeValue, error)
+	FromItem(av types.AttributeValue) (types.AttributeValue, error)
+}
+
+// bookCodec encodes books into items and decodes them back with the encoder
+// and decoder settings of a repository.
+type bookCodec struct {
+	encoderOptions []func(*attributevalue.EncoderOptions)
+	decoderOptions []func(*attributevalue.DecoderOptions)
+	converters     map[string]AttributeConverter
+	omitEmpty      bool
+	// pool, if set, holds the encoders and decoders to use.
+	pool *codecPool
+}
+
+// WithDecoderOptions adds attributevalue decoder options used when reading
+// books.
+func WithDecoderOptions(optFns ...func(*attributevalue.DecoderOptions)) RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.codec.decoderOptions = append(d.codec.decoderOptions, optFns...)
+	}
+}
+
+// WithAttributeConverter stores the book attribute named attr through c,
+// e.g. WithAttributeConverter("publishedAt", UnixTimeConverter{}). A later
+// converter for the same attribute replaces an earlier one.
+func WithAttributeConverter(attr string, c AttributeConverter) RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.codec.setConverter(attr, c)
+	}
+}
+
+// WithTimeLayout stores time attributes as strings formatted with layout
+// instead of RFC 3339. Strings in RFC 3339 are still read, so items written
+// before the layout changed remain readable. Attributes tagged unixtime are
+// unaffected. Stream consumers decode images with the default layout.
+func WithTimeLayout(layout string) RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.codec.setTimeLayout(layout)
+	}
+}
+
+// WithCompositeEncoderOptions is WithEncoderOptions for
+// CompositeBookRepository.
+func WithCompositeEncoderOptions(optFns ...func(*attributevalue.EncoderOptions)) CompositeOption {
+	return func(c *CompositeBookRepository) {
+		c.codec.encoderOptions = append(c.codec.encoderOptions, optFns...)
+	}
+}
+
+// WithCompositeDecoderOptions is WithDecoderOptions for
+// CompositeBookRepository.
+func WithCompositeDecoderOptions(optFns ...func(*attributevalue.DecoderOptions)) CompositeOption {
+	return func(c *CompositeBookRepository) {
+		c.codec.decoderOptions = append(c.codec.decoderOptions, optFns...)
+	}
+}
+
+// WithCompositeAttributeConverter is WithAttributeConverter for
+// CompositeBookRepository. Converters cannot apply to the key attributes.
+func WithCompositeAttributeConverter(attr string, conv AttributeConverter) CompositeOption {
+	return func(c *CompositeBookRepository) {
+		c.codec.setConverter(attr, conv)
+	}
+}
+
+// WithCompositeTimeLayout is WithTimeLayout for CompositeBookRepository.
+func WithCompositeTimeLayout(layout string) CompositeOption {
+	return func(c *CompositeBookRepository) {
+		c.codec.setTimeLayout(layout)
+	}
+}
+
+func (c *bookCodec) setConverter(attr string, conv AttributeConverter) {
+	if c.converters == nil {
+		c.converters = map[string]AttributeConverter{}
+	}
+	c.converters[attr] = conv
+}
+
+func (c *bookCodec) setTimeLayout(layout string) {
+	c.encoderOptions = append(c.encoderOptions, func(o *attributevalue.EncoderOptions) {
+		o.EncodeTime = func(t time.Time) (types.AttributeValue, error) {
+			return &types.AttributeValueMemberS{Value: t.Format(layout)}, nil
+		}
+	})
+	c.decoderOptions = append(c.decoderOptions, func(o *attributevalue.DecoderOptions) {
+		o.DecodeTime.S = func(s string) (time.Time, error) {
+			t, err := time.Parse(layout, s)
+			if err != nil {
+				if t, rfcErr := time.Parse(time.RFC3339, s); rfcErr == nil {
+					return t, nil
+				}
+			}
+			return t, err
+		}
+	})
+}
+
+// marshal encodes a book into an item of the current item version, placed
+// in the sort indexes. A zero PublishedAt and NULLs, such as those encoding
+// empty tag sets, are not written: they read back as the zero value anyway,
+// and a NULL tags attribute would break ADD and DELETE. A zero CreatedAt is
+// written as 0 so that the book still appears in the created index.
+func (c *bookCodec) marshal(book *Book) (map[string]types.AttributeValue, error) {
+	av, err := c.marshalMap(book)
+	if err != nil {
+		return nil, err
+	}
+	if book.PublishedAt.IsZero() {
+		delete(av, publishedAtAttribute)
+	}
+	for name, value := range av {
+		if _, ok := value.(*types.AttributeValueMemberNULL); ok && name != idAttribute {
+			delete(av, name)
+		}
+	}
+	if c.omitEmpty {
+		for name, value := range av {
+			// The key must be written even when it is zero.
+			if name != idAttribute && isZeroAttribute(value) {
+				delete(av, name)
+			}
+		}
+	}
+	for name, conv := range c.converters {
+		value, ok := av[name]
+		if !ok {
+			continue
+		}
+		if value, err = conv.ToItem(value); err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		if value == nil {
+			delete(av, name)
+		} else {
+			av[name] = value
+		}
+	}
+	if book.CreatedAt.IsZero() {
+		av[createdAtAttribute] = &types.AttributeValueMemberN{Value: createdAtKey(book.CreatedAt)}
+	}
+	av[listingAttribute] = &types.AttributeValueMemberS{Value: listingPartition}
+	av[itemVersionAttribute] = &types.AttributeValueMemberN{Value: strconv.Itoa(currentItemVersion)}
+	return av, nil
+}
+
+// marshalAttribute encodes value as attribute name of the items marshal
+// writes: as the Book field stored in name would be, with its tags and the
+// converters of c, or as attributevalue encodes it for attributes Book does
+// not know. It returns nil if marshal would not write the attribute, e.g.
+// for empty tags, and ErrValidation if value does not fit the field.
+func (c *bookCodec) marshalAttribute(name string, value any) (types.AttributeValue, error) {
+	i, ok := bookFields[name]
+	if !ok {
+		return attributevalue.MarshalWithOptions(value, c.encoderOptions...)
+	}
+	var book Book
+	field := reflect.ValueOf(&book).Elem().Field(i)
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Type().AssignableTo(field.Type()):
+		field.Set(v)
+	case field.Kind() == reflect.Pointer && v.Type().AssignableTo(field.Type().Elem()):
+		field.Set(reflect.New(field.Type().Elem()))
+		field.Elem().Set(v)
+	default:
+		return nil, fmt.Errorf("%w: attribute %q takes a %s, not a %T", ErrValidation, name, field.Type(), value)
+	}
+	av, err := c.marshal(&book)
+	if err != nil {
+		return nil, err
+	}
+	return av[name], nil
+}
+
+func isZeroAttribute(value types.AttributeValue) bool {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value == ""
+	case *types.AttributeValueMemberN:
+		return v.Value == "0"
+	}
+	return false
+}
+
+// unmarshal decodes an item into book, upgrading items of an older item
+// version first. The item is not modified.
+func (c *bookCodec) unmarshal(item map[string]types.AttributeValue, book *Book) error {
+	item, _, err := upgradeItem(item)
+	if err != nil {
+		return err
+	}
+	if len(c.converters) > 0 {
+		converted := make(map[string]types.AttributeValue, len(item))
+		for name, value := range item {
+			converted[name] = value
+		}
+		for name, conv := range c.converters {
+			value, ok := item[name]
+			if !ok {
+				continue
+			}
+			value, err := conv.FromItem(value)
+			if err != nil {
+				return fmt.Errorf("attribute %s: %w", name, err)
+			}
+			converted[name] = value
+		}
+		item = converted
+	}
+	if err := c.unmarshalMap(item, book); err != nil {
+		return err
+	}
+	book.CreatedAt = fromCreatedAtKey(book.CreatedAt)
+	return nil
+}
+
+// fromCreatedAtKey undoes createdAtKey on a creation time decoded from Unix
+// seconds, which decode into local time.
+func fromCreatedAtKey(t time.Time) time.Time {
+	if t.Unix() == 0 {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+// unmarshalList decodes a page of items.
+func (c *bookCodec) unmarshalList(items []map[string]types.AttributeValue) ([]*Book, error) {
+	books := make([]*Book, 0, len(items))
+	for _, item := range items {
+		book := new(Book)
+		if err := c.unmarshal(item, book); err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, nil
+}
+
+// UnixTimeConverter stores a time attribute as a number of seconds since
+// the Unix epoch, e.g. so that it can be compared numerically or used as a
+// TTL. It expects the attribute to be encoded with the default RFC 3339
+// layout; fractions of a second are dropped.
+type UnixTimeConverter struct{}
+
+func (UnixTimeConverter) ToItem(av types.AttributeValue) (types.AttributeValue, error) {
+	s, ok := av.(*types.AttributeValueMemberS)
+	if !ok {
+		return av, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &types.AttributeValueMemberN{Value: fmt.Sprint(t.Unix())}, nil
+}
+
+// FromItem passes numbers through, which attributevalue decodes into a
+// time.Time as Unix seconds, as well as strings written before the
+// converter was installed.
+func (UnixTimeConverter) FromItem(av types.AttributeValue) (types.AttributeValue, error) {
+	return av, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWithOmitEmptyDropsZeroAttributes(t *testing.T) {
+	stored := map[string]bool{}
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		switch op {
+		case "PutItem":
+			for name := range decodeInput(t, input).Item {
+				stored[name] = true
+			}
+			return nil, nil
+		case "GetItem":
+			return map[string]any{"Item": map[string]any{
+				"id":      map[string]any{"N": "1"},
+				"name":    map[string]any{"S": "Dune"},
+				"version": map[string]any{"N": "1"},
+			}}, nil
+		}
+		return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+	})
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name       string
+		opts       []RepositoryOption
+		wantAuthor bool
+	}{
+		{"default", nil, true},
+		{"WithOmitEmpty", []RepositoryOption{WithOmitEmpty()}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clear(stored)
+			repo := stub.repository(tc.opts...)
+			if err := repo.Create(ctx, &Book{Id: 1, Name: "Dune"}); err != nil {
+				t.Fatal(err)
+			}
+			if stored["author"] != tc.wantAuthor {
+				t.Errorf("author written = %v, want %v; item has %v", stored["author"], tc.wantAuthor, stored)
+			}
+			for _, attr := range []string{"id", "name", "version"} {
+				if !stored[attr] {
+					t.Errorf("attribute %s not written", attr)
+				}
+			}
+
+			book, err := repo.GetById(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if book.Author != "" || book.Year != 0 || book.Tags != nil || book.Name != "Dune" {
+				t.Errorf("GetById of a sparse item = %+v, want zero values for the missing attributes", book)
+			}
+		})
+	}
+}
+
+func TestMarshalMapFailsForValuesThatAreNotMaps(t *testing.T) {
+	var pooled bookCodec
+	pooled.pool = newCodecPool(&pooled)
+	for name, c := range map[string]*bookCodec{"unpooled": {}, "pooled": &pooled} {
+		if item, err := c.marshalMap(nil); err == nil {
+			t.Errorf("%s: marshalMap(nil) = %v, want an error", name, item)
+		}
+		item, err := c.marshalMap(&Book{Id: 1, Name: "Dune"})
+		if err != nil || item[nameAttribute] == nil {
+			t.Errorf("%s: marshalMap(book) = %v, %v, want its attributes", name, item, err)
+		}
+	}
+}
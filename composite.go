@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// Attribute and index names of the composite-key book table. Items are
+// partitioned by author and sorted by "book#<id>", so all books of an author
+// live in one partition and can be read with a single Query.
+const (
+	partitionKeyAttribute = "PK"
+	sortKeyAttribute      = "SK"
+	bookSortKeyPrefix     = "book#"
+	idIndexName           = "id-index"
+)
+
+// compositeBatchGetConcurrency bounds the concurrent id lookups of BatchGet.
+const compositeBatchGetConcurrency = 10
+
+// KeyMode selects the primary key layout of the book table.
+type KeyMode string
+
+const (
+	// KeyModeSimple keys books by id alone (DynamoDbBookRepository).
+	KeyModeSimple KeyMode = "simple"
+	// KeyModeComposite keys books by author and id (CompositeBookRepository).
+	KeyModeComposite KeyMode = "composite"
+)
+
+// ParseKeyMode returns the KeyMode named s.
+func ParseKeyMode(s string) (KeyMode, error) {
+	switch m := KeyMode(s); m {
+	case KeyModeSimple, KeyModeComposite:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown key mode %q", s)
+}
+
+// PrefixedNumberKey encodes an int as a string attribute holding Prefix
+// followed by the decimal number, e.g. "book#42".
+type PrefixedNumberKey struct {
+	Attribute string
+	Prefix    string
+}
+
+// MarshalKey implements KeyCodec.
+func (k PrefixedNumberKey) MarshalKey(n int) map[string]types.AttributeValue {
+	return StringKey(k.Attribute).MarshalKey(k.Prefix + strconv.Itoa(n))
+}
+
+// UnmarshalKey implements KeyCodec.
+func (k PrefixedNumberKey) UnmarshalKey(item map[string]types.AttributeValue) (int, error) {
+	s, err := StringKey(k.Attribute).UnmarshalKey(item)
+	if err != nil {
+		return 0, err
+	}
+	if len(s) < len(k.Prefix) || s[:len(k.Prefix)] != k.Prefix {
+		return 0, fmt.Errorf("key attribute %s: %q lacks prefix %q", k.Attribute, s, k.Prefix)
+	}
+	n, err := strconv.Atoi(s[len(k.Prefix):])
+	if err != nil {
+		return 0, fmt.Errorf("key attribute %s: %w", k.Attribute, err)
+	}
+	return n, nil
+}
+
+// compositeBookKey is the codec of the composite table's primary key.
+var compositeBookKey = NewCompositeKeyCodec[string, int](
+	StringKey(partitionKeyAttribute),
+	PrefixedNumberKey{Attribute: sortKeyAttribute, Prefix: bookSortKeyPrefix},
+)
+
+// CompositeBookRepository is a BookRepository over a table keyed by
+// PK=author and SK=book#<id>. Lookups by author are a single Query, or one
+// per shard with WithWriteShards; lookups by id alone go through the
+// id-index and are eventually consistent. Because the author is part of the
+// key, Update cannot change it, and ids are only guaranteed unique per
+// author.
+type CompositeBookRepository struct {
+	client        *dynamodb.Client
+	clientOptions []func(*dynamodb.Options)
+	tableName     string
+	batchRetry    batchRetryPolicy
+	sharding      WriteSharding
+	callTimeout   time.Duration
+	codec         bookCodec
+}
+
+// CompositeOption configures a CompositeBookRepository.
+type CompositeOption func(*CompositeBookRepository)
+
+// WithCompositeClientOptions adds options applied to the underlying DynamoDB
+// client, e.g. to point it at a local endpoint.
+func WithCompositeClientOptions(optFns ...func(*dynamodb.Options)) CompositeOption {
+	return func(c *CompositeBookRepository) {
+		c.clientOptions = append(c.clientOptions, optFns...)
+	}
+}
+
+// WithWriteShards spreads each author's books over n partition keys
+// (PK=author#<id mod n>) for authors whose writes would overload a single
+// partition. Author queries then fan out to every shard. The shard count is
+// part of the data layout: it must be chosen before the table is written and
+// not changed afterwards.
+func WithWriteShards(n int) CompositeOption {
+	return func(c *CompositeBookRepository) {
+		c.sharding = WriteSharding{Shards: n}
+	}
+}
+
+func NewCompositeBookRepository(cfg aws.Config, tableName string, opts ...CompositeOption) *CompositeBookRepository {
+	c := &CompositeBookRepository{
+		tableName:   tableName,
+		callTimeout: defaultCallTimeout,
+		batchRetry: batchRetryPolicy{
+			maxRetries: defaultBatchMaxRetries,
+			baseDelay:  defaultBatchBaseDelay,
+			maxDelay:   defaultBatchMaxDelay,
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.client = dynamodb.NewFromConfig(cfg, append(c.clientOptions, func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, addConsumedCapacityMiddleware)
+		if c.callTimeout > 0 {
+			o.APIOptions = append(o.APIOptions, addCallTimeoutMiddleware(c.callTimeout))
+		}
+	})...)
+	return c
+}
+
+// compositeBookTableDefinition describes the composite-key book table.
+func compositeBookTableDefinition(tableName string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(partitionKeyAttribute), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String(sortKeyAttribute), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String(idAttribute), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(partitionKeyAttribute), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(sortKeyAttribute), KeyType: types.KeyTypeRange},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String(idIndexName),
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String(idAttribute), KeyType: types.KeyTypeHash},
+				},
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+		StreamSpecification: &types.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: types.StreamViewTypeNewAndOldImages,
+		},
+	}
+}
+
+// MigrateComposite is Migrate for the composite-key book table.
+func MigrateComposite(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	return migrateTable(ctx, client, compositeBookTableDefinition(tableName))
+}
+
+// bookKey returns the primary key of a book in the composite table.
+func (c *CompositeBookRepository) bookKey(author string, id int) map[string]types.AttributeValue {
+	return compositeBookKey.MarshalKey(CompositeKey[string, int]{Partition: c.sharding.Key(author, id), Sort: id})
+}
+
+// marshal encodes a book together with its PK and SK attributes.
+func (c *CompositeBookRepository) marshal(book *Book) (map[string]types.AttributeValue, error) {
+	if err := rejectISBN(book, "composite key mode"); err != nil {
+		return nil, err
+	}
+	av, err := c.codec.marshal(book)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range c.bookKey(book.Author, book.Id) {
+		av[name] = value
+	}
+	return av, nil
+}
+
+// unmarshalBooks decodes items, ignoring the PK and SK attributes.
+func (c *CompositeBookRepository) unmarshalBooks(items []map[string]types.AttributeValue) ([]*Book, error) {
+	return c.codec.unmarshalList(items)
+}
+
+// put writes book with an optional condition.
+func (c *CompositeBookRepository) put(ctx context.Context, book *Book, cond *expression.ConditionBuilder) error {
+	av, err := c.marshal(book)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{Item: av, TableName: aws.String(c.tableName)}
+	if cond != nil {
+		expr, err := expression.NewBuilder().WithCondition(*cond).Build()
+		if err != nil {
+			return err
+		}
+		input.ConditionExpression = expr.Condition()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+	_, err = c.client.PutItem(ctx, input)
+	return translateError(err)
+}
+
+// Create implements BookRepository. It fails with ErrBookAlreadyExists if
+// the author already has a book with the same id.
+func (c *CompositeBookRepository) Create(ctx context.Context, book *Book) error {
+	book.Version = 1
+	cond := expression.AttributeNotExists(expression.Name(partitionKeyAttribute))
+	err := c.put(ctx, book, &cond)
+	if isConflict(err) {
+		return ErrBookAlreadyExists
+	}
+	return err
+}
+
+// Upsert implements BookRepository.
+func (c *CompositeBookRepository) Upsert(ctx context.Context, book *Book) error {
+	book.Version++
+	return c.put(ctx, book, nil)
+}
+
+// GetBook returns the book of author with the given id, or ErrNotFound.
+func (c *CompositeBookRepository) GetBook(ctx context.Context, author string, id int) (*Book, error) {
+	result, err := c.client.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:            c.bookKey(author, id),
+		TableName:      aws.String(c.tableName),
+		ConsistentRead: consistentRead(ctx, false),
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+	book := new(Book)
+	if err := c.codec.unmarshal(result.Item, book); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// GetById implements BookRepository by querying the id index. If several
+// authors have a book with the id, the first one found is returned.
+func (c *CompositeBookRepository) GetById(ctx context.Context, id int) (*Book, error) {
+	keyCond := expression.Key(idAttribute).Equal(expression.Value(id))
+	books, err := c.query(ctx, aws.String(idIndexName), keyCond)
+	if err != nil {
+		return nil, err
+	}
+	if len(books) == 0 {
+		return nil, ErrNotFound
+	}
+	return books[0], nil
+}
+
+// Update implements BookRepository with the same optimistic locking as
+// DynamoDbBookRepository.Update. The author cannot be changed because it is
+// part of the key; doing so returns ErrValidation.
+func (c *CompositeBookRepository) Update(ctx context.Context, book *Book) error {
+	current, err := c.GetById(ctx, book.Id)
+	if errors.Is(err, ErrNotFound) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+	if current.Author != book.Author {
+		return fmt.Errorf("%w: author cannot be changed in composite key mode", ErrValidation)
+	}
+
+	expected := book.Version
+	book.Version++
+	cond := expression.Name(versionAttribute).Equal(expression.Value(expected))
+	err = c.put(ctx, book, &cond)
+	if err != nil {
+		book.Version = expected
+	}
+	if isConflict(err) {
+		return ErrVersionConflict
+	}
+	return err
+}
+
+// Delete implements BookRepository. Deleting a missing book is not an error.
+func (c *CompositeBookRepository) Delete(ctx context.Context, id int) error {
+	book, err := c.GetById(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = c.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		Key:       c.bookKey(book.Author, book.Id),
+		TableName: aws.String(c.tableName),
+	})
+	return translateError(err)
+}
+
+// List implements BookRepository.
+func (c *CompositeBookRepository) List(ctx context.Context) ([]*Book, error) {
+	books := []*Book{}
+	paginator := dynamodb.NewScanPaginator(c.client, &dynamodb.ScanInput{
+		TableName: aws.String(c.tableName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		pageBooks, err := c.unmarshalBooks(page.Items)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, pageBooks...)
+	}
+	return books, nil
+}
+
+// ListPage implements BookRepository.
+func (c *CompositeBookRepository) ListPage(ctx context.Context, limit int, cursor string) ([]*Book, string, error) {
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	input := &dynamodb.ScanInput{
+		TableName:         aws.String(c.tableName),
+		ExclusiveStartKey: startKey,
+	}
+	if limit > 0 {
+		input.Limit = aws.Int32(int32(limit))
+	}
+	result, err := c.client.Scan(ctx, input)
+	if err != nil {
+		return nil, "", translateError(err)
+	}
+	books, err := c.unmarshalBooks(result.Items)
+	if err != nil {
+		return nil, "", err
+	}
+	next, err := encodeCursor(result.LastEvaluatedKey)
+	return books, next, err
+}
+
+// ListSorted implements BookRepository. The table has no sort indexes, so
+// every call scans it and sorts the books in memory.
+func (c *CompositeBookRepository) ListSorted(ctx context.Context, opts ListOptions) ([]*Book, string, error) {
+	books, err := c.List(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return sortedPage(books, opts)
+}
+
+// GetByAuthor implements BookRepository; see GetBooksByAuthor.
+func (c *CompositeBookRepository) GetByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	return c.GetBooksByAuthor(ctx, author)
+}
+
+// GetByISBN implements BookRepository. The table has neither an ISBN index
+// nor ISBN claims, so every call scans it. Without claims ISBNs cannot be
+// kept unique, so writes reject books with one, and only books stored with
+// an ISBN by other means are found.
+func (c *CompositeBookRepository) GetByISBN(ctx context.Context, isbn string) (*Book, error) {
+	books, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, book := range books {
+		if isbn != "" && book.ISBN == isbn {
+			return book, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetBooksByAuthor returns the books of author in id order (as strings, so
+// book#10 sorts before book#9) with a single-partition Query.
+func (c *CompositeBookRepository) GetBooksByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	return c.QueryBooksByIDPrefix(ctx, author, "")
+}
+
+// QueryBooksByIDPrefix returns the books of author whose decimal id starts
+// with prefix, e.g. "12" matches 12 and 120 to 129, using begins_with on the
+// sort key. With write sharding every shard is queried concurrently and the
+// results are merged back into sort key order.
+func (c *CompositeBookRepository) QueryBooksByIDPrefix(ctx context.Context, author, prefix string) ([]*Book, error) {
+	books, err := ScatterGather(ctx, c.sharding.Keys(author), 0, func(ctx context.Context, pk string) ([]*Book, error) {
+		keyCond := expression.Key(partitionKeyAttribute).Equal(expression.Value(pk)).
+			And(expression.Key(sortKeyAttribute).BeginsWith(bookSortKeyPrefix + prefix))
+		return c.query(ctx, nil, keyCond)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.sharding.Enabled() {
+		sort.Slice(books, func(i, j int) bool {
+			return strconv.Itoa(books[i].Id) < strconv.Itoa(books[j].Id)
+		})
+	}
+	if books == nil {
+		books = []*Book{}
+	}
+	return books, nil
+}
+
+func (c *CompositeBookRepository) query(ctx context.Context, index *string, keyCond expression.KeyConditionBuilder) ([]*Book, error) {
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(c.tableName),
+		IndexName:                 index,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+	// Global secondary indexes only support eventually consistent reads.
+	if index == nil {
+		input.ConsistentRead = consistentRead(ctx, false)
+	}
+	paginator := dynamodb.NewQueryPaginator(c.client, input)
+	books := []*Book{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		pageBooks, err := c.unmarshalBooks(page.Items)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, pageBooks...)
+	}
+	return books, nil
+}
+
+// BatchCreate implements BookRepository with BatchWriteItem in chunks of 25.
+func (c *CompositeBookRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	for start := 0; start < len(books); start += batchWriteLimit {
+		end := start + batchWriteLimit
+		if end > len(books) {
+			end = len(books)
+		}
+		requests := make([]types.WriteRequest, 0, end-start)
+		for _, book := range books[start:end] {
+			if book.Version == 0 {
+				book.Version = 1
+			}
+			av, err := c.marshal(book)
+			if err != nil {
+				return err
+			}
+			requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: av}})
+		}
+		pending := map[string][]types.WriteRequest{c.tableName: requests}
+		err := c.batchRetry.run(ctx, "BatchWriteItem", func() (int, error) {
+			result, err := c.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return len(pending[c.tableName]), translateError(err)
+			}
+			pending = result.UnprocessedItems
+			return len(pending[c.tableName]), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchGet implements BookRepository. Index queries cannot be batched, so
+// the ids are looked up concurrently.
+func (c *CompositeBookRepository) BatchGet(ctx context.Context, ids []int) ([]*Book, error) {
+	seen := make(map[int]bool, len(ids))
+	found := make([]*Book, len(ids))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(compositeBatchGetConcurrency)
+	for i, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		i, id := i, id
+		g.Go(func() error {
+			book, err := c.GetById(ctx, id)
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			found[i] = book
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	books := []*Book{}
+	for _, book := range found {
+		if book != nil {
+			books = append(books, book)
+		}
+	}
+	return books, nil
+}
@@ -0,0 +1,651 @@
+// Package config loads the service settings from defaults, an optional JSON
+// or YAML file and environment variables, in increasing order of precedence.
+// Command-line flags, applied by the caller, override all three.
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnvVar names the environment variable holding the path of the config
+// file, used when no path is given explicitly.
+const FileEnvVar = "BOOK_CONFIG"
+
+// Environment variables overriding the settings of the same name.
+const (
+	RegionEnvVar                = "AWS_REGION"
+	TableEnvVar                 = "BOOK_TABLE"
+	EndpointEnvVar              = "DYNAMODB_ENDPOINT"
+	LogLevelEnvVar              = "LOG_LEVEL"
+	LogFormatEnvVar             = "LOG_FORMAT"
+	HTTPAddrEnvVar              = "HTTP_ADDR"
+	GRPCAddrEnvVar              = "GRPC_ADDR"
+	RequestTimeoutEnvVar        = "REQUEST_TIMEOUT"
+	ShutdownTimeoutEnvVar       = "SHUTDOWN_TIMEOUT"
+	DrainTimeoutEnvVar          = "DRAIN_TIMEOUT"
+	CallTimeoutEnvVar           = "DYNAMODB_CALL_TIMEOUT"
+	SearchURLEnvVar             = "SEARCH_URL"
+	SearchIndexEnvVar           = "SEARCH_INDEX"
+	SearchIndexingEnvVar        = "SEARCH_INDEXING"
+	EventBusEnvVar              = "EVENT_BUS"
+	EventTopicARNEnvVar         = "EVENT_TOPIC_ARN"
+	EventSourceEnvVar           = "EVENT_SOURCE"
+	EventDeliveryEnvVar         = "EVENT_DELIVERY"
+	BulkWorkersEnvVar           = "BULK_WORKERS"
+	MaxIdleConnsEnvVar          = "HTTP_MAX_IDLE_CONNS"
+	ReadRateLimitEnvVar         = "READ_RATE_LIMIT"
+	WriteRateLimitEnvVar        = "WRITE_RATE_LIMIT"
+	RateLimitModeEnvVar         = "RATE_LIMIT_MODE"
+	AuditLogEnvVar              = "AUDIT_LOG"
+	CoalesceWindowEnvVar        = "GET_COALESCE_WINDOW"
+	WriteBufferWindowEnvVar     = "WRITE_BUFFER_WINDOW"
+	SecondaryRegionEnvVar       = "AWS_SECONDARY_REGION"
+	ProbeIntervalEnvVar         = "REGION_PROBE_INTERVAL"
+	BreakerThresholdEnvVar      = "BREAKER_FAILURE_THRESHOLD"
+	BreakerCooldownEnvVar       = "BREAKER_COOLDOWN"
+	BreakerProbesEnvVar         = "BREAKER_HALF_OPEN_PROBES"
+	FaultLatencyEnvVar          = "FAULT_LATENCY"
+	FaultLatencyRateEnvVar      = "FAULT_LATENCY_RATE"
+	FaultThrottleRateEnvVar     = "FAULT_THROTTLE_RATE"
+	FaultConditionRateEnvVar    = "FAULT_CONDITION_RATE"
+	FaultPartialBatchRateEnvVar = "FAULT_PARTIAL_BATCH_RATE"
+	AuthJWTSecretEnvVar         = "AUTH_JWT_SECRET"
+	AuthIssuerEnvVar            = "AUTH_JWT_ISSUER"
+	AuthAudienceEnvVar          = "AUTH_JWT_AUDIENCE"
+	OutboxPollIntervalEnvVar    = "OUTBOX_POLL_INTERVAL"
+	UpgradeItemsOnReadEnvVar    = "UPGRADE_ITEMS_ON_READ"
+	RoutingFileEnvVar           = "ROUTING_FILE"
+	RoutingReloadEnvVar         = "ROUTING_RELOAD_INTERVAL"
+	IDGeneratorEnvVar           = "ID_GENERATOR"
+	IDNodeEnvVar                = "ID_NODE"
+	EncryptionKeysEnvVar        = "ENCRYPTION_KEYS"
+	EncryptionPlaintextEnvVar   = "ENCRYPTION_PLAINTEXT_READS"
+)
+
+// Values of SearchIndexing.
+const (
+	// SearchIndexingSync indexes books as the API writes them.
+	SearchIndexingSync = "sync"
+	// SearchIndexingStream leaves indexing to the stream consumer.
+	SearchIndexingStream = "stream"
+)
+
+// Values of EventDelivery.
+const (
+	// EventDeliverySync publishes events as the API writes books, best
+	// effort.
+	EventDeliverySync = "sync"
+	// EventDeliveryStream publishes events from the stream consumer, for
+	// every committed write.
+	EventDeliveryStream = "stream"
+	// EventDeliveryOutbox writes events to an outbox table in the
+	// transaction of each write, for the servers to publish.
+	EventDeliveryOutbox = "outbox"
+)
+
+// Values of RateLimitMode.
+const (
+	// RateLimitModeWait makes calls over the rate wait.
+	RateLimitModeWait = "wait"
+	// RateLimitModeFail rejects calls over the rate as throttled.
+	RateLimitModeFail = "fail"
+)
+
+// Values of IDGenerator.
+const (
+	// IDGeneratorSnowflake assigns time-ordered 63-bit ids, unique across
+	// up to 1024 processes with distinct IDNode values.
+	IDGeneratorSnowflake = "snowflake"
+)
+
+// Values of AuditLog.
+const (
+	// AuditLogTransactional writes audit records in the transaction of
+	// each write.
+	AuditLogTransactional = "transactional"
+	// AuditLogStream leaves audit records to the stream consumer.
+	AuditLogStream = "stream"
+)
+
+// Config holds the settings shared by the CLI, the servers and the Lambda
+// function. Field names double as the keys of the config file.
+type Config struct {
+	// Region is the AWS region of the tables.
+	Region string `json:"region" yaml:"region"`
+	// Table is the name of the book table; companion tables derive their
+	// names from it.
+	Table string `json:"table" yaml:"table"`
+	// Endpoint redirects DynamoDB requests, e.g. to dynamodb-local. Empty
+	// means the regional AWS endpoint.
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+	// LogLevel is debug, info, warn or error.
+	LogLevel string `json:"logLevel" yaml:"logLevel"`
+	// LogFormat is text or json.
+	LogFormat string `json:"logFormat" yaml:"logFormat"`
+	// HTTPAddr is the address the HTTP API listens on, e.g. ":8080".
+	HTTPAddr string `json:"httpAddr" yaml:"httpAddr"`
+	// GRPCAddr is the address the gRPC API listens on; empty disables it.
+	GRPCAddr string `json:"grpcAddr" yaml:"grpcAddr"`
+	// RequestTimeout bounds the handling of a single HTTP API request.
+	RequestTimeout Duration `json:"requestTimeout" yaml:"requestTimeout"`
+	// ShutdownTimeout is how long the servers wait for in-flight requests
+	// to complete after being asked to stop.
+	ShutdownTimeout Duration `json:"shutdownTimeout" yaml:"shutdownTimeout"`
+	// DrainTimeout bounds the whole shutdown of the service: the servers
+	// draining, then the background workers stopping, then telemetry being
+	// flushed. It should exceed ShutdownTimeout.
+	DrainTimeout Duration `json:"drainTimeout" yaml:"drainTimeout"`
+	// CallTimeout bounds each DynamoDB call made without a deadline of its
+	// own; zero disables it.
+	CallTimeout Duration `json:"callTimeout" yaml:"callTimeout"`
+	// SearchURL is the OpenSearch or Elasticsearch endpoint books are
+	// indexed into; empty disables search.
+	SearchURL string `json:"searchUrl" yaml:"searchUrl"`
+	// SearchIndex is the name of the search index.
+	SearchIndex string `json:"searchIndex" yaml:"searchIndex"`
+	// SearchIndexing is SearchIndexingSync or SearchIndexingStream.
+	SearchIndexing string `json:"searchIndexing" yaml:"searchIndexing"`
+	// EventBus is the EventBridge bus book events are put on. EventBus and
+	// EventTopicARN are exclusive; with neither, no events are published.
+	EventBus string `json:"eventBus" yaml:"eventBus"`
+	// EventTopicARN is the SNS topic book events are published to.
+	EventTopicARN string `json:"eventTopicArn" yaml:"eventTopicArn"`
+	// EventSource is the source of EventBridge entries.
+	EventSource string `json:"eventSource" yaml:"eventSource"`
+	// EventDelivery is EventDeliverySync, EventDeliveryStream or
+	// EventDeliveryOutbox.
+	EventDelivery string `json:"eventDelivery" yaml:"eventDelivery"`
+	// OutboxPollInterval is how often the servers publish the events in
+	// the outbox when EventDelivery is EventDeliveryOutbox.
+	OutboxPollInterval Duration `json:"outboxPollInterval" yaml:"outboxPollInterval"`
+	// BulkWorkers is the number of concurrent requests of bulk operations
+	// such as imports and parallel scans.
+	BulkWorkers int `json:"bulkWorkers" yaml:"bulkWorkers"`
+	// MaxIdleConns is how many idle connections to DynamoDB are kept open;
+	// zero keeps the SDK default of 10.
+	MaxIdleConns int `json:"maxIdleConns" yaml:"maxIdleConns"`
+	// IDGenerator, if set, assigns ids to books created without one; it is
+	// IDGeneratorSnowflake. Without it callers must choose the ids.
+	IDGenerator string `json:"idGenerator" yaml:"idGenerator"`
+	// IDNode tells apart the processes generating ids; every process
+	// creating books must have its own, from 0 to 1023.
+	IDNode int `json:"idNode" yaml:"idNode"`
+	// ReadRateLimit caps the books read per second by the service; zero
+	// means no limit.
+	ReadRateLimit float64 `json:"readRateLimit" yaml:"readRateLimit"`
+	// WriteRateLimit caps the books written per second; zero means no
+	// limit.
+	WriteRateLimit float64 `json:"writeRateLimit" yaml:"writeRateLimit"`
+	// RateLimitMode is RateLimitModeWait or RateLimitModeFail.
+	RateLimitMode string `json:"rateLimitMode" yaml:"rateLimitMode"`
+	// AuditLog is AuditLogTransactional or AuditLogStream to record every
+	// change of a book in the audit table; empty disables it.
+	AuditLog string `json:"auditLog" yaml:"auditLog"`
+	// CoalesceWindow is how long a read of one book waits for others to
+	// share its BatchGetItem; zero disables coalescing.
+	CoalesceWindow Duration `json:"getCoalesceWindow" yaml:"getCoalesceWindow"`
+	// WriteBufferWindow is how long creates and updates wait to be written
+	// together in a BatchWriteItem; zero writes each at once.
+	WriteBufferWindow Duration `json:"writeBufferWindow" yaml:"writeBufferWindow"`
+	// SecondaryRegion is the region of the global table replica that writes
+	// fail over to while Region is unreachable; empty disables failover.
+	SecondaryRegion string `json:"secondaryRegion" yaml:"secondaryRegion"`
+	// ProbeInterval is how often the servers probe the reachability and
+	// latency of both regions when SecondaryRegion is set.
+	ProbeInterval Duration `json:"regionProbeInterval" yaml:"regionProbeInterval"`
+	// BreakerThreshold is the number of consecutive DynamoDB outages or
+	// throttled calls after which the repository fails calls fast; zero
+	// disables the circuit breaker.
+	BreakerThreshold int `json:"breakerFailureThreshold" yaml:"breakerFailureThreshold"`
+	// BreakerCooldown is how long an open circuit breaker fails calls
+	// before probing the repository again. The breakers of the regions use
+	// it too.
+	BreakerCooldown Duration `json:"breakerCooldown" yaml:"breakerCooldown"`
+	// BreakerProbes is the number of concurrent calls a circuit breaker
+	// lets through once its cooldown has passed.
+	BreakerProbes int `json:"breakerHalfOpenProbes" yaml:"breakerHalfOpenProbes"`
+	// FaultLatency is added to the repository calls chosen with
+	// FaultLatencyRate. The Fault settings inject faults for resilience
+	// testing and must stay zero in production.
+	FaultLatency     Duration `json:"faultLatency" yaml:"faultLatency"`
+	FaultLatencyRate float64  `json:"faultLatencyRate" yaml:"faultLatencyRate"`
+	// FaultThrottleRate is the share of repository calls failed as
+	// throttled.
+	FaultThrottleRate float64 `json:"faultThrottleRate" yaml:"faultThrottleRate"`
+	// FaultConditionRate is the share of creates and updates failed as
+	// conflicting.
+	FaultConditionRate float64 `json:"faultConditionRate" yaml:"faultConditionRate"`
+	// FaultPartialBatchRate is the share of batch writes that write only
+	// some of their books.
+	FaultPartialBatchRate float64 `json:"faultPartialBatchRate" yaml:"faultPartialBatchRate"`
+	// AuthJWTSecret is the HS256 secret the bearer tokens of API requests
+	// are signed with; empty disables authentication. With it set, only
+	// librarians may delete books.
+	AuthJWTSecret string `json:"authJwtSecret" yaml:"authJwtSecret"`
+	// AuthIssuer and AuthAudience, if set, must match the iss and aud
+	// claims of the tokens.
+	AuthIssuer   string `json:"authJwtIssuer" yaml:"authJwtIssuer"`
+	AuthAudience string `json:"authJwtAudience" yaml:"authJwtAudience"`
+	// UpgradeItemsOnRead writes the books the service reads in an older
+	// item version back in the current one.
+	UpgradeItemsOnRead bool `json:"upgradeItemsOnRead" yaml:"upgradeItemsOnRead"`
+	// RoutingFile is the path of a Routing file sending each request to the
+	// table of the route it names; empty serves every request from Table.
+	RoutingFile string `json:"routingFile" yaml:"routingFile"`
+	// RoutingReloadInterval is how often the routing file is read again.
+	RoutingReloadInterval Duration `json:"routingReloadInterval" yaml:"routingReloadInterval"`
+	// EncryptionKeys lists the master keys encrypting the attributes tagged
+	// `encrypted:"true"`, such as review comments, as comma-separated
+	// id:base64 pairs of 16, 24 or 32 byte keys; empty stores them in
+	// plaintext. The first key wraps new data keys, the others only unwrap
+	// those issued before a rotation. See MasterKeys.
+	EncryptionKeys string `json:"encryptionKeys" yaml:"encryptionKeys"`
+	// EncryptionPlaintextReads accepts attributes stored before encryption
+	// was enabled, in plaintext, instead of failing their reads. Turn it
+	// off once they have all been written again.
+	EncryptionPlaintextReads bool `json:"encryptionPlaintextReads" yaml:"encryptionPlaintextReads"`
+}
+
+// MasterKey is a master key of EncryptionKeys.
+type MasterKey struct {
+	ID  string
+	Key []byte
+}
+
+// MasterKeys parses EncryptionKeys, active key first.
+func (c Config) MasterKeys() ([]MasterKey, error) {
+	if c.EncryptionKeys == "" {
+		return nil, nil
+	}
+	var keys []MasterKey
+	seen := map[string]bool{}
+	for _, pair := range strings.Split(c.EncryptionKeys, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("encryption key %q must be id:base64 with an id of 1 to 255 bytes", pair)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("encryption key %s is listed twice", id)
+		}
+		seen[id] = true
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s: %w", id, err)
+		}
+		if n := len(key); n != 16 && n != 24 && n != 32 {
+			return nil, fmt.Errorf("encryption key %s is %d bytes, want 16, 24 or 32", id, n)
+		}
+		keys = append(keys, MasterKey{ID: id, Key: key})
+	}
+	return keys, nil
+}
+
+// Duration is a time.Duration written as a string such as "30s" or "1m30s"
+// in config files and environment variables.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// Default returns the settings used when neither a file nor the environment
+// sets them.
+func Default() Config {
+	return Config{
+		Region:             "ap-southeast-1",
+		Table:              "book",
+		LogLevel:           "info",
+		LogFormat:          "text",
+		HTTPAddr:           ":8080",
+		RequestTimeout:     Duration{30 * time.Second},
+		ShutdownTimeout:    Duration{10 * time.Second},
+		DrainTimeout:       Duration{15 * time.Second},
+		CallTimeout:        Duration{2 * time.Second},
+		SearchIndex:        "books",
+		SearchIndexing:     SearchIndexingSync,
+		EventSource:        "dynamoDBExample.books",
+		EventDelivery:      EventDeliverySync,
+		OutboxPollInterval: Duration{time.Second},
+		BulkWorkers:        4,
+		RateLimitMode:      RateLimitModeWait,
+		ProbeInterval:      Duration{10 * time.Second},
+		BreakerCooldown:    Duration{30 * time.Second},
+		BreakerProbes:      1,
+
+		RoutingReloadInterval: Duration{30 * time.Second},
+	}
+}
+
+// Load returns the default settings overridden by the file at path, if path
+// is not empty, and then by the environment. The file format follows its
+// extension: .json, or .yaml or .yml. The result is not validated, so that
+// callers can apply flags first; see Validate.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadFile decodes the file at path over c. Unknown keys are rejected so
+// that misspelt settings do not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	if err := decode(data, filepath.Ext(path), c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// decode decodes data in the format of the file extension ext into v.
+func decode(data []byte, ext string, v any) error {
+	var err error
+	switch ext = strings.ToLower(ext); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(v)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(v); errors.Is(err, io.EOF) {
+			err = nil // an empty file sets nothing
+		}
+	default:
+		return fmt.Errorf("unsupported extension %q, want .json, .yaml or .yml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("parse: %w", err)
+	}
+	return nil
+}
+
+// loadEnv overrides c with the environment variables that are set, even if
+// empty, so that e.g. GRPC_ADDR= disables a gRPC address set in the file.
+func (c *Config) loadEnv() error {
+	for name, dst := range map[string]*string{
+		RegionEnvVar:          &c.Region,
+		TableEnvVar:           &c.Table,
+		EndpointEnvVar:        &c.Endpoint,
+		LogLevelEnvVar:        &c.LogLevel,
+		LogFormatEnvVar:       &c.LogFormat,
+		HTTPAddrEnvVar:        &c.HTTPAddr,
+		GRPCAddrEnvVar:        &c.GRPCAddr,
+		SearchURLEnvVar:       &c.SearchURL,
+		SearchIndexEnvVar:     &c.SearchIndex,
+		SearchIndexingEnvVar:  &c.SearchIndexing,
+		EventBusEnvVar:        &c.EventBus,
+		EventTopicARNEnvVar:   &c.EventTopicARN,
+		EventSourceEnvVar:     &c.EventSource,
+		EventDeliveryEnvVar:   &c.EventDelivery,
+		RateLimitModeEnvVar:   &c.RateLimitMode,
+		AuditLogEnvVar:        &c.AuditLog,
+		SecondaryRegionEnvVar: &c.SecondaryRegion,
+		AuthJWTSecretEnvVar:   &c.AuthJWTSecret,
+		AuthIssuerEnvVar:      &c.AuthIssuer,
+		AuthAudienceEnvVar:    &c.AuthAudience,
+		RoutingFileEnvVar:     &c.RoutingFile,
+		IDGeneratorEnvVar:     &c.IDGenerator,
+		EncryptionKeysEnvVar:  &c.EncryptionKeys,
+	} {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	for name, dst := range map[string]*Duration{
+		RequestTimeoutEnvVar:     &c.RequestTimeout,
+		ShutdownTimeoutEnvVar:    &c.ShutdownTimeout,
+		DrainTimeoutEnvVar:       &c.DrainTimeout,
+		CallTimeoutEnvVar:        &c.CallTimeout,
+		CoalesceWindowEnvVar:     &c.CoalesceWindow,
+		WriteBufferWindowEnvVar:  &c.WriteBufferWindow,
+		ProbeIntervalEnvVar:      &c.ProbeInterval,
+		BreakerCooldownEnvVar:    &c.BreakerCooldown,
+		FaultLatencyEnvVar:       &c.FaultLatency,
+		OutboxPollIntervalEnvVar: &c.OutboxPollInterval,
+		RoutingReloadEnvVar:      &c.RoutingReloadInterval,
+	} {
+		if v, ok := os.LookupEnv(name); ok {
+			if err := dst.UnmarshalText([]byte(v)); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	for name, dst := range map[string]*int{
+		BulkWorkersEnvVar:      &c.BulkWorkers,
+		MaxIdleConnsEnvVar:     &c.MaxIdleConns,
+		IDNodeEnvVar:           &c.IDNode,
+		BreakerThresholdEnvVar: &c.BreakerThreshold,
+		BreakerProbesEnvVar:    &c.BreakerProbes,
+	} {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = n
+		}
+	}
+	for name, dst := range map[string]*float64{
+		ReadRateLimitEnvVar:         &c.ReadRateLimit,
+		WriteRateLimitEnvVar:        &c.WriteRateLimit,
+		FaultLatencyRateEnvVar:      &c.FaultLatencyRate,
+		FaultThrottleRateEnvVar:     &c.FaultThrottleRate,
+		FaultConditionRateEnvVar:    &c.FaultConditionRate,
+		FaultPartialBatchRateEnvVar: &c.FaultPartialBatchRate,
+	} {
+		if v, ok := os.LookupEnv(name); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = f
+		}
+	}
+	for name, dst := range map[string]*bool{
+		UpgradeItemsOnReadEnvVar:  &c.UpgradeItemsOnRead,
+		EncryptionPlaintextEnvVar: &c.EncryptionPlaintextReads,
+	} {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = b
+		}
+	}
+	return nil
+}
+
+// tableNamePattern matches the table names DynamoDB accepts. The limit
+// leaves room for the suffixes of companion tables.
+var tableNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,200}$`)
+
+// searchIndexPattern matches a conservative subset of valid index names.
+var searchIndexPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,254}$`)
+
+// minJWTSecretLen is the shortest HS256 secret accepted, as many bytes as
+// the signature.
+const minJWTSecretLen = 32
+
+// Validate reports every invalid setting of c in one error.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Region == "" {
+		errs = append(errs, errors.New("region must not be empty"))
+	}
+	if c.SecondaryRegion != "" {
+		if c.SecondaryRegion == c.Region {
+			errs = append(errs, fmt.Errorf("secondary region %q must differ from the region", c.SecondaryRegion))
+		}
+		if c.ProbeInterval.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("region probe interval %s must be positive", c.ProbeInterval))
+		}
+	}
+	if !tableNamePattern.MatchString(c.Table) {
+		errs = append(errs, fmt.Errorf("table %q must be 3 to 200 letters, digits, '_', '-' or '.'", c.Table))
+	}
+	if c.Endpoint != "" && !absoluteURL(c.Endpoint) {
+		errs = append(errs, fmt.Errorf("endpoint %q must be an absolute URL", c.Endpoint))
+	}
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log level %q must be debug, info, warn or error", c.LogLevel))
+	}
+	switch strings.ToLower(c.LogFormat) {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log format %q must be text or json", c.LogFormat))
+	}
+	if c.HTTPAddr == "" {
+		errs = append(errs, errors.New("http address must not be empty"))
+	}
+	if c.RequestTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("request timeout %s must be positive", c.RequestTimeout))
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown timeout %s must be positive", c.ShutdownTimeout))
+	}
+	if c.DrainTimeout.Duration < c.ShutdownTimeout.Duration {
+		errs = append(errs, fmt.Errorf("drain timeout %s must be at least the shutdown timeout %s", c.DrainTimeout, c.ShutdownTimeout))
+	}
+	if c.CoalesceWindow.Duration < 0 {
+		errs = append(errs, fmt.Errorf("get coalesce window %s must not be negative", c.CoalesceWindow))
+	}
+	if c.WriteBufferWindow.Duration < 0 {
+		errs = append(errs, fmt.Errorf("write buffer window %s must not be negative", c.WriteBufferWindow))
+	}
+	if c.BulkWorkers < 1 {
+		errs = append(errs, fmt.Errorf("bulk workers %d must be at least 1", c.BulkWorkers))
+	}
+	if c.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("max idle connections %d must not be negative", c.MaxIdleConns))
+	}
+	if c.BreakerThreshold < 0 {
+		errs = append(errs, fmt.Errorf("breaker failure threshold %d must not be negative", c.BreakerThreshold))
+	}
+	if c.BreakerCooldown.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("breaker cooldown %s must be positive", c.BreakerCooldown))
+	}
+	if c.BreakerProbes < 1 {
+		errs = append(errs, fmt.Errorf("breaker half-open probes %d must be at least 1", c.BreakerProbes))
+	}
+	if c.FaultLatency.Duration < 0 {
+		errs = append(errs, fmt.Errorf("fault latency %s must not be negative", c.FaultLatency))
+	}
+	for name, rate := range map[string]float64{
+		"fault latency rate":       c.FaultLatencyRate,
+		"fault throttle rate":      c.FaultThrottleRate,
+		"fault condition rate":     c.FaultConditionRate,
+		"fault partial batch rate": c.FaultPartialBatchRate,
+	} {
+		if rate < 0 || rate > 1 {
+			errs = append(errs, fmt.Errorf("%s %g must be between 0 and 1", name, rate))
+		}
+	}
+	if c.AuthJWTSecret != "" && len(c.AuthJWTSecret) < minJWTSecretLen {
+		errs = append(errs, fmt.Errorf("auth jwt secret must be at least %d bytes", minJWTSecretLen))
+	}
+	if c.AuthJWTSecret == "" && (c.AuthIssuer != "" || c.AuthAudience != "") {
+		errs = append(errs, errors.New("auth jwt issuer and audience require an auth jwt secret"))
+	}
+	if c.ReadRateLimit < 0 || c.WriteRateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rate limits %g and %g must not be negative", c.ReadRateLimit, c.WriteRateLimit))
+	}
+	if c.RateLimitMode != RateLimitModeWait && c.RateLimitMode != RateLimitModeFail {
+		errs = append(errs, fmt.Errorf("rate limit mode %q must be %s or %s", c.RateLimitMode, RateLimitModeWait, RateLimitModeFail))
+	}
+	if c.IDGenerator != "" && c.IDGenerator != IDGeneratorSnowflake {
+		errs = append(errs, fmt.Errorf("id generator %q must be empty or %s", c.IDGenerator, IDGeneratorSnowflake))
+	}
+	if c.IDNode < 0 || c.IDNode > 1023 {
+		errs = append(errs, fmt.Errorf("id node %d must be between 0 and 1023", c.IDNode))
+	}
+	if c.AuditLog != "" && c.AuditLog != AuditLogTransactional && c.AuditLog != AuditLogStream {
+		errs = append(errs, fmt.Errorf("audit log %q must be empty, %s or %s", c.AuditLog, AuditLogTransactional, AuditLogStream))
+	}
+	if c.RoutingFile != "" && c.RoutingReloadInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("routing reload interval %s must be positive", c.RoutingReloadInterval))
+	}
+	if _, err := c.MasterKeys(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.EncryptionPlaintextReads && c.EncryptionKeys == "" {
+		errs = append(errs, errors.New("encryption plaintext reads require encryption keys"))
+	}
+	if c.CallTimeout.Duration < 0 {
+		errs = append(errs, fmt.Errorf("call timeout %s must not be negative", c.CallTimeout))
+	}
+	if c.SearchURL != "" {
+		if !absoluteURL(c.SearchURL) {
+			errs = append(errs, fmt.Errorf("search url %q must be an absolute URL", c.SearchURL))
+		}
+		if !searchIndexPattern.MatchString(c.SearchIndex) {
+			errs = append(errs, fmt.Errorf("search index %q must be lowercase letters, digits, '_' or '-'", c.SearchIndex))
+		}
+		if c.SearchIndexing != SearchIndexingSync && c.SearchIndexing != SearchIndexingStream {
+			errs = append(errs, fmt.Errorf("search indexing %q must be %s or %s", c.SearchIndexing, SearchIndexingSync, SearchIndexingStream))
+		}
+	}
+	if c.EventBus != "" || c.EventTopicARN != "" {
+		if c.EventBus != "" && c.EventTopicARN != "" {
+			errs = append(errs, errors.New("event bus and event topic are exclusive"))
+		}
+		if c.EventBus != "" && c.EventSource == "" {
+			errs = append(errs, errors.New("event source must not be empty"))
+		}
+		switch c.EventDelivery {
+		case EventDeliverySync, EventDeliveryStream:
+		case EventDeliveryOutbox:
+			if c.OutboxPollInterval.Duration <= 0 {
+				errs = append(errs, fmt.Errorf("outbox poll interval %s must be positive", c.OutboxPollInterval))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("event delivery %q must be %s, %s or %s", c.EventDelivery, EventDeliverySync, EventDeliveryStream, EventDeliveryOutbox))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	return nil
+}
+
+func absoluteURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
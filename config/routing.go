@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Routing sends requests to the table of the route they name, e.g. to keep
+// staging and production books, or the books of each region, apart:
+//
+//	{"default": "prod", "routes": {
+//	  "prod":    {"table": "book-prod"},
+//	  "staging": {"table": "book-staging"},
+//	  "eu":      {"table": "book-prod", "region": "eu-west-1"}}}
+type Routing struct {
+	// Default is the route of requests that name none.
+	Default string `json:"default" yaml:"default"`
+	// Routes maps route names to their tables.
+	Routes map[string]Route `json:"routes" yaml:"routes"`
+}
+
+// Route is a table requests are routed to.
+type Route struct {
+	Table string `json:"table" yaml:"table"`
+	// Region is the region of the table; empty means the service region.
+	Region string `json:"region" yaml:"region"`
+}
+
+// LoadRouting reads and validates the routing file at path, in JSON or
+// YAML after its extension.
+func LoadRouting(path string) (Routing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Routing{}, fmt.Errorf("read routing file: %w", err)
+	}
+	r, err := ParseRouting(data, filepath.Ext(path))
+	if err != nil {
+		return Routing{}, fmt.Errorf("routing file %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// ParseRouting decodes and validates routing in the format of the file
+// extension ext, e.g. the value of a parameter holding a routing file.
+func ParseRouting(data []byte, ext string) (Routing, error) {
+	var r Routing
+	if err := decode(data, ext, &r); err != nil {
+		return Routing{}, err
+	}
+	if err := r.Validate(); err != nil {
+		return Routing{}, err
+	}
+	return r, nil
+}
+
+// Validate reports every invalid route of r in one error.
+func (r Routing) Validate() error {
+	var errs []error
+	if _, ok := r.Routes[r.Default]; !ok {
+		errs = append(errs, fmt.Errorf("default route %q is not one of the routes", r.Default))
+	}
+	names := make([]string, 0, len(r.Routes))
+	for name := range r.Routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if table := r.Routes[name].Table; !tableNamePattern.MatchString(table) {
+			errs = append(errs, fmt.Errorf("route %q: table %q must be 3 to 200 letters, digits, '_', '-' or '.'", name, table))
+		}
+	}
+	return errors.Join(errs...)
+}
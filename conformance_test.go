@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// conformanceCheck is one behavior every BookRepository must have. It runs
+// against an empty repository.
+type conformanceCheck struct {
+	name string
+	run  func(ctx context.Context, repo BookRepository) error
+}
+
+// testBookRepository runs the BookRepository contract as subtests, each
+// against a fresh repository returned by newRepo. It pins down the semantics
+// callers rely on regardless of the backend: ErrNotFound for missing books,
+// Create refusing and Upsert allowing overwrites, versioned updates,
+// idempotent deletes, and pagination returning every book once in List
+// order or sorted. The test of a new backend is one line:
+//
+//	func TestMyBookRepository(t *testing.T) {
+//		testBookRepository(t, func() BookRepository { return NewMyBookRepository() })
+//	}
+//
+// It cannot be named TestBookRepository: go test rejects functions named
+// TestXxx that take more than a *testing.T.
+func testBookRepository(t *testing.T, newRepo func() BookRepository) {
+	t.Helper()
+	for _, c := range conformanceChecks {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.run(context.Background(), newRepo()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestMemoryBookRepository(t *testing.T) {
+	testBookRepository(t, func() BookRepository { return NewMemoryBookRepository() })
+}
+
+func TestFakeBookRepository(t *testing.T) {
+	testBookRepository(t, func() BookRepository { return NewFakeBookRepository() })
+}
+
+var conformanceChecks = []conformanceCheck{
+	{"get of a missing book returns ErrNotFound", func(ctx context.Context, repo BookRepository) error {
+		_, err := repo.GetById(ctx, 1)
+		return wantErr(err, ErrNotFound)
+	}},
+	{"create stores the book at version 1", func(ctx context.Context, repo BookRepository) error {
+		book := &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Tags: []string{"sf"}}
+		if err := repo.Create(ctx, book); err != nil {
+			return err
+		}
+		if book.Version != 1 {
+			return fmt.Errorf("version after create = %d, want 1", book.Version)
+		}
+		return wantStored(ctx, repo, book)
+	}},
+	{"create of an existing id returns ErrBookAlreadyExists", func(ctx context.Context, repo BookRepository) error {
+		if err := repo.Create(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+			return err
+		}
+		err := repo.Create(ctx, &Book{Id: 1, Name: "Emma", Author: "Jane Austen"})
+		if err := wantErr(err, ErrBookAlreadyExists); err != nil {
+			return err
+		}
+		if !errors.Is(err, ErrConflict) {
+			return fmt.Errorf("error %v does not match ErrConflict", err)
+		}
+		return wantStored(ctx, repo, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 1})
+	}},
+	{"upsert overwrites and bumps the version", func(ctx context.Context, repo BookRepository) error {
+		if err := repo.Create(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+			return err
+		}
+		book := &Book{Id: 1, Name: "Dune Messiah", Author: "Frank Herbert", Version: 1}
+		if err := repo.Upsert(ctx, book); err != nil {
+			return err
+		}
+		if book.Version != 2 {
+			return fmt.Errorf("version after upsert = %d, want 2", book.Version)
+		}
+		return wantStored(ctx, repo, book)
+	}},
+	{"update with the stored version succeeds", func(ctx context.Context, repo BookRepository) error {
+		book := &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}
+		if err := repo.Create(ctx, book); err != nil {
+			return err
+		}
+		book.Name = "Dune Messiah"
+		if err := repo.Update(ctx, book); err != nil {
+			return err
+		}
+		if book.Version != 2 {
+			return fmt.Errorf("version after update = %d, want 2", book.Version)
+		}
+		return wantStored(ctx, repo, book)
+	}},
+	{"update with a stale version returns ErrVersionConflict", func(ctx context.Context, repo BookRepository) error {
+		if err := repo.Create(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+			return err
+		}
+		if err := repo.Update(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 1}); err != nil {
+			return err
+		}
+		stale := &Book{Id: 1, Name: "Emma", Author: "Frank Herbert", Version: 1}
+		if err := wantErr(repo.Update(ctx, stale), ErrVersionConflict); err != nil {
+			return err
+		}
+		if stale.Version != 1 {
+			return fmt.Errorf("version after failed update = %d, want it unchanged at 1", stale.Version)
+		}
+		return wantStored(ctx, repo, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 2})
+	}},
+	{"update of a missing book returns ErrVersionConflict", func(ctx context.Context, repo BookRepository) error {
+		return wantErr(repo.Update(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 1}), ErrVersionConflict)
+	}},
+	{"delete removes the book and is idempotent", func(ctx context.Context, repo BookRepository) error {
+		if err := repo.Create(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+			return err
+		}
+		if err := repo.Delete(ctx, 1); err != nil {
+			return err
+		}
+		if _, err := repo.GetById(ctx, 1); !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("get after delete: %w", wantErr(err, ErrNotFound))
+		}
+		if err := repo.Delete(ctx, 1); err != nil {
+			return fmt.Errorf("delete of a missing book: %w", err)
+		}
+		return nil
+	}},
+	{"pages hold every book once, in List order", func(ctx context.Context, repo BookRepository) error {
+		const n = 7
+		for i := 1; i <= n; i++ {
+			if err := repo.Create(ctx, &Book{Id: i, Name: fmt.Sprintf("Book %d", i), Author: "Author"}); err != nil {
+				return err
+			}
+		}
+		all, err := repo.List(ctx)
+		if err != nil {
+			return err
+		}
+		var paged []*Book
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > n {
+				return errors.New("pagination does not terminate")
+			}
+			page, next, err := repo.ListPage(ctx, 3, cursor)
+			if err != nil {
+				return err
+			}
+			if len(page) > 3 {
+				return fmt.Errorf("page of %d books exceeds the limit of 3", len(page))
+			}
+			paged = append(paged, page...)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		if got, want := bookIDs(paged), bookIDs(all); fmt.Sprint(got) != fmt.Sprint(want) {
+			return fmt.Errorf("pages hold ids %v, List %v", got, want)
+		}
+		if len(all) != n {
+			return fmt.Errorf("List returned %d books, want %d", len(all), n)
+		}
+		return nil
+	}},
+	{"an invalid cursor is rejected", func(ctx context.Context, repo BookRepository) error {
+		_, _, err := repo.ListPage(ctx, 3, "not a cursor")
+		if err == nil {
+			return errors.New("ListPage accepted an invalid cursor")
+		}
+		return nil
+	}},
+	{"sorted pages hold every book once, in sort order both ways", func(ctx context.Context, repo BookRepository) error {
+		names := []string{"Emma", "Dune", "Beloved", "Dune", "Anna Karenina"}
+		for i, name := range names {
+			if err := repo.Create(ctx, &Book{Id: i + 1, Name: name, Author: "Author"}); err != nil {
+				return err
+			}
+		}
+		for order, want := range map[SortOrder]string{
+			Ascending:  "[Anna Karenina Beloved Dune Dune Emma]",
+			Descending: "[Emma Dune Dune Beloved Anna Karenina]",
+		} {
+			var paged []*Book
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(names) {
+					return fmt.Errorf("%s: pagination does not terminate", order)
+				}
+				page, next, err := repo.ListSorted(ctx, ListOptions{SortBy: SortByName, Order: order, Limit: 2, Cursor: cursor})
+				if err != nil {
+					return err
+				}
+				if len(page) > 2 {
+					return fmt.Errorf("%s: page of %d books exceeds the limit of 2", order, len(page))
+				}
+				paged = append(paged, page...)
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			got := make([]string, len(paged))
+			for i, b := range paged {
+				got[i] = b.Name
+			}
+			if fmt.Sprint(got) != want {
+				return fmt.Errorf("%s: pages hold names %v, want %s", order, got, want)
+			}
+			if ids := sortedBookIDs(paged); fmt.Sprint(ids) != "[1 2 3 4 5]" {
+				return fmt.Errorf("%s: pages hold ids %v, want each of 1 to 5 once", order, ids)
+			}
+		}
+		return nil
+	}},
+	{"get by author returns exactly that author's books", func(ctx context.Context, repo BookRepository) error {
+		books := []*Book{
+			{Id: 1, Name: "Dune", Author: "Frank Herbert"},
+			{Id: 2, Name: "Emma", Author: "Jane Austen"},
+			{Id: 3, Name: "Dune Messiah", Author: "Frank Herbert"},
+		}
+		for _, b := range books {
+			if err := repo.Create(ctx, b); err != nil {
+				return err
+			}
+		}
+		got, err := repo.GetByAuthor(ctx, "Frank Herbert")
+		if err != nil {
+			return err
+		}
+		if ids := sortedBookIDs(got); fmt.Sprint(ids) != "[1 3]" {
+			return fmt.Errorf("books of Frank Herbert = %v, want [1 3]", ids)
+		}
+		got, err = repo.GetByAuthor(ctx, "Nobody")
+		if err != nil {
+			return err
+		}
+		if got == nil || len(got) != 0 {
+			return fmt.Errorf("books of an unknown author = %v, want an empty slice", got)
+		}
+		return nil
+	}},
+	{"get by isbn follows the book's current isbn", func(ctx context.Context, repo BookRepository) error {
+		const first, second = "9780441172719", "9780593099322"
+		book := &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", ISBN: first}
+		if err := repo.Create(ctx, book); err != nil {
+			return err
+		}
+		if got, err := repo.GetByISBN(ctx, first); err != nil || got.Id != 1 {
+			return fmt.Errorf("get by isbn %s = %v, %v; want book 1", first, got, err)
+		}
+		book.ISBN = second
+		if err := repo.Update(ctx, book); err != nil {
+			return err
+		}
+		if _, err := repo.GetByISBN(ctx, first); !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("get by replaced isbn: %w", wantErr(err, ErrNotFound))
+		}
+		if got, err := repo.GetByISBN(ctx, second); err != nil || got.Id != 1 {
+			return fmt.Errorf("get by isbn %s = %v, %v; want book 1", second, got, err)
+		}
+		return nil
+	}},
+	{"batch create overwrites and batch get skips missing ids", func(ctx context.Context, repo BookRepository) error {
+		if err := repo.Create(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+			return err
+		}
+		batch := []*Book{
+			{Id: 1, Name: "Dune Messiah", Author: "Frank Herbert"},
+			{Id: 2, Name: "Emma", Author: "Jane Austen"},
+		}
+		if err := repo.BatchCreate(ctx, batch); err != nil {
+			return err
+		}
+		got, err := repo.BatchGet(ctx, []int{2, 1, 2, 99})
+		if err != nil {
+			return err
+		}
+		if ids := sortedBookIDs(got); fmt.Sprint(ids) != "[1 2]" {
+			return fmt.Errorf("batch get returned ids %v, want [1 2]", ids)
+		}
+		return wantStored(ctx, repo, &Book{Id: 1, Name: "Dune Messiah", Author: "Frank Herbert", Version: 1})
+	}},
+}
+
+// wantErr returns nil if err matches target, or an error describing err.
+func wantErr(err, target error) error {
+	if errors.Is(err, target) {
+		return nil
+	}
+	return fmt.Errorf("got error %v, want %v", err, target)
+}
+
+// wantStored returns an error unless the stored book with want's id has
+// want's fields.
+func wantStored(ctx context.Context, repo BookRepository, want *Book) error {
+	got, err := repo.GetById(ctx, want.Id)
+	if err != nil {
+		return fmt.Errorf("get book %d: %w", want.Id, err)
+	}
+	if got.Name != want.Name || got.Author != want.Author || got.Version != want.Version ||
+		fmt.Sprint(got.Tags) != fmt.Sprint(want.Tags) {
+		return fmt.Errorf("stored book %+v, want %+v", *got, *want)
+	}
+	return nil
+}
+
+func bookIDs(books []*Book) []int {
+	ids := make([]int, len(books))
+	for i, b := range books {
+		ids[i] = b.Id
+	}
+	return ids
+}
+
+func sortedBookIDs(books []*Book) []int {
+	ids := bookIDs(books)
+	sort.Ints(ids)
+	return ids
+}
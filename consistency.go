@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+)
+
+// ReadOption adjusts a single read made through BookUseCase.
+type ReadOption func(*readPreference)
+
+// readPreference is the per-call read configuration carried in a context.
+type readPreference struct {
+	consistent *bool
+	fields     []string
+}
+
+// WithConsistentRead makes the read strongly consistent, so it reflects every
+// write that completed before it. It costs twice the read capacity and is
+// ignored by queries on global secondary indexes, which only support
+// eventually consistent reads.
+func WithConsistentRead() ReadOption {
+	return func(p *readPreference) {
+		p.consistent = aws.Bool(true)
+	}
+}
+
+// WithEventuallyConsistentRead makes the read eventually consistent,
+// overriding a repository built WithConsistentReads.
+func WithEventuallyConsistentRead() ReadOption {
+	return func(p *readPreference) {
+		p.consistent = aws.Bool(false)
+	}
+}
+
+// WithFields limits the read to the named top-level attributes, so list
+// screens that only need a few fields do not pay for whole items. Fields
+// left out are zero in the returned books. The key attributes, and whatever
+// else the repository needs to interpret an item, are always fetched.
+func WithFields(fields ...string) ReadOption {
+	return func(p *readPreference) {
+		p.fields = append(p.fields, fields...)
+	}
+}
+
+type readPreferenceKey struct{}
+
+// withReadOptions returns a context carrying opts for the repository calls
+// made with it. Without options ctx is returned unchanged.
+func withReadOptions(ctx context.Context, opts []ReadOption) context.Context {
+	if len(opts) == 0 {
+		return ctx
+	}
+	pref := &readPreference{}
+	for _, opt := range opts {
+		opt(pref)
+	}
+	return context.WithValue(ctx, readPreferenceKey{}, pref)
+}
+
+// consistentRead resolves whether a read made with ctx should be strongly
+// consistent, falling back to def when the caller expressed no preference.
+func consistentRead(ctx context.Context, def bool) *bool {
+	if pref, ok := ctx.Value(readPreferenceKey{}).(*readPreference); ok && pref.consistent != nil {
+		return pref.consistent
+	}
+	return aws.Bool(def)
+}
+
+// strongRead reports whether the caller of a read made with ctx asked for a
+// strongly consistent one.
+func strongRead(ctx context.Context) bool {
+	pref, ok := ctx.Value(readPreferenceKey{}).(*readPreference)
+	return ok && pref.consistent != nil && *pref.consistent
+}
+
+// projection returns the projection expression and attribute name
+// placeholders for a read made with ctx, or nils if the caller asked for
+// whole items. required names attributes added to every projection. Names
+// are always substituted with placeholders, so reserved words such as name
+// and year are safe.
+func projection(ctx context.Context, required []string) (*string, map[string]string, error) {
+	pref, ok := ctx.Value(readPreferenceKey{}).(*readPreference)
+	if !ok || len(pref.fields) == 0 {
+		return nil, nil, nil
+	}
+	var proj expression.ProjectionBuilder
+	seen := map[string]bool{}
+	for _, field := range append(append([]string{}, required...), pref.fields...) {
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+		proj = proj.AddNames(expression.Name(field))
+	}
+	expr, err := expression.NewBuilder().WithProjection(proj).Build()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid fields: %v", ErrValidation, err)
+	}
+	return expr.Projection(), expr.Names(), nil
+}
+
+// withRequiredFields returns ctx with fields added to those a partial read
+// fetches. Whole-item reads are left as they are.
+func withRequiredFields(ctx context.Context, fields ...string) context.Context {
+	pref, ok := ctx.Value(readPreferenceKey{}).(*readPreference)
+	if !ok || len(pref.fields) == 0 {
+		return ctx
+	}
+	extended := &readPreference{consistent: pref.consistent, fields: append(append([]string{}, pref.fields...), fields...)}
+	return context.WithValue(ctx, readPreferenceKey{}, extended)
+}
+
+// partialRead reports whether reads made with ctx fetch only some fields.
+func partialRead(ctx context.Context) bool {
+	pref, ok := ctx.Value(readPreferenceKey{}).(*readPreference)
+	return ok && len(pref.fields) > 0
+}
+
+// WithConsistentReads makes strongly consistent reads the repository default.
+// Individual calls can still opt out with WithEventuallyConsistentRead.
+func WithConsistentReads() RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.consistentReads = true
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// hoursPerMonth is the average month AWS bills by.
+const hoursPerMonth = 730
+
+// Pricing is the price of on-demand request units in USD.
+type Pricing struct {
+	ReadPerMillion  float64
+	WritePerMillion float64
+}
+
+// DefaultPricing is the on-demand pricing of us-east-1 for standard tables.
+// Other regions and the infrequent-access table class differ.
+var DefaultPricing = Pricing{ReadPerMillion: 0.125, WritePerMillion: 0.625}
+
+// OperationCapacity is the capacity consumed by the calls of one DynamoDB
+// operation.
+type OperationCapacity struct {
+	Operation  string  `json:"operation"`
+	Calls      int64   `json:"calls"`
+	ReadUnits  float64 `json:"readUnits"`
+	WriteUnits float64 `json:"writeUnits"`
+}
+
+// CapacityCollector aggregates the capacity consumed by every DynamoDB call
+// of the repositories it is installed in, per operation. Install it with
+// WithCapacityCollector or WithCompositeCapacityCollector.
+type CapacityCollector struct {
+	pricing Pricing
+	start   time.Time
+
+	mu  sync.Mutex
+	ops map[string]*OperationCapacity
+}
+
+// NewCapacityCollector returns a collector whose reports are priced with
+// pricing.
+func NewCapacityCollector(pricing Pricing) *CapacityCollector {
+	return &CapacityCollector{pricing: pricing, start: time.Now(), ops: map[string]*OperationCapacity{}}
+}
+
+// WithCapacityCollector makes the repository request the consumed capacity
+// of every call and record it in c.
+func WithCapacityCollector(c *CapacityCollector) RepositoryOption {
+	return WithClientOptions(c.clientOption)
+}
+
+// WithCompositeCapacityCollector is WithCapacityCollector for
+// CompositeBookRepository.
+func WithCompositeCapacityCollector(c *CapacityCollector) CompositeOption {
+	return WithCompositeClientOptions(c.clientOption)
+}
+
+// readOperations consume read capacity; all other operations that report
+// capacity consume write capacity.
+var readOperations = map[string]bool{
+	"GetItem":          true,
+	"BatchGetItem":     true,
+	"Query":            true,
+	"Scan":             true,
+	"TransactGetItems": true,
+}
+
+func (c *CapacityCollector) clientOption(o *dynamodb.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		op := stack.ID()
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CollectConsumedCapacity",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				if !requestConsumedCapacity(in.Parameters) {
+					return next.HandleInitialize(ctx, in)
+				}
+				out, md, err := next.HandleInitialize(ctx, in)
+				if err == nil {
+					c.record(op, out.Result)
+				}
+				return out, md, err
+			}), middleware.After)
+	})
+}
+
+// record adds the capacity reported in result to op. Transactions report
+// reads and writes separately; other operations only a total, which is
+// attributed by the kind of operation.
+func (c *CapacityCollector) record(op string, result any) {
+	var read, write float64
+	for _, cc := range consumedCapacities(result) {
+		switch {
+		case cc.ReadCapacityUnits != nil || cc.WriteCapacityUnits != nil:
+			read += floatValue(cc.ReadCapacityUnits)
+			write += floatValue(cc.WriteCapacityUnits)
+		case readOperations[op]:
+			read += floatValue(cc.CapacityUnits)
+		default:
+			write += floatValue(cc.CapacityUnits)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	oc := c.ops[op]
+	if oc == nil {
+		oc = &OperationCapacity{Operation: op}
+		c.ops[op] = oc
+	}
+	oc.Calls++
+	oc.ReadUnits += read
+	oc.WriteUnits += write
+}
+
+func floatValue(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return *f
+}
+
+// CostReport summarizes the capacity consumed since a collector was created
+// and extrapolates its on-demand cost to a month of the same traffic.
+type CostReport struct {
+	Since                time.Time           `json:"since"`
+	Elapsed              time.Duration       `json:"elapsed"`
+	Operations           []OperationCapacity `json:"operations"`
+	ReadUnits            float64             `json:"readUnits"`
+	WriteUnits           float64             `json:"writeUnits"`
+	Cost                 float64             `json:"cost"`
+	EstimatedMonthlyCost float64             `json:"estimatedMonthlyCost"`
+}
+
+// CostReport returns the capacity consumed so far, by operation name, and its
+// cost. The monthly estimate assumes the observed rate of use continues, so
+// it is only meaningful for representative periods of traffic.
+func (c *CapacityCollector) CostReport() CostReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := CostReport{Since: c.start, Elapsed: time.Since(c.start)}
+	for _, oc := range c.ops {
+		r.Operations = append(r.Operations, *oc)
+		r.ReadUnits += oc.ReadUnits
+		r.WriteUnits += oc.WriteUnits
+	}
+	sort.Slice(r.Operations, func(i, j int) bool { return r.Operations[i].Operation < r.Operations[j].Operation })
+	r.Cost = (r.ReadUnits*c.pricing.ReadPerMillion + r.WriteUnits*c.pricing.WritePerMillion) / 1e6
+	if r.Elapsed > 0 {
+		r.EstimatedMonthlyCost = r.Cost * float64(hoursPerMonth*time.Hour) / float64(r.Elapsed)
+	}
+	return r
+}
+
+// printCostReport writes r as an aligned table.
+func printCostReport(w io.Writer, r CostReport) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPERATION\tCALLS\tREAD UNITS\tWRITE UNITS")
+	for _, oc := range r.Operations {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.1f\n", oc.Operation, oc.Calls, oc.ReadUnits, oc.WriteUnits)
+	}
+	fmt.Fprintf(tw, "total\t\t%.1f\t%.1f\n", r.ReadUnits, r.WriteUnits)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "cost over %s: $%.6f, estimated monthly at this rate: $%.2f\n",
+		r.Elapsed.Round(time.Millisecond), r.Cost, r.EstimatedMonthlyCost)
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Count returns the number of books in the table using a Select=COUNT scan,
+// so no items are transferred or unmarshalled. Soft-deleted books are not
+// counted unless the repository includes them. The scan still reads, and
+// consumes capacity for, every item.
+func (d *DynamoDbBookRepository) Count(ctx context.Context) (int, error) {
+	input := &dynamodb.ScanInput{
+		TableName:      aws.String(d.tableName),
+		Select:         types.SelectCount,
+		ConsistentRead: consistentRead(ctx, d.consistentReads),
+	}
+	if !d.includeDeleted {
+		expr, err := expression.NewBuilder().
+			WithFilter(expression.AttributeNotExists(expression.Name(deletedAtAttribute))).
+			Build()
+		if err != nil {
+			return 0, err
+		}
+		input.FilterExpression = expr.Filter()
+		input.ExpressionAttributeNames = expr.Names()
+	}
+
+	count := 0
+	paginator := dynamodb.NewScanPaginator(d.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, translateError(err)
+		}
+		count += int(page.Count)
+	}
+	return count, nil
+}
+
+// Exists reports whether a book with the given id is stored. It fetches only
+// the key and deletedAt attributes, so soft-deleted books are reported as
+// missing unless the repository includes them. It honors WithConsistentRead;
+// WithFields is ignored, the projection stays key-only.
+func (d *DynamoDbBookRepository) Exists(ctx context.Context, id int) (bool, error) {
+	proj := expression.NamesList(expression.Name(idAttribute), expression.Name(deletedAtAttribute))
+	expr, err := expression.NewBuilder().WithProjection(proj).Build()
+	if err != nil {
+		return false, err
+	}
+	result, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:                      d.key.MarshalKey(id),
+		TableName:                aws.String(d.tableName),
+		ProjectionExpression:     expr.Projection(),
+		ExpressionAttributeNames: expr.Names(),
+		ConsistentRead:           consistentRead(ctx, d.consistentReads),
+	})
+	if err != nil {
+		return false, translateError(err)
+	}
+	if result.Item == nil {
+		return false, nil
+	}
+	if _, deleted := result.Item[deletedAtAttribute]; deleted && !d.includeDeleted {
+		return false, nil
+	}
+	return true, nil
+}
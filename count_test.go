@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestExistsPassesConsistentReadWithKeyOnlyProjection(t *testing.T) {
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		return map[string]any{"Item": map[string]any{"id": map[string]any{"N": "1"}}}, nil
+	})
+	repo := stub.repository()
+
+	for _, tc := range []struct {
+		name string
+		opts []ReadOption
+		want bool
+	}{
+		{"default", nil, false},
+		{"WithConsistentRead", []ReadOption{WithConsistentRead(), WithFields("name", "author")}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			exists, err := repo.Exists(withReadOptions(context.Background(), tc.opts), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !exists {
+				t.Error("Exists = false, want true")
+			}
+			calls := stub.callsTo("GetItem")
+			in := decodeInput(t, calls[len(calls)-1])
+			if got := in.ConsistentRead != nil && *in.ConsistentRead; got != tc.want {
+				t.Errorf("ConsistentRead = %v, want %v", got, tc.want)
+			}
+			projected := in.projected()
+			slices.Sort(projected)
+			if want := []string{deletedAtAttribute, idAttribute}; !slices.Equal(projected, want) {
+				t.Errorf("projection %q = %v, want only %v", in.ProjectionExpression, projected, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attribute names of the counters table, which is keyed by book id. Counters
+// live outside the book table so that Upsert and Update, which replace whole
+// book items, cannot reset them.
+const (
+	viewsAttribute    = "views"
+	borrowedAttribute = "borrowed"
+)
+
+// BookStats holds the counters of a book.
+type BookStats struct {
+	Id int `json:"id" dynamodbav:"id"`
+	// Views is the number of times the book has been viewed.
+	Views int `json:"views" dynamodbav:"views"`
+	// Borrowed is the number of copies currently on loan.
+	Borrowed int `json:"borrowed" dynamodbav:"borrowed"`
+}
+
+// countersTableName returns the name of the table holding the counters of
+// the books in bookTable.
+func countersTableName(bookTable string) string {
+	return bookTable + "-counters"
+}
+
+// IncrementViewCount atomically adds one to the view count of the book and
+// returns the new count. Counters start at zero, so the book does not have to
+// have been counted before.
+func (d *DynamoDbBookRepository) IncrementViewCount(ctx context.Context, id int) (int, error) {
+	return d.addToCounter(ctx, id, viewsAttribute, 1)
+}
+
+// Borrow atomically records one more copy of the book as on loan and returns
+// the number of copies now borrowed.
+func (d *DynamoDbBookRepository) Borrow(ctx context.Context, id int) (int, error) {
+	return d.addToCounter(ctx, id, borrowedAttribute, 1)
+}
+
+// Return atomically records a borrowed copy as returned and returns the
+// number of copies still borrowed. It fails with ErrNotBorrowed rather than
+// letting the count go negative.
+func (d *DynamoDbBookRepository) Return(ctx context.Context, id int) (int, error) {
+	n, err := d.addToCounter(ctx, id, borrowedAttribute, -1)
+	if isConflict(err) {
+		return 0, ErrNotBorrowed
+	}
+	return n, err
+}
+
+// GetStats returns the counters of the book. A book that was never viewed or
+// borrowed has zero counters rather than ErrNotFound.
+func (d *DynamoDbBookRepository) GetStats(ctx context.Context, id int) (*BookStats, error) {
+	result, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(countersTableName(d.tableName)),
+		Key:            NumberKey(idAttribute).MarshalKey(id),
+		ConsistentRead: consistentRead(ctx, d.consistentReads),
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	stats := &BookStats{Id: id}
+	if result.Item != nil {
+		if err := attributevalue.UnmarshalMap(result.Item, stats); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// addToCounter adds delta to the counter attribute of the book with a single
+// UpdateItem ADD, which DynamoDB applies atomically however many callers race.
+// Decrements are conditional so the counter never goes below zero; a failed
+// condition is reported as ErrConflict.
+func (d *DynamoDbBookRepository) addToCounter(ctx context.Context, id int, attribute string, delta int) (int, error) {
+	input := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(countersTableName(d.tableName)),
+		Key:                      NumberKey(idAttribute).MarshalKey(id),
+		UpdateExpression:         aws.String("ADD #count :delta"),
+		ExpressionAttributeNames: map[string]string{"#count": attribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":delta": &types.AttributeValueMemberN{Value: strconv.Itoa(delta)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	}
+	if delta < 0 {
+		input.ConditionExpression = aws.String("#count >= :min")
+		input.ExpressionAttributeValues[":min"] = &types.AttributeValueMemberN{Value: strconv.Itoa(-delta)}
+	}
+	result, err := d.client.UpdateItem(ctx, input)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	var n int
+	if err := attributevalue.Unmarshal(result.Attributes[attribute], &n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// countersTableDefinition describes the counters table of bookTable: a
+// numeric id partition key, like the book table.
+func countersTableDefinition(bookTable string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(countersTableName(bookTable)),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(idAttribute), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(idAttribute), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
+
+// MigrateCounters creates the counters table of bookTable if needed. It is
+// safe to run repeatedly.
+func MigrateCounters(ctx context.Context, client *dynamodb.Client, bookTable string) error {
+	return migrateTable(ctx, client, countersTableDefinition(bookTable))
+}
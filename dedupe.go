@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DefaultNameSimilarity is the similarity two normalized names must reach
+// for FindDuplicates to take their books for the same: names of five
+// letters may differ in one.
+const DefaultNameSimilarity = 0.8
+
+// maxTransactionItems is the most items DynamoDB accepts in one
+// TransactWriteItems call.
+const maxTransactionItems = 100
+
+// DuplicateGroup is a set of books that look like the same book.
+type DuplicateGroup struct {
+	// Keep is the book the others are merged into: the one with the lowest
+	// id.
+	Keep *Book `json:"keep"`
+	// Duplicates are the other books, by id.
+	Duplicates []*Book `json:"duplicates"`
+}
+
+// FindDuplicates groups books whose authors normalize to the same and whose
+// names are at least similarity alike once normalized, from 0 for any
+// names to 1 for equal ones. Names are grouped transitively: if A is like B
+// and B like C, all three are one group. Books without a duplicate are left
+// out; groups are returned by the id of the book to keep.
+func FindDuplicates(books []*Book, similarity float64) []DuplicateGroup {
+	byAuthor := map[string][]*Book{}
+	for _, book := range books {
+		key := normalizeAuthor(book.Author)
+		byAuthor[key] = append(byAuthor[key], book)
+	}
+
+	var groups []DuplicateGroup
+	for _, candidates := range byAuthor {
+		names := make([]string, len(candidates))
+		for i, book := range candidates {
+			names[i] = normalizeName(book.Name)
+		}
+		// Union-find over the candidates, joining every similar pair.
+		parent := make([]int, len(candidates))
+		for i := range parent {
+			parent[i] = i
+		}
+		var root func(int) int
+		root = func(i int) int {
+			if parent[i] != i {
+				parent[i] = root(parent[i])
+			}
+			return parent[i]
+		}
+		for i := range candidates {
+			for j := i + 1; j < len(candidates); j++ {
+				if nameSimilarity(names[i], names[j]) >= similarity {
+					parent[root(j)] = root(i)
+				}
+			}
+		}
+		clusters := map[int][]*Book{}
+		for i, book := range candidates {
+			clusters[root(i)] = append(clusters[root(i)], book)
+		}
+		for _, cluster := range clusters {
+			if len(cluster) < 2 {
+				continue
+			}
+			sort.Slice(cluster, func(i, j int) bool { return cluster[i].Id < cluster[j].Id })
+			groups = append(groups, DuplicateGroup{Keep: cluster[0], Duplicates: cluster[1:]})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Keep.Id < groups[j].Keep.Id })
+	return groups
+}
+
+// dedupeWords returns the lowercase words of s, splitting at anything but
+// letters and digits.
+func dedupeWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// normalizeName reduces a book name to its words, without punctuation, case
+// or a leading article, so "The Hobbit." and "hobbit" compare equal.
+func normalizeName(name string) string {
+	words := dedupeWords(name)
+	if len(words) > 1 {
+		switch words[0] {
+		case "the", "a", "an":
+			words = words[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// normalizeAuthor reduces an author to the letters of their sorted words, so
+// "J. R. R. Tolkien", "JRR Tolkien" and "Tolkien, J.R.R." compare equal.
+func normalizeAuthor(author string) string {
+	words := dedupeWords(author)
+	sort.Strings(words)
+	return strings.Join(words, "")
+}
+
+// nameSimilarity returns 1 minus the edit distance of a and b relative to
+// the longer of them, or 0 if they hold different numbers: "Volume 1" and
+// "Volume 2" are different books, however alike.
+func nameSimilarity(a, b string) float64 {
+	if digits(a) != digits(b) {
+		return 0
+	}
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(editDistance(ra, rb))/float64(longest)
+}
+
+// digits returns the digits of s.
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// editDistance returns the Levenshtein distance of a and b.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// mergedBook returns group.Keep completed from its duplicates: the tags of
+// all of them, the ISBN, year and publication date of the first that has one
+// if Keep has none, the earliest creation time and the review aggregates of
+// all of them.
+func mergedBook(group DuplicateGroup) *Book {
+	merged := copyBook(group.Keep)
+	for _, dup := range group.Duplicates {
+		merged.ReviewCount += dup.ReviewCount
+		merged.RatingTotal += dup.RatingTotal
+		if merged.ReviewCount > 0 {
+			merged.AverageRating = float64(merged.RatingTotal) / float64(merged.ReviewCount)
+		}
+		for _, tag := range dup.Tags {
+			if !slices.Contains(merged.Tags, tag) {
+				merged.Tags = append(merged.Tags, tag)
+			}
+		}
+		if merged.ISBN == "" {
+			merged.ISBN = dup.ISBN
+		}
+		if merged.Year == 0 {
+			merged.Year = dup.Year
+		}
+		if merged.PublishedAt.IsZero() {
+			merged.PublishedAt = dup.PublishedAt
+		}
+		if !dup.CreatedAt.IsZero() && (merged.CreatedAt.IsZero() || dup.CreatedAt.Before(merged.CreatedAt)) {
+			merged.CreatedAt = dup.CreatedAt
+		}
+	}
+	return merged
+}
+
+// MergeDuplicates merges the duplicates of group into group.Keep in one
+// transaction and returns the merged book. The duplicates are deleted,
+// their view and loan counters, copies and review aggregates added to those
+// of Keep, and an active hold on one of them moved to Keep. The ISBN claims
+// of the duplicates are moved to Keep if it takes over their ISBN, and
+// deleted otherwise. It fails with an error matching ErrConflict if any
+// book, claim or copy count changed since it was read, with ErrISBNTaken if
+// a book outside the group claims the ISBN Keep takes over, with
+// ErrBookOnHold if more than one book of the group is on hold, or with
+// ErrBookOnLoan if a duplicate has copies on loan, and then changes nothing. Ended holds
+// of the duplicates are left for the TTL to remove. The writes bypass
+// BookUseCase, so only stream consumers see them.
+func (d *DynamoDbBookRepository) MergeDuplicates(ctx context.Context, group DuplicateGroup) (*Book, error) {
+	merged := mergedBook(group)
+	merged.Version++
+	if err := validateBook(merged); err != nil {
+		return nil, fmt.Errorf("merge into book %d: %w", merged.Id, err)
+	}
+	av, err := d.codec.marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	condition, names, values := bookGuard(group.Keep)
+	items := []types.TransactWriteItem{{Put: &types.Put{
+		TableName:                 aws.String(d.tableName),
+		Item:                      av,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}}}
+	labels := []string{fmt.Sprintf("put book %d", merged.Id)}
+	for _, dup := range group.Duplicates {
+		condition, names, values := bookGuard(dup)
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 aws.String(d.tableName),
+			Key:                       d.key.MarshalKey(dup.Id),
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}})
+		labels = append(labels, fmt.Sprintf("delete book %d", dup.Id))
+	}
+
+	counterItems, counterLabels, err := d.mergeCounters(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	holdItems, holdLabels, err := d.mergeHolds(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	copyItems, copyLabels, err := d.mergeCopies(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	claimItems, claimLabels, err := d.mergeISBNClaims(ctx, group, merged)
+	if err != nil {
+		return nil, err
+	}
+	items = append(append(append(append(items, counterItems...), holdItems...), copyItems...), claimItems...)
+	labels = append(append(append(append(labels, counterLabels...), holdLabels...), copyLabels...), claimLabels...)
+	if len(items) > maxTransactionItems {
+		return nil, fmt.Errorf("%w: merging %d books into book %d takes %d writes, more than the %d of a transaction",
+			ErrValidation, len(group.Duplicates), merged.Id, len(items), maxTransactionItems)
+	}
+	if err := d.transact(ctx, items, labels); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// versionGuard returns the condition that a book is still at version, as
+// Update checks it.
+func versionGuard(version int) (string, map[string]string, map[string]types.AttributeValue) {
+	names := map[string]string{"#version": versionAttribute}
+	values := map[string]types.AttributeValue{":expected": &types.AttributeValueMemberN{Value: strconv.Itoa(version)}}
+	if version == 0 {
+		names["#id"] = idAttribute
+		return "attribute_exists(#id) AND (attribute_not_exists(#version) OR #version = :expected)", names, values
+	}
+	return "#version = :expected", names, values
+}
+
+// bookGuard returns the condition that book is still at its version and
+// has the review aggregate it was read with, which SingleTable.AddReview
+// changes without a new version.
+func bookGuard(book *Book) (string, map[string]string, map[string]types.AttributeValue) {
+	condition, names, values := versionGuard(book.Version)
+	names["#reviewCount"] = reviewCountAttribute
+	values[":reviewCount"] = &types.AttributeValueMemberN{Value: strconv.Itoa(book.ReviewCount)}
+	return "(" + condition + ") AND " + counterGuard("#reviewCount", ":reviewCount", book.ReviewCount), names, values
+}
+
+// mergeCounters returns the writes moving the counters of the duplicates of
+// group to Keep. Each counter item is deleted only if it still holds what
+// was read, so no concurrent view or loan is lost.
+func (d *DynamoDbBookRepository) mergeCounters(ctx context.Context, group DuplicateGroup) ([]types.TransactWriteItem, []string, error) {
+	ctx = withReadOptions(ctx, []ReadOption{WithConsistentRead()})
+	var items []types.TransactWriteItem
+	var labels []string
+	views, borrowed := 0, 0
+	for _, dup := range group.Duplicates {
+		stats, err := d.GetStats(ctx, dup.Id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read counters of book %d: %w", dup.Id, err)
+		}
+		if stats.Views == 0 && stats.Borrowed == 0 {
+			continue
+		}
+		views += stats.Views
+		borrowed += stats.Borrowed
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                aws.String(countersTableName(d.tableName)),
+			Key:                      NumberKey(idAttribute).MarshalKey(dup.Id),
+			ConditionExpression:      aws.String(counterGuard("#views", ":views", stats.Views) + " AND " + counterGuard("#borrowed", ":borrowed", stats.Borrowed)),
+			ExpressionAttributeNames: map[string]string{"#views": viewsAttribute, "#borrowed": borrowedAttribute},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":views":    &types.AttributeValueMemberN{Value: strconv.Itoa(stats.Views)},
+				":borrowed": &types.AttributeValueMemberN{Value: strconv.Itoa(stats.Borrowed)},
+			},
+		}})
+		labels = append(labels, fmt.Sprintf("delete counters of book %d", dup.Id))
+	}
+	if len(items) == 0 {
+		return nil, nil, nil
+	}
+	items = append(items, types.TransactWriteItem{Update: &types.Update{
+		TableName:                aws.String(countersTableName(d.tableName)),
+		Key:                      NumberKey(idAttribute).MarshalKey(group.Keep.Id),
+		UpdateExpression:         aws.String("ADD #views :views, #borrowed :borrowed"),
+		ExpressionAttributeNames: map[string]string{"#views": viewsAttribute, "#borrowed": borrowedAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":views":    &types.AttributeValueMemberN{Value: strconv.Itoa(views)},
+			":borrowed": &types.AttributeValueMemberN{Value: strconv.Itoa(borrowed)},
+		},
+	}})
+	labels = append(labels, fmt.Sprintf("add counters to book %d", group.Keep.Id))
+	return items, labels, nil
+}
+
+// counterGuard returns the condition that the counter name still holds n,
+// the value placeholder, a missing counter counting as zero.
+func counterGuard(name, value string, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("(attribute_not_exists(%s) OR %s = %s)", name, name, value)
+	}
+	return fmt.Sprintf("%s = %s", name, value)
+}
+
+// mergeCopies returns the writes moving the copies of the duplicates of
+// group to Keep. Each availability item is deleted only if it still counts
+// the copies read and lists no loans; duplicates without one must still
+// have none. A duplicate with copies on loan fails with ErrBookOnLoan:
+// moving its loans would mean rewriting their LOAN#<id> items too, so the
+// merge waits for them to be returned instead.
+func (d *DynamoDbBookRepository) mergeCopies(ctx context.Context, group DuplicateGroup) ([]types.TransactWriteItem, []string, error) {
+	var items []types.TransactWriteItem
+	var labels []string
+	available := 0
+	for _, dup := range group.Duplicates {
+		result, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(loansTableName(d.tableName)),
+			Key:            availabilityKey(dup.Id),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("read copies of book %d: %w", dup.Id, translateError(err))
+		}
+		if result.Item == nil {
+			items = append(items, types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+				TableName:                aws.String(loansTableName(d.tableName)),
+				Key:                      availabilityKey(dup.Id),
+				ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+				ExpressionAttributeNames: map[string]string{"#id": idAttribute},
+			}})
+			labels = append(labels, fmt.Sprintf("check book %d has no copies", dup.Id))
+			continue
+		}
+		var copies struct {
+			Available int      `dynamodbav:"available"`
+			Loans     []string `dynamodbav:"loans,stringset"`
+		}
+		if err := attributevalue.UnmarshalMap(result.Item, &copies); err != nil {
+			return nil, nil, fmt.Errorf("read copies of book %d: %w", dup.Id, err)
+		}
+		if len(copies.Loans) > 0 {
+			return nil, nil, fmt.Errorf("merge into book %d: book %d has %d copies on loan: %w",
+				group.Keep.Id, dup.Id, len(copies.Loans), ErrBookOnLoan)
+		}
+		available += copies.Available
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 aws.String(loansTableName(d.tableName)),
+			Key:                       availabilityKey(dup.Id),
+			ConditionExpression:       aws.String("attribute_not_exists(#loans) AND " + counterGuard("#available", ":available", copies.Available)),
+			ExpressionAttributeNames:  map[string]string{"#loans": loansAttribute, "#available": availableAttribute},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":available": &types.AttributeValueMemberN{Value: strconv.Itoa(copies.Available)}},
+		}})
+		labels = append(labels, fmt.Sprintf("delete copies of book %d", dup.Id))
+	}
+	if available == 0 {
+		return items, labels, nil
+	}
+	items = append(items, types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String(loansTableName(d.tableName)),
+		Key:                       availabilityKey(group.Keep.Id),
+		UpdateExpression:          aws.String("ADD #available :available"),
+		ExpressionAttributeNames:  map[string]string{"#available": availableAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":available": &types.AttributeValueMemberN{Value: strconv.Itoa(available)}},
+	}})
+	labels = append(labels, fmt.Sprintf("add copies to book %d", group.Keep.Id))
+	return items, labels, nil
+}
+
+// mergeISBNClaims returns the writes moving the ISBN claim of the
+// duplicate whose ISBN merged takes over to merged, and deleting the claims
+// the other duplicates hold. Each write requires the claim to still be held
+// by its duplicate, or, for an ISBN that nobody claimed, to still be absent.
+func (d *DynamoDbBookRepository) mergeISBNClaims(ctx context.Context, group DuplicateGroup, merged *Book) ([]types.TransactWriteItem, []string, error) {
+	var items []types.TransactWriteItem
+	var labels []string
+	heldBy := func(id int) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{":holder": &types.AttributeValueMemberN{Value: strconv.Itoa(id)}}
+	}
+	adopted := group.Keep.ISBN == "" && merged.ISBN != ""
+	for _, dup := range group.Duplicates {
+		if dup.ISBN == "" {
+			continue
+		}
+		claim, err := d.isbnClaim(ctx, dup.ISBN, aws.Bool(true))
+		if err != nil {
+			return nil, nil, fmt.Errorf("read claim of isbn %s: %w", dup.ISBN, err)
+		}
+		if adopted && dup.ISBN == merged.ISBN {
+			adopted = false
+			put := &types.Put{
+				TableName: aws.String(isbnTableName(d.tableName)),
+				Item: map[string]types.AttributeValue{
+					isbnAttribute:       &types.AttributeValueMemberS{Value: merged.ISBN},
+					isbnBookIdAttribute: &types.AttributeValueMemberN{Value: strconv.Itoa(merged.Id)},
+				},
+			}
+			switch {
+			case claim == nil:
+				put.ConditionExpression = aws.String("attribute_not_exists(#isbn)")
+				put.ExpressionAttributeNames = map[string]string{"#isbn": isbnAttribute}
+			case claim.BookId == dup.Id:
+				put.ConditionExpression = aws.String("#bookId = :holder")
+				put.ExpressionAttributeNames = map[string]string{"#bookId": isbnBookIdAttribute}
+				put.ExpressionAttributeValues = heldBy(dup.Id)
+			default:
+				return nil, nil, fmt.Errorf("merge isbn %s into book %d: claimed by book %d: %w",
+					merged.ISBN, merged.Id, claim.BookId, ErrISBNTaken)
+			}
+			items = append(items, types.TransactWriteItem{Put: put})
+			labels = append(labels, fmt.Sprintf("move claim of isbn %s to book %d", merged.ISBN, merged.Id))
+			continue
+		}
+		if claim == nil || claim.BookId != dup.Id {
+			continue
+		}
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 aws.String(isbnTableName(d.tableName)),
+			Key:                       map[string]types.AttributeValue{isbnAttribute: &types.AttributeValueMemberS{Value: dup.ISBN}},
+			ConditionExpression:       aws.String("#bookId = :holder"),
+			ExpressionAttributeNames:  map[string]string{"#bookId": isbnBookIdAttribute},
+			ExpressionAttributeValues: heldBy(dup.Id),
+		}})
+		labels = append(labels, fmt.Sprintf("delete claim of isbn %s", dup.ISBN))
+	}
+	return items, labels, nil
+}
+
+// mergeHolds returns the writes moving the active hold of a duplicate of
+// group, if there is one, to Keep.
+func (d *DynamoDbBookRepository) mergeHolds(ctx context.Context, group DuplicateGroup) ([]types.TransactWriteItem, []string, error) {
+	ctx = withReadOptions(ctx, []ReadOption{WithConsistentRead()})
+	var held []*Reservation
+	for _, book := range append([]*Book{group.Keep}, group.Duplicates...) {
+		hold, err := d.GetHold(ctx, book.Id)
+		switch {
+		case errors.Is(err, ErrNotFound):
+		case err != nil:
+			return nil, nil, fmt.Errorf("read hold of book %d: %w", book.Id, err)
+		default:
+			held = append(held, hold)
+		}
+	}
+	switch {
+	case len(held) > 1:
+		return nil, nil, fmt.Errorf("merge into book %d: books %d and %d are both held: %w",
+			group.Keep.Id, held[0].BookId, held[1].BookId, ErrBookOnHold)
+	case len(held) == 0 || held[0].BookId == group.Keep.Id:
+		return nil, nil, nil
+	}
+
+	from := held[0]
+	moved := *from
+	moved.BookId = group.Keep.Id
+	item, err := attributevalue.MarshalMap(&moved)
+	if err != nil {
+		return nil, nil, err
+	}
+	active := &types.AttributeValueMemberS{Value: string(HoldActive)}
+	now := &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)}
+	items := []types.TransactWriteItem{
+		{Put: &types.Put{
+			TableName: aws.String(holdsTableName(d.tableName)),
+			Item:      item,
+			// Keep must not have gained an active hold since it was read.
+			ConditionExpression:       aws.String("attribute_not_exists(#id) OR #state <> :active OR #expiresAt <= :now"),
+			ExpressionAttributeNames:  map[string]string{"#id": idAttribute, "#state": holdStateAttribute, "#expiresAt": ttlAttribute},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":active": active, ":now": now},
+		}},
+		{Delete: &types.Delete{
+			TableName:                aws.String(holdsTableName(d.tableName)),
+			Key:                      NumberKey(idAttribute).MarshalKey(from.BookId),
+			ConditionExpression:      aws.String("#state = :active AND #holder = :holder AND #expiresAt > :now"),
+			ExpressionAttributeNames: map[string]string{"#state": holdStateAttribute, "#holder": holderAttribute, "#expiresAt": ttlAttribute},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":active": active,
+				":now":    now,
+				":holder": &types.AttributeValueMemberS{Value: from.Holder},
+			},
+		}},
+	}
+	labels := []string{
+		fmt.Sprintf("move hold to book %d", group.Keep.Id),
+		fmt.Sprintf("delete hold of book %d", from.BookId),
+	}
+	return items, labels, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// claimStub returns a dynamoStub of a table whose ISBN claims are claims,
+// from ISBN to book id, and which has no counters or holds. Transactions
+// succeed.
+func claimStub(t *testing.T, claims map[string]int) *dynamoStub {
+	return newDynamoStub(t, func(op string, input []byte) (any, error) {
+		switch op {
+		case "GetItem":
+			in := decodeInput(t, input)
+			if in.TableName != isbnTableName(stubTable) {
+				return map[string]any{}, nil
+			}
+			isbn := in.Key[isbnAttribute].value().(*types.AttributeValueMemberS).Value
+			id, ok := claims[isbn]
+			if !ok {
+				return map[string]any{}, nil
+			}
+			claim := map[string]types.AttributeValue{
+				isbnAttribute:       &types.AttributeValueMemberS{Value: isbn},
+				isbnBookIdAttribute: &types.AttributeValueMemberN{Value: strconv.Itoa(id)},
+			}
+			return map[string]any{"Item": wireAttributes(t, claim)}, nil
+		case "TransactWriteItems":
+			return nil, nil
+		}
+		return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+	})
+}
+
+// claimWrites returns the writes to the ISBN table of a TransactWriteItems
+// input, e.g. "put 9780441172719 1" or "delete 9780593099322".
+func claimWrites(t *testing.T, input []byte) []string {
+	t.Helper()
+	var in struct {
+		TransactItems []struct {
+			Put, Delete *struct {
+				TableName string
+				Item, Key map[string]*encodedAttribute
+			}
+		}
+	}
+	if err := json.Unmarshal(input, &in); err != nil {
+		t.Fatal(err)
+	}
+	var writes []string
+	for _, item := range in.TransactItems {
+		switch {
+		case item.Put != nil && item.Put.TableName == isbnTableName(stubTable):
+			claim := attributes(item.Put.Item)
+			writes = append(writes, "put "+claim[isbnAttribute].(*types.AttributeValueMemberS).Value+" "+
+				claim[isbnBookIdAttribute].(*types.AttributeValueMemberN).Value)
+		case item.Delete != nil && item.Delete.TableName == isbnTableName(stubTable):
+			writes = append(writes, "delete "+attributes(item.Delete.Key)[isbnAttribute].(*types.AttributeValueMemberS).Value)
+		}
+	}
+	return writes
+}
+
+func TestMergeDuplicatesMovesISBNClaims(t *testing.T) {
+	const dune, messiah, emma = "9780441172719", "9780593099322", "9780141439587"
+	book := func(id int, isbn string) *Book {
+		return &Book{Id: id, Name: "Dune", Author: "Frank Herbert", ISBN: isbn, Version: 1}
+	}
+	for _, tc := range []struct {
+		name     string
+		group    DuplicateGroup
+		claims   map[string]int
+		wantISBN string
+		want     []string
+	}{{
+		name:     "keep without isbn takes over the first",
+		group:    DuplicateGroup{Keep: book(1, ""), Duplicates: []*Book{book(2, dune), book(3, messiah)}},
+		claims:   map[string]int{dune: 2, messiah: 3},
+		wantISBN: dune,
+		want:     []string{"put " + dune + " 1", "delete " + messiah},
+	}, {
+		name:     "keep with isbn keeps it",
+		group:    DuplicateGroup{Keep: book(1, emma), Duplicates: []*Book{book(2, dune)}},
+		claims:   map[string]int{emma: 1, dune: 2},
+		wantISBN: emma,
+		want:     []string{"delete " + dune},
+	}, {
+		name:     "unclaimed isbn is claimed",
+		group:    DuplicateGroup{Keep: book(1, ""), Duplicates: []*Book{book(2, dune)}},
+		claims:   map[string]int{},
+		wantISBN: dune,
+		want:     []string{"put " + dune + " 1"},
+	}, {
+		name:     "stale claims of other books are left alone",
+		group:    DuplicateGroup{Keep: book(1, emma), Duplicates: []*Book{book(2, dune)}},
+		claims:   map[string]int{emma: 1, dune: 9},
+		wantISBN: emma,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := claimStub(t, tc.claims)
+			merged, err := stub.repository().MergeDuplicates(context.Background(), tc.group)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if merged.ISBN != tc.wantISBN {
+				t.Errorf("merged isbn %q, want %q", merged.ISBN, tc.wantISBN)
+			}
+			txs := stub.callsTo("TransactWriteItems")
+			if len(txs) != 1 {
+				t.Fatalf("%d transactions, want 1", len(txs))
+			}
+			if got := claimWrites(t, txs[0]); !slices.Equal(got, tc.want) {
+				t.Errorf("claim writes %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMergeDuplicatesFailsForISBNsClaimedOutsideTheGroup(t *testing.T) {
+	const dune = "9780441172719"
+	stub := claimStub(t, map[string]int{dune: 9})
+	group := DuplicateGroup{
+		Keep:       &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 1},
+		Duplicates: []*Book{{Id: 2, Name: "Dune", Author: "Frank Herbert", ISBN: dune, Version: 1}},
+	}
+	if _, err := stub.repository().MergeDuplicates(context.Background(), group); !errors.Is(err, ErrISBNTaken) {
+		t.Fatalf("got %v, want ErrISBNTaken", err)
+	}
+	if txs := stub.callsTo("TransactWriteItems"); len(txs) != 0 {
+		t.Errorf("%d transactions, want none", len(txs))
+	}
+}
+
+// copiesStub returns a dynamoStub of a table whose loans table holds the
+// availability items copies, by book id, and which has no counters, holds
+// or claims. Transactions succeed.
+func copiesStub(t *testing.T, copies map[int]map[string]types.AttributeValue) *dynamoStub {
+	return newDynamoStub(t, func(op string, input []byte) (any, error) {
+		switch op {
+		case "GetItem":
+			in := decodeInput(t, input)
+			if in.TableName != loansTableName(stubTable) {
+				return map[string]any{}, nil
+			}
+			for id, item := range copies {
+				if *in.Key[idAttribute].S == availabilityKeyPrefix+strconv.Itoa(id) {
+					return map[string]any{"Item": wireAttributes(t, item)}, nil
+				}
+			}
+			return map[string]any{}, nil
+		case "TransactWriteItems":
+			return nil, nil
+		}
+		return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+	})
+}
+
+func TestMergeDuplicatesMovesCopiesAndReviewAggregates(t *testing.T) {
+	available := func(id, n int) map[string]types.AttributeValue {
+		item := availabilityKey(id)
+		item[availableAttribute] = &types.AttributeValueMemberN{Value: strconv.Itoa(n)}
+		return item
+	}
+	stub := copiesStub(t, map[int]map[string]types.AttributeValue{1: available(1, 1), 2: available(2, 2)})
+	group := DuplicateGroup{
+		Keep: &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 1, ReviewCount: 1, RatingTotal: 5, AverageRating: 5},
+		Duplicates: []*Book{
+			{Id: 2, Name: "Dune", Author: "Frank Herbert", Version: 1, ReviewCount: 2, RatingTotal: 5, AverageRating: 2.5},
+			{Id: 3, Name: "Dune", Author: "Frank Herbert", Version: 1},
+		},
+	}
+	merged, err := stub.repository().MergeDuplicates(context.Background(), group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.ReviewCount != 3 || merged.RatingTotal != 10 || merged.AverageRating != 10.0/3 {
+		t.Errorf("merged aggregate %d reviews, total %d, average %g, want 3, 10 and 10/3",
+			merged.ReviewCount, merged.RatingTotal, merged.AverageRating)
+	}
+
+	txs := stub.callsTo("TransactWriteItems")
+	if len(txs) != 1 {
+		t.Fatalf("%d transactions, want 1", len(txs))
+	}
+	type write struct {
+		TableName           string
+		Key                 map[string]*encodedAttribute
+		ConditionExpression string
+	}
+	var in struct {
+		TransactItems []struct{ Put, Delete, Update, ConditionCheck *write }
+	}
+	if err := json.Unmarshal(txs[0], &in); err != nil {
+		t.Fatal(err)
+	}
+	var writes []string
+	for _, item := range in.TransactItems {
+		for op, w := range map[string]*write{"put": item.Put, "delete": item.Delete, "update": item.Update, "check": item.ConditionCheck} {
+			switch {
+			case w == nil:
+			case w.TableName == loansTableName(stubTable):
+				writes = append(writes, op+" "+*w.Key[idAttribute].S)
+			case op == "put" || op == "delete":
+				if !strings.Contains(w.ConditionExpression, "#reviewCount") {
+					t.Errorf("%s of a book with condition %q, want it to guard the review count", op, w.ConditionExpression)
+				}
+			}
+		}
+	}
+	if want := []string{"delete BOOK#2", "check BOOK#3", "update BOOK#1"}; !slices.Equal(writes, want) {
+		t.Errorf("writes to the loans table %q, want %q", writes, want)
+	}
+}
+
+func TestMergeDuplicatesRefusesBooksWithOpenLoans(t *testing.T) {
+	stub := copiesStub(t, map[int]map[string]types.AttributeValue{2: {
+		idAttribute:        &types.AttributeValueMemberS{Value: availabilityKeyPrefix + "2"},
+		availableAttribute: &types.AttributeValueMemberN{Value: "0"},
+		loansAttribute:     &types.AttributeValueMemberSS{Value: []string{"loan-1"}},
+	}})
+	group := DuplicateGroup{
+		Keep:       &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 1},
+		Duplicates: []*Book{{Id: 2, Name: "Dune", Author: "Frank Herbert", Version: 1}},
+	}
+	if _, err := stub.repository().MergeDuplicates(context.Background(), group); !errors.Is(err, ErrBookOnLoan) {
+		t.Fatalf("got %v, want ErrBookOnLoan", err)
+	}
+	if txs := stub.callsTo("TransactWriteItems"); len(txs) != 0 {
+		t.Errorf("%d transactions, want none", len(txs))
+	}
+}
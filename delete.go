@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DeleteIfExists is like Delete but returns ErrNotFound if there is no book
+// with the given id. Soft-deleted books still exist and are removed.
+func (d *DynamoDbBookRepository) DeleteIfExists(ctx context.Context, id int) error {
+	expr, err := expression.NewBuilder().
+		WithCondition(expression.AttributeExists(expression.Name(idAttribute))).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		Key:                      d.key.MarshalKey(id),
+		TableName:                aws.String(d.tableName),
+		ConditionExpression:      expr.Condition(),
+		ExpressionAttributeNames: expr.Names(),
+	})
+	if err != nil {
+		err = translateError(err)
+		if isConflict(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteReturning deletes the book with the given id and returns it as it
+// was stored, soft-deleted or not. It returns ErrNotFound if there was no
+// such book, in which case nothing is written.
+func (d *DynamoDbBookRepository) DeleteReturning(ctx context.Context, id int) (*Book, error) {
+	result, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		Key:          d.key.MarshalKey(id),
+		TableName:    aws.String(d.tableName),
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if len(result.Attributes) == 0 {
+		return nil, ErrNotFound
+	}
+	book := new(Book)
+	if err := d.codec.unmarshal(result.Attributes, book); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
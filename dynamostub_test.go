@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// stubTable is the table of the repositories of dynamoStub.
+const stubTable = "books"
+
+// dynamoStub is an HTTP endpoint standing in for DynamoDB. Every call is
+// recorded and answered by handle with the JSON of the output, or with a
+// stubError. Responses carry a request id, "req-<n>" for the nth call.
+type dynamoStub struct {
+	handle func(op string, input []byte) (any, error)
+
+	mu    sync.Mutex
+	calls []stubCall
+	url   string
+}
+
+// stubCall is a request received by a dynamoStub.
+type stubCall struct {
+	Op    string
+	Input []byte
+}
+
+// stubError is a DynamoDB error returned by a dynamoStub, e.g.
+// ConditionalCheckFailedException.
+type stubError struct {
+	Type    string
+	Message string
+	// Item is the item returned along a failed condition check.
+	Item map[string]any
+}
+
+func (e *stubError) Error() string { return e.Type + ": " + e.Message }
+
+func newDynamoStub(t testing.TB, handle func(op string, input []byte) (any, error)) *dynamoStub {
+	t.Helper()
+	s := &dynamoStub{handle: handle}
+	srv := httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(srv.Close)
+	s.url = srv.URL
+	return s
+}
+
+func (s *dynamoStub) serve(w http.ResponseWriter, r *http.Request) {
+	op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	input, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.calls = append(s.calls, stubCall{Op: op, Input: input})
+	n := len(s.calls)
+	s.mu.Unlock()
+
+	output, err := s.handle(op, input)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.Header().Set("X-Amzn-Requestid", fmt.Sprintf("req-%d", n))
+	var stubErr *stubError
+	switch {
+	case errors.As(err, &stubErr):
+		w.WriteHeader(http.StatusBadRequest)
+		body := map[string]any{"__type": "com.amazonaws.dynamodb.v20120810#" + stubErr.Type, "message": stubErr.Message}
+		if stubErr.Item != nil {
+			body["Item"] = stubErr.Item
+		}
+		json.NewEncoder(w).Encode(body)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		if output == nil {
+			output = map[string]any{}
+		}
+		json.NewEncoder(w).Encode(output)
+	}
+}
+
+// repository returns a repository of stubTable sending its calls to s.
+func (s *dynamoStub) repository(opts ...RepositoryOption) *DynamoDbBookRepository {
+	opts = append([]RepositoryOption{WithEndpoint(s.url)}, opts...)
+	return NewDynamoDBBookRepository(aws.Config{Region: "us-east-1"}, stubTable, opts...)
+}
+
+// callsTo returns the inputs of the calls of operation op, in order.
+func (s *dynamoStub) callsTo(op string) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var inputs [][]byte
+	for _, c := range s.calls {
+		if c.Op == op {
+			inputs = append(inputs, c.Input)
+		}
+	}
+	return inputs
+}
+
+// wireItem returns book as an item in DynamoDB JSON, as marshaled by the
+// default codec.
+func wireItem(t testing.TB, book *Book) map[string]any {
+	t.Helper()
+	item, err := attributevalue.MarshalMap(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wireAttributes(t, item)
+}
+
+// wireAttributes returns item in DynamoDB JSON.
+func wireAttributes(t testing.TB, item map[string]types.AttributeValue) map[string]any {
+	t.Helper()
+	wire := make(map[string]any, len(item))
+	for name, av := range item {
+		v, err := attributeJSON(av)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wire[name] = v
+	}
+	return wire
+}
+
+// wireInput is the part of DynamoDB requests the tests look into.
+type wireInput struct {
+	TableName                 string
+	IndexName                 string
+	ScanIndexForward          *bool
+	Key                       map[string]*encodedAttribute
+	Item                      map[string]*encodedAttribute
+	UpdateExpression          string
+	KeyConditionExpression    string
+	ConditionExpression       string
+	FilterExpression          string
+	ProjectionExpression      string
+	ConsistentRead            *bool
+	Limit                     *int
+	ExclusiveStartKey         map[string]*encodedAttribute
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues map[string]*encodedAttribute
+	RequestItems              map[string]struct {
+		Keys           []map[string]*encodedAttribute
+		ConsistentRead *bool
+	}
+}
+
+func decodeInput(t testing.TB, input []byte) wireInput {
+	t.Helper()
+	var in wireInput
+	if err := json.Unmarshal(input, &in); err != nil {
+		t.Fatalf("decode input %s: %v", input, err)
+	}
+	return in
+}
+
+// attributes converts attributes decoded from DynamoDB JSON.
+func attributes(wire map[string]*encodedAttribute) map[string]types.AttributeValue {
+	item := make(map[string]types.AttributeValue, len(wire))
+	for name, v := range wire {
+		item[name] = v.value()
+	}
+	return item
+}
+
+// keyID returns the book id of the key of a request.
+func keyID(t testing.TB, key map[string]*encodedAttribute) int {
+	t.Helper()
+	id, err := NumberKey(idAttribute).UnmarshalKey(attributes(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// scanPages returns a handler answering Scan and Query with pages of items:
+// the first call gets the first page, and a call continuing from the
+// LastEvaluatedKey of a page gets the page after it.
+func scanPages(t testing.TB, pages ...[]map[string]any) func(op string, input []byte) (any, error) {
+	return func(op string, input []byte) (any, error) {
+		if op != "Scan" && op != "Query" {
+			return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+		}
+		page := 0
+		if start := decodeInput(t, input).ExclusiveStartKey; start != nil {
+			page = keyID(t, start)
+		}
+		output := map[string]any{"Items": pages[page], "Count": len(pages[page])}
+		if page+1 < len(pages) {
+			output["LastEvaluatedKey"] = wireAttributes(t, NumberKey(idAttribute).MarshalKey(page+1))
+		}
+		return output, nil
+	}
+}
+
+// projected returns the attribute names of the ProjectionExpression of in.
+func (in wireInput) projected() []string {
+	var names []string
+	for _, ref := range strings.Split(in.ProjectionExpression, ",") {
+		names = append(names, in.ExpressionAttributeNames[strings.TrimSpace(ref)])
+	}
+	return names
+}
+
+// newTableStub returns a dynamoStub storing items by id, for tests that need
+// reads to see earlier writes. PutItem, UpdateItem and BatchWriteItem
+// write unconditionally, UpdateItem as applyUpdate does; Scan and Query
+// return every item in one page.
+func newTableStub(t testing.TB) *dynamoStub {
+	var mu sync.Mutex
+	items := map[int]map[string]types.AttributeValue{}
+	all := func() []map[string]any {
+		wire := []map[string]any{}
+		for _, item := range items {
+			wire = append(wire, wireAttributes(t, item))
+		}
+		return wire
+	}
+	return newDynamoStub(t, func(op string, input []byte) (any, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if op == "BatchWriteItem" {
+			// Its RequestItems do not fit wireInput.
+			var batch struct {
+				RequestItems map[string][]struct {
+					PutRequest struct{ Item map[string]*encodedAttribute }
+				}
+			}
+			if err := json.Unmarshal(input, &batch); err != nil {
+				return nil, err
+			}
+			for _, request := range batch.RequestItems[stubTable] {
+				items[keyID(t, request.PutRequest.Item)] = attributes(request.PutRequest.Item)
+			}
+			return map[string]any{}, nil
+		}
+		in := decodeInput(t, input)
+		switch op {
+		case "PutItem":
+			items[keyID(t, in.Item)] = attributes(in.Item)
+			return nil, nil
+		case "UpdateItem":
+			id := keyID(t, in.Key)
+			items[id] = applyUpdate(t, items[id], in)
+			return map[string]any{"Attributes": wireAttributes(t, items[id])}, nil
+		case "GetItem":
+			item, ok := items[keyID(t, in.Key)]
+			if !ok {
+				return map[string]any{}, nil
+			}
+			return map[string]any{"Item": wireAttributes(t, item)}, nil
+		case "DeleteItem":
+			delete(items, keyID(t, in.Key))
+			return nil, nil
+		case "Scan", "Query":
+			page := all()
+			return map[string]any{"Items": page, "Count": len(page)}, nil
+		}
+		return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+	})
+}
+
+// updateClause matches the keyword of each clause of an update expression.
+var updateClause = regexp.MustCompile(`\b(SET|REMOVE|ADD|DELETE)\b`)
+
+// applyUpdate applies the UpdateExpression of in to item, which may be nil
+// for an item that does not exist yet, and returns the updated item. It
+// understands the SET, REMOVE, ADD and DELETE clauses written by this
+// package and the expression package: SET of a value, of if_not_exists and
+// of a sum or difference, ADD of numbers and sets, DELETE from sets.
+func applyUpdate(t testing.TB, item map[string]types.AttributeValue, in wireInput) map[string]types.AttributeValue {
+	t.Helper()
+	updated := make(map[string]types.AttributeValue, len(item))
+	for name, value := range item {
+		updated[name] = value
+	}
+	for name, value := range attributes(in.Key) {
+		updated[name] = value
+	}
+	name := func(ref string) string {
+		ref = strings.TrimSpace(ref)
+		if !strings.HasPrefix(ref, "#") {
+			return ref
+		}
+		n, ok := in.ExpressionAttributeNames[ref]
+		if !ok {
+			t.Fatalf("update expression %q: unknown name %s", in.UpdateExpression, ref)
+		}
+		return n
+	}
+	var operand func(s string) types.AttributeValue
+	operand = func(s string) types.AttributeValue {
+		s = strings.TrimSpace(s)
+		if i := strings.LastIndexAny(s, "+-"); i > 0 && !strings.HasSuffix(s[:i], "(") {
+			a, b := operand(s[:i]), operand(s[i+1:])
+			x, _ := strconv.ParseInt(a.(*types.AttributeValueMemberN).Value, 10, 64)
+			y, _ := strconv.ParseInt(b.(*types.AttributeValueMemberN).Value, 10, 64)
+			if s[i] == '-' {
+				y = -y
+			}
+			return &types.AttributeValueMemberN{Value: strconv.FormatInt(x+y, 10)}
+		}
+		if args, ok := strings.CutPrefix(s, "if_not_exists("); ok {
+			path, def, _ := strings.Cut(strings.TrimSuffix(args, ")"), ",")
+			if v, ok := updated[name(path)]; ok {
+				return v
+			}
+			return operand(def)
+		}
+		if strings.HasPrefix(s, ":") {
+			v, ok := in.ExpressionAttributeValues[s]
+			if !ok {
+				t.Fatalf("update expression %q: unknown value %s", in.UpdateExpression, s)
+			}
+			return v.value()
+		}
+		v, ok := updated[name(s)]
+		if !ok {
+			t.Fatalf("update expression %q: %s is not set", in.UpdateExpression, s)
+		}
+		return v
+	}
+	// The actions of a clause are separated by commas outside parentheses.
+	actions := func(clause string) []string {
+		var list []string
+		depth, start := 0, 0
+		for i, c := range clause {
+			switch c {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			case ',':
+				if depth == 0 {
+					list = append(list, clause[start:i])
+					start = i + 1
+				}
+			}
+		}
+		return append(list, clause[start:])
+	}
+	bounds := updateClause.FindAllStringIndex(in.UpdateExpression, -1)
+	for i, b := range bounds {
+		end := len(in.UpdateExpression)
+		if i+1 < len(bounds) {
+			end = bounds[i+1][0]
+		}
+		keyword, clause := in.UpdateExpression[b[0]:b[1]], in.UpdateExpression[b[1]:end]
+		for _, action := range actions(clause) {
+			switch keyword {
+			case "SET":
+				path, value, _ := strings.Cut(action, "=")
+				updated[name(path)] = operand(value)
+			case "REMOVE":
+				delete(updated, name(action))
+			case "ADD", "DELETE":
+				fields := strings.Fields(action)
+				attr, value := name(fields[0]), operand(fields[1])
+				old, exists := updated[attr]
+				switch v := value.(type) {
+				case *types.AttributeValueMemberN:
+					sum := v
+					if exists {
+						sum = operand(fields[0] + " + " + fields[1]).(*types.AttributeValueMemberN)
+					}
+					updated[attr] = sum
+				case *types.AttributeValueMemberSS:
+					var set []string
+					if exists {
+						set = old.(*types.AttributeValueMemberSS).Value
+					}
+					if keyword == "ADD" {
+						for _, s := range v.Value {
+							if !slices.Contains(set, s) {
+								set = append(set, s)
+							}
+						}
+					} else {
+						set = slices.DeleteFunc(slices.Clone(set), func(s string) bool { return slices.Contains(v.Value, s) })
+					}
+					if len(set) == 0 {
+						delete(updated, attr)
+					} else {
+						updated[attr] = &types.AttributeValueMemberSS{Value: set}
+					}
+				default:
+					t.Fatalf("update expression %q: cannot %s a %T", in.UpdateExpression, keyword, value)
+				}
+			}
+		}
+	}
+	return updated
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"dynamoDBExample/config"
+)
+
+// ErrDecrypt is returned when an encrypted attribute cannot be decrypted:
+// it was tampered with, or its data key is unknown to the DataKeyProvider.
+var ErrDecrypt = errors.New("cannot decrypt attribute")
+
+// DataKeyProvider issues the data keys that encrypt attributes, the way KMS
+// GenerateDataKey and Decrypt do: each data key is returned in plaintext and
+// wrapped under a master key named by keyID, and only the wrapped form is
+// stored next to the data. A KMS client implements it with GenerateDataKey
+// (KeySpec AES_256) and Decrypt; LocalKeyProvider keeps master keys in
+// memory.
+type DataKeyProvider interface {
+	GenerateDataKey(ctx context.Context) (plaintext, wrapped []byte, keyID string, err error)
+	DecryptDataKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKeyProvider is a DataKeyProvider wrapping data keys with AES-GCM
+// under master keys it holds, e.g. ones read from a secrets store at start.
+// New data keys are wrapped under the active master key; the others are
+// kept to unwrap data keys issued before a rotation.
+type LocalKeyProvider struct {
+	mu     sync.RWMutex
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// NewLocalKeyProvider returns a provider whose active master key is key,
+// named id. Keys must be 16, 24 or 32 bytes long.
+func NewLocalKeyProvider(id string, key []byte) (*LocalKeyProvider, error) {
+	p := &LocalKeyProvider{keys: map[string]cipher.AEAD{}}
+	if err := p.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Rotate makes key, named id, the active master key. Earlier master keys
+// still unwrap the data keys they issued.
+func (p *LocalKeyProvider) Rotate(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("master key id must be 1 to 255 bytes, got %d", len(id))
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return fmt.Errorf("master key %s: %w", id, err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[id] = aead
+	p.active = id
+	return nil
+}
+
+// GenerateDataKey implements DataKeyProvider.
+func (p *LocalKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, string, error) {
+	p.mu.RLock()
+	id, aead := p.active, p.keys[p.active]
+	p.mu.RUnlock()
+	plaintext := make([]byte, 32)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, nil, "", err
+	}
+	wrapped, err := seal(aead, plaintext, []byte(id))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return plaintext, wrapped, id, nil
+}
+
+// DecryptDataKey implements DataKeyProvider.
+func (p *LocalKeyProvider) DecryptDataKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	p.mu.RLock()
+	aead, ok := p.keys[keyID]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown master key %s", ErrDecrypt, keyID)
+	}
+	return open(aead, wrapped, []byte(keyID))
+}
+
+// Limits on the reuse of a data key. AES-GCM with random nonces must not
+// encrypt much more than 2^32 values under one key; rotating well before
+// that also bounds how much data a leaked data key exposes.
+const (
+	defaultDataKeyAge   = 5 * time.Minute
+	maxDataKeyUses      = 1 << 20
+	maxCachedDataKeys   = 1000
+	encryptedFormatByte = 1
+)
+
+// FieldEncryptor encrypts attributes with AES-256-GCM under data keys from
+// a DataKeyProvider. One data key encrypts the attributes written within
+// its maximum age, so that the provider is not called on every write, and
+// unwrapped data keys are cached for reads.
+//
+// An encrypted attribute is stored as a binary value holding the format
+// version, the master key id, the wrapped data key, the nonce and the
+// ciphertext. The attribute name is authenticated with it, so a value
+// cannot be moved to another attribute. Encrypted attributes cannot be
+// used in key conditions, indexes or filters.
+type FieldEncryptor struct {
+	keys   DataKeyProvider
+	maxAge time.Duration
+	// plaintextReads accepts values stored before encryption was enabled.
+	plaintextReads bool
+
+	mu      sync.Mutex
+	current *dataKey
+	// cache holds unwrapped data keys by master key id and wrapped key.
+	cache map[string]*dataKey
+}
+
+// dataKey is a data key in plaintext and as stored.
+type dataKey struct {
+	aead    cipher.AEAD
+	keyID   string
+	wrapped []byte
+	created time.Time
+	uses    int
+}
+
+// FieldEncryptorOption configures a FieldEncryptor.
+type FieldEncryptorOption func(*FieldEncryptor)
+
+// WithPlaintextReads makes Decrypt return the values that are not
+// encrypted as they are, e.g. while the items stored before encryption was
+// enabled are written again. Without it they fail with ErrDecrypt, so that
+// a value replaced by a plaintext one is not read as if it were genuine.
+func WithPlaintextReads() FieldEncryptorOption {
+	return func(e *FieldEncryptor) {
+		e.plaintextReads = true
+	}
+}
+
+// NewFieldEncryptor returns an encryptor drawing data keys from keys. A
+// data key is used for at most maxAge, 5 minutes if maxAge is 0, before
+// the next write asks keys for a new one.
+func NewFieldEncryptor(keys DataKeyProvider, maxAge time.Duration, opts ...FieldEncryptorOption) *FieldEncryptor {
+	if maxAge <= 0 {
+		maxAge = defaultDataKeyAge
+	}
+	e := &FieldEncryptor{keys: keys, maxAge: maxAge, cache: map[string]*dataKey{}}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// NewFieldEncryptorFromConfig returns the encryptor configured by
+// ENCRYPTION_KEYS and ENCRYPTION_PLAINTEXT_READS, with a LocalKeyProvider
+// of the master keys, or nil if no keys are configured.
+func NewFieldEncryptorFromConfig(c config.Config) (*FieldEncryptor, error) {
+	keys, err := c.MasterKeys()
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	// Rotate through the older keys to the active one, listed first.
+	last := keys[len(keys)-1]
+	provider, err := NewLocalKeyProvider(last.ID, last.Key)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(keys) - 2; i >= 0; i-- {
+		if err := provider.Rotate(keys[i].ID, keys[i].Key); err != nil {
+			return nil, err
+		}
+	}
+	var opts []FieldEncryptorOption
+	if c.EncryptionPlaintextReads {
+		opts = append(opts, WithPlaintextReads())
+	}
+	return NewFieldEncryptor(provider, 0, opts...), nil
+}
+
+// Rotate retires the current data key: the next write uses a new one, e.g.
+// under a master key the provider has just rotated to. Values keep their
+// data key until they are written again.
+func (e *FieldEncryptor) Rotate() {
+	e.mu.Lock()
+	e.current = nil
+	e.mu.Unlock()
+}
+
+// Encrypt encrypts the value of attribute attr.
+func (e *FieldEncryptor) Encrypt(ctx context.Context, attr string, av types.AttributeValue) (types.AttributeValue, error) {
+	plaintext, err := encodeAttribute(av)
+	if err != nil {
+		return nil, err
+	}
+	key, err := e.encryptionKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("data key: %w", err)
+	}
+	ciphertext, err := seal(key.aead, plaintext, []byte(attr))
+	if err != nil {
+		return nil, err
+	}
+	value := []byte{encryptedFormatByte, byte(len(key.keyID))}
+	value = append(value, key.keyID...)
+	value = binary.BigEndian.AppendUint16(value, uint16(len(key.wrapped)))
+	value = append(value, key.wrapped...)
+	return &types.AttributeValueMemberB{Value: append(value, ciphertext...)}, nil
+}
+
+// Decrypt decrypts the value of attribute attr. Values that are not binary
+// were written before the attribute was encrypted; they fail with
+// ErrDecrypt unless the encryptor has WithPlaintextReads.
+func (e *FieldEncryptor) Decrypt(ctx context.Context, attr string, av types.AttributeValue) (types.AttributeValue, error) {
+	b, ok := av.(*types.AttributeValueMemberB)
+	if !ok {
+		if e.plaintextReads {
+			return av, nil
+		}
+		return nil, fmt.Errorf("%w: attribute %s is not encrypted", ErrDecrypt, attr)
+	}
+	keyID, wrapped, ciphertext, ok := splitEncrypted(b.Value)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed value", ErrDecrypt)
+	}
+	key, err := e.decryptionKey(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(key.aead, ciphertext, []byte(attr))
+	if err != nil {
+		return nil, err
+	}
+	return decodeAttribute(plaintext)
+}
+
+// encryptionKey returns the current data key, generating one if it is too
+// old or too used.
+func (e *FieldEncryptor) encryptionKey(ctx context.Context) (*dataKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if k := e.current; k != nil && time.Since(k.created) < e.maxAge && k.uses < maxDataKeyUses {
+		k.uses++
+		return k, nil
+	}
+	plaintext, wrapped, keyID, err := e.keys.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyID) == 0 || len(keyID) > 255 || len(wrapped) > 0xffff {
+		return nil, fmt.Errorf("provider returned a key id of %d bytes and a wrapped key of %d", len(keyID), len(wrapped))
+	}
+	aead, err := newGCM(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	e.current = &dataKey{aead: aead, keyID: keyID, wrapped: wrapped, created: time.Now(), uses: 1}
+	e.cacheKey(e.current)
+	return e.current, nil
+}
+
+// decryptionKey returns the data key wrapped as wrapped under keyID,
+// unwrapping it through the provider unless it is cached.
+func (e *FieldEncryptor) decryptionKey(ctx context.Context, keyID string, wrapped []byte) (*dataKey, error) {
+	e.mu.Lock()
+	k, ok := e.cache[keyID+"\x00"+string(wrapped)]
+	e.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	plaintext, err := e.keys.DecryptDataKey(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("data key: %w", err)
+	}
+	aead, err := newGCM(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	k = &dataKey{aead: aead, keyID: keyID, wrapped: wrapped, created: time.Now()}
+	e.mu.Lock()
+	e.cacheKey(k)
+	e.mu.Unlock()
+	return k, nil
+}
+
+// cacheKey adds k to the cache, which is emptied when full. e.mu must be
+// held.
+func (e *FieldEncryptor) cacheKey(k *dataKey) {
+	if len(e.cache) >= maxCachedDataKeys {
+		clear(e.cache)
+	}
+	e.cache[k.keyID+"\x00"+string(k.wrapped)] = k
+}
+
+// converter returns the AttributeConverter encrypting attr. Converters have
+// no context, so provider calls made on a cache miss are not cancelled
+// with the request.
+func (e *FieldEncryptor) converter(attr string) AttributeConverter {
+	return encryptedAttribute{e, attr}
+}
+
+type encryptedAttribute struct {
+	e    *FieldEncryptor
+	attr string
+}
+
+func (c encryptedAttribute) ToItem(av types.AttributeValue) (types.AttributeValue, error) {
+	return c.e.Encrypt(context.Background(), c.attr, av)
+}
+
+func (c encryptedAttribute) FromItem(av types.AttributeValue) (types.AttributeValue, error) {
+	return c.e.Decrypt(context.Background(), c.attr, av)
+}
+
+// WithFieldEncryption encrypts the book attributes whose fields are tagged
+// `encrypted:"true"` with e. Stream consumers see the encrypted values.
+func WithFieldEncryption(e *FieldEncryptor) RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		for _, attr := range encryptedAttributes(reflect.TypeOf(Book{})) {
+			d.codec.setConverter(attr, e.converter(attr))
+		}
+	}
+}
+
+// EncryptedSchema returns schema encrypting the attributes of T whose
+// fields are tagged `encrypted:"true"` with e, e.g.
+//
+//	NewRepository(client, table, EncryptedSchema(schema, encryptor))
+func EncryptedSchema[T any](schema EntitySchema[T], e *FieldEncryptor) EntitySchema[T] {
+	attrs := encryptedAttributes(reflect.TypeOf(*new(T)))
+	marshal, unmarshal := schema.Marshal, schema.Unmarshal
+	if marshal == nil {
+		marshal = func(v *T) (map[string]types.AttributeValue, error) {
+			return attributevalue.MarshalMap(v)
+		}
+	}
+	if unmarshal == nil {
+		unmarshal = func(item map[string]types.AttributeValue, v *T) error {
+			return attributevalue.UnmarshalMap(item, v)
+		}
+	}
+	schema.Marshal = func(v *T) (map[string]types.AttributeValue, error) {
+		item, err := marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		for _, attr := range attrs {
+			if av, ok := item[attr]; ok {
+				if item[attr], err = e.Encrypt(context.Background(), attr, av); err != nil {
+					return nil, fmt.Errorf("attribute %s: %w", attr, err)
+				}
+			}
+		}
+		return item, nil
+	}
+	schema.Unmarshal = func(item map[string]types.AttributeValue, v *T) error {
+		decrypted := make(map[string]types.AttributeValue, len(item))
+		for name, av := range item {
+			decrypted[name] = av
+		}
+		for _, attr := range attrs {
+			if av, ok := item[attr]; ok {
+				var err error
+				if decrypted[attr], err = e.Decrypt(context.Background(), attr, av); err != nil {
+					return fmt.Errorf("attribute %s: %w", attr, err)
+				}
+			}
+		}
+		return unmarshal(decrypted, v)
+	}
+	return schema
+}
+
+// encryptedAttributes returns the attribute names of the fields of struct
+// type t tagged `encrypted:"true"`.
+func encryptedAttributes(t reflect.Type) []string {
+	var attrs []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("encrypted") != "true" {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("dynamodbav"), ",")
+		if name == "" {
+			name = f.Name
+		}
+		attrs = append(attrs, name)
+	}
+	return attrs
+}
+
+// splitEncrypted parses a value written by FieldEncryptor.Encrypt.
+func splitEncrypted(b []byte) (keyID string, wrapped, ciphertext []byte, ok bool) {
+	if len(b) < 2 || b[0] != encryptedFormatByte {
+		return "", nil, nil, false
+	}
+	n := int(b[1])
+	b = b[2:]
+	if len(b) < n+2 {
+		return "", nil, nil, false
+	}
+	keyID, b = string(b[:n]), b[n:]
+	m := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < m {
+		return "", nil, nil, false
+	}
+	return keyID, b[:m], b[m:], true
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext under a random nonce, which it prepends.
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// open undoes seal.
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed value", ErrDecrypt)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return plaintext, nil
+}
+
+// encodeAttribute encodes av as DynamoDB JSON, e.g. {"S":"text"}, so that
+// it decrypts to a value of the same type.
+func encodeAttribute(av types.AttributeValue) ([]byte, error) {
+	v, err := attributeJSON(av)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func attributeJSON(av types.AttributeValue) (map[string]any, error) {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return map[string]any{"S": v.Value}, nil
+	case *types.AttributeValueMemberN:
+		return map[string]any{"N": v.Value}, nil
+	case *types.AttributeValueMemberB:
+		return map[string]any{"B": v.Value}, nil
+	case *types.AttributeValueMemberBOOL:
+		return map[string]any{"BOOL": v.Value}, nil
+	case *types.AttributeValueMemberNULL:
+		return map[string]any{"NULL": true}, nil
+	case *types.AttributeValueMemberSS:
+		return map[string]any{"SS": v.Value}, nil
+	case *types.AttributeValueMemberNS:
+		return map[string]any{"NS": v.Value}, nil
+	case *types.AttributeValueMemberBS:
+		return map[string]any{"BS": v.Value}, nil
+	case *types.AttributeValueMemberL:
+		list := make([]any, len(v.Value))
+		for i, item := range v.Value {
+			var err error
+			if list[i], err = attributeJSON(item); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]any{"L": list}, nil
+	case *types.AttributeValueMemberM:
+		m := make(map[string]any, len(v.Value))
+		for name, item := range v.Value {
+			var err error
+			if m[name], err = attributeJSON(item); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]any{"M": m}, nil
+	}
+	return nil, fmt.Errorf("cannot encrypt attribute of type %T", av)
+}
+
+// encodedAttribute is the DynamoDB JSON written by encodeAttribute, also
+// the format of table exports.
+type encodedAttribute struct {
+	S    *string                      `json:"S"`
+	N    *string                      `json:"N"`
+	B    []byte                       `json:"B"`
+	BOOL *bool                        `json:"BOOL"`
+	NULL bool                         `json:"NULL"`
+	SS   []string                     `json:"SS"`
+	NS   []string                     `json:"NS"`
+	BS   [][]byte                     `json:"BS"`
+	L    []encodedAttribute           `json:"L"`
+	M    map[string]*encodedAttribute `json:"M"`
+}
+
+// decodeAttribute undoes encodeAttribute.
+func decodeAttribute(data []byte) (types.AttributeValue, error) {
+	var v encodedAttribute
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return v.value(), nil
+}
+
+func (v *encodedAttribute) value() types.AttributeValue {
+	switch {
+	case v.S != nil:
+		return &types.AttributeValueMemberS{Value: *v.S}
+	case v.N != nil:
+		return &types.AttributeValueMemberN{Value: *v.N}
+	case v.B != nil:
+		return &types.AttributeValueMemberB{Value: v.B}
+	case v.BOOL != nil:
+		return &types.AttributeValueMemberBOOL{Value: *v.BOOL}
+	case v.SS != nil:
+		return &types.AttributeValueMemberSS{Value: v.SS}
+	case v.NS != nil:
+		return &types.AttributeValueMemberNS{Value: v.NS}
+	case v.BS != nil:
+		return &types.AttributeValueMemberBS{Value: v.BS}
+	case v.L != nil:
+		list := make([]types.AttributeValue, len(v.L))
+		for i := range v.L {
+			list[i] = v.L[i].value()
+		}
+		return &types.AttributeValueMemberL{Value: list}
+	case v.M != nil:
+		m := make(map[string]types.AttributeValue, len(v.M))
+		for name, item := range v.M {
+			m[name] = item.value()
+		}
+		return &types.AttributeValueMemberM{Value: m}
+	}
+	return &types.AttributeValueMemberNULL{Value: true}
+}
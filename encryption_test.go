@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"dynamoDBExample/config"
+)
+
+// itemStub is a dynamoStub storing the items of any table by the values of
+// keyAttrs. PutItem and the Puts of TransactWriteItems write
+// unconditionally. Query returns in one page the items whose partition key
+// equals, or whose sort key starts with, each value of the key condition.
+type itemStub struct {
+	*dynamoStub
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+}
+
+func newItemStub(t *testing.T, keyAttrs ...string) *itemStub {
+	s := &itemStub{items: map[string]map[string]types.AttributeValue{}}
+	key := func(item map[string]*encodedAttribute) string {
+		var parts []string
+		for _, attr := range keyAttrs {
+			v := item[attr]
+			if v == nil {
+				t.Fatalf("item %v has no key attribute %s", item, attr)
+			}
+			parts = append(parts, aws.ToString(v.S)+aws.ToString(v.N))
+		}
+		return strings.Join(parts, "\x00")
+	}
+	s.dynamoStub = newDynamoStub(t, func(op string, input []byte) (any, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch op {
+		case "PutItem":
+			in := decodeInput(t, input)
+			s.items[key(in.Item)] = attributes(in.Item)
+			return nil, nil
+		case "TransactWriteItems":
+			var in struct {
+				TransactItems []struct {
+					Put *struct{ Item map[string]*encodedAttribute }
+				}
+			}
+			if err := json.Unmarshal(input, &in); err != nil {
+				return nil, err
+			}
+			for _, ti := range in.TransactItems {
+				if ti.Put != nil {
+					s.items[key(ti.Put.Item)] = attributes(ti.Put.Item)
+				}
+			}
+			return nil, nil
+		case "GetItem":
+			item, ok := s.items[key(decodeInput(t, input).Key)]
+			if !ok {
+				return map[string]any{}, nil
+			}
+			return map[string]any{"Item": wireAttributes(t, item)}, nil
+		case "Query":
+			in := decodeInput(t, input)
+			page := []map[string]any{}
+		items:
+			for _, item := range s.items {
+				for _, v := range in.ExpressionAttributeValues {
+					pk, _ := item[keyAttrs[0]].(*types.AttributeValueMemberS)
+					sk, _ := item[keyAttrs[len(keyAttrs)-1]].(*types.AttributeValueMemberS)
+					if v.S == nil || pk == nil || sk == nil || pk.Value != *v.S && !strings.HasPrefix(sk.Value, *v.S) {
+						continue items
+					}
+				}
+				page = append(page, wireAttributes(t, item))
+			}
+			return map[string]any{"Items": page, "Count": len(page)}, nil
+		}
+		return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+	})
+	return s
+}
+
+// stored returns the item stored under the given key values.
+func (s *itemStub) stored(key ...string) map[string]types.AttributeValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.items[strings.Join(key, "\x00")]
+}
+
+// testEncryptor returns the encryptor configured with the given
+// ENCRYPTION_KEYS, one 32-byte key per id filled with the id's first byte.
+func testEncryptor(t *testing.T, plaintextReads bool, ids ...string) *FieldEncryptor {
+	t.Helper()
+	var pairs []string
+	for _, id := range ids {
+		pairs = append(pairs, id+":"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{id[0]}, 32)))
+	}
+	c := config.Config{EncryptionKeys: strings.Join(pairs, ","), EncryptionPlaintextReads: plaintextReads}
+	e, err := NewFieldEncryptorFromConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestReviewRepositoryEncryptsComments(t *testing.T) {
+	ctx := context.Background()
+	stub := newItemStub(t, "bookId", "id")
+	reviews := func(e *FieldEncryptor) *Repository[Review] {
+		return NewReviewRepository(aws.Config{Region: "us-east-1"}, stubTable, e, endpointOption(stub.url))
+	}
+	get := func(repo *Repository[Review], id int) (*Review, error) {
+		return repo.Get(ctx, ReviewKey(1, id))
+	}
+	storedComment := func(id int) *types.AttributeValueMemberB {
+		t.Helper()
+		comment := stub.stored("1", strconv.Itoa(id))["comment"]
+		b, ok := comment.(*types.AttributeValueMemberB)
+		if !ok {
+			t.Fatalf("review %d: comment stored as %#v, want a binary value", id, comment)
+		}
+		return b
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		repo := reviews(testEncryptor(t, false, "k1"))
+		if err := repo.Put(ctx, &Review{BookId: 1, Id: 1, Rating: 5, Comment: "A masterpiece"}); err != nil {
+			t.Fatal(err)
+		}
+		if b := storedComment(1); bytes.Contains(b.Value, []byte("masterpiece")) {
+			t.Error("comment stored in plaintext")
+		}
+		got, err := get(repo, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Comment != "A masterpiece" || got.Rating != 5 {
+			t.Errorf("read back %+v, want the comment decrypted", got)
+		}
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		rotated := reviews(testEncryptor(t, false, "k2", "k1"))
+		if err := rotated.Put(ctx, &Review{BookId: 1, Id: 2, Rating: 3, Comment: "Too long"}); err != nil {
+			t.Fatal(err)
+		}
+		if keyID, _, _, _ := splitEncrypted(storedComment(2).Value); keyID != "k2" {
+			t.Errorf("comment written after the rotation uses master key %q, want k2", keyID)
+		}
+		for id, want := range map[int]string{1: "A masterpiece", 2: "Too long"} {
+			got, err := get(rotated, id)
+			if err != nil || got.Comment != want {
+				t.Errorf("review %d after rotation: %+v, %v, want comment %q", id, got, err, want)
+			}
+		}
+		if _, err := get(reviews(testEncryptor(t, false, "k2")), 1); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("review under a retired master key: got %v, want ErrDecrypt", err)
+		}
+	})
+
+	t.Run("tampered ciphertext", func(t *testing.T) {
+		b := storedComment(1)
+		original := bytes.Clone(b.Value)
+		b.Value[len(b.Value)-1] ^= 1
+		defer copy(b.Value, original)
+		if _, err := get(reviews(testEncryptor(t, false, "k1")), 1); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("tampered comment: got %v, want ErrDecrypt", err)
+		}
+	})
+
+	t.Run("plaintext reads", func(t *testing.T) {
+		if err := reviews(nil).Put(ctx, &Review{BookId: 1, Id: 3, Rating: 4, Comment: "Written before encryption"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := get(reviews(testEncryptor(t, false, "k1")), 3); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("plaintext comment without the opt-in: got %v, want ErrDecrypt", err)
+		}
+		got, err := get(reviews(testEncryptor(t, true, "k1")), 3)
+		if err != nil || got.Comment != "Written before encryption" {
+			t.Errorf("plaintext comment with the opt-in: %+v, %v, want it read as is", got, err)
+		}
+	})
+}
+
+func TestSingleTableEncryptsReviewComments(t *testing.T) {
+	ctx := context.Background()
+	stub := newItemStub(t, partitionKeyAttribute, sortKeyAttribute)
+	table := NewSingleTable(aws.Config{Region: "us-east-1"}, stubTable, testEncryptor(t, false, "k1"), endpointOption(stub.url))
+	if err := table.Books.Put(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.AddReview(ctx, &Review{BookId: 1, Id: 1, Rating: 5, Comment: "A masterpiece"}); err != nil {
+		t.Fatal(err)
+	}
+	comment := stub.stored("BOOK#1", "REVIEW#1")["comment"]
+	if b, ok := comment.(*types.AttributeValueMemberB); !ok || bytes.Contains(b.Value, []byte("masterpiece")) {
+		t.Errorf("comment stored as %#v, want it encrypted", comment)
+	}
+
+	listed, _, err := table.ListReviews(ctx, 1, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, queried, err := table.BookWithReviews(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, reviews := range map[string][]*Review{"ListReviews": listed, "BookWithReviews": queried} {
+		if len(reviews) != 1 || reviews[0].Comment != "A masterpiece" {
+			t.Errorf("%s returned %v, want the review with its comment decrypted", name, reviews)
+		}
+	}
+}
+
+func TestMasterKeysRejectsMalformedKeys(t *testing.T) {
+	valid := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	for _, keys := range []string{
+		"k1",
+		":" + valid,
+		"k1:not base64",
+		"k1:" + base64.StdEncoding.EncodeToString(make([]byte, 20)),
+		"k1:" + valid + ",k1:" + valid,
+	} {
+		if _, err := (config.Config{EncryptionKeys: keys}).MasterKeys(); err == nil {
+			t.Errorf("MasterKeys accepted %q", keys)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// localCredentials are sent to custom endpoints. Emulators accept any
+// credentials, and using fixed ones spares developers an AWS profile.
+var localCredentials = credentials.NewStaticCredentialsProvider("local", "local", "")
+
+// WithClientOptions adds options applied when the repository builds its
+// DynamoDB client.
+func WithClientOptions(optFns ...func(*dynamodb.Options)) RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.clientOptions = append(d.clientOptions, optFns...)
+	}
+}
+
+// WithEndpoint points the repository at endpoint, e.g. http://localhost:8000,
+// using static placeholder credentials.
+func WithEndpoint(endpoint string) RepositoryOption {
+	return WithClientOptions(endpointOption(endpoint))
+}
+
+func endpointOption(endpoint string) func(*dynamodb.Options) {
+	return func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.Credentials = localCredentials
+	}
+}
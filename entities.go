@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Author is a writer of books, stored in an author table keyed by id. Books
+// refer to their author by name; see AuthorBookRepository.
+type Author struct {
+	Id   int    `json:"id" dynamodbav:"id"`
+	Name string `json:"name" dynamodbav:"name"`
+}
+
+// Review is a reader's rating of a book. Reviews are keyed by the book they
+// belong to and their own id within that book. Comment, the reader's own
+// text, is encrypted in repositories given a FieldEncryptor.
+type Review struct {
+	BookId  int    `json:"bookId" dynamodbav:"bookId"`
+	Id      int    `json:"id" dynamodbav:"id"`
+	Rating  int    `json:"rating" dynamodbav:"rating"`
+	Comment string `json:"comment" dynamodbav:"comment" encrypted:"true"`
+}
+
+// Key codecs of the author and review tables. Reviews are partitioned by
+// book and sorted by their own id.
+var (
+	authorKey = NumberKey("id")
+	reviewKey = NewCompositeKeyCodec[int, int](NumberKey("bookId"), NumberKey("id"))
+)
+
+// AuthorKey returns the primary key of the author with the given id.
+func AuthorKey(id int) map[string]types.AttributeValue {
+	return authorKey.MarshalKey(id)
+}
+
+// ReviewKey returns the primary key of a review of a book.
+func ReviewKey(bookID, id int) map[string]types.AttributeValue {
+	return reviewKey.MarshalKey(CompositeKey[int, int]{Partition: bookID, Sort: id})
+}
+
+func NewAuthorRepository(cfg aws.Config, tableName string) *Repository[Author] {
+	return NewRepository(dynamodb.NewFromConfig(cfg), tableName, EntitySchema[Author]{
+		Key: func(a *Author) map[string]types.AttributeValue { return AuthorKey(a.Id) },
+	})
+}
+
+// NewReviewRepository returns the repository of the review table. Comments
+// are encrypted with e, e.g. the one of NewFieldEncryptorFromConfig, or
+// stored in plaintext if e is nil.
+func NewReviewRepository(cfg aws.Config, tableName string, e *FieldEncryptor, optFns ...func(*dynamodb.Options)) *Repository[Review] {
+	schema := EntitySchema[Review]{
+		Key: func(r *Review) map[string]types.AttributeValue { return ReviewKey(r.BookId, r.Id) },
+	}
+	if e != nil {
+		schema = EncryptedSchema(schema, e)
+	}
+	return NewRepository(dynamodb.NewFromConfig(cfg, optFns...), tableName, schema)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// Domain errors returned by BookRepository implementations. Callers should
+// test for them with errors.Is; the underlying SDK error remains available
+// through errors.As.
+var (
+	// ErrNotFound means the requested book does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means a write was rejected because it conflicts with the
+	// stored state, e.g. a failed condition expression.
+	ErrConflict = errors.New("conflict")
+	// ErrThrottled means DynamoDB rejected the request for exceeding the
+	// table's throughput; it may be retried later.
+	ErrThrottled = errors.New("throttled")
+	// ErrValidation means the request was malformed.
+	ErrValidation = errors.New("validation failed")
+)
+
+// ErrBookAlreadyExists is returned by Create when a book with the same id is
+// already stored. It matches ErrConflict.
+var ErrBookAlreadyExists error = &kindError{msg: "book already exists", kind: ErrConflict}
+
+// ErrVersionConflict is returned by Update when the stored book has a
+// different version than the one being written. Callers should re-read the
+// book and retry. It matches ErrConflict.
+var ErrVersionConflict error = &kindError{msg: "book version conflict", kind: ErrConflict}
+
+// ErrIdempotencyKeyReused is returned by CreateIdempotent when the key was
+// already used to create a different book. It matches ErrConflict.
+var ErrIdempotencyKeyReused error = &kindError{msg: "idempotency key already used for another book", kind: ErrConflict}
+
+// ErrNotBorrowed is returned by Return when no copy of the book is out on
+// loan. It matches ErrConflict.
+var ErrNotBorrowed error = &kindError{msg: "book is not borrowed", kind: ErrConflict}
+
+// ErrBookOnHold is returned by PlaceHold when someone else holds the book.
+// It matches ErrConflict.
+var ErrBookOnHold error = &kindError{msg: "book is on hold", kind: ErrConflict}
+
+// ErrBookOnLoan is returned by MergeDuplicates when a duplicate has copies
+// on loan. It matches ErrConflict.
+var ErrBookOnLoan error = &kindError{msg: "book has copies on loan", kind: ErrConflict}
+
+// ErrNotHeld is returned by ReleaseHold when the holder has no active hold
+// on the book. It matches ErrConflict.
+var ErrNotHeld error = &kindError{msg: "book is not held", kind: ErrConflict}
+
+// ErrISBNTaken is returned by writes of a book whose ISBN another book
+// already has. It matches ErrConflict.
+var ErrISBNTaken error = &kindError{msg: "isbn belongs to another book", kind: ErrConflict}
+
+// ErrReviewAlreadyExists is returned by AddReview when the book already has
+// a review with the same id. It matches ErrConflict.
+var ErrReviewAlreadyExists error = &kindError{msg: "review already exists", kind: ErrConflict}
+
+// ErrNoCopyAvailable is returned by CheckoutBook when every copy of the book
+// is on loan. It matches ErrConflict.
+var ErrNoCopyAvailable error = &kindError{msg: "no copy of the book is available", kind: ErrConflict}
+
+// ErrRateLimited is returned by the RateLimit middleware in fail-fast mode
+// when a call exceeds the configured rate. It matches ErrThrottled.
+var ErrRateLimited error = &kindError{msg: "rate limit exceeded", kind: ErrThrottled}
+
+// ErrCircuitOpen is returned by the CircuitBreaking middleware while its
+// breaker is open. It matches ErrThrottled.
+var ErrCircuitOpen error = &kindError{msg: "circuit breaker is open", kind: ErrThrottled}
+
+// ErrNoRegionAvailable is returned by MultiRegionRepository when the
+// circuit breakers of all its regions are open.
+var ErrNoRegionAvailable = errors.New("no region is available")
+
+// ErrSearchUnavailable is returned by SearchBooks when no search backend is
+// configured.
+var ErrSearchUnavailable = errors.New("search is not configured")
+
+// ErrCheckoutUnavailable is returned by CheckoutBook when no checkout store
+// is configured.
+var ErrCheckoutUnavailable = errors.New("checkout is not configured")
+
+// ErrNoTenant is returned by TenantBookRepository when the tenant of a call
+// cannot be resolved from its context.
+var ErrNoTenant = errors.New("no tenant in context")
+
+// ErrUnauthenticated means a request carries no valid credentials.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// ErrForbidden means the authenticated caller lacks the role an operation
+// requires.
+var ErrForbidden = errors.New("forbidden")
+
+// ErrTableNotActive is returned by Migrate when a table or one of its indexes
+// does not become ACTIVE within the wait allowed.
+var ErrTableNotActive = errors.New("table did not become active in time")
+
+// kindError is a specific sentinel error that also matches a broader domain
+// error kind.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+// retryAfterError is a throttling error that knows when the call may
+// succeed again, which the HTTP layer passes on as Retry-After.
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// RetryAfter returns how long the caller should wait before retrying.
+func (e *retryAfterError) RetryAfter() time.Duration {
+	return e.after
+}
+
+// repositoryError is a DynamoDB failure translated for callers: it matches
+// the domain error kind (if any) and carries the request id AWS assigned to
+// the call (x-amzn-RequestId), which AWS support asks for when investigating
+// an issue.
+type repositoryError struct {
+	kind      error
+	requestID string
+	err       error
+}
+
+func (e *repositoryError) Error() string {
+	if e.requestID == "" {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("%v (request id: %s)", e.err, e.requestID)
+}
+
+func (e *repositoryError) Unwrap() []error {
+	if e.kind == nil {
+		return []error{e.err}
+	}
+	return []error{e.kind, e.err}
+}
+
+// translateError maps an SDK error onto the domain errors and attaches the
+// request id carried by the response, if any. Errors that are neither are
+// returned unchanged.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	kind := errorKind(err)
+	id := RequestID(err)
+	if kind == nil && id == "" {
+		return err
+	}
+	return &repositoryError{kind: kind, requestID: id, err: err}
+}
+
+func errorKind(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return nil
+	}
+	switch apiErr.ErrorCode() {
+	case "ConditionalCheckFailedException", "TransactionConflictException":
+		return ErrConflict
+	case "ProvisionedThroughputExceededException", "RequestLimitExceeded", "ThrottlingException":
+		return ErrThrottled
+	case "ValidationException":
+		return ErrValidation
+	}
+	return nil
+}
+
+// isThrottled reports whether err means DynamoDB throttled the request.
+func isThrottled(err error) bool {
+	return errors.Is(err, ErrThrottled)
+}
+
+// RequestID returns the AWS request id of a failed call, or "" when err did
+// not come from a service response.
+func RequestID(err error) string {
+	var repoErr *repositoryError
+	if errors.As(err, &repoErr) && repoErr.requestID != "" {
+		return repoErr.requestID
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.ServiceRequestID()
+	}
+	return ""
+}
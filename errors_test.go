@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestErrorsCarryTheRequestID(t *testing.T) {
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		return nil, &stubError{Type: "ValidationException", Message: "one or more parameter values were invalid"}
+	})
+
+	_, err := stub.repository().GetById(context.Background(), 1)
+	if err == nil {
+		t.Fatal("GetById succeeded, want the ValidationException")
+	}
+	if !strings.Contains(err.Error(), "(request id: req-1)") {
+		t.Errorf("error %q does not name the request id req-1", err)
+	}
+	if got := RequestID(err); got != "req-1" {
+		t.Errorf("RequestID = %q, want req-1", got)
+	}
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("error %v does not match ErrValidation", err)
+	}
+}
+
+func TestTranslateErrorLeavesOtherErrorsAlone(t *testing.T) {
+	err := errors.New("dial tcp: connection refused")
+	if got := translateError(err); got != err {
+		t.Errorf("translateError(%v) = %v, want it unchanged", err, got)
+	}
+	if got := RequestID(err); got != "" {
+		t.Errorf("RequestID = %q, want none", got)
+	}
+}
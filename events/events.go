@@ -0,0 +1,166 @@
+// Package events publishes book domain events to downstream systems through
+// Amazon EventBridge or Amazon SNS.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// Type names a kind of event.
+type Type string
+
+const (
+	BookCreated Type = "BookCreated"
+	BookUpdated Type = "BookUpdated"
+	BookDeleted Type = "BookDeleted"
+	// BookCheckedOut is a copy of the book going out on loan.
+	BookCheckedOut Type = "BookCheckedOut"
+)
+
+// batchLimit is the number of entries PutEvents and PublishBatch accept per
+// call.
+const batchLimit = 10
+
+// Event is a change to one book, or a checkout of one. Consumers should
+// expect duplicates and use ID to discard them.
+type Event struct {
+	// ID identifies the event; redeliveries of an event keep it.
+	ID     string    `json:"id"`
+	Type   Type      `json:"type"`
+	Time   time.Time `json:"time"`
+	BookID int       `json:"bookId"`
+	// Book is the JSON of the book after the change; it is omitted for
+	// deletions.
+	Book json.RawMessage `json:"book,omitempty"`
+	// Loan is the JSON of the loan of a checkout.
+	Loan json.RawMessage `json:"loan,omitempty"`
+}
+
+// Publisher delivers events. Publish returns an error unless every event
+// was accepted; events may then have been partially delivered.
+type Publisher interface {
+	Publish(ctx context.Context, events ...Event) error
+}
+
+// EventBridgePublisher puts events on an EventBridge bus. Each event's type
+// becomes the detail-type of the entry, so rules can route on it, and the
+// event itself the detail.
+type EventBridgePublisher struct {
+	client *eventbridge.Client
+	bus    string
+	source string
+}
+
+// NewEventBridgePublisher returns a publisher to the bus named or with the
+// ARN bus, using source as the source of every entry.
+func NewEventBridgePublisher(cfg aws.Config, bus, source string) *EventBridgePublisher {
+	return &EventBridgePublisher{client: eventbridge.NewFromConfig(cfg), bus: bus, source: source}
+}
+
+func (p *EventBridgePublisher) Publish(ctx context.Context, events ...Event) error {
+	return inBatches(events, func(batch []Event) error {
+		entries := make([]ebtypes.PutEventsRequestEntry, len(batch))
+		for i, e := range batch {
+			detail, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			entries[i] = ebtypes.PutEventsRequestEntry{
+				EventBusName: aws.String(p.bus),
+				Source:       aws.String(p.source),
+				DetailType:   aws.String(string(e.Type)),
+				Detail:       aws.String(string(detail)),
+				Time:         aws.Time(e.Time),
+			}
+		}
+		out, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: entries})
+		if err != nil {
+			return fmt.Errorf("put events: %w", err)
+		}
+		if out.FailedEntryCount == 0 {
+			return nil
+		}
+		var errs []error
+		for i, entry := range out.Entries {
+			if entry.ErrorCode != nil {
+				errs = append(errs, fmt.Errorf("event %s: %s: %s", batch[i].ID, aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage)))
+			}
+		}
+		return fmt.Errorf("put events: %d of %d failed: %w", out.FailedEntryCount, len(batch), errors.Join(errs...))
+	})
+}
+
+// SNSPublisher publishes events to an SNS topic. The event type is sent as
+// the "type" message attribute for subscription filter policies. On FIFO
+// topics messages are grouped by book, so the events of one book keep their
+// order, and deduplicated by event ID.
+type SNSPublisher struct {
+	client   *sns.Client
+	topicARN string
+	fifo     bool
+}
+
+func NewSNSPublisher(cfg aws.Config, topicARN string) *SNSPublisher {
+	return &SNSPublisher{client: sns.NewFromConfig(cfg), topicARN: topicARN, fifo: strings.HasSuffix(topicARN, ".fifo")}
+}
+
+func (p *SNSPublisher) Publish(ctx context.Context, events ...Event) error {
+	return inBatches(events, func(batch []Event) error {
+		entries := make([]snstypes.PublishBatchRequestEntry, len(batch))
+		for i, e := range batch {
+			msg, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			entries[i] = snstypes.PublishBatchRequestEntry{
+				// Batch entry ids only need to be unique within the call.
+				Id:      aws.String(strconv.Itoa(i)),
+				Message: aws.String(string(msg)),
+				MessageAttributes: map[string]snstypes.MessageAttributeValue{
+					"type": {DataType: aws.String("String"), StringValue: aws.String(string(e.Type))},
+				},
+			}
+			if p.fifo {
+				entries[i].MessageGroupId = aws.String(strconv.Itoa(e.BookID))
+				entries[i].MessageDeduplicationId = aws.String(e.ID)
+			}
+		}
+		out, err := p.client.PublishBatch(ctx, &sns.PublishBatchInput{TopicArn: aws.String(p.topicARN), PublishBatchRequestEntries: entries})
+		if err != nil {
+			return fmt.Errorf("publish batch: %w", err)
+		}
+		if len(out.Failed) == 0 {
+			return nil
+		}
+		errs := make([]error, len(out.Failed))
+		for i, f := range out.Failed {
+			errs[i] = fmt.Errorf("entry %s: %s: %s", aws.ToString(f.Id), aws.ToString(f.Code), aws.ToString(f.Message))
+		}
+		return fmt.Errorf("publish batch: %d of %d failed: %w", len(out.Failed), len(batch), errors.Join(errs...))
+	})
+}
+
+// inBatches calls publish with consecutive batches of at most batchLimit
+// events, stopping at the first error.
+func inBatches(events []Event, publish func([]Event) error) error {
+	for len(events) > 0 {
+		n := min(len(events), batchLimit)
+		if err := publish(events[:n]); err != nil {
+			return err
+		}
+		events = events[n:]
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// latencyWeight is the weight of the newest probe in a region's moving
+// average latency.
+const latencyWeight = 0.3
+
+// Region is one replica of a DynamoDB global table.
+type Region struct {
+	Name string
+	Repo BookRepository
+	// Ready probes the region, e.g. with tableReady of its client.
+	Ready ReadinessCheck
+}
+
+// MultiRegionRepository is a BookRepository over the replicas of a global
+// table in a primary and a secondary region. Writes go to the primary and
+// fail over to the secondary while the primary is unreachable; reads go to
+// the reachable region with the lowest probed latency, falling back to the
+// other. A region is unreachable after the policy's FailureThreshold of
+// consecutive network errors, timeouts or 5xx responses, and its circuit
+// breaker then skips it until the cooldown has passed or a probe succeeds.
+//
+// Global tables replicate asynchronously and resolve concurrent writes by
+// last writer wins, so after a failover reads may miss recent writes of the
+// other region, and a write that failed in the primary may still have been
+// applied there.
+type MultiRegionRepository struct {
+	primary, secondary *regionState
+}
+
+type regionState struct {
+	Region
+	breaker *circuitBreaker
+
+	mu sync.Mutex
+	// latency is the moving average of the probes; zero until the first.
+	latency time.Duration
+}
+
+// NewMultiRegionRepository returns a repository over the replicas in
+// primary and secondary, each with a circuit breaker following policy.
+// Failed probes count as failed calls.
+func NewMultiRegionRepository(primary, secondary Region, policy BreakerPolicy) *MultiRegionRepository {
+	newState := func(r Region) *regionState {
+		return &regionState{Region: r, breaker: newCircuitBreaker(policy)}
+	}
+	return &MultiRegionRepository{primary: newState(primary), secondary: newState(secondary)}
+}
+
+// RegionStatus is the routing state of a region.
+type RegionStatus struct {
+	Name      string        `json:"name"`
+	Available bool          `json:"available"`
+	Latency   time.Duration `json:"latency"`
+}
+
+// Status returns the state of the primary and the secondary region.
+func (m *MultiRegionRepository) Status() []RegionStatus {
+	status := make([]RegionStatus, 0, 2)
+	for _, r := range []*regionState{m.primary, m.secondary} {
+		r.mu.Lock()
+		latency := r.latency
+		r.mu.Unlock()
+		status = append(status, RegionStatus{Name: r.Name, Available: r.breaker.current() != breakerOpen, Latency: latency})
+	}
+	return status
+}
+
+// Ready is a ReadinessCheck that passes while either region is available,
+// as the service can then still serve requests.
+func (m *MultiRegionRepository) Ready(ctx context.Context) error {
+	var errs []error
+	for _, r := range []*regionState{m.primary, m.secondary} {
+		err := r.Ready(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("region %s: %w", r.Name, err))
+	}
+	return errors.Join(errs...)
+}
+
+// Probe checks both regions once, updating their latency and breakers.
+func (m *MultiRegionRepository) Probe(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range []*regionState{m.primary, m.secondary} {
+		r := r
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.probe(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// RunProbes probes the regions every interval until ctx is done.
+func (m *MultiRegionRepository) RunProbes(ctx context.Context, interval time.Duration) {
+	m.Probe(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Probe(ctx)
+		}
+	}
+}
+
+func (r *regionState) probe(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	start := time.Now()
+	err := r.Ready(ctx)
+	elapsed := time.Since(start)
+	if ctx.Err() != nil && err != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return // shutting down
+	}
+	if err != nil {
+		r.breaker.failure()
+		return
+	}
+	r.breaker.reset()
+	r.mu.Lock()
+	if r.latency == 0 {
+		r.latency = elapsed
+	} else {
+		r.latency = time.Duration(latencyWeight*float64(elapsed) + (1-latencyWeight)*float64(r.latency))
+	}
+	r.mu.Unlock()
+}
+
+// readOrder returns the regions by probed latency, the primary first on a
+// tie or before the first probes.
+func (m *MultiRegionRepository) readOrder() []*regionState {
+	regions := []*regionState{m.primary, m.secondary}
+	latency := make([]time.Duration, len(regions))
+	for i, r := range regions {
+		r.mu.Lock()
+		latency[i] = r.latency
+		r.mu.Unlock()
+	}
+	if latency[1] != 0 && (latency[0] == 0 || latency[1] < latency[0]) {
+		regions[0], regions[1] = regions[1], regions[0]
+	}
+	return regions
+}
+
+// route runs call against the regions in order, skipping those whose
+// breaker is open and moving on when a region is unreachable. before runs
+// ahead of each attempt. It returns ErrNoRegionAvailable if no region was
+// tried.
+func route(ctx context.Context, regions []*regionState, before func(), call func(BookRepository) error) error {
+	err := ErrNoRegionAvailable
+	for _, r := range regions {
+		if !r.breaker.allow() {
+			continue
+		}
+		if before != nil {
+			before()
+		}
+		err = call(r.Repo)
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about the region.
+			r.breaker.release()
+			return err
+		}
+		if !isUnreachable(err) {
+			r.breaker.reset()
+			return err
+		}
+		r.breaker.failure()
+		err = fmt.Errorf("region %s: %w", r.Name, err)
+	}
+	return err
+}
+
+func (m *MultiRegionRepository) read(ctx context.Context, call func(BookRepository) error) error {
+	return route(ctx, m.readOrder(), nil, call)
+}
+
+// write runs call in the primary, then in the secondary if the primary is
+// unreachable. The versions of books are restored before each attempt, as
+// writes bump them.
+func (m *MultiRegionRepository) write(ctx context.Context, call func(BookRepository) error, books ...*Book) error {
+	return route(ctx, []*regionState{m.primary, m.secondary}, keepVersions(books...), call)
+}
+
+// isUnreachable reports whether err means the region could not serve the
+// call: the network failed, the call timed out or DynamoDB answered with a
+// server error.
+func isUnreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() >= 500
+}
+
+// Create implements BookRepository.
+func (m *MultiRegionRepository) Create(ctx context.Context, book *Book) error {
+	return m.write(ctx, func(repo BookRepository) error { return repo.Create(ctx, book) }, book)
+}
+
+// Upsert implements BookRepository.
+func (m *MultiRegionRepository) Upsert(ctx context.Context, book *Book) error {
+	return m.write(ctx, func(repo BookRepository) error { return repo.Upsert(ctx, book) }, book)
+}
+
+// GetById implements BookRepository.
+func (m *MultiRegionRepository) GetById(ctx context.Context, id int) (book *Book, err error) {
+	err = m.read(ctx, func(repo BookRepository) error {
+		book, err = repo.GetById(ctx, id)
+		return err
+	})
+	return book, err
+}
+
+// Update implements BookRepository.
+func (m *MultiRegionRepository) Update(ctx context.Context, book *Book) error {
+	return m.write(ctx, func(repo BookRepository) error { return repo.Update(ctx, book) }, book)
+}
+
+// Delete implements BookRepository.
+func (m *MultiRegionRepository) Delete(ctx context.Context, id int) error {
+	return m.write(ctx, func(repo BookRepository) error { return repo.Delete(ctx, id) })
+}
+
+// List implements BookRepository.
+func (m *MultiRegionRepository) List(ctx context.Context) (books []*Book, err error) {
+	err = m.read(ctx, func(repo BookRepository) error {
+		books, err = repo.List(ctx)
+		return err
+	})
+	return books, err
+}
+
+// ListPage implements BookRepository. Cursors hold the table key, which the
+// replicas share, so a scan may continue in the other region.
+func (m *MultiRegionRepository) ListPage(ctx context.Context, limit int, cursor string) (books []*Book, next string, err error) {
+	err = m.read(ctx, func(repo BookRepository) error {
+		books, next, err = repo.ListPage(ctx, limit, cursor)
+		return err
+	})
+	return books, next, err
+}
+
+// ListSorted implements BookRepository. Like those of ListPage, its cursors
+// hold keys the replicas share.
+func (m *MultiRegionRepository) ListSorted(ctx context.Context, opts ListOptions) (books []*Book, next string, err error) {
+	err = m.read(ctx, func(repo BookRepository) error {
+		books, next, err = repo.ListSorted(ctx, opts)
+		return err
+	})
+	return books, next, err
+}
+
+// GetByAuthor implements BookRepository.
+func (m *MultiRegionRepository) GetByAuthor(ctx context.Context, author string) (books []*Book, err error) {
+	err = m.read(ctx, func(repo BookRepository) error {
+		books, err = repo.GetByAuthor(ctx, author)
+		return err
+	})
+	return books, err
+}
+
+// GetByISBN implements BookRepository.
+func (m *MultiRegionRepository) GetByISBN(ctx context.Context, isbn string) (book *Book, err error) {
+	err = m.read(ctx, func(repo BookRepository) error {
+		book, err = repo.GetByISBN(ctx, isbn)
+		return err
+	})
+	return book, err
+}
+
+// BatchCreate implements BookRepository. A batch that failed in the primary
+// is written whole to the secondary; its puts overwrite the books already
+// written.
+func (m *MultiRegionRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	return m.write(ctx, func(repo BookRepository) error { return repo.BatchCreate(ctx, books) }, books...)
+}
+
+// BatchGet implements BookRepository.
+func (m *MultiRegionRepository) BatchGet(ctx context.Context, ids []int) (books []*Book, err error) {
+	err = m.read(ctx, func(repo BookRepository) error {
+		books, err = repo.BatchGet(ctx, ids)
+		return err
+	})
+	return books, err
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// FakeCall is a call made to a FakeBookRepository.
+type FakeCall struct {
+	// Method is the name of the BookRepository method, e.g. "GetById".
+	Method string
+	// Args are the arguments after the context, e.g. the book or the id.
+	// Books are recorded as passed, so later changes to them show.
+	Args []any
+}
+
+// FakeBookRepository is a scriptable BookRepository for testing code built
+// on one, such as BookUseCase, without a datastore. Every call is recorded.
+// A call then fails with the error injected for its method, if any, or is
+// answered by the method's Func field if set, and by Store otherwise:
+//
+//	repo := NewFakeBookRepository()
+//	repo.FailNext("Update", ErrVersionConflict)
+//	repo.GetByIdFunc = func(ctx context.Context, id int) (*Book, error) {
+//		return &Book{Id: id, Name: "Dune"}, nil
+//	}
+//	uc := NewBookUseCase(repo)
+//
+// The Func fields and Store must be set before the repository is used; the
+// other methods are safe for concurrent use.
+type FakeBookRepository struct {
+	CreateFunc      func(ctx context.Context, book *Book) error
+	UpsertFunc      func(ctx context.Context, book *Book) error
+	GetByIdFunc     func(ctx context.Context, id int) (*Book, error)
+	UpdateFunc      func(ctx context.Context, book *Book) error
+	DeleteFunc      func(ctx context.Context, id int) error
+	ListFunc        func(ctx context.Context) ([]*Book, error)
+	ListPageFunc    func(ctx context.Context, limit int, cursor string) ([]*Book, string, error)
+	ListSortedFunc  func(ctx context.Context, opts ListOptions) ([]*Book, string, error)
+	GetByAuthorFunc func(ctx context.Context, author string) ([]*Book, error)
+	GetByISBNFunc   func(ctx context.Context, isbn string) (*Book, error)
+	BatchCreateFunc func(ctx context.Context, books []*Book) error
+	BatchGetFunc    func(ctx context.Context, ids []int) ([]*Book, error)
+
+	// Store answers the calls of methods without a Func. It is a
+	// MemoryBookRepository unless replaced.
+	Store BookRepository
+
+	mu     sync.Mutex
+	calls  []FakeCall
+	next   map[string][]error
+	always map[string]error
+}
+
+// NewFakeBookRepository returns a fake backed by an empty in-memory store.
+func NewFakeBookRepository() *FakeBookRepository {
+	return &FakeBookRepository{Store: NewMemoryBookRepository(), next: map[string][]error{}, always: map[string]error{}}
+}
+
+// FailNext makes the next calls of method fail with errs, one call per
+// error, before any error set with FailAlways.
+func (f *FakeBookRepository) FailNext(method string, errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.next[method] = append(f.next[method], errs...)
+}
+
+// FailAlways makes every call of method fail with err until it is called
+// again with a nil err.
+func (f *FakeBookRepository) FailAlways(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.always, method)
+		return
+	}
+	f.always[method] = err
+}
+
+// Calls returns the calls made so far, in order.
+func (f *FakeBookRepository) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCall(nil), f.calls...)
+}
+
+// CallsTo returns the calls of method made so far, in order.
+func (f *FakeBookRepository) CallsTo(method string) []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []FakeCall
+	for _, c := range f.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the recorded calls and the injected errors.
+func (f *FakeBookRepository) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+	f.next = map[string][]error{}
+	f.always = map[string]error{}
+}
+
+// record records a call and returns the error injected for it, if any.
+func (f *FakeBookRepository) record(method string, args ...any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, FakeCall{Method: method, Args: args})
+	if errs := f.next[method]; len(errs) > 0 {
+		f.next[method] = errs[1:]
+		return errs[0]
+	}
+	return f.always[method]
+}
+
+// Create implements BookRepository.
+func (f *FakeBookRepository) Create(ctx context.Context, book *Book) error {
+	if err := f.record("Create", book); err != nil {
+		return err
+	}
+	if f.CreateFunc != nil {
+		return f.CreateFunc(ctx, book)
+	}
+	return f.Store.Create(ctx, book)
+}
+
+// Upsert implements BookRepository.
+func (f *FakeBookRepository) Upsert(ctx context.Context, book *Book) error {
+	if err := f.record("Upsert", book); err != nil {
+		return err
+	}
+	if f.UpsertFunc != nil {
+		return f.UpsertFunc(ctx, book)
+	}
+	return f.Store.Upsert(ctx, book)
+}
+
+// GetById implements BookRepository.
+func (f *FakeBookRepository) GetById(ctx context.Context, id int) (*Book, error) {
+	if err := f.record("GetById", id); err != nil {
+		return nil, err
+	}
+	if f.GetByIdFunc != nil {
+		return f.GetByIdFunc(ctx, id)
+	}
+	return f.Store.GetById(ctx, id)
+}
+
+// Update implements BookRepository.
+func (f *FakeBookRepository) Update(ctx context.Context, book *Book) error {
+	if err := f.record("Update", book); err != nil {
+		return err
+	}
+	if f.UpdateFunc != nil {
+		return f.UpdateFunc(ctx, book)
+	}
+	return f.Store.Update(ctx, book)
+}
+
+// Delete implements BookRepository.
+func (f *FakeBookRepository) Delete(ctx context.Context, id int) error {
+	if err := f.record("Delete", id); err != nil {
+		return err
+	}
+	if f.DeleteFunc != nil {
+		return f.DeleteFunc(ctx, id)
+	}
+	return f.Store.Delete(ctx, id)
+}
+
+// List implements BookRepository.
+func (f *FakeBookRepository) List(ctx context.Context) ([]*Book, error) {
+	if err := f.record("List"); err != nil {
+		return nil, err
+	}
+	if f.ListFunc != nil {
+		return f.ListFunc(ctx)
+	}
+	return f.Store.List(ctx)
+}
+
+// ListPage implements BookRepository.
+func (f *FakeBookRepository) ListPage(ctx context.Context, limit int, cursor string) ([]*Book, string, error) {
+	if err := f.record("ListPage", limit, cursor); err != nil {
+		return nil, "", err
+	}
+	if f.ListPageFunc != nil {
+		return f.ListPageFunc(ctx, limit, cursor)
+	}
+	return f.Store.ListPage(ctx, limit, cursor)
+}
+
+// ListSorted implements BookRepository.
+func (f *FakeBookRepository) ListSorted(ctx context.Context, opts ListOptions) ([]*Book, string, error) {
+	if err := f.record("ListSorted", opts); err != nil {
+		return nil, "", err
+	}
+	if f.ListSortedFunc != nil {
+		return f.ListSortedFunc(ctx, opts)
+	}
+	return f.Store.ListSorted(ctx, opts)
+}
+
+// GetByAuthor implements BookRepository.
+func (f *FakeBookRepository) GetByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	if err := f.record("GetByAuthor", author); err != nil {
+		return nil, err
+	}
+	if f.GetByAuthorFunc != nil {
+		return f.GetByAuthorFunc(ctx, author)
+	}
+	return f.Store.GetByAuthor(ctx, author)
+}
+
+// GetByISBN implements BookRepository.
+func (f *FakeBookRepository) GetByISBN(ctx context.Context, isbn string) (*Book, error) {
+	if err := f.record("GetByISBN", isbn); err != nil {
+		return nil, err
+	}
+	if f.GetByISBNFunc != nil {
+		return f.GetByISBNFunc(ctx, isbn)
+	}
+	return f.Store.GetByISBN(ctx, isbn)
+}
+
+// BatchCreate implements BookRepository.
+func (f *FakeBookRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	if err := f.record("BatchCreate", books); err != nil {
+		return err
+	}
+	if f.BatchCreateFunc != nil {
+		return f.BatchCreateFunc(ctx, books)
+	}
+	return f.Store.BatchCreate(ctx, books)
+}
+
+// BatchGet implements BookRepository.
+func (f *FakeBookRepository) BatchGet(ctx context.Context, ids []int) ([]*Book, error) {
+	if err := f.record("BatchGet", ids); err != nil {
+		return nil, err
+	}
+	if f.BatchGetFunc != nil {
+		return f.BatchGetFunc(ctx, ids)
+	}
+	return f.Store.BatchGet(ctx, ids)
+}
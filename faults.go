@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Errors injected by FaultInjectingRepository. They match the domain errors
+// of the failures they stand for, so callers handle them like real ones.
+var (
+	errInjectedThrottle  error = &kindError{msg: "injected fault: throttled", kind: ErrThrottled}
+	errInjectedCondition error = &kindError{msg: "injected fault: conditional check failed", kind: ErrConflict}
+)
+
+// Faults configures FaultInjectingRepository. Rates are probabilities
+// between 0 and 1, drawn independently for every call; a zero rate disables
+// that fault.
+type Faults struct {
+	// Latency is added to calls chosen with LatencyRate, before any other
+	// fault. A call whose context ends while it waits fails with the
+	// context's error.
+	Latency     time.Duration
+	LatencyRate float64
+	// ThrottleRate fails calls with an error matching ErrThrottled without
+	// calling the repository.
+	ThrottleRate float64
+	// ConditionRate fails the conditional writes, Create and Update, with
+	// an error matching ErrConflict without calling the repository.
+	ConditionRate float64
+	// PartialBatchRate makes BatchCreate write only some of its books, a
+	// random prefix, and then fail with an error matching ErrThrottled.
+	PartialBatchRate float64
+	// Seed makes the faults reproducible; zero seeds them randomly.
+	Seed int64
+}
+
+// FaultInjectingRepository is a BookRepository decorator that injects
+// faults into the calls of the repository it wraps, for checking that the
+// retries, circuit breaker and timeouts configured around it cope with
+// them end to end. It sits above the repository's own retries, so injected
+// throttling is not retried by the SDK. It is meant for test environments.
+type FaultInjectingRepository struct {
+	next   BookRepository
+	faults Faults
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewFaultInjectingRepository returns a repository injecting faults into
+// the calls of next.
+func NewFaultInjectingRepository(next BookRepository, faults Faults) *FaultInjectingRepository {
+	seed := faults.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &FaultInjectingRepository{next: next, faults: faults, rand: rand.New(rand.NewSource(seed))}
+}
+
+// InjectFaults returns a middleware that wraps repositories in a
+// FaultInjectingRepository.
+func InjectFaults(faults Faults) RepositoryMiddleware {
+	return func(next BookRepository) BookRepository {
+		return NewFaultInjectingRepository(next, faults)
+	}
+}
+
+// chance reports whether a fault of the given rate happens.
+func (f *FaultInjectingRepository) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Float64() < rate
+}
+
+func (f *FaultInjectingRepository) intn(n int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Intn(n)
+}
+
+// inject delays the call and decides whether it fails before reaching the
+// repository. conditional marks conditional writes.
+func (f *FaultInjectingRepository) inject(ctx context.Context, conditional bool) error {
+	if f.faults.Latency > 0 && f.chance(f.faults.LatencyRate) {
+		timer := time.NewTimer(f.faults.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if f.chance(f.faults.ThrottleRate) {
+		return errInjectedThrottle
+	}
+	if conditional && f.chance(f.faults.ConditionRate) {
+		return errInjectedCondition
+	}
+	return nil
+}
+
+// Create implements BookRepository.
+func (f *FaultInjectingRepository) Create(ctx context.Context, book *Book) error {
+	if err := f.inject(ctx, true); err != nil {
+		return err
+	}
+	return f.next.Create(ctx, book)
+}
+
+// Upsert implements BookRepository.
+func (f *FaultInjectingRepository) Upsert(ctx context.Context, book *Book) error {
+	if err := f.inject(ctx, false); err != nil {
+		return err
+	}
+	return f.next.Upsert(ctx, book)
+}
+
+// GetById implements BookRepository.
+func (f *FaultInjectingRepository) GetById(ctx context.Context, id int) (*Book, error) {
+	if err := f.inject(ctx, false); err != nil {
+		return nil, err
+	}
+	return f.next.GetById(ctx, id)
+}
+
+// Update implements BookRepository.
+func (f *FaultInjectingRepository) Update(ctx context.Context, book *Book) error {
+	if err := f.inject(ctx, true); err != nil {
+		return err
+	}
+	return f.next.Update(ctx, book)
+}
+
+// Delete implements BookRepository.
+func (f *FaultInjectingRepository) Delete(ctx context.Context, id int) error {
+	if err := f.inject(ctx, false); err != nil {
+		return err
+	}
+	return f.next.Delete(ctx, id)
+}
+
+// List implements BookRepository.
+func (f *FaultInjectingRepository) List(ctx context.Context) ([]*Book, error) {
+	if err := f.inject(ctx, false); err != nil {
+		return nil, err
+	}
+	return f.next.List(ctx)
+}
+
+// ListPage implements BookRepository.
+func (f *FaultInjectingRepository) ListPage(ctx context.Context, limit int, cursor string) ([]*Book, string, error) {
+	if err := f.inject(ctx, false); err != nil {
+		return nil, "", err
+	}
+	return f.next.ListPage(ctx, limit, cursor)
+}
+
+// ListSorted implements BookRepository.
+func (f *FaultInjectingRepository) ListSorted(ctx context.Context, opts ListOptions) ([]*Book, string, error) {
+	if err := f.inject(ctx, false); err != nil {
+		return nil, "", err
+	}
+	return f.next.ListSorted(ctx, opts)
+}
+
+// GetByAuthor implements BookRepository.
+func (f *FaultInjectingRepository) GetByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	if err := f.inject(ctx, false); err != nil {
+		return nil, err
+	}
+	return f.next.GetByAuthor(ctx, author)
+}
+
+// GetByISBN implements BookRepository.
+func (f *FaultInjectingRepository) GetByISBN(ctx context.Context, isbn string) (*Book, error) {
+	if err := f.inject(ctx, false); err != nil {
+		return nil, err
+	}
+	return f.next.GetByISBN(ctx, isbn)
+}
+
+// BatchCreate implements BookRepository.
+func (f *FaultInjectingRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	if err := f.inject(ctx, false); err != nil {
+		return err
+	}
+	if len(books) > 0 && f.chance(f.faults.PartialBatchRate) {
+		written := f.intn(len(books))
+		if written > 0 {
+			if err := f.next.BatchCreate(ctx, books[:written]); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("%w: %d of %d books not written", errInjectedThrottle, len(books)-written, len(books))
+	}
+	return f.next.BatchCreate(ctx, books)
+}
+
+// BatchGet implements BookRepository.
+func (f *FaultInjectingRepository) BatchGet(ctx context.Context, ids []int) ([]*Book, error) {
+	if err := f.inject(ctx, false); err != nil {
+		return nil, err
+	}
+	return f.next.BatchGet(ctx, ids)
+}
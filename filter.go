@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ListFilter narrows the books returned by ListFiltered. Zero fields are
+// ignored, so the zero ListFilter matches every book.
+type ListFilter struct {
+	// Author matches books by exactly this author.
+	Author string
+	// NamePrefix matches books whose name starts with it.
+	NamePrefix string
+	// PublishedAfter and PublishedBefore match books published strictly
+	// after and before the given years.
+	PublishedAfter  int
+	PublishedBefore int
+}
+
+// conditions returns the filter expression conditions for every criterion
+// of f except Author, which ListFiltered uses as a key condition.
+func (f ListFilter) conditions() []expression.ConditionBuilder {
+	var conds []expression.ConditionBuilder
+	if f.NamePrefix != "" {
+		conds = append(conds, expression.Name(nameAttribute).BeginsWith(f.NamePrefix))
+	}
+	if f.PublishedAfter != 0 {
+		conds = append(conds, expression.Name(yearAttribute).GreaterThan(expression.Value(f.PublishedAfter)))
+	}
+	if f.PublishedBefore != 0 {
+		conds = append(conds, expression.Name(yearAttribute).LessThan(expression.Value(f.PublishedBefore)))
+	}
+	return conds
+}
+
+// ListFiltered returns the books matching f. With an author the author index
+// is queried; otherwise the table is scanned. The other criteria become a
+// filter expression, so DynamoDB still reads, and charges for, every item
+// the query or scan visits. Soft-deleted books are filtered out unless the
+// repository includes them.
+func (d *DynamoDbBookRepository) ListFiltered(ctx context.Context, f ListFilter) ([]*Book, error) {
+	conds := f.conditions()
+	if !d.includeDeleted {
+		conds = append(conds, expression.AttributeNotExists(expression.Name(deletedAtAttribute)))
+	}
+	builder := expression.NewBuilder()
+	switch len(conds) {
+	case 0:
+	case 1:
+		builder = builder.WithFilter(conds[0])
+	default:
+		builder = builder.WithFilter(expression.And(conds[0], conds[1], conds[2:]...))
+	}
+
+	if f.Author == "" {
+		return d.scanFiltered(ctx, builder, len(conds) > 0)
+	}
+	expr, err := builder.
+		WithKeyCondition(expression.Key(authorAttribute).Equal(expression.Value(f.Author))).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(d.tableName),
+		IndexName:                 aws.String(authorIndexName),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	books := []*Book{}
+	paginator := dynamodb.NewQueryPaginator(d.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		if books, err = d.appendBooks(books, page.Items); err != nil {
+			return nil, err
+		}
+	}
+	return books, nil
+}
+
+// Page is one page of books and the cursor of the next page, which is empty
+// on the last.
+type Page struct {
+	Books []*Book `json:"books"`
+	Next  string  `json:"next,omitempty"`
+}
+
+// SearchPage returns at most limit books matching f, starting after cursor,
+// and the cursor resuming the same search. It queries or scans like
+// ListFiltered. With a projection, only the named attributes are read, as
+// with WithFields. DynamoDB applies the limit before the filter, so
+// SearchPage reads on until it has limit books or the books run out; a
+// selective filter may take many reads. A cursor is only valid with the
+// filter it was issued for: replayed with another one, it is rejected with
+// ErrValidation.
+func (d *DynamoDbBookRepository) SearchPage(ctx context.Context, f ListFilter, projection []string, limit int32, cursor string) (Page, error) {
+	if limit <= 0 {
+		return Page{}, fmt.Errorf("%w: limit must be positive", ErrValidation)
+	}
+	scope, err := json.Marshal(f)
+	if err != nil {
+		return Page{}, err
+	}
+	startKey, err := decodeScopedCursor(cursor, scope)
+	if err != nil {
+		return Page{}, err
+	}
+
+	conds := f.conditions()
+	if !d.includeDeleted {
+		conds = append(conds, expression.AttributeNotExists(expression.Name(deletedAtAttribute)))
+	}
+	builder := expression.NewBuilder()
+	switch len(conds) {
+	case 0:
+	case 1:
+		builder = builder.WithFilter(conds[0])
+	default:
+		builder = builder.WithFilter(expression.And(conds[0], conds[1], conds[2:]...))
+	}
+	if len(projection) > 0 {
+		var proj expression.ProjectionBuilder
+		for _, name := range append(append([]string{}, d.items.projected...), projection...) {
+			proj = proj.AddNames(expression.Name(name))
+		}
+		builder = builder.WithProjection(proj)
+	}
+	if f.Author != "" {
+		builder = builder.WithKeyCondition(expression.Key(authorAttribute).Equal(expression.Value(f.Author)))
+	}
+	var expr expression.Expression
+	if len(conds) > 0 || len(projection) > 0 || f.Author != "" {
+		if expr, err = builder.Build(); err != nil {
+			return Page{}, fmt.Errorf("%w: %v", ErrValidation, err)
+		}
+	}
+
+	page := Page{Books: []*Book{}}
+	for {
+		var items []map[string]types.AttributeValue
+		var lastKey map[string]types.AttributeValue
+		remaining := aws.Int32(limit - int32(len(page.Books)))
+		if f.Author == "" {
+			result, err := d.client.Scan(ctx, &dynamodb.ScanInput{
+				TableName:                 aws.String(d.tableName),
+				FilterExpression:          expr.Filter(),
+				ProjectionExpression:      expr.Projection(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+				ExclusiveStartKey:         startKey,
+				Limit:                     remaining,
+				ConsistentRead:            consistentRead(ctx, d.consistentReads),
+			})
+			if err != nil {
+				return Page{}, translateError(err)
+			}
+			items, lastKey = result.Items, result.LastEvaluatedKey
+		} else {
+			result, err := d.client.Query(ctx, &dynamodb.QueryInput{
+				TableName:                 aws.String(d.tableName),
+				IndexName:                 aws.String(authorIndexName),
+				KeyConditionExpression:    expr.KeyCondition(),
+				FilterExpression:          expr.Filter(),
+				ProjectionExpression:      expr.Projection(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+				ExclusiveStartKey:         startKey,
+				Limit:                     remaining,
+			})
+			if err != nil {
+				return Page{}, translateError(err)
+			}
+			items, lastKey = result.Items, result.LastEvaluatedKey
+		}
+		if page.Books, err = d.appendBooks(page.Books, items); err != nil {
+			return Page{}, err
+		}
+		// Each read is limited to the books still missing, so the page
+		// never overshoots and the last key is where the next page starts.
+		startKey = lastKey
+		if len(startKey) == 0 || len(page.Books) >= int(limit) {
+			break
+		}
+	}
+	page.Next, err = encodeScopedCursor(startKey, scope)
+	return page, err
+}
+
+// scanFiltered scans the whole table, applying the builder's filter if
+// hasFilter is set. An expression builder without any expression cannot be
+// built, hence the flag.
+func (d *DynamoDbBookRepository) scanFiltered(ctx context.Context, builder expression.Builder, hasFilter bool) ([]*Book, error) {
+	input := &dynamodb.ScanInput{
+		TableName:      aws.String(d.tableName),
+		ConsistentRead: consistentRead(ctx, d.consistentReads),
+	}
+	if hasFilter {
+		expr, err := builder.Build()
+		if err != nil {
+			return nil, err
+		}
+		input.FilterExpression = expr.Filter()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+
+	books := []*Book{}
+	paginator := dynamodb.NewScanPaginator(d.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		if books, err = d.appendBooks(books, page.Items); err != nil {
+			return nil, err
+		}
+	}
+	return books, nil
+}
+
+// appendBooks unmarshals a page of items and appends them to books.
+func (d *DynamoDbBookRepository) appendBooks(books []*Book, items []map[string]types.AttributeValue) ([]*Book, error) {
+	page, err := d.codec.unmarshalList(items)
+	if err != nil {
+		return nil, err
+	}
+	return append(books, page...), nil
+}
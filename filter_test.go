@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSearchPageResumesAFilteredProjectedScan(t *testing.T) {
+	names := []string{"Dune", "Emma", "Dracula", "Ulysses", "Dubliners", "Don Quixote"}
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		if op != "Scan" {
+			return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+		}
+		in := decodeInput(t, input)
+		if !strings.Contains(in.FilterExpression, "begins_with") {
+			t.Errorf("filter expression %q, want a begins_with", in.FilterExpression)
+		}
+		start := 0
+		if in.ExclusiveStartKey != nil {
+			start = keyID(t, in.ExclusiveStartKey)
+		}
+		// Like DynamoDB, the limit counts the items scanned, before the
+		// filter drops those not starting with D.
+		items := []any{}
+		last := min(start+*in.Limit, len(names))
+		for id := start + 1; id <= last; id++ {
+			if !strings.HasPrefix(names[id-1], "D") {
+				continue
+			}
+			item := wireItem(t, &Book{Id: id, Name: names[id-1], Author: "Author", Version: 1})
+			for name := range item {
+				if !strings.Contains(","+strings.Join(in.projected(), ",")+",", ","+name+",") {
+					delete(item, name)
+				}
+			}
+			items = append(items, item)
+		}
+		output := map[string]any{"Items": items, "Count": len(items)}
+		if last < len(names) {
+			output["LastEvaluatedKey"] = wireAttributes(t, NumberKey(idAttribute).MarshalKey(last))
+		}
+		return output, nil
+	})
+	repo := stub.repository()
+	ctx := context.Background()
+	filter := ListFilter{NamePrefix: "D"}
+
+	ids := func(page Page) []int {
+		var ids []int
+		for _, b := range page.Books {
+			if b.Author != "" {
+				t.Errorf("book %d has author %q, want it left out by the projection", b.Id, b.Author)
+			}
+			ids = append(ids, b.Id)
+		}
+		return ids
+	}
+	first, err := repo.SearchPage(ctx, filter, []string{nameAttribute}, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(first); len(got) != 2 || got[0] != 1 || got[1] != 3 || first.Next == "" {
+		t.Fatalf("first page %v, next %q, want books 1 and 3 and a cursor", got, first.Next)
+	}
+	second, err := repo.SearchPage(ctx, filter, []string{nameAttribute}, 2, first.Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(second); len(got) != 2 || got[0] != 5 || got[1] != 6 || second.Next != "" {
+		t.Errorf("second page %v, next %q, want books 5 and 6 and no cursor", got, second.Next)
+	}
+	if second.Books[0].Name != "Dubliners" {
+		t.Errorf("book 5 named %q, want the projected name", second.Books[0].Name)
+	}
+
+	calls := len(stub.calls)
+	if _, err := repo.SearchPage(ctx, ListFilter{NamePrefix: "E"}, nil, 2, first.Next); !errors.Is(err, ErrValidation) {
+		t.Errorf("cursor replayed with another filter: got %v, want ErrValidation", err)
+	}
+	if len(stub.calls) != calls {
+		t.Error("a rejected cursor reached DynamoDB")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// EntitySchema describes how values of an entity type are stored as items.
+type EntitySchema[T any] struct {
+	// Key returns the primary key attributes of an entity.
+	Key func(*T) map[string]types.AttributeValue
+	// Marshal encodes an entity into an item. Defaults to
+	// attributevalue.MarshalMap.
+	Marshal func(*T) (map[string]types.AttributeValue, error)
+	// Unmarshal decodes an item into an entity. Defaults to
+	// attributevalue.UnmarshalMap.
+	Unmarshal func(map[string]types.AttributeValue, *T) error
+}
+
+// Repository implements the basic DynamoDB operations for any entity type
+// described by an EntitySchema, so each entity only has to define its key
+// and, if needed, its encoding.
+type Repository[T any] struct {
+	client    *dynamodb.Client
+	tableName string
+	schema    EntitySchema[T]
+	// consistentReads is the default for reads without a ReadOption.
+	consistentReads bool
+	// projected lists attributes fetched even when a read asks for
+	// specific fields with WithFields.
+	projected []string
+	// createCondition, if set, is the condition of Create, built once
+	// because every entity has the same key attributes.
+	createCondition *keyCondition
+}
+
+func NewRepository[T any](client *dynamodb.Client, tableName string, schema EntitySchema[T]) *Repository[T] {
+	if schema.Marshal == nil {
+		schema.Marshal = func(v *T) (map[string]types.AttributeValue, error) {
+			return attributevalue.MarshalMap(v)
+		}
+	}
+	if schema.Unmarshal == nil {
+		schema.Unmarshal = func(item map[string]types.AttributeValue, v *T) error {
+			return attributevalue.UnmarshalMap(item, v)
+		}
+	}
+	return &Repository[T]{client: client, tableName: tableName, schema: schema}
+}
+
+// Create writes entity unless an item with the same key exists, in which
+// case it returns an error matching ErrConflict.
+func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
+	av, err := r.schema.Marshal(entity)
+	if err != nil {
+		return err
+	}
+	// An item with the same key exists iff it has the key attributes.
+	condition := r.createCondition
+	if condition == nil {
+		condition = notExistsCondition(r.schema.Key(entity))
+	}
+	_, err = r.client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:                     av,
+		TableName:                aws.String(r.tableName),
+		ConditionExpression:      aws.String(condition.expression),
+		ExpressionAttributeNames: condition.names,
+	})
+	return translateError(err)
+}
+
+// keyCondition is a condition expression on the key attributes of an item,
+// together with the attribute names it uses.
+type keyCondition struct {
+	expression string
+	names      map[string]string
+}
+
+// notExistsCondition returns the condition that no item with the key
+// attributes of key exists.
+func notExistsCondition(key map[string]types.AttributeValue) *keyCondition {
+	names := make(map[string]string, len(key))
+	conditions := make([]string, 0, len(key))
+	for name := range key {
+		placeholder := fmt.Sprintf("#k%d", len(names))
+		names[placeholder] = name
+		conditions = append(conditions, "attribute_not_exists("+placeholder+")")
+	}
+	return &keyCondition{expression: strings.Join(conditions, " AND "), names: names}
+}
+
+// Put writes entity, replacing any item with the same key.
+func (r *Repository[T]) Put(ctx context.Context, entity *T) error {
+	av, err := r.schema.Marshal(entity)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(r.tableName),
+	})
+	return translateError(err)
+}
+
+// Get returns the entity stored under key, or ErrNotFound.
+func (r *Repository[T]) Get(ctx context.Context, key map[string]types.AttributeValue) (*T, error) {
+	item, err := r.getItem(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	entity := new(T)
+	if err := r.schema.Unmarshal(item, entity); err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+// getItem returns the raw item stored under key.
+func (r *Repository[T]) getItem(ctx context.Context, key map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	proj, names, err := projection(ctx, r.projected)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.client.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:                      key,
+		TableName:                aws.String(r.tableName),
+		ConsistentRead:           consistentRead(ctx, r.consistentReads),
+		ProjectionExpression:     proj,
+		ExpressionAttributeNames: names,
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+	return result.Item, nil
+}
+
+// Delete removes the item stored under key. Deleting a missing item is not
+// an error.
+func (r *Repository[T]) Delete(ctx context.Context, key map[string]types.AttributeValue) error {
+	_, err := r.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		Key:       key,
+		TableName: aws.String(r.tableName),
+	})
+	return translateError(err)
+}
+
+// Scan returns every entity in the table, following scan pages. In a table
+// shared by several entity types, items of other types (rejected by the
+// schema with ErrEntityMismatch) are skipped.
+func (r *Repository[T]) Scan(ctx context.Context) ([]*T, error) {
+	proj, names, err := projection(ctx, r.projected)
+	if err != nil {
+		return nil, err
+	}
+	entities := []*T{}
+	paginator := dynamodb.NewScanPaginator(r.client, &dynamodb.ScanInput{
+		TableName:                aws.String(r.tableName),
+		ConsistentRead:           consistentRead(ctx, r.consistentReads),
+		ProjectionExpression:     proj,
+		ExpressionAttributeNames: names,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		for _, item := range page.Items {
+			entity := new(T)
+			err := r.schema.Unmarshal(item, entity)
+			if errors.Is(err, ErrEntityMismatch) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			entities = append(entities, entity)
+		}
+	}
+	return entities, nil
+}
+
+// isConflict reports whether err is a failed condition check.
+func isConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
@@ -0,0 +1,325 @@
+package main
+
+//go:generate protoc -I proto --go_out=proto --go_opt=paths=source_relative --go-grpc_out=proto --go-grpc_opt=paths=source_relative book/v1/book.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	bookv1 "dynamoDBExample/proto/book/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchBufferSize is the number of changes buffered per WatchBooks stream.
+// A subscriber that falls further behind misses changes rather than
+// blocking writers.
+const watchBufferSize = 64
+
+// ChangeBroadcaster is a ChangeHandler that fans changes out to any number of
+// subscribers. It backs the WatchBooks RPC.
+type ChangeBroadcaster struct {
+	mu   sync.Mutex
+	subs map[chan BookChange]struct{}
+}
+
+func NewChangeBroadcaster() *ChangeBroadcaster {
+	return &ChangeBroadcaster{subs: map[chan BookChange]struct{}{}}
+}
+
+// HandleChange implements ChangeHandler. It never blocks: subscribers whose
+// buffer is full drop the change.
+func (b *ChangeBroadcaster) HandleChange(ctx context.Context, change BookChange) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe returns a channel receiving subsequent changes and a function
+// that unsubscribes and closes it.
+func (b *ChangeBroadcaster) Subscribe() (<-chan BookChange, func()) {
+	ch := make(chan BookChange, watchBufferSize)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+		close(ch)
+	}
+}
+
+// grpcBookServer implements bookv1.BookServiceServer on a BookUseCase.
+type grpcBookServer struct {
+	bookv1.UnimplementedBookServiceServer
+	uc      *BookUseCase
+	changes *ChangeBroadcaster
+}
+
+// NewGRPCServer returns a gRPC server exposing BookService with logging and
+// panic recovery interceptors. changes feeds WatchBooks; the use case should
+// publish to it with WithChangeHandler. A non-nil auth authenticates the
+// bearer token in the authorization metadata of every call, failing calls
+// without a valid one with Unauthenticated.
+func NewGRPCServer(uc *BookUseCase, changes *ChangeBroadcaster, logger *slog.Logger, auth Authenticator) *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{unaryLoggingInterceptor(logger), unaryRecoveryInterceptor(logger)}
+	stream := []grpc.StreamServerInterceptor{streamLoggingInterceptor(logger), streamRecoveryInterceptor(logger)}
+	if auth != nil {
+		unary = append(unary, unaryAuthInterceptor(auth))
+		stream = append(stream, streamAuthInterceptor(auth))
+	}
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	bookv1.RegisterBookServiceServer(srv, &grpcBookServer{uc: uc, changes: changes})
+	return srv
+}
+
+func (s *grpcBookServer) CreateBook(ctx context.Context, req *bookv1.CreateBookRequest) (*bookv1.Book, error) {
+	if req.GetBook() == nil {
+		return nil, status.Error(codes.InvalidArgument, "book is required")
+	}
+	book := bookFromProto(req.GetBook())
+	if err := s.uc.createBook(ctx, book, req.GetIdempotencyKey()); err != nil {
+		return nil, grpcError(err)
+	}
+	return bookToProto(book), nil
+}
+
+func (s *grpcBookServer) GetBook(ctx context.Context, req *bookv1.GetBookRequest) (*bookv1.Book, error) {
+	book, err := s.uc.GetById(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return bookToProto(book), nil
+}
+
+func (s *grpcBookServer) UpdateBook(ctx context.Context, req *bookv1.UpdateBookRequest) (*bookv1.Book, error) {
+	if req.GetBook() == nil {
+		return nil, status.Error(codes.InvalidArgument, "book is required")
+	}
+	book := bookFromProto(req.GetBook())
+	if err := s.uc.Update(ctx, book); err != nil {
+		return nil, grpcError(err)
+	}
+	return bookToProto(book), nil
+}
+
+func (s *grpcBookServer) DeleteBook(ctx context.Context, req *bookv1.DeleteBookRequest) (*bookv1.DeleteBookResponse, error) {
+	if err := s.uc.Delete(ctx, int(req.GetId())); err != nil {
+		return nil, grpcError(err)
+	}
+	return &bookv1.DeleteBookResponse{}, nil
+}
+
+// ListBooks streams books one scan page at a time, so the whole table is
+// never held in memory.
+func (s *grpcBookServer) ListBooks(req *bookv1.ListBooksRequest, stream bookv1.BookService_ListBooksServer) error {
+	pageSize := int(req.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = exportPageSize
+	}
+	ctx := stream.Context()
+	cursor := ""
+	for {
+		books, next, err := s.uc.ListPage(ctx, pageSize, cursor)
+		if err != nil {
+			return grpcError(err)
+		}
+		for _, book := range books {
+			if err := stream.Send(bookToProto(book)); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// WatchBooks streams changes until the client cancels or the server stops.
+func (s *grpcBookServer) WatchBooks(req *bookv1.WatchBooksRequest, stream bookv1.BookService_WatchBooksServer) error {
+	if s.changes == nil {
+		return status.Error(codes.Unimplemented, "watching is not enabled")
+	}
+	changes, unsubscribe := s.changes.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case change := <-changes:
+			if err := stream.Send(bookEventToProto(change)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func bookToProto(b *Book) *bookv1.Book {
+	return &bookv1.Book{
+		Id:      int64(b.Id),
+		Name:    b.Name,
+		Author:  b.Author,
+		Version: int64(b.Version),
+		Tags:    b.Tags,
+		Year:    int32(b.Year),
+	}
+}
+
+func bookFromProto(b *bookv1.Book) *Book {
+	return &Book{
+		Id:      int(b.GetId()),
+		Name:    b.GetName(),
+		Author:  b.GetAuthor(),
+		Version: int(b.GetVersion()),
+		Tags:    b.GetTags(),
+		Year:    int(b.GetYear()),
+	}
+}
+
+func bookEventToProto(change BookChange) *bookv1.BookEvent {
+	event := &bookv1.BookEvent{Id: int64(change.Id)}
+	switch change.Type {
+	case ChangeInsert:
+		event.Type = bookv1.BookEvent_TYPE_CREATED
+	case ChangeModify:
+		event.Type = bookv1.BookEvent_TYPE_UPDATED
+	case ChangeRemove:
+		event.Type = bookv1.BookEvent_TYPE_DELETED
+	}
+	if change.New != nil {
+		event.Book = bookToProto(change.New)
+	}
+	return event
+}
+
+// grpcError converts a domain error into a gRPC status, mirroring
+// writeRepositoryError.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, "book not found")
+	case errors.Is(err, ErrBookAlreadyExists), errors.Is(err, ErrISBNTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, ErrVersionConflict), errors.Is(err, ErrIdempotencyKeyReused):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, ErrConflict):
+		return status.Error(codes.Aborted, "conflict")
+	case errors.Is(err, ErrThrottled):
+		return status.Error(codes.Unavailable, "throttled, retry later")
+	case errors.Is(err, ErrNoRegionAvailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, ErrValidation):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ErrForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Internal, "internal error")
+}
+
+func unaryLoggingInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logRPC(ctx, logger, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func streamLoggingInterceptor(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logRPC(ss.Context(), logger, info.FullMethod, start, err)
+		return err
+	}
+}
+
+func logRPC(ctx context.Context, logger *slog.Logger, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch code {
+	case codes.OK, codes.Canceled:
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+	logger.Log(ctx, level, "grpc call", "method", method, "code", code.String(), "latency", time.Since(start), "error", err)
+}
+
+func unaryRecoveryInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer recoverRPC(ctx, logger, info.FullMethod, &err)
+		return handler(ctx, req)
+	}
+}
+
+func streamRecoveryInterceptor(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer recoverRPC(ss.Context(), logger, info.FullMethod, &err)
+		return handler(srv, ss)
+	}
+}
+
+// recoverRPC turns a panic in a handler into an Internal error so one bad
+// request cannot crash the server.
+func recoverRPC(ctx context.Context, logger *slog.Logger, method string, err *error) {
+	if r := recover(); r != nil {
+		logger.ErrorContext(ctx, "grpc handler panicked", "method", method, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+		*err = status.Error(codes.Internal, "internal error")
+	}
+}
+
+// serveGRPC runs srv on addr until ctx is cancelled, then stops it
+// gracefully, giving in-flight calls up to shutdownTimeout to finish.
+func serveGRPC(ctx context.Context, addr string, srv *grpc.Server, shutdownTimeout time.Duration) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(lis)
+	}()
+	slog.Info("grpc listening", "addr", addr)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		srv.Stop()
+	}
+	return nil
+}
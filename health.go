@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// defaultReadinessCacheTTL is how long a readiness result is reused, so
+	// frequent probes do not each cost a DescribeTable call.
+	defaultReadinessCacheTTL = 5 * time.Second
+	// readinessTimeout bounds a single readiness check.
+	readinessTimeout = 2 * time.Second
+)
+
+// ReadinessCheck reports whether the service can handle requests, returning
+// the reason if it cannot.
+type ReadinessCheck func(ctx context.Context) error
+
+// HealthHandler serves the probes of container orchestrators:
+//
+//	GET /healthz  liveness: 200 as long as the process serves HTTP
+//	GET /readyz   readiness: 200 if the datastore is reachable, 503 if not
+//
+// Readiness results are cached for the configured TTL.
+type HealthHandler struct {
+	check ReadinessCheck
+	ttl   time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	lastErr   error
+}
+
+// NewHealthHandler returns a HealthHandler running check at most once per
+// ttl.
+func NewHealthHandler(check ReadinessCheck, ttl time.Duration) *HealthHandler {
+	return &HealthHandler{check: check, ttl: ttl}
+}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	switch strings.Trim(r.URL.Path, "/") {
+	case "healthz":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case "readyz":
+		if err := h.ready(r.Context()); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "not found")
+	}
+}
+
+// ready returns the cached readiness result, running the check if it is
+// stale. Concurrent probes wait for a single check rather than each
+// starting one.
+func (h *HealthHandler) ready(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.checkedAt.IsZero() && time.Since(h.checkedAt) < h.ttl {
+		return h.lastErr
+	}
+	// A probe that disconnects must not leave its cancellation cached.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readinessTimeout)
+	defer cancel()
+	h.lastErr = h.check(ctx)
+	h.checkedAt = time.Now()
+	return h.lastErr
+}
+
+// tableReady returns a ReadinessCheck that describes tableName and requires
+// it to be ACTIVE, which verifies both connectivity and the table itself.
+func tableReady(client *dynamodb.Client, tableName string) ReadinessCheck {
+	return func(ctx context.Context) error {
+		out, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+		if err != nil {
+			return fmt.Errorf("describe table %s: %w", tableName, translateError(err))
+		}
+		if status := out.Table.TableStatus; status != types.TableStatusActive {
+			return fmt.Errorf("table %s is %s", tableName, status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attribute names of the holds table, which is keyed by book id: a book has
+// at most one hold item, holding its latest reservation. Expired items are
+// removed by TTL on ttlAttribute.
+const (
+	holderAttribute    = "holder"
+	holdStateAttribute = "state"
+)
+
+// maxHolderLength bounds holder ids.
+const maxHolderLength = 255
+
+// HoldState is the state of a Reservation. A hold starts active and ends
+// either released by its holder or expired; ended holds never become active
+// again, a new hold is placed instead.
+type HoldState string
+
+const (
+	HoldActive   HoldState = "active"
+	HoldReleased HoldState = "released"
+	HoldExpired  HoldState = "expired"
+)
+
+// Reservation is a hold on a book: while it is active, no one else can
+// place a hold on the book.
+type Reservation struct {
+	BookId    int       `json:"bookId" dynamodbav:"id"`
+	Holder    string    `json:"holder" dynamodbav:"holder"`
+	State     HoldState `json:"state" dynamodbav:"state"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	// ExpiresAt is when an active hold lapses. It doubles as the TTL of the
+	// item, so DynamoDB eventually deletes holds after they end.
+	ExpiresAt time.Time `json:"expiresAt" dynamodbav:"expiresAt,unixtime"`
+}
+
+// Active reports whether the hold is in force at now. A hold past its
+// expiry is not, even before the sweeper marks it expired.
+func (r *Reservation) Active(now time.Time) bool {
+	return r.State == HoldActive && now.Before(r.ExpiresAt)
+}
+
+// holdsTableName returns the name of the table holding the reservations of
+// the books in bookTable.
+func holdsTableName(bookTable string) string {
+	return bookTable + "-holds"
+}
+
+// PlaceHold reserves the book for holder for d. It fails with ErrNotFound
+// if the book does not exist or is soft-deleted, and with ErrBookOnHold if
+// another active hold is in force. Placing a hold again as its holder
+// renews it.
+func (d *DynamoDbBookRepository) PlaceHold(ctx context.Context, bookID int, holder string, dur time.Duration) (*Reservation, error) {
+	if holder == "" || len(holder) > maxHolderLength {
+		return nil, fmt.Errorf("%w: holder must be 1 to %d bytes", ErrValidation, maxHolderLength)
+	}
+	if dur <= 0 {
+		return nil, fmt.Errorf("%w: hold duration must be positive", ErrValidation)
+	}
+	now := time.Now()
+	hold := &Reservation{BookId: bookID, Holder: holder, State: HoldActive, CreatedAt: now, ExpiresAt: now.Add(dur)}
+	item, err := attributevalue.MarshalMap(hold)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{ConditionCheck: &types.ConditionCheck{
+				TableName:                aws.String(d.tableName),
+				Key:                      d.key.MarshalKey(bookID),
+				ConditionExpression:      aws.String("attribute_exists(#id) AND attribute_not_exists(#deletedAt)"),
+				ExpressionAttributeNames: map[string]string{"#id": idAttribute, "#deletedAt": deletedAtAttribute},
+			}},
+			{Put: &types.Put{
+				TableName: aws.String(holdsTableName(d.tableName)),
+				Item:      item,
+				// A hold can be placed unless another holder's is active.
+				ConditionExpression: aws.String("attribute_not_exists(#id) OR #state <> :active OR #expiresAt <= :now OR #holder = :holder"),
+				ExpressionAttributeNames: map[string]string{
+					"#id":        idAttribute,
+					"#state":     holdStateAttribute,
+					"#expiresAt": ttlAttribute,
+					"#holder":    holderAttribute,
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":active": &types.AttributeValueMemberS{Value: string(HoldActive)},
+					":now":    &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+					":holder": &types.AttributeValueMemberS{Value: holder},
+				},
+			}},
+		},
+	})
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		if err != nil {
+			return nil, translateError(err)
+		}
+		return hold, nil
+	}
+	reasons := canceled.CancellationReasons
+	if len(reasons) != 2 {
+		return nil, translateError(err)
+	}
+	if aws.ToString(reasons[0].Code) == "ConditionalCheckFailed" {
+		return nil, ErrNotFound
+	}
+	if aws.ToString(reasons[1].Code) == "ConditionalCheckFailed" {
+		return nil, ErrBookOnHold
+	}
+	return nil, translateError(err)
+}
+
+// ReleaseHold ends the active hold of holder on the book. It fails with
+// ErrNotHeld if holder has no hold in force on it.
+func (d *DynamoDbBookRepository) ReleaseHold(ctx context.Context, bookID int, holder string) error {
+	return d.endHold(ctx, bookID, HoldReleased,
+		"#state = :active AND #holder = :holder AND #expiresAt > :now",
+		map[string]types.AttributeValue{":holder": &types.AttributeValueMemberS{Value: holder}},
+		time.Now())
+}
+
+// GetHold returns the hold in force on the book, or ErrNotFound if there is
+// none.
+func (d *DynamoDbBookRepository) GetHold(ctx context.Context, bookID int) (*Reservation, error) {
+	result, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(holdsTableName(d.tableName)),
+		Key:            NumberKey(idAttribute).MarshalKey(bookID),
+		ConsistentRead: consistentRead(ctx, d.consistentReads),
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+	hold := new(Reservation)
+	if err := attributevalue.UnmarshalMap(result.Item, hold); err != nil {
+		return nil, err
+	}
+	if !hold.Active(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return hold, nil
+}
+
+// SweepHolds marks every active hold past its expiry as expired and returns
+// how many it marked. Holds lapse on their own, so sweeping only makes
+// their state reflect it; it scans the whole holds table.
+func (d *DynamoDbBookRepository) SweepHolds(ctx context.Context) (int, error) {
+	now := time.Now()
+	paginator := dynamodb.NewScanPaginator(d.client, &dynamodb.ScanInput{
+		TableName:            aws.String(holdsTableName(d.tableName)),
+		ProjectionExpression: aws.String("#id"),
+		FilterExpression:     aws.String("#state = :active AND #expiresAt <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#id":        idAttribute,
+			"#state":     holdStateAttribute,
+			"#expiresAt": ttlAttribute,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":active": &types.AttributeValueMemberS{Value: string(HoldActive)},
+			":now":    &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	})
+	swept := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return swept, translateError(err)
+		}
+		for _, item := range page.Items {
+			id, err := NumberKey(idAttribute).UnmarshalKey(item)
+			if err != nil {
+				return swept, err
+			}
+			err = d.endHold(ctx, id, HoldExpired, "#state = :active AND #expiresAt <= :now", nil, now)
+			// The hold was released or renewed since the scan.
+			if errors.Is(err, ErrNotHeld) {
+				continue
+			}
+			if err != nil {
+				return swept, err
+			}
+			swept++
+		}
+	}
+	return swept, nil
+}
+
+// RunHoldSweeper calls SweepHolds every interval until ctx is done. Failed
+// sweeps are logged and retried at the next interval.
+func (d *DynamoDbBookRepository) RunHoldSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n, err := d.SweepHolds(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "sweep holds", "table", holdsTableName(d.tableName), "error", err)
+		}
+		if n > 0 {
+			slog.InfoContext(ctx, "expired holds", "table", holdsTableName(d.tableName), "count", n)
+		}
+	}
+}
+
+// endHold moves the hold of the book to state if condition holds, failing
+// with ErrNotHeld otherwise. condition may use #state, #holder, #expiresAt,
+// :active and :now besides the given values.
+func (d *DynamoDbBookRepository) endHold(ctx context.Context, bookID int, state HoldState, condition string, values map[string]types.AttributeValue, now time.Time) error {
+	exprValues := map[string]types.AttributeValue{
+		":active": &types.AttributeValueMemberS{Value: string(HoldActive)},
+		":now":    &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		":state":  &types.AttributeValueMemberS{Value: string(state)},
+	}
+	for k, v := range values {
+		exprValues[k] = v
+	}
+	names := map[string]string{"#state": holdStateAttribute, "#expiresAt": ttlAttribute}
+	if values[":holder"] != nil {
+		names["#holder"] = holderAttribute
+	}
+	_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(holdsTableName(d.tableName)),
+		Key:                       NumberKey(idAttribute).MarshalKey(bookID),
+		UpdateExpression:          aws.String("SET #state = :state"),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: exprValues,
+	})
+	err = translateError(err)
+	if isConflict(err) {
+		return ErrNotHeld
+	}
+	return err
+}
+
+// holdsTableDefinition describes the holds table of bookTable: a numeric
+// book id partition key and nothing else.
+func holdsTableDefinition(bookTable string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(holdsTableName(bookTable)),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(idAttribute), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(idAttribute), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
+
+// MigrateHolds creates the holds table of bookTable if needed and enables
+// TTL on it so ended holds are cleaned up. It is safe to run repeatedly.
+func MigrateHolds(ctx context.Context, client *dynamodb.Client, bookTable string) error {
+	return migrateTable(ctx, client, holdsTableDefinition(bookTable))
+}
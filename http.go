@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// idempotencyKeyHeader carries the client's idempotency key on POST /books.
+// Retrying a create with the same key returns the originally created book.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// actorHeader names who makes a request, for the audit log. It is trusted as
+// is, so it must be set by an authenticating proxy, not by clients. Behind
+// Authenticate it is ignored in favor of the principal.
+const actorHeader = "X-Actor"
+
+// maxBookBodyBytes caps the body of a book request, well above the 400 KB a
+// DynamoDB item can hold.
+const maxBookBodyBytes = 1 << 20
+
+// Page sizes of paginated list requests.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+// BookHandler serves the Book REST API:
+//
+//	POST   /books              create a book (with an optional Idempotency-Key header)
+//	GET    /books              list books (?limit=N&cursor=C for one page)
+//	GET    /books/search       search books (?q=terms&limit=N), most relevant first
+//	GET    /books/isbn/{isbn}  fetch the book with an ISBN-10 or ISBN-13
+//	GET    /books/{id}         fetch a book
+//	PUT    /books/{id}         replace a book
+//	DELETE /books/{id}         delete a book
+//
+// GET requests accept ?consistent=true for a strongly consistent read and
+// ?fields=id,name to fetch only some attributes. Writes are audited as made
+// by the actor in the X-Actor header, if any. With a routing file, the
+// X-Book-Route header picks the table a request is served from.
+type BookHandler struct {
+	uc *BookUseCase
+}
+
+func NewBookHandler(uc *BookUseCase) *BookHandler {
+	return &BookHandler{uc: uc}
+}
+
+func (h *BookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if actor := r.Header.Get(actorHeader); actor != "" {
+		r = r.WithContext(WithActor(r.Context(), actor))
+	}
+	if route := r.Header.Get(routeHeader); route != "" {
+		r = r.WithContext(WithRoute(r.Context(), route))
+	}
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "books" {
+		switch r.Method {
+		case http.MethodGet:
+			h.list(w, r)
+		case http.MethodPost:
+			h.create(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+
+	if path == "books/search" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.search(w, r)
+		return
+	}
+
+	if isbn, ok := strings.CutPrefix(path, "books/isbn/"); ok && !strings.Contains(isbn, "/") {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.getByISBN(w, r, isbn)
+		return
+	}
+
+	rawID, ok := strings.CutPrefix(path, "books/")
+	if !ok || strings.Contains(rawID, "/") {
+		writeError(w, http.StatusNotFound, "not_found", "not found")
+		return
+	}
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid book id")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, r, id)
+	case http.MethodPut:
+		h.update(w, r, id)
+	case http.MethodDelete:
+		h.delete(w, r, id)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// readOptions returns the read options requested by the query string:
+// consistent=true asks for a strongly consistent read and fields=a,b for
+// only the listed attributes.
+func readOptions(r *http.Request) []ReadOption {
+	var opts []ReadOption
+	query := r.URL.Query()
+	if consistent, _ := strconv.ParseBool(query.Get("consistent")); consistent {
+		opts = append(opts, WithConsistentRead())
+	}
+	if raw := query.Get("fields"); raw != "" {
+		var fields []string
+		for _, field := range strings.Split(raw, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, field)
+			}
+		}
+		opts = append(opts, WithFields(fields...))
+	}
+	return opts
+}
+
+// list returns every book, or a single page if the request has a limit or
+// cursor parameter.
+func (h *BookHandler) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := ListOptions{
+		SortBy: SortBy(query.Get("sort")),
+		Order:  SortOrder(query.Get("order")),
+		Cursor: query.Get("cursor"),
+	}
+	// Sorted listings are always paged, like those asking for a page.
+	paged := query.Has("limit") || query.Has("cursor") || opts.SortBy != ""
+	if paged {
+		opts.Limit = defaultPageLimit
+	}
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxPageLimit {
+			writeError(w, http.StatusBadRequest, "invalid_limit", "invalid limit")
+			return
+		}
+		opts.Limit = n
+	}
+	books, next, err := h.uc.List(r.Context(), opts, readOptions(r)...)
+	if err != nil {
+		writeRepositoryError(w, r, err)
+		return
+	}
+	if !paged {
+		writeJSON(w, http.StatusOK, books)
+		return
+	}
+	writeJSON(w, http.StatusOK, Page{Books: books, Next: next})
+}
+
+func (h *BookHandler) search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 0
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxSearchLimit {
+			writeError(w, http.StatusBadRequest, "invalid_limit", "invalid limit")
+			return
+		}
+		limit = n
+	}
+	hits, err := h.uc.SearchBooks(r.Context(), query.Get("q"), limit)
+	if err != nil {
+		writeRepositoryError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, hits)
+}
+
+func (h *BookHandler) create(w http.ResponseWriter, r *http.Request) {
+	book, ok := decodeBook(w, r)
+	if !ok {
+		return
+	}
+	if err := h.uc.createBook(r.Context(), book, r.Header.Get(idempotencyKeyHeader)); err != nil {
+		writeRepositoryError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, book)
+}
+
+func (h *BookHandler) get(w http.ResponseWriter, r *http.Request, id int) {
+	book, err := h.uc.GetById(r.Context(), id, readOptions(r)...)
+	if err != nil {
+		writeRepositoryError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, book)
+}
+
+func (h *BookHandler) getByISBN(w http.ResponseWriter, r *http.Request, isbn string) {
+	book, err := h.uc.GetByISBN(r.Context(), isbn, readOptions(r)...)
+	if err != nil {
+		writeRepositoryError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, book)
+}
+
+func (h *BookHandler) update(w http.ResponseWriter, r *http.Request, id int) {
+	book, ok := decodeBook(w, r)
+	if !ok {
+		return
+	}
+	// The path is authoritative for which book is being replaced.
+	book.Id = id
+	if err := h.uc.Update(r.Context(), book); err != nil {
+		writeRepositoryError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, book)
+}
+
+func (h *BookHandler) delete(w http.ResponseWriter, r *http.Request, id int) {
+	if err := h.uc.Delete(r.Context(), id); err != nil {
+		writeRepositoryError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeBook reads a Book from the request body, replying 400 on malformed
+// input. It reports whether decoding succeeded.
+func decodeBook(w http.ResponseWriter, r *http.Request) (*Book, bool) {
+	var raw json.RawMessage
+	book := new(Book)
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBookBodyBytes)).Decode(&raw)
+	if err == nil {
+		err = decodeBookJSON(raw, book, true)
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+			return nil, false
+		}
+		writeError(w, http.StatusBadRequest, "invalid_body", "invalid request body: "+err.Error())
+		return nil, false
+	}
+	return book, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write response: %v", err)
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+}
+
+// serveHTTP runs an HTTP server for handler on addr until ctx is cancelled,
+// then shuts it down gracefully, giving in-flight requests up to
+// shutdownTimeout to complete before their contexts are canceled.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler, shutdownTimeout time.Duration) error {
+	// Requests keep running while the server drains them and are only
+	// canceled if they outlast shutdownTimeout.
+	requestCtx, cancelRequests := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRequests()
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return requestCtx },
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	log.Printf("listening on %s", addr)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		cancelRequests()
+		srv.Close()
+		return fmt.Errorf("drain requests: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCreateRejectsOversizedBodies(t *testing.T) {
+	handler := NewBookHandler(NewBookUseCase(NewMemoryBookRepository()))
+	body := `{"id":1,"name":"Dune","author":"` + strings.Repeat("a", maxBookBodyBytes) + `"}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(body)))
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != problemContentType {
+		t.Errorf("Content-Type %q, want %q", ct, problemContentType)
+	}
+	var p Problem
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != "body_too_large" || p.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("problem %+v, want body_too_large", p)
+	}
+}
+
+func TestBookIdsRoundTripFromEitherEncoding(t *testing.T) {
+	// Above 2^53, where a JavaScript number would round it to ...424000.
+	const id = 7311112873063424001
+	handler := ValidateRequests(NewBookHandler(NewBookUseCase(NewMemoryBookRepository())))
+	for _, body := range []string{
+		`{"id":"7311112873063424001","name":"Dune","author":"Frank Herbert"}`,
+		`{"id":42,"name":"Emma","author":"Jane Austen"}`,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(body)))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create %s: status %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+
+	for path, want := range map[string]int{"/books/7311112873063424001": id, "/books/42": 42} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("get %s: status %d: %s", path, rec.Code, rec.Body)
+		}
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &raw); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(raw["id"]); got != strconv.Itoa(want) {
+			t.Errorf("get %s: id %s, want the number %d", path, got, want)
+		}
+		var book Book
+		if err := json.Unmarshal(rec.Body.Bytes(), &book); err != nil || book.Id != want {
+			t.Errorf("get %s: decoded book %+v, %v, want id %d", path, book, err, want)
+		}
+	}
+}
+
+func TestCreateRejectsUnknownFields(t *testing.T) {
+	handler := NewBookHandler(NewBookUseCase(NewMemoryBookRepository()))
+	body := `{"id":"1","name":"Dune","author":"Frank Herbert","pages":412}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(body)))
+	wantProblem(t, rec, http.StatusBadRequest, "invalid_body")
+}
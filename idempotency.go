@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// idempotencyKeyAttribute is the partition key of the idempotency table.
+// Records expire through TTL on ttlAttribute.
+const idempotencyKeyAttribute = "key"
+
+const (
+	// defaultIdempotencyRetention is how long an idempotency key is
+	// remembered after the create it guarded.
+	defaultIdempotencyRetention = 24 * time.Hour
+	// maxIdempotencyKeyLength bounds client-supplied keys.
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotentCreator creates books guarded by a client-supplied idempotency
+// key. Retrying a create with the same key returns the book stored by the
+// first attempt instead of failing or creating it twice.
+type IdempotentCreator interface {
+	// CreateIdempotent creates book unless key was already used. It reports
+	// whether the call replayed an earlier create, in which case the
+	// returned book is the stored one.
+	CreateIdempotent(ctx context.Context, book *Book, key string) (*Book, bool, error)
+}
+
+// idempotencyRecord is the companion item remembering which book an
+// idempotency key created.
+type idempotencyRecord struct {
+	Key       string    `dynamodbav:"key"`
+	BookId    int       `dynamodbav:"bookId"`
+	ExpiresAt time.Time `dynamodbav:"expiresAt,unixtime"`
+}
+
+// idempotencyTableName returns the name of the table holding the
+// idempotency keys of bookTable.
+func idempotencyTableName(bookTable string) string {
+	return bookTable + "-idempotency"
+}
+
+// WithIdempotencyRetention sets how long idempotency keys are remembered.
+// Retries arriving later create the book anew, or fail with
+// ErrBookAlreadyExists if it still exists.
+func WithIdempotencyRetention(d time.Duration) RepositoryOption {
+	return func(r *DynamoDbBookRepository) {
+		r.idempotencyRetention = d
+	}
+}
+
+// CreateIdempotent implements IdempotentCreator. The book and its
+// idempotency record, and the claim of its ISBN if it has one, are written in
+// one transaction, so a key can never be recorded without its book or the
+// other way round. Records past their expiry are treated as absent, since TTL
+// deletion can lag by days.
+func (d *DynamoDbBookRepository) CreateIdempotent(ctx context.Context, book *Book, key string) (*Book, bool, error) {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return nil, false, fmt.Errorf("%w: idempotency key must be 1 to %d bytes", ErrValidation, maxIdempotencyKeyLength)
+	}
+	book.Version = 1
+	av, err := d.codec.marshal(book)
+	if err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	record, err := attributevalue.MarshalMap(idempotencyRecord{
+		Key:       key,
+		BookId:    book.Id,
+		ExpiresAt: now.Add(d.idempotencyRetention),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	bookLabel, recordLabel := fmt.Sprintf("put book %d", book.Id), "record idempotency key"
+	err = d.transactClaimingISBN(ctx, book, []types.TransactWriteItem{
+		{Put: &types.Put{
+			TableName:                aws.String(d.tableName),
+			Item:                     av,
+			ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+			ExpressionAttributeNames: map[string]string{"#id": idAttribute},
+		}},
+		{Put: &types.Put{
+			TableName:           aws.String(idempotencyTableName(d.tableName)),
+			Item:                record,
+			ConditionExpression: aws.String("attribute_not_exists(#key) OR #expiresAt < :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#key":       idempotencyKeyAttribute,
+				"#expiresAt": ttlAttribute,
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			},
+		}},
+	}, []string{bookLabel, recordLabel})
+	var txErr *TransactionCanceledError
+	switch {
+	case !errors.As(err, &txErr):
+		if err != nil {
+			return nil, false, err
+		}
+		return book, false, nil
+	case txErr.rejected(recordLabel):
+		return d.replay(ctx, book.Id, key)
+	case txErr.rejected(bookLabel):
+		return nil, false, ErrBookAlreadyExists
+	}
+	return nil, false, err
+}
+
+// replay returns the book created earlier under key, provided key was used
+// for the book with id wantId, or for any book if the id of the create was
+// generated.
+func (d *DynamoDbBookRepository) replay(ctx context.Context, wantId int, key string) (*Book, bool, error) {
+	result, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(idempotencyTableName(d.tableName)),
+		Key: map[string]types.AttributeValue{
+			idempotencyKeyAttribute: &types.AttributeValueMemberS{Value: key},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, false, translateError(err)
+	}
+	if result.Item == nil {
+		// The record expired and was deleted since the transaction ran;
+		// the caller may simply retry.
+		return nil, false, ErrConflict
+	}
+	record := new(idempotencyRecord)
+	if err := attributevalue.UnmarshalMap(result.Item, record); err != nil {
+		return nil, false, err
+	}
+	if record.BookId != wantId && !idGenerated(ctx) {
+		return nil, false, ErrIdempotencyKeyReused
+	}
+	book, err := d.GetById(withReadOptions(ctx, []ReadOption{WithConsistentRead()}), record.BookId)
+	if err != nil {
+		return nil, false, err
+	}
+	return book, true, nil
+}
+
+// idempotencyTableDefinition describes the idempotency table of bookTable: a
+// string key partition key and nothing else.
+func idempotencyTableDefinition(bookTable string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(idempotencyTableName(bookTable)),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(idempotencyKeyAttribute), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(idempotencyKeyAttribute), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
+
+// MigrateIdempotency creates the idempotency table of bookTable if needed
+// and enables TTL on it so old records are cleaned up. It is safe to run
+// repeatedly.
+func MigrateIdempotency(ctx context.Context, client *dynamodb.Client, bookTable string) error {
+	return migrateTable(ctx, client, idempotencyTableDefinition(bookTable))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IDGenerator assigns ids to books created without one. Ids must be unique
+// across every process writing to the same table.
+type IDGenerator interface {
+	NextID() (int, error)
+}
+
+// WithIDGenerator makes creates of books without an id, i.e. with Id 0,
+// take their id from g. Without it such creates fail validation.
+func WithIDGenerator(g IDGenerator) BookUseCaseOption {
+	return func(uc *BookUseCase) {
+		uc.ids = g
+	}
+}
+
+// Layout of snowflake ids, from the most significant bit: a zero sign bit,
+// the milliseconds since snowflakeEpoch, the node and a sequence number
+// within the millisecond.
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	snowflakeTimeBits     = 63 - snowflakeNodeBits - snowflakeSequenceBits
+
+	maxSnowflakeNode     = 1<<snowflakeNodeBits - 1
+	maxSnowflakeSequence = 1<<snowflakeSequenceBits - 1
+)
+
+// snowflakeEpoch is the time of snowflake id 0; the 41 bits of milliseconds
+// last until 2093.
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeGenerator generates snowflake ids: positive 63-bit integers that
+// sort by creation time, so they fit the numeric keys of existing tables
+// and the bigint ids of PostgreSQL. Each node generates up to 4096 ids per
+// millisecond; faster callers wait for the next one.
+type SnowflakeGenerator struct {
+	node int64
+
+	mu       sync.Mutex
+	last     int64 // milliseconds since snowflakeEpoch of the last id
+	sequence int64
+}
+
+// NewSnowflakeGenerator returns a generator of the ids of node, which must
+// be unique among the processes generating ids, from 0 to 1023.
+func NewSnowflakeGenerator(node int) (*SnowflakeGenerator, error) {
+	if node < 0 || node > maxSnowflakeNode {
+		return nil, fmt.Errorf("snowflake node %d must be between 0 and %d", node, maxSnowflakeNode)
+	}
+	return &SnowflakeGenerator{node: int64(node)}, nil
+}
+
+// NextID implements IDGenerator. It fails if the clock is set back before
+// the last id, rather than risk repeating ids.
+func (g *SnowflakeGenerator) NextID() (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Since(snowflakeEpoch).Milliseconds()
+	switch {
+	case now < g.last:
+		return 0, fmt.Errorf("generate id: clock moved back %s", time.Duration(g.last-now)*time.Millisecond)
+	case now == g.last:
+		g.sequence = (g.sequence + 1) & maxSnowflakeSequence
+		if g.sequence == 0 {
+			for now <= g.last {
+				time.Sleep(time.Until(snowflakeEpoch.Add(time.Duration(g.last+1) * time.Millisecond)))
+				now = time.Since(snowflakeEpoch).Milliseconds()
+			}
+		}
+	default:
+		g.sequence = 0
+	}
+	if now >= 1<<snowflakeTimeBits {
+		return 0, fmt.Errorf("generate id: snowflake ids ran out in %d", snowflakeEpoch.Add(time.Duration(now)*time.Millisecond).Year())
+	}
+	g.last = now
+	return int(now<<(snowflakeNodeBits+snowflakeSequenceBits) | g.node<<snowflakeSequenceBits | g.sequence), nil
+}
+
+// generatedIDKey marks the context of a create whose id was generated.
+type generatedIDKey struct{}
+
+// withGeneratedID marks ctx as the context of a create whose id was
+// generated, so that a retry with the same idempotency key, which gets
+// another id, replays the first create instead of failing.
+func withGeneratedID(ctx context.Context) context.Context {
+	return context.WithValue(ctx, generatedIDKey{}, true)
+}
+
+// idGenerated reports whether ctx is marked by withGeneratedID.
+func idGenerated(ctx context.Context) bool {
+	generated, _ := ctx.Value(generatedIDKey{}).(bool)
+	return generated
+}
@@ -0,0 +1,135 @@
+//go:build integration
+
+package main
+
+// The integration tests run DynamoDbBookRepository against DynamoDB Local,
+// started in a container through testcontainers-go, which needs Docker:
+//
+//	go test -tags integration -run Integration .
+//
+// DYNAMODB_ENDPOINT overrides the container with a DynamoDB API already
+// running, e.g. DynamoDB Local in CI services or a test account:
+//
+//	DYNAMODB_ENDPOINT=http://localhost:8000 go test -tags integration -run Integration .
+//
+// Each test creates its own tables with Migrate and deletes them when it is
+// done.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// integrationTables numbers the tables created by integrationRepository.
+var integrationTables atomic.Int64
+
+// dynamoDBLocalImage is the DynamoDB Local image the tests start.
+const dynamoDBLocalImage = "amazon/dynamodb-local:2.5.2"
+
+// dynamoDBLocal is the DynamoDB Local container shared by the tests, started
+// by the first one that needs it and stopped by TestMain.
+var dynamoDBLocal struct {
+	once      sync.Once
+	container testcontainers.Container
+	endpoint  string
+	err       error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if c := dynamoDBLocal.container; c != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := c.Terminate(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "stop %s: %v\n", dynamoDBLocalImage, err)
+		}
+		cancel()
+	}
+	os.Exit(code)
+}
+
+// integrationEndpoint returns DYNAMODB_ENDPOINT if it is set, and the
+// endpoint of the DynamoDB Local container otherwise, starting it if
+// needed. It fails t if the container cannot be started.
+func integrationEndpoint(t *testing.T) string {
+	t.Helper()
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	dynamoDBLocal.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		dynamoDBLocal.container, dynamoDBLocal.endpoint, dynamoDBLocal.err = startDynamoDBLocal(ctx)
+	})
+	if dynamoDBLocal.err != nil {
+		t.Fatalf("start %s: %v; set DYNAMODB_ENDPOINT to use a running DynamoDB instead", dynamoDBLocalImage, dynamoDBLocal.err)
+	}
+	return dynamoDBLocal.endpoint
+}
+
+// startDynamoDBLocal starts a DynamoDB Local container keeping its tables
+// in memory and returns it with the URL of its API.
+func startDynamoDBLocal(ctx context.Context) (testcontainers.Container, string, error) {
+	c, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        dynamoDBLocalImage,
+			Cmd:          []string{"-jar", "DynamoDBLocal.jar", "-inMemory", "-sharedDb"},
+			ExposedPorts: []string{"8000/tcp"},
+			WaitingFor:   wait.ForListeningPort("8000/tcp"),
+		},
+		Started: true,
+	})
+	if err != nil {
+		if c != nil {
+			c.Terminate(context.Background())
+		}
+		return nil, "", err
+	}
+	endpoint, err := c.PortEndpoint(ctx, "8000/tcp", "http")
+	if err != nil {
+		c.Terminate(context.Background())
+		return nil, "", err
+	}
+	return c, endpoint, nil
+}
+
+// integrationRepository returns a repository of a new, empty book table of
+// the DynamoDB at endpoint, with its ISBN table, both deleted when t ends.
+// It reports failures with t.Errorf rather than t.Fatal, as it is called
+// from the subtests of testBookRepository.
+func integrationRepository(t *testing.T, endpoint string, opts ...RepositoryOption) *DynamoDbBookRepository {
+	t.Helper()
+	table := fmt.Sprintf("books-it-%d-%d", time.Now().UnixNano(), integrationTables.Add(1))
+	opts = append([]RepositoryOption{WithEndpoint(endpoint)}, opts...)
+	repo := NewDynamoDBBookRepository(aws.Config{Region: "us-east-1"}, table, opts...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	t.Cleanup(func() {
+		for _, name := range []string{table, isbnTableName(table)} {
+			if _, err := repo.client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(name)}); err != nil {
+				t.Logf("delete table %s: %v", name, err)
+			}
+		}
+	})
+	if err := Migrate(ctx, repo.client, table); err != nil {
+		t.Errorf("migrate %s: %v", table, err)
+	} else if err := MigrateISBN(ctx, repo.client, table); err != nil {
+		t.Errorf("migrate %s: %v", isbnTableName(table), err)
+	}
+	return repo
+}
+
+func TestIntegrationBookRepositoryContract(t *testing.T) {
+	endpoint := integrationEndpoint(t)
+	testBookRepository(t, func() BookRepository { return integrationRepository(t, endpoint) })
+}
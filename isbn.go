@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attribute names of the ISBN table, which is keyed by ISBN. Each item
+// claims an ISBN for the book with id isbnBookIdAttribute.
+const (
+	isbnAttribute       = "isbn"
+	isbnBookIdAttribute = "bookId"
+)
+
+// maxISBNClaimAttempts bounds how often a write retries claiming an ISBN
+// whose claim changed hands while it was being taken over.
+const maxISBNClaimAttempts = 3
+
+// canonicalISBN returns s, an ISBN-10 or ISBN-13 with optional hyphens or
+// spaces, as the 13 digits of its ISBN-13, and whether s is a valid ISBN.
+// An ISBN-10 and the ISBN-13 it was converted to name the same book, so
+// they are stored alike.
+func canonicalISBN(s string) (string, bool) {
+	s = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+	switch len(s) {
+	case 10:
+		sum := 0
+		for i, c := range s {
+			d := int(c - '0')
+			switch {
+			case i == 9 && c == 'X':
+				d = 10
+			case c < '0' || c > '9':
+				return "", false
+			}
+			sum += (10 - i) * d
+		}
+		if sum%11 != 0 {
+			return "", false
+		}
+		isbn := "978" + s[:9]
+		return isbn + isbn13CheckDigit(isbn), true
+	case 13:
+		for _, c := range s {
+			if c < '0' || c > '9' {
+				return "", false
+			}
+		}
+		if !strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979") || isbn13CheckDigit(s[:12]) != s[12:] {
+			return "", false
+		}
+		return s, true
+	}
+	return "", false
+}
+
+// isbn13CheckDigit returns the check digit of the first 12 digits of an
+// ISBN-13.
+func isbn13CheckDigit(digits string) string {
+	sum := 0
+	for i, c := range digits[:12] {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(c-'0')
+	}
+	return strconv.Itoa((10 - sum%10) % 10)
+}
+
+// normalizeISBN parses the ISBN a client gave in a lookup.
+func normalizeISBN(s string) (string, error) {
+	isbn, ok := canonicalISBN(s)
+	if !ok {
+		return "", fmt.Errorf("%w: %q is not a valid ISBN-10 or ISBN-13", ErrValidation, s)
+	}
+	return isbn, nil
+}
+
+// rejectISBN returns a FieldErrors if book has an ISBN, for repositories
+// without ISBN claims, named by mode: they cannot keep ISBNs unique, so
+// they store no books with one.
+func rejectISBN(book *Book, mode string) error {
+	if book.ISBN == "" {
+		return nil
+	}
+	return FieldErrors{"isbn": "cannot be stored in " + mode + ", which does not keep ISBNs unique"}
+}
+
+// isbnTableName returns the name of the table holding the ISBN claims of
+// the books in bookTable.
+func isbnTableName(bookTable string) string {
+	return bookTable + "-isbn"
+}
+
+// isbnClaim is the companion item reserving an ISBN for one book. It is
+// written in the same transaction as the book, so no two books can be
+// stored with the same ISBN.
+//
+// Claims are not released when their book is deleted or given another
+// ISBN. A claim whose book no longer carries its ISBN is stale: the next
+// book written with the ISBN takes it over, and GetByISBN ignores it.
+type isbnClaim struct {
+	ISBN   string `dynamodbav:"isbn"`
+	BookId int    `dynamodbav:"bookId"`
+}
+
+// isbnTableDefinition describes the ISBN table of bookTable: a string isbn
+// partition key and nothing else.
+func isbnTableDefinition(bookTable string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(isbnTableName(bookTable)),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(isbnAttribute), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(isbnAttribute), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
+
+// MigrateISBN creates the ISBN table of bookTable if needed. It is safe to
+// run repeatedly.
+func MigrateISBN(ctx context.Context, client *dynamodb.Client, bookTable string) error {
+	return migrateTable(ctx, client, isbnTableDefinition(bookTable))
+}
+
+// GetByISBN implements BookRepository by reading the claim of isbn, then
+// the book holding it. It returns ErrNotFound if no book has isbn, which
+// must be canonical (see canonicalISBN).
+func (d *DynamoDbBookRepository) GetByISBN(ctx context.Context, isbn string) (*Book, error) {
+	claim, err := d.isbnClaim(ctx, isbn, consistentRead(ctx, d.consistentReads))
+	if err != nil {
+		return nil, err
+	}
+	if claim == nil {
+		return nil, ErrNotFound
+	}
+	book, err := d.GetById(withRequiredFields(ctx, isbnAttribute), claim.BookId)
+	if err != nil {
+		return nil, err
+	}
+	if book.ISBN != isbn {
+		return nil, ErrNotFound
+	}
+	return book, nil
+}
+
+// isbnClaim reads the claim of isbn, nil if there is none.
+func (d *DynamoDbBookRepository) isbnClaim(ctx context.Context, isbn string, consistent *bool) (*isbnClaim, error) {
+	result, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(isbnTableName(d.tableName)),
+		Key: map[string]types.AttributeValue{
+			isbnAttribute: &types.AttributeValueMemberS{Value: isbn},
+		},
+		ConsistentRead: consistent,
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	claim := new(isbnClaim)
+	if err := attributevalue.UnmarshalMap(result.Item, claim); err != nil {
+		return nil, err
+	}
+	return claim, nil
+}
+
+// putBook writes book with put, claiming its ISBN if it has one. It returns
+// conflict if put fails its condition and ErrISBNTaken if another book
+// holds the ISBN.
+func (d *DynamoDbBookRepository) putBook(ctx context.Context, book *Book, put *types.Put, conflict error) error {
+	label := fmt.Sprintf("put book %d", book.Id)
+	err := d.transactClaimingISBN(ctx, book, []types.TransactWriteItem{{Put: put}}, []string{label})
+	var txErr *TransactionCanceledError
+	if errors.As(err, &txErr) && txErr.rejected(label) {
+		return conflict
+	}
+	return err
+}
+
+// transactClaimingISBN runs the transaction of items, which writes book,
+// together with the claim of book's ISBN; without one, items run alone. If
+// a stale claim holds the ISBN, the transaction takes it over, checking in
+// the same transaction that its book still does not carry the ISBN. It
+// returns ErrISBNTaken if another book holds the ISBN, and otherwise the
+// error of d.transact.
+func (d *DynamoDbBookRepository) transactClaimingISBN(ctx context.Context, book *Book, items []types.TransactWriteItem, labels []string) error {
+	if book.ISBN == "" {
+		return d.transact(ctx, items, labels)
+	}
+	claimLabel, holderLabel := "claim isbn "+book.ISBN, "check holder of isbn "+book.ISBN
+	holder := 0
+	for attempt := 1; ; attempt++ {
+		claimItems, claimLabels := d.isbnClaimItems(book, holder)
+		err := d.transact(ctx, append(items[:len(items):len(items)], claimItems...), append(append([]string(nil), labels...), claimLabels...))
+		var txErr *TransactionCanceledError
+		if !errors.As(err, &txErr) {
+			return err
+		}
+		switch {
+		case slices.ContainsFunc(labels, txErr.rejected):
+			// The caller's items failed, whoever holds the ISBN.
+			return err
+		case txErr.rejected(holderLabel):
+			return ErrISBNTaken
+		case !txErr.rejected(claimLabel):
+			return err
+		case attempt == maxISBNClaimAttempts:
+			return fmt.Errorf("claim isbn %s: %w", book.ISBN, ErrConflict)
+		}
+		claim, err := d.isbnClaim(ctx, book.ISBN, aws.Bool(true))
+		if err != nil {
+			return err
+		}
+		holder = 0
+		if claim != nil && claim.BookId != book.Id {
+			holder = claim.BookId
+		}
+	}
+}
+
+// isbnClaimItems returns the transaction items claiming book's ISBN and
+// their labels. With holder 0 the claim must be absent or already book's;
+// otherwise it must belong to holder, whose book must not carry the ISBN.
+func (d *DynamoDbBookRepository) isbnClaimItems(book *Book, holder int) ([]types.TransactWriteItem, []string) {
+	claim := &types.Put{
+		TableName: aws.String(isbnTableName(d.tableName)),
+		Item: map[string]types.AttributeValue{
+			isbnAttribute:       &types.AttributeValueMemberS{Value: book.ISBN},
+			isbnBookIdAttribute: &types.AttributeValueMemberN{Value: strconv.Itoa(book.Id)},
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(#isbn) OR #bookId = :id"),
+		ExpressionAttributeNames: map[string]string{"#isbn": isbnAttribute, "#bookId": isbnBookIdAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberN{Value: strconv.Itoa(book.Id)},
+		},
+	}
+	items := []types.TransactWriteItem{{Put: claim}}
+	labels := []string{"claim isbn " + book.ISBN}
+	if holder == 0 {
+		return items, labels
+	}
+	claim.ConditionExpression = aws.String("#bookId = :holder")
+	claim.ExpressionAttributeNames = map[string]string{"#bookId": isbnBookIdAttribute}
+	claim.ExpressionAttributeValues = map[string]types.AttributeValue{
+		":holder": &types.AttributeValueMemberN{Value: strconv.Itoa(holder)},
+	}
+	items = append(items, types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+		TableName:                aws.String(d.tableName),
+		Key:                      d.key.MarshalKey(holder),
+		ConditionExpression:      aws.String("attribute_not_exists(#isbn) OR #isbn <> :isbn"),
+		ExpressionAttributeNames: map[string]string{"#isbn": isbnAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":isbn": &types.AttributeValueMemberS{Value: book.ISBN},
+		},
+	}})
+	return items, append(labels, "check holder of isbn "+book.ISBN)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// itemVersionAttribute holds the version of the shape a book item was
+// written in. Items written before it was introduced have none, which
+// stands for version 0.
+const itemVersionAttribute = "itemVersion"
+
+// ItemMigration upgrades a stored book item from the shape of one item
+// version to that of the next.
+type ItemMigration struct {
+	// Description says what the migration changes.
+	Description string
+	// Upgrade changes item in place. Partial updates, such as AddTag, do not
+	// bump the item version, so Upgrade must leave attributes that are
+	// already in the new shape alone.
+	Upgrade func(item map[string]types.AttributeValue) error
+}
+
+// bookItemMigrations is the registry of item migrations: the one at index i
+// upgrades items of version i to version i+1. Append a migration whenever
+// the shape of book items changes; never reorder or remove one, as stored
+// items refer to them by position.
+var bookItemMigrations = []ItemMigration{
+	{Description: "store tags written as a list as a string set, so that AddTag and RemoveTag work on them", Upgrade: tagsToStringSet},
+	{Description: "derive a missing year from publishedAt", Upgrade: yearFromPublishedAt},
+	{Description: "place the book in the sort indexes, with an unknown creation time", Upgrade: addSortKeys},
+}
+
+// currentItemVersion is the item version books are written in.
+var currentItemVersion = len(bookItemMigrations)
+
+// WithUpgradeOnRead makes GetById write the books it reads in an older
+// item shape back in the current one, best effort. Reads of only some
+// fields are not written back. Items are upgraded on read either way; this
+// only saves upgrading them again.
+func WithUpgradeOnRead() RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.upgradeOnRead = true
+	}
+}
+
+// itemVersion returns the item version of item.
+func itemVersion(item map[string]types.AttributeValue) (int, error) {
+	av, ok := item[itemVersionAttribute]
+	if !ok {
+		return 0, nil
+	}
+	n, ok := av.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, fmt.Errorf("attribute %s is not a number", itemVersionAttribute)
+	}
+	return strconv.Atoi(n.Value)
+}
+
+// upgradeItem returns item in the current shape and whether that took any
+// migration. Items of a newer version, written by a newer release, are
+// returned as they are. The item is not modified.
+func upgradeItem(item map[string]types.AttributeValue) (map[string]types.AttributeValue, bool, error) {
+	version, err := itemVersion(item)
+	if err != nil {
+		return nil, false, err
+	}
+	if version >= currentItemVersion {
+		return item, false, nil
+	}
+	upgraded := maps.Clone(item)
+	for i, m := range bookItemMigrations[version:] {
+		if err := m.Upgrade(upgraded); err != nil {
+			return nil, false, fmt.Errorf("upgrade item to version %d: %w", version+i+1, err)
+		}
+	}
+	upgraded[itemVersionAttribute] = &types.AttributeValueMemberN{Value: strconv.Itoa(currentItemVersion)}
+	return upgraded, true, nil
+}
+
+// tagsToStringSet converts tags stored as a list of strings, as written by
+// early importers, into a string set. An empty list is removed, like an
+// empty set is never written.
+func tagsToStringSet(item map[string]types.AttributeValue) error {
+	list, ok := item[tagsAttribute].(*types.AttributeValueMemberL)
+	if !ok {
+		return nil
+	}
+	seen := map[string]bool{}
+	var tags []string
+	for _, av := range list.Value {
+		s, ok := av.(*types.AttributeValueMemberS)
+		if !ok {
+			return fmt.Errorf("attribute %s holds a %T", tagsAttribute, av)
+		}
+		if !seen[s.Value] {
+			seen[s.Value] = true
+			tags = append(tags, s.Value)
+		}
+	}
+	if len(tags) == 0 {
+		delete(item, tagsAttribute)
+		return nil
+	}
+	item[tagsAttribute] = &types.AttributeValueMemberSS{Value: tags}
+	return nil
+}
+
+// yearFromPublishedAt sets year from publishedAt, stored in RFC 3339 or as
+// Unix seconds, on items that have a publication date but no year.
+func yearFromPublishedAt(item map[string]types.AttributeValue) error {
+	if _, ok := item[yearAttribute]; ok {
+		return nil
+	}
+	var published time.Time
+	switch av := item[publishedAtAttribute].(type) {
+	case *types.AttributeValueMemberS:
+		t, err := time.Parse(time.RFC3339Nano, av.Value)
+		if err != nil {
+			return nil // a custom time layout; leave the year unknown
+		}
+		published = t
+	case *types.AttributeValueMemberN:
+		secs, err := strconv.ParseInt(av.Value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("attribute %s: %w", publishedAtAttribute, err)
+		}
+		published = time.Unix(secs, 0).UTC()
+	default:
+		return nil
+	}
+	if published.IsZero() || published.Year() <= 0 {
+		return nil
+	}
+	item[yearAttribute] = &types.AttributeValueMemberN{Value: strconv.Itoa(published.Year())}
+	return nil
+}
+
+// addSortKeys adds the attributes the sort indexes are keyed on to items
+// written before the indexes existed. Their creation time is unknown and
+// stored as 0, so they sort before every book created since.
+func addSortKeys(item map[string]types.AttributeValue) error {
+	if _, ok := item[listingAttribute]; !ok {
+		item[listingAttribute] = &types.AttributeValueMemberS{Value: listingPartition}
+	}
+	if _, ok := item[createdAtAttribute]; !ok {
+		item[createdAtAttribute] = &types.AttributeValueMemberN{Value: createdAtKey(time.Time{})}
+	}
+	return nil
+}
+
+// writeUpgraded stores upgraded, the upgrade of original, by changing only
+// the attributes the migrations changed. It fails with an error matching
+// ErrConflict if the book was deleted, or the version of the book or any of
+// those attributes changed, since original was read.
+func (d *DynamoDbBookRepository) writeUpgraded(ctx context.Context, original, upgraded map[string]types.AttributeValue) error {
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+	var set, remove, conditions []string
+	placeholder := func(attr string) string {
+		p := fmt.Sprintf("#a%d", len(names))
+		names[p] = attr
+		return p
+	}
+	value := func(av types.AttributeValue) string {
+		p := fmt.Sprintf(":v%d", len(values))
+		values[p] = av
+		return p
+	}
+	// guard makes the write conditional on attr still having its value in
+	// original.
+	guard := func(p, attr string) {
+		if old, ok := original[attr]; ok {
+			conditions = append(conditions, p+" = "+value(old))
+		} else {
+			conditions = append(conditions, "attribute_not_exists("+p+")")
+		}
+	}
+
+	key := map[string]types.AttributeValue{}
+	for attr := range d.key.MarshalKey(0) {
+		key[attr] = original[attr]
+		conditions = append(conditions, "attribute_exists("+placeholder(attr)+")")
+	}
+	guard(placeholder(versionAttribute), versionAttribute)
+	for attr, av := range upgraded {
+		if old, ok := original[attr]; ok && reflect.DeepEqual(old, av) {
+			continue
+		}
+		p := placeholder(attr)
+		guard(p, attr)
+		set = append(set, p+" = "+value(av))
+	}
+	for attr := range original {
+		if _, ok := upgraded[attr]; !ok {
+			p := placeholder(attr)
+			guard(p, attr)
+			remove = append(remove, p)
+		}
+	}
+	update := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		update += " REMOVE " + strings.Join(remove, ", ")
+	}
+	_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(d.tableName),
+		Key:                       key,
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	return translateError(err)
+}
+
+// writeBackUpgraded writes item back in the current shape if it is older.
+// Failures are logged, as the read has succeeded anyway.
+func (d *DynamoDbBookRepository) writeBackUpgraded(ctx context.Context, item map[string]types.AttributeValue) {
+	upgraded, changed, err := upgradeItem(item)
+	if err != nil || !changed {
+		return // unmarshal reports the error
+	}
+	err = d.writeUpgraded(ctx, item, upgraded)
+	if err != nil && !isConflict(err) {
+		slog.WarnContext(ctx, "write back upgraded book", "table", d.tableName, "error", err)
+	}
+}
+
+// ItemUpgradeStats is the outcome of UpgradeItems.
+type ItemUpgradeStats struct {
+	// Scanned is the number of items read and Upgraded the number written
+	// in the current shape.
+	Scanned  int
+	Upgraded int
+	// Changed is the number of items that changed while being upgraded
+	// and were left for a later run.
+	Changed int
+}
+
+// UpgradeItems scans the table and writes every item of an older item
+// version in the current shape, at most ratePerSecond items per second, or
+// as fast as DynamoDB allows for a rate of zero. Items written since the
+// scan read them are counted as Changed; they are either already current or
+// upgraded by running UpgradeItems again.
+func (d *DynamoDbBookRepository) UpgradeItems(ctx context.Context, ratePerSecond float64) (ItemUpgradeStats, error) {
+	var stats ItemUpgradeStats
+	writes := newTokenBucket(ratePerSecond, time.Second, false)
+	paginator := dynamodb.NewScanPaginator(d.client, &dynamodb.ScanInput{
+		TableName:      aws.String(d.tableName),
+		ConsistentRead: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return stats, translateError(err)
+		}
+		for _, item := range page.Items {
+			stats.Scanned++
+			upgraded, changed, err := upgradeItem(item)
+			if err != nil {
+				return stats, fmt.Errorf("item %d: %w", stats.Scanned, err)
+			}
+			if !changed {
+				continue
+			}
+			if err := writes.take(ctx, 1); err != nil {
+				return stats, err
+			}
+			err = d.writeUpgraded(ctx, item, upgraded)
+			switch {
+			case err == nil:
+				stats.Upgraded++
+			case errors.Is(err, ErrConflict):
+				stats.Changed++
+			default:
+				return stats, err
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// KeyCodec converts between a key value of type K and the primary key
+// attributes of an item. Building keys only through a codec keeps their
+// encoding in one place.
+type KeyCodec[K any] interface {
+	// MarshalKey returns the key attributes identifying key.
+	MarshalKey(key K) map[string]types.AttributeValue
+	// UnmarshalKey extracts the key from an item or key map. It fails if
+	// an attribute is missing or has the wrong type.
+	UnmarshalKey(item map[string]types.AttributeValue) (K, error)
+}
+
+// NumberKey encodes an int as a decimal number attribute with the given name.
+type NumberKey string
+
+// MarshalKey implements KeyCodec.
+func (k NumberKey) MarshalKey(n int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		string(k): &types.AttributeValueMemberN{Value: strconv.Itoa(n)},
+	}
+}
+
+// UnmarshalKey implements KeyCodec.
+func (k NumberKey) UnmarshalKey(item map[string]types.AttributeValue) (int, error) {
+	av, ok := item[string(k)].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, fmt.Errorf("key attribute %s: missing or not a number", string(k))
+	}
+	n, err := strconv.Atoi(av.Value)
+	if err != nil {
+		return 0, fmt.Errorf("key attribute %s: %w", string(k), err)
+	}
+	return n, nil
+}
+
+// StringKey encodes a string as a string attribute with the given name.
+type StringKey string
+
+// MarshalKey implements KeyCodec.
+func (k StringKey) MarshalKey(s string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		string(k): &types.AttributeValueMemberS{Value: s},
+	}
+}
+
+// UnmarshalKey implements KeyCodec.
+func (k StringKey) UnmarshalKey(item map[string]types.AttributeValue) (string, error) {
+	av, ok := item[string(k)].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", fmt.Errorf("key attribute %s: missing or not a string", string(k))
+	}
+	return av.Value, nil
+}
+
+// CompositeKey is the value of a partition and sort key pair.
+type CompositeKey[P, S any] struct {
+	Partition P
+	Sort      S
+}
+
+// compositeKeyCodec combines the codecs of a partition and a sort key.
+type compositeKeyCodec[P, S any] struct {
+	partition KeyCodec[P]
+	sort      KeyCodec[S]
+}
+
+// NewCompositeKeyCodec returns a codec for keys made of a partition key
+// encoded by partition and a sort key encoded by sort.
+func NewCompositeKeyCodec[P, S any](partition KeyCodec[P], sort KeyCodec[S]) KeyCodec[CompositeKey[P, S]] {
+	return compositeKeyCodec[P, S]{partition: partition, sort: sort}
+}
+
+// MarshalKey implements KeyCodec.
+func (c compositeKeyCodec[P, S]) MarshalKey(key CompositeKey[P, S]) map[string]types.AttributeValue {
+	item := c.partition.MarshalKey(key.Partition)
+	for name, av := range c.sort.MarshalKey(key.Sort) {
+		item[name] = av
+	}
+	return item
+}
+
+// UnmarshalKey implements KeyCodec.
+func (c compositeKeyCodec[P, S]) UnmarshalKey(item map[string]types.AttributeValue) (CompositeKey[P, S], error) {
+	var key CompositeKey[P, S]
+	var err error
+	if key.Partition, err = c.partition.UnmarshalKey(item); err != nil {
+		return key, err
+	}
+	if key.Sort, err = c.sort.UnmarshalKey(item); err != nil {
+		return key, err
+	}
+	return key, nil
+}
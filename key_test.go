@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestNumberKeyRoundTrip(t *testing.T) {
+	codec := NumberKey("id")
+	for _, n := range []int{0, 1, 65, -7, 1 << 53, math.MaxInt64} {
+		key := codec.MarshalKey(n)
+		if len(key) != 1 {
+			t.Fatalf("MarshalKey(%d) = %v, want the id attribute only", n, key)
+		}
+		av, ok := key["id"].(*types.AttributeValueMemberN)
+		if !ok {
+			t.Fatalf("MarshalKey(%d) = %#v, want a number attribute", n, key["id"])
+		}
+		got, err := codec.UnmarshalKey(key)
+		if err != nil || got != n {
+			t.Errorf("round trip of %d via %q = %d, %v", n, av.Value, got, err)
+		}
+	}
+	// The decimal form, not the rune an int converts to as a string.
+	if got := codec.MarshalKey(65)["id"].(*types.AttributeValueMemberN).Value; got != "65" {
+		t.Errorf("MarshalKey(65) encodes %q, want \"65\"", got)
+	}
+}
+
+func TestStringKeyRoundTrip(t *testing.T) {
+	codec := StringKey("pk")
+	for _, s := range []string{"book#1", "", "Gödel, Escher, Bach"} {
+		got, err := codec.UnmarshalKey(codec.MarshalKey(s))
+		if err != nil || got != s {
+			t.Errorf("round trip of %q = %q, %v", s, got, err)
+		}
+	}
+}
+
+func TestCompositeKeyRoundTrip(t *testing.T) {
+	codec := NewCompositeKeyCodec[string, int](StringKey("pk"), NumberKey("sk"))
+	key := CompositeKey[string, int]{Partition: "tenant#acme", Sort: 42}
+	item := codec.MarshalKey(key)
+	if len(item) != 2 {
+		t.Fatalf("MarshalKey = %v, want the partition and sort attributes", item)
+	}
+	// Other attributes of an item are ignored.
+	item["name"] = &types.AttributeValueMemberS{Value: "Dune"}
+	got, err := codec.UnmarshalKey(item)
+	if err != nil || got != key {
+		t.Errorf("round trip of %+v = %+v, %v", key, got, err)
+	}
+}
+
+func TestUnmarshalKeyRejectsMalformedKeys(t *testing.T) {
+	composite := NewCompositeKeyCodec[string, int](StringKey("pk"), NumberKey("sk"))
+	for _, tc := range []struct {
+		name string
+		item map[string]types.AttributeValue
+		// unmarshal returns the error of decoding item.
+		unmarshal func(map[string]types.AttributeValue) error
+	}{
+		{"number missing", map[string]types.AttributeValue{}, numberKeyErr},
+		{"number as string", map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "1"}}, numberKeyErr},
+		{"fractional number", map[string]types.AttributeValue{"id": &types.AttributeValueMemberN{Value: "1.5"}}, numberKeyErr},
+		{"string as number", map[string]types.AttributeValue{"pk": &types.AttributeValueMemberN{Value: "1"}}, func(item map[string]types.AttributeValue) error {
+			_, err := StringKey("pk").UnmarshalKey(item)
+			return err
+		}},
+		{"sort key missing", map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: "a"}}, func(item map[string]types.AttributeValue) error {
+			_, err := composite.UnmarshalKey(item)
+			return err
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.unmarshal(tc.item); err == nil {
+				t.Errorf("UnmarshalKey(%v) succeeded, want an error", tc.item)
+			}
+		})
+	}
+}
+
+func numberKeyErr(item map[string]types.AttributeValue) error {
+	_, err := NumberKey("id").UnmarshalKey(item)
+	return err
+}
+
+func TestRepositoryKeysAreDecimalNumbers(t *testing.T) {
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		return map[string]any{}, nil
+	})
+	repo := stub.repository()
+	ctx := context.Background()
+	if _, err := repo.GetById(ctx, 65); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetById = %v, want ErrNotFound", err)
+	}
+	if err := repo.Delete(ctx, 65); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []string{"GetItem", "DeleteItem"} {
+		in := decodeInput(t, stub.callsTo(op)[0])
+		if n := in.Key["id"].N; n == nil || *n != "65" {
+			t.Errorf("%s key id %+v, want the number 65", op, in.Key["id"])
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"dynamoDBExample/config"
+)
+
+// lambdaRuntimeEnvVar is set by the Lambda runtime. When present, the binary
+// serves API Gateway events instead of parsing a command line, so the same
+// build can be deployed as a function (named bootstrap on provided.al2023) or
+// run as a long-lived server.
+const lambdaRuntimeEnvVar = "AWS_LAMBDA_RUNTIME_API"
+
+// inLambda reports whether the process was started by the Lambda runtime.
+func inLambda() bool {
+	return os.Getenv(lambdaRuntimeEnvVar) != ""
+}
+
+// lambdaHandlerEnvVar selects what a function deployment handles: "api" (the
+// default) for API Gateway proxy events, "streams" for the table's DynamoDB
+// stream or "outbox" for scheduled events relaying the events of the outbox.
+const lambdaHandlerEnvVar = "BOOK_LAMBDA_HANDLER"
+
+// runLambda serves API Gateway proxy events with the book HTTP handler, or
+// stream events with a StreamDispatcher. It is configured from the same
+// config file and environment variables as the CLI.
+func runLambda(ctx context.Context) error {
+	cfg, err := config.Load(os.Getenv(config.FileEnvVar))
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	g := globalOptions{
+		Config:  cfg,
+		keyMode: envOr("BOOK_KEY_MODE", string(KeyModeSimple)),
+	}
+	logger, err := NewLogger(os.Stderr, cfg.LogLevel, "json")
+	if err != nil {
+		return err
+	}
+	switch mode := envOr(lambdaHandlerEnvVar, "api"); mode {
+	case "api":
+	case "streams":
+		handlers := []ChangeHandler{ChangeLogger(logger)}
+		if cfg.SearchURL != "" && cfg.SearchIndexing == config.SearchIndexingStream {
+			handlers = append(handlers, NewOpenSearchIndex(cfg.SearchURL, cfg.SearchIndex))
+		}
+		if cfg.EventDelivery == config.EventDeliveryStream {
+			awsCfg, err := loadAWSConfig(ctx, g)
+			if err != nil {
+				return err
+			}
+			if p := newEventPublisher(awsCfg, cfg); p != nil {
+				handlers = append(handlers, EventPublisher(p))
+			}
+		}
+		if cfg.AuditLog == config.AuditLogStream {
+			awsCfg, err := loadAWSConfig(ctx, g)
+			if err != nil {
+				return err
+			}
+			handlers = append(handlers, NewAuditLog(NewDynamoDBBookRepository(awsCfg, cfg.Table)))
+		}
+		dispatcher := NewStreamDispatcher(handlers...)
+		lambda.StartWithOptions(dispatcher.HandleEvent, lambda.WithContext(ctx))
+		return nil
+	case "outbox":
+		a, err := newApp(ctx, g, logger)
+		if err != nil {
+			return err
+		}
+		defer a.close()
+		if a.relay == nil {
+			return fmt.Errorf("%s=outbox requires %s=%s and an event bus or topic", lambdaHandlerEnvVar, config.EventDeliveryEnvVar, config.EventDeliveryOutbox)
+		}
+		lambda.StartWithOptions(func(ctx context.Context) error {
+			_, err := a.relay.RelayOnce(ctx)
+			return err
+		}, lambda.WithContext(ctx))
+		return nil
+	default:
+		return fmt.Errorf("unknown %s %q", lambdaHandlerEnvVar, mode)
+	}
+
+	var opts []BookUseCaseOption
+	auth := newAuthenticator(g)
+	if auth != nil {
+		opts = append(opts, WithAccessPolicy(DefaultAccessPolicy))
+	}
+	a, err := newApp(ctx, g, logger, opts...)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	lambda.StartWithOptions(NewLambdaHandler(protect(auth, ValidateRequests(NewBookHandler(a.useCase)))), lambda.WithContext(ctx))
+	return nil
+}
+
+// NewLambdaHandler adapts h to API Gateway REST proxy integration events.
+func NewLambdaHandler(h http.Handler) func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		req, err := newRequestFromEvent(ctx, event)
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode:        rec.Code,
+			MultiValueHeaders: rec.Header(),
+			Body:              rec.Body.String(),
+		}, nil
+	}
+}
+
+// newRequestFromEvent builds the http.Request described by a proxy event.
+func newRequestFromEvent(ctx context.Context, event events.APIGatewayProxyRequest) (*http.Request, error) {
+	body := []byte(event.Body)
+	if event.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(event.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decode request body: %w", err)
+		}
+		body = decoded
+	}
+
+	query := url.Values{}
+	for name, values := range event.MultiValueQueryStringParameters {
+		query[name] = values
+	}
+	for name, value := range event.QueryStringParameters {
+		if _, ok := query[name]; !ok {
+			query.Set(name, value)
+		}
+	}
+	u := url.URL{Path: event.Path, RawQuery: query.Encode()}
+
+	req, err := http.NewRequestWithContext(ctx, event.HTTPMethod, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range event.MultiValueHeaders {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	for name, value := range event.Headers {
+		if req.Header.Get(name) == "" {
+			req.Header.Set(name, value)
+		}
+	}
+	req.RemoteAddr = event.RequestContext.Identity.SourceIP
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	return req, nil
+}
@@ -0,0 +1,159 @@
+// Package lifecycle runs the long-lived components of a process, such as
+// servers and background workers, and shuts them down in order when the
+// process is asked to stop or one of them fails.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SignalContext returns a context that is canceled on the first SIGINT or
+// SIGTERM, with the signal as its cause. The signal handler is removed at
+// that point, so a second signal kills the process without waiting for the
+// shutdown to complete.
+func SignalContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			cancel(fmt.Errorf("received %s", sig))
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// stage is a set of components stopped together.
+type stage int
+
+const (
+	servers stage = iota
+	workers
+	stages
+)
+
+type component struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// Manager runs components until its context is done or one of them fails,
+// then stops them in stages, all within the drain timeout: first the
+// servers, so that they stop accepting work and drain in-flight requests,
+// then the workers, which may still be doing work for those requests.
+// Resources the components share, such as telemetry exporters, are closed
+// by the caller once Run returns.
+type Manager struct {
+	logger *slog.Logger
+	drain  time.Duration
+	stages [stages][]component
+}
+
+func New(logger *slog.Logger, drainTimeout time.Duration) *Manager {
+	return &Manager{logger: logger, drain: drainTimeout}
+}
+
+// Serve adds a server. run must return once ctx is done and it has drained
+// its in-flight requests.
+func (m *Manager) Serve(name string, run func(ctx context.Context) error) {
+	m.stages[servers] = append(m.stages[servers], component{name, run})
+}
+
+// Work adds a background worker. Its ctx is canceled once every server has
+// returned.
+func (m *Manager) Work(name string, run func(ctx context.Context) error) {
+	m.stages[workers] = append(m.stages[workers], component{name, run})
+}
+
+// Run starts every component and blocks until they are stopped. Components
+// see the values of ctx but are only canceled in their stage of the
+// shutdown. It returns the errors of the components, and one for
+// each component that did not return within the drain timeout, which it
+// abandons.
+func (m *Manager) Run(ctx context.Context) error {
+	failed, fail := context.WithCancelCause(ctx)
+	defer fail(nil)
+
+	var mu sync.Mutex
+	var errs []error
+	var cancels [stages]context.CancelFunc
+	var running [stages]map[string]chan struct{}
+	for s, components := range m.stages {
+		stageCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		cancels[s] = cancel
+		running[s] = map[string]chan struct{}{}
+		for _, c := range components {
+			c := c
+			done := make(chan struct{})
+			running[s][c.name] = done
+			go func() {
+				defer close(done)
+				if err := c.run(stageCtx); err != nil {
+					err = fmt.Errorf("%s: %w", c.name, err)
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					fail(err)
+				}
+			}()
+		}
+	}
+
+	<-failed.Done()
+	m.logger.Info("shutting down", "cause", context.Cause(failed), "drain_timeout", m.drain)
+	deadline := time.Now().Add(m.drain)
+	drained := true
+	for s := range running {
+		cancels[s]()
+		if drained {
+			drained = m.wait(running[s], deadline, &mu, &errs)
+		}
+	}
+	// Abandoned components may still fail.
+	mu.Lock()
+	defer mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// wait waits until the components of running have returned or deadline has
+// passed, and reports whether they have all returned; if not, it records an
+// error naming the others.
+func (m *Manager) wait(running map[string]chan struct{}, deadline time.Time, mu *sync.Mutex, errs *[]error) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for name, done := range running {
+		select {
+		case <-done:
+			delete(running, name)
+		case <-timer.C:
+			var names []string
+			for name, done := range running {
+				select {
+				case <-done:
+				default:
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			err := fmt.Errorf("%s did not stop within %s", strings.Join(names, ", "), m.drain)
+			mu.Lock()
+			*errs = append(*errs, err)
+			mu.Unlock()
+			return false
+		}
+	}
+	return true
+}
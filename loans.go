@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The loans table, keyed by a string id, holds two kinds of items: the
+// availability of a book, under BOOK#<book id>, and loans, under
+// LOAN#<loan id>. The availability item counts the copies that can still
+// be checked out and lists the loans holding the others, so that taking or
+// putting back the copy of a loan can be repeated safely.
+const (
+	availableAttribute = "available"
+	loansAttribute     = "loans"
+
+	availabilityKeyPrefix = "BOOK#"
+	loanKeyPrefix         = "LOAN#"
+)
+
+// Loan is a copy of a book checked out by a borrower.
+type Loan struct {
+	Id        string    `json:"id" dynamodbav:"loanId"`
+	BookId    int       `json:"bookId" dynamodbav:"bookId"`
+	Borrower  string    `json:"borrower" dynamodbav:"borrower"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	DueAt     time.Time `json:"dueAt" dynamodbav:"dueAt"`
+}
+
+// loansTableName returns the name of the table holding the loans of the
+// books in bookTable.
+func loansTableName(bookTable string) string {
+	return bookTable + "-loans"
+}
+
+func availabilityKey(bookID int) map[string]types.AttributeValue {
+	return StringKey(idAttribute).MarshalKey(availabilityKeyPrefix + strconv.Itoa(bookID))
+}
+
+func loanKey(loanID string) map[string]types.AttributeValue {
+	return StringKey(idAttribute).MarshalKey(loanKeyPrefix + loanID)
+}
+
+// AddCopies adds n copies of the book to those that can be checked out, or
+// removes them if n is negative, and returns how many are available now.
+// Copies on loan cannot be removed: removing more copies than are available
+// fails with ErrNoCopyAvailable.
+func (d *DynamoDbBookRepository) AddCopies(ctx context.Context, bookID, n int) (int, error) {
+	input := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(loansTableName(d.tableName)),
+		Key:                      availabilityKey(bookID),
+		UpdateExpression:         aws.String("ADD #available :delta"),
+		ExpressionAttributeNames: map[string]string{"#available": availableAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":delta": &types.AttributeValueMemberN{Value: strconv.Itoa(n)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	}
+	if n < 0 {
+		input.ConditionExpression = aws.String("#available >= :min")
+		input.ExpressionAttributeValues[":min"] = &types.AttributeValueMemberN{Value: strconv.Itoa(-n)}
+	}
+	result, err := d.client.UpdateItem(ctx, input)
+	if err != nil {
+		err = translateError(err)
+		if isConflict(err) {
+			return 0, ErrNoCopyAvailable
+		}
+		return 0, err
+	}
+	var available int
+	if err := attributevalue.Unmarshal(result.Attributes[availableAttribute], &available); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
+
+// TakeCopy takes one available copy of the book for the loan loanID. It
+// fails with ErrNoCopyAvailable if all copies are on loan. Taking a copy
+// again for the same loan does nothing.
+func (d *DynamoDbBookRepository) TakeCopy(ctx context.Context, bookID int, loanID string) error {
+	_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                           aws.String(loansTableName(d.tableName)),
+		Key:                                 availabilityKey(bookID),
+		UpdateExpression:                    aws.String("ADD #available :take, #loans :loan"),
+		ConditionExpression:                 aws.String("#available >= :one AND NOT contains(#loans, :loanId)"),
+		ExpressionAttributeNames:            map[string]string{"#available": availableAttribute, "#loans": loansAttribute},
+		ExpressionAttributeValues:           loanCopyValues(loanID, -1),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		var loans []string
+		if err := attributevalue.Unmarshal(ccf.Item[loansAttribute], &loans); err == nil && slices.Contains(loans, loanID) {
+			return nil
+		}
+		return ErrNoCopyAvailable
+	}
+	return translateError(err)
+}
+
+// PutBackCopy makes the copy taken for the loan loanID available again. It
+// does nothing if the loan holds no copy, e.g. because it was put back
+// already.
+func (d *DynamoDbBookRepository) PutBackCopy(ctx context.Context, bookID int, loanID string) error {
+	_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(loansTableName(d.tableName)),
+		Key:                       availabilityKey(bookID),
+		UpdateExpression:          aws.String("ADD #available :one DELETE #loans :loan"),
+		ConditionExpression:       aws.String("contains(#loans, :loanId)"),
+		ExpressionAttributeNames:  map[string]string{"#available": availableAttribute, "#loans": loansAttribute},
+		ExpressionAttributeValues: loanCopyValues(loanID, 0),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return nil
+	}
+	return translateError(err)
+}
+
+// loanCopyValues returns the values of TakeCopy and PutBackCopy; take is
+// the change of the available copies, if any.
+func loanCopyValues(loanID string, take int) map[string]types.AttributeValue {
+	values := map[string]types.AttributeValue{
+		":one":    &types.AttributeValueMemberN{Value: "1"},
+		":loan":   &types.AttributeValueMemberSS{Value: []string{loanID}},
+		":loanId": &types.AttributeValueMemberS{Value: loanID},
+	}
+	if take != 0 {
+		values[":take"] = &types.AttributeValueMemberN{Value: strconv.Itoa(take)}
+	}
+	return values
+}
+
+// CreateLoan stores loan. Loan ids are random, so a loan already stored
+// under the same id was stored by an earlier attempt and is left as is.
+func (d *DynamoDbBookRepository) CreateLoan(ctx context.Context, loan *Loan) error {
+	item, err := attributevalue.MarshalMap(loan)
+	if err != nil {
+		return err
+	}
+	for name, value := range loanKey(loan.Id) {
+		item[name] = value
+	}
+	_, err = d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(loansTableName(d.tableName)),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]string{"#id": idAttribute},
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return nil
+	}
+	return translateError(err)
+}
+
+// DeleteLoan removes the loan loanID. Deleting a missing loan is not an
+// error.
+func (d *DynamoDbBookRepository) DeleteLoan(ctx context.Context, loanID string) error {
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(loansTableName(d.tableName)),
+		Key:       loanKey(loanID),
+	})
+	return translateError(err)
+}
+
+// GetLoan returns the loan loanID, or ErrNotFound.
+func (d *DynamoDbBookRepository) GetLoan(ctx context.Context, loanID string) (*Loan, error) {
+	result, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(loansTableName(d.tableName)),
+		Key:            loanKey(loanID),
+		ConsistentRead: consistentRead(ctx, d.consistentReads),
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+	loan := new(Loan)
+	if err := attributevalue.UnmarshalMap(result.Item, loan); err != nil {
+		return nil, err
+	}
+	return loan, nil
+}
+
+// loansTableDefinition describes the loans table of bookTable: a string id
+// partition key and nothing else.
+func loansTableDefinition(bookTable string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(loansTableName(bookTable)),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(idAttribute), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(idAttribute), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
+
+// MigrateLoans creates the loans table of bookTable if needed. It is safe
+// to run repeatedly.
+func MigrateLoans(ctx context.Context, client *dynamodb.Client, bookTable string) error {
+	return migrateTable(ctx, client, loansTableDefinition(bookTable))
+}
@@ -0,0 +1,384 @@
+// Package lock implements lease-based locks over a DynamoDB table, so that
+// of several processes sharing the table only one runs a job at a time.
+//
+// A lock is an item keyed by the lock's name that records its holder and
+// the time its lease runs out. Holders renew the lease while they hold the
+// lock and delete the item when they release it; a lock whose lease ran
+// out, because its holder crashed or lost its connection, can be taken
+// over. Leases are compared with the clocks of the processes, so their
+// duration must comfortably exceed the clock skew between them.
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attributes of the lock table.
+const (
+	// NameAttribute is the partition key of the lock table.
+	NameAttribute  = "name"
+	ownerAttribute = "owner"
+	tokenAttribute = "token"
+	// leaseAttribute holds the Unix millisecond the lease runs out at.
+	leaseAttribute = "leaseUntil"
+	// ExpiresAtAttribute holds the Unix second the lease runs out at, for
+	// the table's TTL to remove abandoned locks.
+	ExpiresAtAttribute = "expiresAt"
+)
+
+// DefaultLease is the lease of a Locker created without WithLease.
+const DefaultLease = 30 * time.Second
+
+var (
+	// ErrHeld is returned when acquiring a lock another holder's lease is
+	// in force on.
+	ErrHeld = errors.New("lock is held")
+	// ErrLost reports that a lock was taken over, or could not be renewed
+	// before its lease ran out, while it was held.
+	ErrLost = errors.New("lock lost")
+)
+
+// Locker acquires the locks of one table on behalf of one process.
+type Locker struct {
+	client *dynamodb.Client
+	table  string
+	owner  string
+	lease  time.Duration
+	now    func() time.Time
+}
+
+// Option configures a Locker.
+type Option func(*Locker)
+
+// WithOwner sets the name recorded as the holder of the locks, such as a
+// host name, instead of a random one. It only serves to tell holders apart
+// in errors and when inspecting the table: every acquisition is told apart
+// by a token of its own.
+func WithOwner(owner string) Option {
+	return func(l *Locker) {
+		l.owner = owner
+	}
+}
+
+// WithLease sets how long a lock stays held without being renewed. Held
+// locks are renewed every third of it.
+func WithLease(d time.Duration) Option {
+	return func(l *Locker) {
+		l.lease = d
+	}
+}
+
+// New returns a locker of the locks stored in table.
+func New(client *dynamodb.Client, table string, opts ...Option) (*Locker, error) {
+	l := &Locker{client: client, table: table, lease: DefaultLease, now: time.Now}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.lease <= 0 {
+		return nil, fmt.Errorf("lock lease %s must be positive", l.lease)
+	}
+	if l.owner == "" {
+		owner, err := randomToken()
+		if err != nil {
+			return nil, err
+		}
+		l.owner = owner
+	}
+	return l, nil
+}
+
+// Owner returns the name the locker records as the holder of its locks.
+func (l *Locker) Owner() string {
+	return l.owner
+}
+
+// Lock is a held lock. Its lease is renewed in the background until it is
+// released or lost.
+type Lock struct {
+	locker *Locker
+	name   string
+	token  string
+	// stop ends the renewals; done is closed once they have ended.
+	stop context.CancelFunc
+	done chan struct{}
+
+	mu   sync.Mutex
+	lost bool
+}
+
+// Acquire takes the lock called name. It fails with an error matching
+// ErrHeld if another holder's lease on it is in force.
+func (l *Locker) Acquire(ctx context.Context, name string) (*Lock, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	now := l.now()
+	item := map[string]types.AttributeValue{
+		NameAttribute:  &types.AttributeValueMemberS{Value: name},
+		ownerAttribute: &types.AttributeValueMemberS{Value: l.owner},
+		tokenAttribute: &types.AttributeValueMemberS{Value: token},
+	}
+	for attr, av := range l.leaseAttributes(now) {
+		item[attr] = av
+	}
+	_, err = l.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(l.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#name) OR #lease < :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#name":  NameAttribute,
+			"#lease": leaseAttribute,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": unixMillis(now),
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return nil, heldError(name, failed.Item)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("acquire lock %s: %w", name, err)
+	}
+
+	renewCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	lk := &Lock{locker: l, name: name, token: token, stop: stop, done: make(chan struct{})}
+	go lk.renew(renewCtx, now.Add(l.lease))
+	return lk, nil
+}
+
+// heldError describes the holder recorded in item, the lock as it was when
+// acquiring it failed.
+func heldError(name string, item map[string]types.AttributeValue) error {
+	owner, _ := item[ownerAttribute].(*types.AttributeValueMemberS)
+	lease, _ := item[leaseAttribute].(*types.AttributeValueMemberN)
+	if owner == nil || lease == nil {
+		return fmt.Errorf("%w: %s", ErrHeld, name)
+	}
+	ms, err := strconv.ParseInt(lease.Value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %s by %s", ErrHeld, name, owner.Value)
+	}
+	return fmt.Errorf("%w: %s by %s until %s", ErrHeld, name, owner.Value, time.UnixMilli(ms).UTC().Format(time.RFC3339))
+}
+
+// renew extends the lease every third of it until ctx is done. The lock is
+// lost once another holder took it over, or once its lease ran out, at
+// until, before a renewal succeeded; failed renewals are retried until then.
+func (lk *Lock) renew(ctx context.Context, until time.Time) {
+	defer close(lk.done)
+	l := lk.locker
+	ticker := time.NewTicker(l.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := l.now()
+		if !now.Before(until) {
+			lk.markLost()
+			return
+		}
+		err := lk.extend(ctx, now)
+		var failed *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &failed):
+			lk.markLost()
+			return
+		case err != nil:
+			if ctx.Err() == nil {
+				slog.WarnContext(ctx, "renew lock", "table", l.table, "lock", lk.name, "error", err)
+			}
+		default:
+			until = now.Add(l.lease)
+		}
+	}
+}
+
+// extend renews the lease from now if the lock is still held by lk.
+func (lk *Lock) extend(ctx context.Context, now time.Time) error {
+	l := lk.locker
+	lease := l.leaseAttributes(now)
+	_, err := l.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(l.table),
+		Key:                 lk.key(),
+		UpdateExpression:    aws.String("SET #lease = :lease, #expires = :expires"),
+		ConditionExpression: aws.String("#token = :token"),
+		ExpressionAttributeNames: map[string]string{
+			"#lease":   leaseAttribute,
+			"#expires": ExpiresAtAttribute,
+			"#token":   tokenAttribute,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":lease":   lease[leaseAttribute],
+			":expires": lease[ExpiresAtAttribute],
+			":token":   &types.AttributeValueMemberS{Value: lk.token},
+		},
+	})
+	return err
+}
+
+func (lk *Lock) markLost() {
+	lk.mu.Lock()
+	lk.lost = true
+	lk.mu.Unlock()
+}
+
+// Name returns the name of the lock.
+func (lk *Lock) Name() string {
+	return lk.name
+}
+
+// Done returns a channel closed when the lock is released or lost.
+func (lk *Lock) Done() <-chan struct{} {
+	return lk.done
+}
+
+// Err returns ErrLost if the lock was lost, and nil otherwise.
+func (lk *Lock) Err() error {
+	lk.mu.Lock()
+	defer lk.mu.Unlock()
+	if lk.lost {
+		return fmt.Errorf("%w: %s", ErrLost, lk.name)
+	}
+	return nil
+}
+
+// Release stops renewing the lock and deletes it, unless another holder has
+// taken it over since. Releasing a lost lock returns an error matching
+// ErrLost; releasing a lock twice is harmless.
+func (lk *Lock) Release(ctx context.Context) error {
+	lk.stop()
+	<-lk.done
+	if err := lk.Err(); err != nil {
+		return err
+	}
+	_, err := lk.locker.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(lk.locker.table),
+		Key:                      lk.key(),
+		ConditionExpression:      aws.String("#token = :token"),
+		ExpressionAttributeNames: map[string]string{"#token": tokenAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":token": &types.AttributeValueMemberS{Value: lk.token},
+		},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		// Released before, or taken over after the lease ran out.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("release lock %s: %w", lk.name, err)
+	}
+	return nil
+}
+
+func (lk *Lock) key() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{NameAttribute: &types.AttributeValueMemberS{Value: lk.name}}
+}
+
+// Do runs fn holding the lock called name, failing with an error matching
+// ErrHeld if another holder has it. The context of fn is canceled if the
+// lock is lost, in which case Do returns an error matching ErrLost. The lock
+// is released when fn returns.
+func (l *Locker) Do(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	lk, err := l.Acquire(ctx, name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-lk.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err = fn(ctx)
+	// The lock is released even if ctx is done.
+	releaseErr := lk.Release(context.WithoutCancel(ctx))
+	if errors.Is(releaseErr, ErrLost) {
+		return errors.Join(releaseErr, err)
+	}
+	if err != nil {
+		return err
+	}
+	return releaseErr
+}
+
+// Lead runs fn holding the lock called name, for jobs that should run on
+// one process at all times, such as sweepers. While another process holds
+// the lock, or once this one loses it, Lead tries again every retry. fn
+// should run until its context is done; Lead returns once ctx is done.
+func (l *Locker) Lead(ctx context.Context, name string, retry time.Duration, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(retry)
+	defer ticker.Stop()
+	for {
+		err := l.Do(ctx, name, func(ctx context.Context) error {
+			fn(ctx)
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrHeld) && ctx.Err() == nil {
+			slog.WarnContext(ctx, "lead job", "table", l.table, "lock", name, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// leaseAttributes returns the lease attributes of a lease starting at now.
+func (l *Locker) leaseAttributes(now time.Time) map[string]types.AttributeValue {
+	until := now.Add(l.lease)
+	// Rounded up, so that TTL never removes a lock whose lease is in force.
+	expires := until.Add(time.Second - 1).Unix()
+	return map[string]types.AttributeValue{
+		leaseAttribute:     unixMillis(until),
+		ExpiresAtAttribute: &types.AttributeValueMemberN{Value: strconv.FormatInt(expires, 10)},
+	}
+}
+
+func unixMillis(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.UnixMilli(), 10)}
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate lock token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// TableDefinition describes a lock table called table.
+func TableDefinition(table string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(NameAttribute), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(NameAttribute), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
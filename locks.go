@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"dynamoDBExample/lock"
+)
+
+// Names of the locks guarding the jobs that must not run on several
+// processes at once.
+const (
+	importLock          = "import"
+	holdSweeperLock     = "hold-sweeper"
+	dedupeLock          = "dedupe"
+	checkoutResumerLock = "checkout-resumer"
+)
+
+// lockTableName returns the name of the table holding the job locks of the
+// processes sharing bookTable.
+func lockTableName(bookTable string) string {
+	return bookTable + "-locks"
+}
+
+// newLocker returns the locker of the job locks of bookTable. The host name
+// is recorded as the holder, so that the table shows where a job runs.
+func newLocker(client *dynamodb.Client, bookTable string) (*lock.Locker, error) {
+	var opts []lock.Option
+	if host, err := os.Hostname(); err == nil {
+		opts = append(opts, lock.WithOwner(host+"-"+strconv.Itoa(os.Getpid())))
+	}
+	return lock.New(client, lockTableName(bookTable), opts...)
+}
+
+// MigrateLocks creates the lock table of bookTable if needed and enables TTL
+// on it so abandoned locks are cleaned up. It is safe to run repeatedly.
+func MigrateLocks(ctx context.Context, client *dynamodb.Client, bookTable string) error {
+	return migrateTable(ctx, client, lock.TableDefinition(lockTableName(bookTable)))
+}
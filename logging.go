@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// LoggingBookRepository is a BookRepository decorator that writes one
+// structured log record per call with the operation, table, key, consumed
+// capacity, latency and error. Consumed capacity is only known when the
+// decorated repository is a DynamoDbBookRepository.
+type LoggingBookRepository struct {
+	next   BookRepository
+	logger *slog.Logger
+	table  string
+}
+
+func NewLoggingBookRepository(next BookRepository, logger *slog.Logger, table string) *LoggingBookRepository {
+	return &LoggingBookRepository{next: next, logger: logger, table: table}
+}
+
+// Logging returns a middleware that wraps repositories in a
+// LoggingBookRepository.
+func Logging(logger *slog.Logger, table string) RepositoryMiddleware {
+	return func(next BookRepository) BookRepository {
+		return NewLoggingBookRepository(next, logger, table)
+	}
+}
+
+// NewLogger builds a logger writing to w at the given level ("debug",
+// "info", "warn" or "error") in either "json" or "text" format.
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q", format)
+}
+
+// call runs fn with a capacity-recording context and logs the outcome.
+func (l *LoggingBookRepository) call(ctx context.Context, op string, key slog.Attr, fn func(context.Context) error) {
+	ctx, rec := withCapacityRecorder(ctx)
+	start := time.Now()
+	err := fn(ctx)
+
+	attrs := []slog.Attr{
+		slog.String("op", op),
+		slog.String("table", l.table),
+		slog.Float64("consumed_capacity", rec.Units()),
+		slog.Duration("latency", time.Since(start)),
+	}
+	if key.Key != "" {
+		attrs = append(attrs, key)
+	}
+	level := slog.LevelInfo
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+		level = slog.LevelError
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) {
+			// Expected outcomes the caller handles; not a failure of ours.
+			level = slog.LevelWarn
+		}
+	}
+	l.logger.LogAttrs(ctx, level, "dynamodb call", attrs...)
+}
+
+// Create implements BookRepository.
+func (l *LoggingBookRepository) Create(ctx context.Context, book *Book) (err error) {
+	l.call(ctx, "Create", slog.Int("id", book.Id), func(ctx context.Context) error {
+		err = l.next.Create(ctx, book)
+		return err
+	})
+	return err
+}
+
+// Upsert implements BookRepository.
+func (l *LoggingBookRepository) Upsert(ctx context.Context, book *Book) (err error) {
+	l.call(ctx, "Upsert", slog.Int("id", book.Id), func(ctx context.Context) error {
+		err = l.next.Upsert(ctx, book)
+		return err
+	})
+	return err
+}
+
+// GetById implements BookRepository.
+func (l *LoggingBookRepository) GetById(ctx context.Context, id int) (book *Book, err error) {
+	l.call(ctx, "GetById", slog.Int("id", id), func(ctx context.Context) error {
+		book, err = l.next.GetById(ctx, id)
+		return err
+	})
+	return book, err
+}
+
+// Update implements BookRepository.
+func (l *LoggingBookRepository) Update(ctx context.Context, book *Book) (err error) {
+	l.call(ctx, "Update", slog.Int("id", book.Id), func(ctx context.Context) error {
+		err = l.next.Update(ctx, book)
+		return err
+	})
+	return err
+}
+
+// Delete implements BookRepository.
+func (l *LoggingBookRepository) Delete(ctx context.Context, id int) (err error) {
+	l.call(ctx, "Delete", slog.Int("id", id), func(ctx context.Context) error {
+		err = l.next.Delete(ctx, id)
+		return err
+	})
+	return err
+}
+
+// List implements BookRepository.
+func (l *LoggingBookRepository) List(ctx context.Context) (books []*Book, err error) {
+	l.call(ctx, "List", slog.Attr{}, func(ctx context.Context) error {
+		books, err = l.next.List(ctx)
+		return err
+	})
+	return books, err
+}
+
+// ListPage implements BookRepository.
+func (l *LoggingBookRepository) ListPage(ctx context.Context, limit int, cursor string) (books []*Book, next string, err error) {
+	l.call(ctx, "ListPage", slog.String("cursor", cursor), func(ctx context.Context) error {
+		books, next, err = l.next.ListPage(ctx, limit, cursor)
+		return err
+	})
+	return books, next, err
+}
+
+// ListSorted implements BookRepository.
+func (l *LoggingBookRepository) ListSorted(ctx context.Context, opts ListOptions) (books []*Book, next string, err error) {
+	l.call(ctx, "ListSorted", slog.String("sort", string(opts.SortBy)), func(ctx context.Context) error {
+		books, next, err = l.next.ListSorted(ctx, opts)
+		return err
+	})
+	return books, next, err
+}
+
+// GetByAuthor implements BookRepository.
+func (l *LoggingBookRepository) GetByAuthor(ctx context.Context, author string) (books []*Book, err error) {
+	l.call(ctx, "GetByAuthor", slog.String("author", author), func(ctx context.Context) error {
+		books, err = l.next.GetByAuthor(ctx, author)
+		return err
+	})
+	return books, err
+}
+
+// GetByISBN implements BookRepository.
+func (l *LoggingBookRepository) GetByISBN(ctx context.Context, isbn string) (book *Book, err error) {
+	l.call(ctx, "GetByISBN", slog.String("isbn", isbn), func(ctx context.Context) error {
+		book, err = l.next.GetByISBN(ctx, isbn)
+		return err
+	})
+	return book, err
+}
+
+// BatchCreate implements BookRepository.
+func (l *LoggingBookRepository) BatchCreate(ctx context.Context, books []*Book) (err error) {
+	l.call(ctx, "BatchCreate", slog.Int("count", len(books)), func(ctx context.Context) error {
+		err = l.next.BatchCreate(ctx, books)
+		return err
+	})
+	return err
+}
+
+// BatchGet implements BookRepository.
+func (l *LoggingBookRepository) BatchGet(ctx context.Context, ids []int) (books []*Book, err error) {
+	l.call(ctx, "BatchGet", slog.Int("count", len(ids)), func(ctx context.Context) error {
+		books, err = l.next.BatchGet(ctx, ids)
+		return err
+	})
+	return books, err
+}
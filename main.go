@@ -2,156 +2,833 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"dynamoDBExample/events"
+	"dynamoDBExample/lifecycle"
 )
 
 type Book struct {
-	Id     int    `json:"id"`
-	Name   string `json:"name"`
-	Author string `json:"author"`
+	Id     int    `json:"id" dynamodbav:"id"`
+	Name   string `json:"name" dynamodbav:"name"`
+	Author string `json:"author" dynamodbav:"author"`
+	// Version is incremented on every write and used for optimistic locking.
+	Version int `json:"version" dynamodbav:"version"`
+	// Tags is stored as a string set; an empty set is not written.
+	Tags []string `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	// ISBN is the book's ISBN-13, or empty if unknown. No two books share
+	// one; an ISBN-10 given by a client is stored as its ISBN-13.
+	ISBN string `json:"isbn,omitempty" dynamodbav:"isbn,omitempty"`
+	// Year is the year of publication, or 0 if unknown.
+	Year int `json:"year,omitempty" dynamodbav:"year,omitempty"`
+	// PublishedAt is the date of publication, or zero if unknown.
+	PublishedAt time.Time `json:"publishedAt,omitzero" dynamodbav:"publishedAt"`
+	// CreatedAt is set by BookUseCase when the book is first stored. It is
+	// kept to the second and zero for books stored before it existed.
+	CreatedAt time.Time `json:"createdAt,omitzero" dynamodbav:"createdAt,unixtime"`
+	// DeletedAt is set by SoftDelete. Soft-deleted books are hidden from
+	// GetById and List unless the repository was built WithIncludeDeleted.
+	DeletedAt *time.Time `json:"deletedAt,omitempty" dynamodbav:"deletedAt,omitempty"`
+	// ExpiresAt is the TTL after which DynamoDB removes a soft-deleted book.
+	ExpiresAt *time.Time `json:"-" dynamodbav:"expiresAt,unixtime,omitempty"`
+	// ReviewCount, RatingTotal and AverageRating aggregate the reviews of
+	// the book. SingleTable.AddReview maintains them in the transaction
+	// adding a review; books in other repositories have none.
+	ReviewCount   int     `json:"reviewCount,omitempty" dynamodbav:"reviewCount,omitempty"`
+	RatingTotal   int     `json:"-" dynamodbav:"ratingTotal,omitempty"`
+	AverageRating float64 `json:"averageRating,omitempty" dynamodbav:"averageRating,omitempty"`
 }
 
 type BookRepository interface {
-	Create(book *Book) error
-	GetById(id int) (*Book, error)
-	Update(book *Book) error
-	Delete(id int) error
-	List() ([]*Book, error)
+	Create(ctx context.Context, book *Book) error
+	Upsert(ctx context.Context, book *Book) error
+	GetById(ctx context.Context, id int) (*Book, error)
+	Update(ctx context.Context, book *Book) error
+	Delete(ctx context.Context, id int) error
+	List(ctx context.Context) ([]*Book, error)
+	ListPage(ctx context.Context, limit int, cursor string) ([]*Book, string, error)
+	ListSorted(ctx context.Context, opts ListOptions) ([]*Book, string, error)
+	GetByAuthor(ctx context.Context, author string) ([]*Book, error)
+	GetByISBN(ctx context.Context, isbn string) (*Book, error)
+	BatchCreate(ctx context.Context, books []*Book) error
+	BatchGet(ctx context.Context, ids []int) ([]*Book, error)
 }
 type BookUseCase struct {
-	repo BookRepository
+	repo           BookRepository
+	keepRawStrings bool
+	changes        []ChangeHandler
+	idempotency    IdempotentCreator
+	search         BookSearcher
+	importWorkers  int
+	access         AccessPolicy
+	metrics        *RequestMetrics
+	checkout       CheckoutStore
+	checkoutEvents events.Publisher
+	ids            IDGenerator
 }
 
-func NewBookUseCase(repo BookRepository) *BookUseCase {
-	return &BookUseCase{repo: repo}
+// BookUseCaseOption configures a BookUseCase.
+type BookUseCaseOption func(*BookUseCase)
+
+// WithRawStrings disables whitespace normalization of Name and Author, so
+// books are stored exactly as given.
+func WithRawStrings() BookUseCaseOption {
+	return func(uc *BookUseCase) {
+		uc.keepRawStrings = true
+	}
 }
 
-func (uc *BookUseCase) createBook(book *Book) error {
-	return uc.repo.Create(book)
+// WithChangeHandler makes the use case report every successful write to h,
+// e.g. a ChangeBroadcaster feeding WatchBooks. Handlers given by several
+// options are called in order.
+func WithChangeHandler(h ChangeHandler) BookUseCaseOption {
+	return func(uc *BookUseCase) {
+		uc.changes = append(uc.changes, h)
+	}
 }
 
-func (uc *BookUseCase) GetById(id int) (*Book, error) {
-	return uc.repo.GetById(id)
+// WithIdempotency lets creates carrying an idempotency key go through c, so
+// that retried requests return the original book. Without it keys are
+// ignored and a retry fails with ErrBookAlreadyExists instead.
+func WithIdempotency(c IdempotentCreator) BookUseCaseOption {
+	return func(uc *BookUseCase) {
+		uc.idempotency = c
+	}
 }
 
-func (uc *BookUseCase) Update(book *Book) error {
-	return uc.repo.Update(book)
+// WithSearch backs SearchBooks with s. Without it SearchBooks fails with
+// ErrSearchUnavailable.
+func WithSearch(s BookSearcher) BookUseCaseOption {
+	return func(uc *BookUseCase) {
+		uc.search = s
+	}
 }
 
-func (uc *BookUseCase) Delete(id int) error {
-	return uc.repo.Delete(id)
+// WithImportWorkers sets how many chunks ImportBooks writes at once.
+func WithImportWorkers(n int) BookUseCaseOption {
+	return func(uc *BookUseCase) {
+		uc.importWorkers = n
+	}
 }
 
-func (uc *BookUseCase) List() ([]*Book, error) {
-	return uc.repo.List()
+// WithAccessPolicy makes every operation check the roles of the principal
+// of its context against p, failing with ErrUnauthenticated or ErrForbidden.
+// Without it the use case trusts its callers.
+func WithAccessPolicy(p AccessPolicy) BookUseCaseOption {
+	return func(uc *BookUseCase) {
+		uc.access = p
+	}
 }
 
-type DynamoDbBookRepository struct {
-	client    *dynamodb.Client
-	tableName string
+// WithRequestMetrics records the count, latency and outcome of every
+// operation in m.
+func WithRequestMetrics(m *RequestMetrics) BookUseCaseOption {
+	return func(uc *BookUseCase) {
+		uc.metrics = m
+	}
 }
 
-// Create implements BookRepository.
-func (d *DynamoDbBookRepository) Create(book *Book) error {
-	av, err := attributevalue.MarshalMap(book)
-	if err != nil {
+func NewBookUseCase(repo BookRepository, opts ...BookUseCaseOption) *BookUseCase {
+	uc := &BookUseCase{repo: repo, importWorkers: defaultBulkWorkers}
+	for _, opt := range opts {
+		opt(uc)
+	}
+	return uc
+}
+
+// normalize trims Name and Author and collapses internal runs of whitespace
+// into a single space, so exact-match lookups are not defeated by stray spaces.
+// Valid ISBNs are rewritten to their canonical ISBN-13.
+func (uc *BookUseCase) normalize(book *Book) {
+	// ISBNs are compared for uniqueness, so they are normalized regardless.
+	if isbn, ok := canonicalISBN(book.ISBN); ok {
+		book.ISBN = isbn
+	}
+	if uc.keepRawStrings {
+		return
+	}
+	book.Name = strings.Join(strings.Fields(book.Name), " ")
+	book.Author = strings.Join(strings.Fields(book.Author), " ")
+}
+
+// begin starts operation op: its span and, if configured, its request
+// metrics. The returned function ends them with the outcome in *err.
+func (uc *BookUseCase) begin(ctx context.Context, op string) (context.Context, func(err *error)) {
+	ctx, span := startSpan(ctx, "BookUseCase."+op)
+	start := time.Now()
+	return ctx, func(err *error) {
+		if uc.metrics != nil {
+			uc.metrics.observe(op, time.Since(start), *err)
+		}
+		endSpan(span, err)
+	}
+}
+
+// notify reports a successful write to the change handlers, if any. Handler
+// errors are logged; the write has already happened.
+func (uc *BookUseCase) notify(ctx context.Context, typ ChangeType, id int, book *Book) {
+	if len(uc.changes) == 0 {
+		return
+	}
+	change := BookChange{Type: typ, Id: id, Time: time.Now()}
+	if book != nil {
+		change.New = copyBook(book)
+	}
+	for _, h := range uc.changes {
+		if err := h.HandleChange(ctx, change); err != nil {
+			slog.WarnContext(ctx, "notify book change", "id", id, "error", err)
+		}
+	}
+}
+
+// createBook creates book, with an id from the IDGenerator of the use case
+// if it has none. A non-empty idempotencyKey makes retries safe if the use
+// case was built WithIdempotency: a repeated call returns nil and overwrites
+// *book with the book stored by the first one.
+func (uc *BookUseCase) createBook(ctx context.Context, book *Book, idempotencyKey string) (err error) {
+	ctx, end := uc.begin(ctx, "CreateBook")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "Create"); err != nil {
 		return err
 	}
+	uc.normalize(book)
+	if book.Id == 0 && uc.ids != nil {
+		if book.Id, err = uc.ids.NextID(); err != nil {
+			return err
+		}
+		ctx = withGeneratedID(ctx)
+	}
+	if err := validateBook(book); err != nil {
+		return err
+	}
+	if book.CreatedAt.IsZero() {
+		book.CreatedAt = creationTime()
+	}
+	if idempotencyKey != "" && uc.idempotency != nil {
+		stored, replayed, err := uc.idempotency.CreateIdempotent(ctx, book, idempotencyKey)
+		if err != nil {
+			return err
+		}
+		if replayed {
+			*book = *stored
+			return nil
+		}
+	} else if err := uc.repo.Create(ctx, book); err != nil {
+		return err
+	}
+	uc.notify(ctx, ChangeInsert, book.Id, book)
+	return nil
+}
 
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(d.tableName),
+func (uc *BookUseCase) Upsert(ctx context.Context, book *Book) (err error) {
+	ctx, end := uc.begin(ctx, "Upsert")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "Upsert"); err != nil {
+		return err
 	}
-	_, err = d.client.PutItem(context.TODO(), input)
-	return err
+	uc.normalize(book)
+	if err := validateBook(book); err != nil {
+		return err
+	}
+	if err := uc.keepCreatedAt(ctx, book); err != nil {
+		return err
+	}
+	if err := uc.repo.Upsert(ctx, book); err != nil {
+		return err
+	}
+	uc.notify(ctx, ChangeModify, book.Id, book)
+	return nil
 }
 
-// Delete implements BookRepository.
-func (d *DynamoDbBookRepository) Delete(id int) error {
-	input := &dynamodb.DeleteItemInput{
-		Key: map[string]types.AttributeValue{
-			"id": &types.AttributeValueMemberN{Value: string(id)},
-		},
-		TableName: aws.String(d.tableName),
+// keepCreatedAt gives book, about to replace the stored book with its id,
+// the stored creation time if it has none, or the current time if there is
+// no such book.
+func (uc *BookUseCase) keepCreatedAt(ctx context.Context, book *Book) error {
+	if !book.CreatedAt.IsZero() {
+		return nil
+	}
+	stored, err := uc.repo.GetById(withReadOptions(ctx, []ReadOption{WithConsistentRead(), WithFields(createdAtAttribute)}), book.Id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		book.CreatedAt = creationTime()
+	case err != nil:
+		return err
+	default:
+		book.CreatedAt = stored.CreatedAt
+	}
+	return nil
+}
+
+// creationTime returns the current time to the precision creation times are
+// stored with.
+func creationTime() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func (uc *BookUseCase) GetById(ctx context.Context, id int, opts ...ReadOption) (book *Book, err error) {
+	ctx, end := uc.begin(ctx, "GetById")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "GetById"); err != nil {
+		return nil, err
+	}
+	return uc.repo.GetById(withReadOptions(ctx, opts), id)
+}
+
+func (uc *BookUseCase) Update(ctx context.Context, book *Book) (err error) {
+	ctx, end := uc.begin(ctx, "Update")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "Update"); err != nil {
+		return err
+	}
+	uc.normalize(book)
+	if err := validateBook(book); err != nil {
+		return err
+	}
+	if err := uc.keepCreatedAt(ctx, book); err != nil {
+		return err
+	}
+	if err := uc.repo.Update(ctx, book); err != nil {
+		return err
+	}
+	uc.notify(ctx, ChangeModify, book.Id, book)
+	return nil
+}
+
+func (uc *BookUseCase) Delete(ctx context.Context, id int) (err error) {
+	ctx, end := uc.begin(ctx, "Delete")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "Delete"); err != nil {
+		return err
+	}
+	if err := uc.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	uc.notify(ctx, ChangeRemove, id, nil)
+	return nil
+}
+
+// List returns the page of books selected by opts and the cursor of the
+// next one, empty after the last. Without a SortBy the books come in no
+// particular order, all of them if no Limit or Cursor is given either.
+func (uc *BookUseCase) List(ctx context.Context, opts ListOptions, readOpts ...ReadOption) (books []*Book, next string, err error) {
+	ctx, end := uc.begin(ctx, "List")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "List"); err != nil {
+		return nil, "", err
+	}
+	ctx = withReadOptions(ctx, readOpts)
+	switch {
+	case opts.SortBy != "":
+		return uc.repo.ListSorted(ctx, opts)
+	case opts.Order != "":
+		return nil, "", fmt.Errorf("%w: a sort order needs a sort", ErrValidation)
+	case opts.Limit == 0 && opts.Cursor == "":
+		books, err := uc.repo.List(ctx)
+		return books, "", err
+	}
+	return uc.repo.ListPage(ctx, opts.Limit, opts.Cursor)
+}
+
+func (uc *BookUseCase) ListPage(ctx context.Context, limit int, cursor string, opts ...ReadOption) (books []*Book, next string, err error) {
+	ctx, end := uc.begin(ctx, "ListPage")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "ListPage"); err != nil {
+		return nil, "", err
+	}
+	return uc.repo.ListPage(withReadOptions(ctx, opts), limit, cursor)
+}
+
+// SearchBooks returns up to limit books matching query, most relevant first.
+// A limit of 0 means defaultSearchLimit.
+func (uc *BookUseCase) SearchBooks(ctx context.Context, query string, limit int) (hits []SearchHit, err error) {
+	ctx, end := uc.begin(ctx, "SearchBooks")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "SearchBooks"); err != nil {
+		return nil, err
+	}
+	if uc.search == nil {
+		return nil, ErrSearchUnavailable
+	}
+	query = strings.TrimSpace(query)
+	switch {
+	case query == "":
+		return nil, fmt.Errorf("%w: search query must not be empty", ErrValidation)
+	case len(query) > maxSearchQueryLen:
+		return nil, fmt.Errorf("%w: search query exceeds %d bytes", ErrValidation, maxSearchQueryLen)
+	case limit < 0 || limit > maxSearchLimit:
+		return nil, fmt.Errorf("%w: search limit must be between 1 and %d", ErrValidation, maxSearchLimit)
+	case limit == 0:
+		limit = defaultSearchLimit
+	}
+	return uc.search.SearchBooks(ctx, query, limit)
+}
+
+func (uc *BookUseCase) GetByAuthor(ctx context.Context, author string, opts ...ReadOption) (books []*Book, err error) {
+	ctx, end := uc.begin(ctx, "GetByAuthor")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "GetByAuthor"); err != nil {
+		return nil, err
+	}
+	return uc.repo.GetByAuthor(withReadOptions(ctx, opts), author)
+}
+
+// GetByISBN returns the book with isbn, an ISBN-10 or ISBN-13, or
+// ErrNotFound if there is none.
+func (uc *BookUseCase) GetByISBN(ctx context.Context, isbn string, opts ...ReadOption) (book *Book, err error) {
+	ctx, end := uc.begin(ctx, "GetByISBN")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "GetByISBN"); err != nil {
+		return nil, err
+	}
+	if isbn, err = normalizeISBN(isbn); err != nil {
+		return nil, err
+	}
+	return uc.repo.GetByISBN(withReadOptions(ctx, opts), isbn)
+}
+
+func (uc *BookUseCase) BatchCreate(ctx context.Context, books []*Book) (err error) {
+	ctx, end := uc.begin(ctx, "BatchCreate")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "BatchCreate"); err != nil {
+		return err
+	}
+	for _, book := range books {
+		uc.normalize(book)
+	}
+	if err := validateBooks(books); err != nil {
+		return err
+	}
+	for _, book := range books {
+		if book.CreatedAt.IsZero() {
+			book.CreatedAt = creationTime()
+		}
+	}
+	// BatchWriteItem does not tell inserts from overwrites, so the books
+	// stored already are read first, but only if someone is notified.
+	var existing map[int]bool
+	if len(uc.changes) > 0 {
+		ids := make([]int, len(books))
+		for i, book := range books {
+			ids[i] = book.Id
+		}
+		stored, err := uc.repo.BatchGet(withReadOptions(ctx, []ReadOption{WithConsistentRead(), WithFields(idAttribute)}), ids)
+		if err != nil {
+			return err
+		}
+		existing = make(map[int]bool, len(stored))
+		for _, book := range stored {
+			existing[book.Id] = true
+		}
+	}
+	if err := uc.repo.BatchCreate(ctx, books); err != nil {
+		return err
+	}
+	for _, book := range books {
+		typ := ChangeInsert
+		if existing[book.Id] {
+			typ = ChangeModify
+		}
+		uc.notify(ctx, typ, book.Id, book)
+	}
+	return nil
+}
+
+func (uc *BookUseCase) BatchGet(ctx context.Context, ids []int, opts ...ReadOption) (books []*Book, err error) {
+	ctx, end := uc.begin(ctx, "BatchGet")
+	defer end(&err)
+	if err := uc.access.authorize(ctx, "BatchGet"); err != nil {
+		return nil, err
+	}
+	return uc.repo.BatchGet(withReadOptions(ctx, opts), ids)
+}
+
+type DynamoDbBookRepository struct {
+	client         *dynamodb.Client
+	items          *Repository[Book]
+	clientOptions  []func(*dynamodb.Options)
+	tableName      string
+	codec          bookCodec
+	batchRetry     batchRetryPolicy
+	includeDeleted bool
+	retention      time.Duration
+	key            KeyCodec[int]
+	// consistentReads is the default for reads without a ReadOption.
+	consistentReads bool
+	// idempotencyRetention is how long CreateIdempotent remembers keys.
+	idempotencyRetention time.Duration
+	// callTimeout bounds calls without deadline; see WithCallTimeout.
+	callTimeout time.Duration
+	// bulkWorkers bounds the concurrency of bulk operations.
+	bulkWorkers int
+	// upgradeOnRead writes books read in an older item version back.
+	upgradeOnRead bool
+	// marshalPooling and keyTemplates are set by WithMarshalPooling and
+	// WithKeyTemplates.
+	marshalPooling bool
+	keyTemplates   bool
+	// now is the clock of GetByIdWithAge.
+	now func() time.Time
+}
+
+// RepositoryOption configures a DynamoDbBookRepository.
+type RepositoryOption func(*DynamoDbBookRepository)
+
+// WithEncoderOptions adds attributevalue encoder options used when writing books.
+func WithEncoderOptions(optFns ...func(*attributevalue.EncoderOptions)) RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.codec.encoderOptions = append(d.codec.encoderOptions, optFns...)
+	}
+}
+
+// WithOmitEmpty drops zero-valued attributes (empty strings, zero numbers)
+// from written items. Reads are unaffected: a missing attribute
+// unmarshals to the field's zero value.
+func WithOmitEmpty() RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.codec.omitEmpty = true
+	}
+}
+
+// Create implements BookRepository. It fails with ErrBookAlreadyExists if a
+// book with the same id is already stored; use Upsert to overwrite. A book
+// with an ISBN is written in a transaction claiming it.
+func (d *DynamoDbBookRepository) Create(ctx context.Context, book *Book) error {
+	book.Version = 1
+	if book.ISBN != "" {
+		av, err := d.codec.marshal(book)
+		if err != nil {
+			return err
+		}
+		return d.putBook(ctx, book, &types.Put{
+			TableName:                aws.String(d.tableName),
+			Item:                     av,
+			ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+			ExpressionAttributeNames: d.createNames(),
+		}, ErrBookAlreadyExists)
+	}
+	err := d.items.Create(ctx, book)
+	if isConflict(err) {
+		return ErrBookAlreadyExists
 	}
-	_, err := d.client.DeleteItem(context.TODO(), input)
 	return err
 }
 
-// GetById implements BookRepository.
-func (d *DynamoDbBookRepository) GetById(id int) (*Book, error) {
-	input := &dynamodb.GetItemInput{
-		Key: map[string]types.AttributeValue{
-			"id": &types.AttributeValueMemberN{Value: string(id)},
-		},
-		TableName: aws.String(d.tableName),
+// Upsert implements BookRepository. It bumps the version so that concurrent
+// Updates holding the previous version fail. Like Create, it claims the ISBN
+// of a book that has one.
+func (d *DynamoDbBookRepository) Upsert(ctx context.Context, book *Book) error {
+	book.Version++
+	if book.ISBN != "" {
+		av, err := d.codec.marshal(book)
+		if err != nil {
+			return err
+		}
+		return d.putBook(ctx, book, &types.Put{TableName: aws.String(d.tableName), Item: av}, ErrConflict)
+	}
+	return d.items.Put(ctx, book)
+}
+
+// Delete implements BookRepository.
+func (d *DynamoDbBookRepository) Delete(ctx context.Context, id int) error {
+	return d.items.Delete(ctx, d.key.MarshalKey(id))
+}
+
+// GetById implements BookRepository. It returns ErrNotFound if there is no
+// book with the given id or the book has been soft-deleted.
+func (d *DynamoDbBookRepository) GetById(ctx context.Context, id int) (*Book, error) {
+	item, err := d.items.getItem(ctx, d.key.MarshalKey(id))
+	if err != nil {
+		return nil, err
+	}
+	book := new(Book)
+	if err := d.codec.unmarshal(item, book); err != nil {
+		return nil, err
+	}
+	if d.upgradeOnRead && !partialRead(ctx) {
+		d.writeBackUpgraded(ctx, item)
 	}
+	if book.DeletedAt != nil && !d.includeDeleted {
+		return nil, ErrNotFound
+	}
+	return book, nil
+}
 
-	result, err := d.client.GetItem(context.TODO(), input)
+// List implements BookRepository. It reads every page of the table and skips
+// soft-deleted books.
+func (d *DynamoDbBookRepository) List(ctx context.Context) ([]*Book, error) {
+	books, err := d.items.Scan(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return d.visible(books), nil
+}
 
-	if result.Item == nil {
-		return nil, nil
+// ListLenient is like List but unmarshals items one at a time. Items that
+// fail to unmarshal are skipped and reported in the returned error slice
+// instead of failing the whole call. Like List, it reads every page and
+// skips soft-deleted books.
+func (d *DynamoDbBookRepository) ListLenient(ctx context.Context) ([]*Book, []error, error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(d.tableName),
 	}
-	book := new(Book)
-	err = attributevalue.UnmarshalMap(result.Item, book)
-	return book, err
+	books := []*Book{}
+	var itemErrs []error
+	paginator := dynamodb.NewScanPaginator(d.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, nil, translateError(err)
+		}
+		for _, item := range page.Items {
+			book := new(Book)
+			if err := d.codec.unmarshal(item, book); err != nil {
+				id := "unknown"
+				if n, err := d.key.UnmarshalKey(item); err == nil {
+					id = strconv.Itoa(n)
+				}
+				itemErrs = append(itemErrs, fmt.Errorf("unmarshal book %s: %w", id, err))
+				continue
+			}
+			books = append(books, book)
+		}
+	}
+	return d.visible(books), itemErrs, nil
 }
 
-// List implements BookRepository.
-func (d *DynamoDbBookRepository) List() ([]*Book, error) {
+// DuplicateNames scans the whole table and returns the ids of books that
+// share a name, keyed by that name. Names used by a single book are omitted,
+// and soft-deleted books are skipped, as by List.
+func (d *DynamoDbBookRepository) DuplicateNames(ctx context.Context) (map[string][]int, error) {
 	input := &dynamodb.ScanInput{
 		TableName: aws.String(d.tableName),
 	}
-	result, err := d.client.Scan(context.TODO(), input)
-	if err != nil {
-		return nil, err
+	byName := map[string][]int{}
+	paginator := dynamodb.NewScanPaginator(d.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		books, err := d.codec.unmarshalList(page.Items)
+		if err != nil {
+			return nil, err
+		}
+		for _, book := range d.visible(books) {
+			byName[book.Name] = append(byName[book.Name], book.Id)
+		}
 	}
 
-	books := []*Book{}
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &books)
-	return books, err
+	for name, ids := range byName {
+		if len(ids) < 2 {
+			delete(byName, name)
+		}
+	}
+	return byName, nil
+}
 
+// BackfillAttribute scans the table and, for every item that lacks attr,
+// stores the value computed by fn. Items for which fn returns false are left
+// untouched. It is meant for populating attributes introduced after items
+// were written, e.g. the key of a newly added index.
+func (d *DynamoDbBookRepository) BackfillAttribute(ctx context.Context, attr string, fn func(*Book) (any, bool)) error {
+	return d.backfill(ctx, attr, nil, fn)
 }
 
-// Update implements BookRepository.
-func (d *DynamoDbBookRepository) Update(book *Book) error {
-	av, err := attributevalue.MarshalMap(book)
+// backfill is BackfillAttribute, also filling items whose attr is the
+// number unset, if not nil, which stands for a missing value.
+func (d *DynamoDbBookRepository) backfill(ctx context.Context, attr string, unset *types.AttributeValueMemberN, fn func(*Book) (any, bool)) error {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(d.tableName),
+	}
+	condition := "attribute_not_exists(#attr)"
+	if unset != nil {
+		condition += " OR #attr = :unset"
+	}
+	paginator := dynamodb.NewScanPaginator(d.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return translateError(err)
+		}
+		for _, item := range page.Items {
+			if value, ok := item[attr]; ok {
+				if n, isN := value.(*types.AttributeValueMemberN); unset == nil || !isN || n.Value != unset.Value {
+					continue
+				}
+			}
+			book := new(Book)
+			if err := d.codec.unmarshal(item, book); err != nil {
+				return err
+			}
+			value, ok := fn(book)
+			if !ok {
+				continue
+			}
+			av, err := attributevalue.Marshal(value)
+			if err != nil {
+				return err
+			}
+			values := map[string]types.AttributeValue{":value": av}
+			if unset != nil {
+				values[":unset"] = unset
+			}
+			_, err = d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				Key:                       d.key.MarshalKey(book.Id),
+				TableName:                 aws.String(d.tableName),
+				UpdateExpression:          aws.String("SET #attr = :value"),
+				ConditionExpression:       aws.String(condition),
+				ExpressionAttributeNames:  map[string]string{"#attr": attr},
+				ExpressionAttributeValues: values,
+			})
+			// Another writer set the attribute since the scan; keep its value.
+			var ccf *types.ConditionalCheckFailedException
+			if err != nil && !errors.As(err, &ccf) {
+				return translateError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Update implements BookRepository. book.Version must match the stored
+// version; on success it is incremented in both the table and book. If the
+// stored version differs, or the book does not exist, ErrVersionConflict is
+// returned and book is left unchanged. Like Create, it claims the ISBN of a
+// book that has one.
+//
+// Update changes the item with UpdateItem rather than replacing it: it sets
+// the attributes of book and removes those Book has but book leaves empty.
+// Attributes Book does not know, such as ones written by BackfillAttribute
+// or by later versions of Book, are preserved.
+func (d *DynamoDbBookRepository) Update(ctx context.Context, book *Book) error {
+	expected := book.Version
+	book.Version++
+	av, err := d.codec.marshal(book)
 	if err != nil {
+		book.Version = expected
 		return err
 	}
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(d.tableName),
+
+	condition := "#version = :expected"
+	if expected == 0 {
+		// Books written before versioning was introduced have no version.
+		condition = "attribute_exists(#id) AND (attribute_not_exists(#version) OR #version = :expected)"
 	}
-	_, err = d.client.PutItem(context.TODO(), input)
-	return err
+	update, values := bookUpdateExpression(av)
+	values[":expected"] = &types.AttributeValueMemberN{Value: strconv.Itoa(expected)}
+	names := d.updateNames(expected == 0)
+	if book.ISBN != "" {
+		label := fmt.Sprintf("update book %d", book.Id)
+		err := d.transactClaimingISBN(ctx, book, []types.TransactWriteItem{{Update: &types.Update{
+			Key:                       d.key.MarshalKey(book.Id),
+			TableName:                 aws.String(d.tableName),
+			UpdateExpression:          aws.String(update),
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}}}, []string{label})
+		var txErr *TransactionCanceledError
+		if errors.As(err, &txErr) && txErr.rejected(label) {
+			err = ErrVersionConflict
+		}
+		if err != nil {
+			book.Version = expected
+		}
+		return err
+	}
+	_, err = d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:                       d.key.MarshalKey(book.Id),
+		TableName:                 aws.String(d.tableName),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		book.Version = expected
+	}
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrVersionConflict
+	}
+	return translateError(err)
 }
 
-func NewDynamoDBBookRepository(cfg aws.Config, tableName string) BookRepository {
-	return &DynamoDbBookRepository{
-		client:    dynamodb.NewFromConfig(cfg),
+func NewDynamoDBBookRepository(cfg aws.Config, tableName string, opts ...RepositoryOption) *DynamoDbBookRepository {
+	repo := &DynamoDbBookRepository{
 		tableName: tableName,
+		batchRetry: batchRetryPolicy{
+			maxRetries: defaultBatchMaxRetries,
+			baseDelay:  defaultBatchBaseDelay,
+			maxDelay:   defaultBatchMaxDelay,
+		},
+		retention:            defaultSoftDeleteRetention,
+		idempotencyRetention: defaultIdempotencyRetention,
+		callTimeout:          defaultCallTimeout,
+		bulkWorkers:          defaultBulkWorkers,
+		key:                  NumberKey(idAttribute),
+		now:                  time.Now,
+	}
+	for _, opt := range opts {
+		opt(repo)
+	}
+	if repo.marshalPooling {
+		repo.codec.pool = newCodecPool(&repo.codec)
+	}
+	repo.client = dynamodb.NewFromConfig(cfg, append(repo.clientOptions, func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, addConsumedCapacityMiddleware)
+		if repo.callTimeout > 0 {
+			o.APIOptions = append(o.APIOptions, addCallTimeoutMiddleware(repo.callTimeout))
+		}
+	})...)
+	repo.items = NewRepository(repo.client, tableName, EntitySchema[Book]{
+		Key: func(b *Book) map[string]types.AttributeValue {
+			return repo.key.MarshalKey(b.Id)
+		},
+		Marshal:   repo.codec.marshal,
+		Unmarshal: repo.codec.unmarshal,
+	})
+	repo.items.consistentReads = repo.consistentReads
+	if repo.keyTemplates {
+		repo.items.createCondition = notExistsCondition(repo.key.MarshalKey(0))
+	}
+	// Soft-delete filtering needs deletedAt even in projected reads, and
+	// upgrading items their item version.
+	for name := range repo.key.MarshalKey(0) {
+		repo.items.projected = append(repo.items.projected, name)
 	}
+	repo.items.projected = append(repo.items.projected, deletedAtAttribute, itemVersionAttribute)
+	return repo
 }
 
 func main() {
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("ap-southeast-1"))
-	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+	ctx, stop := lifecycle.SignalContext(context.Background())
+	defer stop()
+
+	if inLambda() {
+		if err := runLambda(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			stop()
+			os.Exit(1)
+		}
+		return
+	}
+	if err := runCLI(ctx, os.Args[1:], os.Stdout); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		stop()
+		os.Exit(1)
 	}
-	repo := NewDynamoDBBookRepository(cfg, "book")
-	useCase := NewBookUseCase(repo)
-	fmt.Println(useCase.List())
-	// Print a message to indicate the client was created successfully
-	fmt.Println("Successfully created DynamoDB client")
 }
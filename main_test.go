@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestDuplicateNames(t *testing.T) {
+	book := func(id int, name string) map[string]any {
+		return wireItem(t, &Book{Id: id, Name: name, Author: "Author", Version: 1})
+	}
+	stub := newDynamoStub(t, scanPages(t,
+		[]map[string]any{book(1, "Dune"), book(2, "Emma"), book(3, "Dune")},
+		[]map[string]any{book(4, "Ulysses"), book(5, "Emma"), book(6, "Dune")},
+	))
+	got, err := stub.repository().DuplicateNames(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ids := range got {
+		slices.Sort(ids)
+	}
+	want := map[string][]int{"Dune": {1, 3, 6}, "Emma": {2, 5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DuplicateNames() = %v, want %v", got, want)
+	}
+	if n := len(stub.callsTo("Scan")); n != 2 {
+		t.Errorf("%d Scan calls, want one per page", n)
+	}
+}
+
+func TestBackfillAttribute(t *testing.T) {
+	withAuthorLC := wireItem(t, &Book{Id: 2, Name: "Emma", Author: "Jane Austen", Version: 1})
+	withAuthorLC["author_lc"] = map[string]any{"S": "jane austen"}
+	items := []map[string]any{
+		wireItem(t, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 1}),
+		withAuthorLC,
+		wireItem(t, &Book{Id: 3, Name: "Anonymous", Version: 1}),
+		wireItem(t, &Book{Id: 4, Name: "Ulysses", Author: "James Joyce", Version: 1}),
+	}
+	scan := scanPages(t, items[:2], items[2:])
+	var mu sync.Mutex
+	written := map[int]string{}
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		if op != "UpdateItem" {
+			return scan(op, input)
+		}
+		in := decodeInput(t, input)
+		if in.ExpressionAttributeNames["#attr"] != "author_lc" || in.ConditionExpression != "attribute_not_exists(#attr)" {
+			t.Errorf("UpdateItem %s names %v, want a conditional SET of author_lc", in.ConditionExpression, in.ExpressionAttributeNames)
+		}
+		id := keyID(t, in.Key)
+		mu.Lock()
+		defer mu.Unlock()
+		written[id] = *in.ExpressionAttributeValues[":value"].S
+		if id == 4 {
+			// A concurrent writer set the attribute since the scan.
+			return nil, &stubError{Type: "ConditionalCheckFailedException", Message: "exists"}
+		}
+		return nil, nil
+	})
+
+	err := stub.repository().BackfillAttribute(context.Background(), "author_lc", func(b *Book) (any, bool) {
+		if b.Author == "" {
+			return nil, false
+		}
+		return strings.ToLower(b.Author), true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{1: "frank herbert", 4: "james joyce"}
+	if !reflect.DeepEqual(written, want) {
+		t.Errorf("backfilled %v, want %v: items having the attribute or refused by fn must be skipped", written, want)
+	}
+}
+
+func TestListLenientReportsCorruptItems(t *testing.T) {
+	corrupt := wireItem(t, &Book{Id: 2, Name: "Emma", Author: "Jane Austen", Version: 1})
+	corrupt["version"] = map[string]any{"S": "two"}
+	deleted := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	stub := newDynamoStub(t, scanPages(t,
+		[]map[string]any{wireItem(t, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 1}), corrupt},
+		[]map[string]any{
+			wireItem(t, &Book{Id: 3, Name: "Ulysses", Author: "James Joyce", Version: 1}),
+			wireItem(t, &Book{Id: 4, Name: "Gone", Author: "Someone", Version: 1, DeletedAt: &deleted}),
+		},
+	))
+
+	books, itemErrs, err := stub.repository().ListLenient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, b := range books {
+		ids = append(ids, b.Id)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Errorf("ListLenient returned books %v, want the valid, not deleted books 1 and 3 of both pages", ids)
+	}
+	if len(itemErrs) != 1 || !strings.Contains(itemErrs[0].Error(), "book 2") {
+		t.Errorf("ListLenient reported %v, want one error about book 2", itemErrs)
+	}
+}
+
+func TestDuplicateNamesSkipsDeletedBooks(t *testing.T) {
+	deleted := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	stub := newDynamoStub(t, scanPages(t, []map[string]any{
+		wireItem(t, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 1}),
+		wireItem(t, &Book{Id: 2, Name: "Dune", Author: "Frank Herbert", Version: 1, DeletedAt: &deleted}),
+	}))
+	got, err := stub.repository().DuplicateNames(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("DuplicateNames() = %v, want none: the only other Dune is deleted", got)
+	}
+}
+
+func TestCreateAndUpdateNormalizeWhitespace(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name                 string
+		opts                 []BookUseCaseOption
+		wantName, wantAuthor string
+		wantUpdatedAuthor    string
+	}{
+		{"default", nil, "The Hobbit", "J. R. R. Tolkien", "J.R.R. Tolkien"},
+		{"WithRawStrings", []BookUseCaseOption{WithRawStrings()}, "  The   Hobbit", " J. R. R.  Tolkien ", "J.R.R.\tTolkien\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewMemoryBookRepository()
+			uc := NewBookUseCase(repo, tc.opts...)
+			book := &Book{Id: 1, Name: "  The   Hobbit", Author: " J. R. R.  Tolkien "}
+			if err := uc.createBook(ctx, book, ""); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := repo.GetById(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Name != tc.wantName || stored.Author != tc.wantAuthor {
+				t.Errorf("created book stored as %q by %q, want %q by %q", stored.Name, stored.Author, tc.wantName, tc.wantAuthor)
+			}
+
+			stored.Author = "J.R.R.\tTolkien\n"
+			if err := uc.Update(ctx, stored); err != nil {
+				t.Fatal(err)
+			}
+			updated, err := repo.GetById(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.Author != tc.wantUpdatedAuthor {
+				t.Errorf("updated author stored as %q, want %q", updated.Author, tc.wantUpdatedAuthor)
+			}
+		})
+	}
+}
+
+func TestBatchCreateNotifiesInsertsAndOverwrites(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryBookRepository()
+	if err := repo.Create(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	changes := map[int]ChangeType{}
+	uc := NewBookUseCase(repo, WithChangeHandler(ChangeHandlerFunc(func(ctx context.Context, change BookChange) error {
+		mu.Lock()
+		defer mu.Unlock()
+		changes[change.Id] = change.Type
+		return nil
+	})))
+
+	err := uc.BatchCreate(ctx, []*Book{
+		{Id: 1, Name: "Dune", Author: "Frank Herbert", Year: 1965},
+		{Id: 2, Name: "Emma", Author: "Jane Austen"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]ChangeType{1: ChangeModify, 2: ChangeInsert}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("notified %v, want %v", changes, want)
+	}
+}
+
+func TestBookUseCase(t *testing.T) {
+	dune := func() *Book { return &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"} }
+	for _, tc := range []struct {
+		name string
+		// setup prepares the fake before the use case runs.
+		setup func(t *testing.T, repo *FakeBookRepository)
+		run   func(ctx context.Context, uc *BookUseCase) error
+		// wantErr is the error run must fail with, or nil.
+		wantErr error
+		// wantCalls are the repository methods run must reach, in order.
+		wantCalls []string
+		// wantChanges are the changes run must notify, in order.
+		wantChanges []ChangeType
+	}{{
+		name: "create",
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			return uc.createBook(ctx, dune(), "")
+		},
+		wantCalls:   []string{"Create"},
+		wantChanges: []ChangeType{ChangeInsert},
+	}, {
+		name: "create invalid book",
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			return uc.createBook(ctx, &Book{Id: 1, Author: "Frank Herbert"}, "")
+		},
+		wantErr: ErrValidation,
+	}, {
+		name: "create existing book",
+		setup: func(t *testing.T, repo *FakeBookRepository) {
+			if err := repo.Store.Create(context.Background(), dune()); err != nil {
+				t.Fatal(err)
+			}
+		},
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			return uc.createBook(ctx, dune(), "")
+		},
+		wantErr:   ErrBookAlreadyExists,
+		wantCalls: []string{"Create"},
+	}, {
+		name: "get missing book",
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			_, err := uc.GetById(ctx, 1)
+			return err
+		},
+		wantErr:   ErrNotFound,
+		wantCalls: []string{"GetById"},
+	}, {
+		name: "upsert",
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			return uc.Upsert(ctx, dune())
+		},
+		wantCalls:   []string{"GetById", "Upsert"},
+		wantChanges: []ChangeType{ChangeModify},
+	}, {
+		name: "upsert invalid book",
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			return uc.Upsert(ctx, &Book{Id: 1, Name: "Dune"})
+		},
+		wantErr: ErrValidation,
+	}, {
+		name: "update",
+		setup: func(t *testing.T, repo *FakeBookRepository) {
+			if err := repo.Store.Create(context.Background(), dune()); err != nil {
+				t.Fatal(err)
+			}
+		},
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			book := dune()
+			book.Version = 1
+			return uc.Update(ctx, book)
+		},
+		wantCalls:   []string{"GetById", "Update"},
+		wantChanges: []ChangeType{ChangeModify},
+	}, {
+		name: "update missing book",
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			return uc.Update(ctx, dune())
+		},
+		wantErr:   ErrVersionConflict,
+		wantCalls: []string{"GetById", "Update"},
+	}, {
+		name: "update stale version",
+		setup: func(t *testing.T, repo *FakeBookRepository) {
+			repo.FailNext("Update", ErrVersionConflict)
+		},
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			book := dune()
+			book.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			return uc.Update(ctx, book)
+		},
+		wantErr:   ErrVersionConflict,
+		wantCalls: []string{"Update"},
+	}, {
+		name: "update invalid book",
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			return uc.Update(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Year: -1})
+		},
+		wantErr: ErrValidation,
+	}, {
+		name: "delete",
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			return uc.Delete(ctx, 1)
+		},
+		wantCalls:   []string{"Delete"},
+		wantChanges: []ChangeType{ChangeRemove},
+	}, {
+		name: "failed delete",
+		setup: func(t *testing.T, repo *FakeBookRepository) {
+			repo.FailNext("Delete", ErrConflict)
+		},
+		run: func(ctx context.Context, uc *BookUseCase) error {
+			return uc.Delete(ctx, 1)
+		},
+		wantErr:   ErrConflict,
+		wantCalls: []string{"Delete"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewFakeBookRepository()
+			if tc.setup != nil {
+				tc.setup(t, repo)
+			}
+			var changes []ChangeType
+			uc := NewBookUseCase(repo, WithChangeHandler(ChangeHandlerFunc(func(ctx context.Context, change BookChange) error {
+				changes = append(changes, change.Type)
+				return nil
+			})))
+
+			err := tc.run(context.Background(), uc)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			var calls []string
+			for _, c := range repo.Calls() {
+				calls = append(calls, c.Method)
+			}
+			if !slices.Equal(calls, tc.wantCalls) {
+				t.Errorf("repository calls %v, want %v", calls, tc.wantCalls)
+			}
+			if !slices.Equal(changes, tc.wantChanges) {
+				t.Errorf("notified %v, want %v", changes, tc.wantChanges)
+			}
+		})
+	}
+}
+
+func TestUpdatePreservesUnknownAttributes(t *testing.T) {
+	ctx := context.Background()
+	stub := newTableStub(t)
+	repo := stub.repository()
+	var codec bookCodec
+	item, err := codec.marshal(&Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Tags: []string{"sf"}, Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item["author_lc"] = &types.AttributeValueMemberS{Value: "frank herbert"}
+	if _, err := repo.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(stubTable), Item: item}); err != nil {
+		t.Fatal(err)
+	}
+
+	book, err := repo.GetById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Name, book.Tags = "Dune Messiah", nil
+	if err := repo.Update(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(stub.callsTo("PutItem")); n != 1 {
+		t.Errorf("%d PutItem calls, want only the raw put: Update must not replace the item", n)
+	}
+	for _, input := range stub.callsTo("UpdateItem") {
+		if in := decodeInput(t, input); in.ConditionExpression != "#version = :expected" || *in.ExpressionAttributeValues[":expected"].N != "1" {
+			t.Errorf("UpdateItem condition %q, want one on version 1", in.ConditionExpression)
+		}
+	}
+
+	out, err := repo.client.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(stubTable), Key: NumberKey(idAttribute).MarshalKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := out.Item["author_lc"].(*types.AttributeValueMemberS); !ok || got.Value != "frank herbert" {
+		t.Errorf("author_lc after Update = %v, want it preserved", out.Item["author_lc"])
+	}
+	if _, ok := out.Item["tags"]; ok {
+		t.Errorf("tags after clearing them = %v, want none", out.Item["tags"])
+	}
+	got, err := repo.GetById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Dune Messiah" || got.Author != "Frank Herbert" || got.Version != 2 {
+		t.Errorf("book after Update = %+v, want the new name at version 2", got)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryBookRepository is an in-process BookRepository backed by a map. It
+// mirrors the semantics of DynamoDbBookRepository (ErrNotFound for missing
+// books, conditional create and versioned update, unique ISBNs, cursor
+// pagination) so
+// BookUseCase and the HTTP handlers can be exercised without AWS. It is safe
+// for concurrent use.
+type MemoryBookRepository struct {
+	mu    sync.RWMutex
+	books map[int]*Book
+}
+
+func NewMemoryBookRepository() *MemoryBookRepository {
+	return &MemoryBookRepository{books: map[int]*Book{}}
+}
+
+// copyBook returns a deep copy so callers never share memory with the store.
+func copyBook(book *Book) *Book {
+	c := *book
+	if book.Tags != nil {
+		c.Tags = append([]string(nil), book.Tags...)
+	}
+	return &c
+}
+
+// Create implements BookRepository.
+func (m *MemoryBookRepository) Create(ctx context.Context, book *Book) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.books[book.Id]; ok {
+		return ErrBookAlreadyExists
+	}
+	if m.isbnTaken(book) {
+		return ErrISBNTaken
+	}
+	book.Version = 1
+	m.books[book.Id] = copyBook(book)
+	return nil
+}
+
+// Upsert implements BookRepository.
+func (m *MemoryBookRepository) Upsert(ctx context.Context, book *Book) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.isbnTaken(book) {
+		return ErrISBNTaken
+	}
+	book.Version++
+	m.books[book.Id] = copyBook(book)
+	return nil
+}
+
+// GetById implements BookRepository.
+func (m *MemoryBookRepository) GetById(ctx context.Context, id int) (*Book, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	book, ok := m.books[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyBook(book), nil
+}
+
+// Update implements BookRepository.
+func (m *MemoryBookRepository) Update(ctx context.Context, book *Book) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.books[book.Id]
+	if !ok || stored.Version != book.Version {
+		return ErrVersionConflict
+	}
+	if m.isbnTaken(book) {
+		return ErrISBNTaken
+	}
+	book.Version++
+	m.books[book.Id] = copyBook(book)
+	return nil
+}
+
+// Delete implements BookRepository.
+func (m *MemoryBookRepository) Delete(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.books, id)
+	return nil
+}
+
+// List implements BookRepository.
+func (m *MemoryBookRepository) List(ctx context.Context) ([]*Book, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sorted(), nil
+}
+
+// ListPage implements BookRepository. Books are returned in id order.
+func (m *MemoryBookRepository) ListPage(ctx context.Context, limit int, cursor string) ([]*Book, string, error) {
+	key, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	after, hasAfter := 0, false
+	if key != nil {
+		if after, err = NumberKey(idAttribute).UnmarshalKey(key); err != nil {
+			return nil, "", fmt.Errorf("decode cursor: %w", err)
+		}
+		hasAfter = true
+	}
+
+	m.mu.RLock()
+	all := m.sorted()
+	m.mu.RUnlock()
+
+	start := 0
+	if hasAfter {
+		start = sort.Search(len(all), func(i int) bool { return all[i].Id > after })
+	}
+	end := len(all)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	page := all[start:end]
+	if end == len(all) {
+		return page, "", nil
+	}
+	next, err := encodeCursor(NumberKey(idAttribute).MarshalKey(page[len(page)-1].Id))
+	return page, next, err
+}
+
+// ListSorted implements BookRepository.
+func (m *MemoryBookRepository) ListSorted(ctx context.Context, opts ListOptions) ([]*Book, string, error) {
+	m.mu.RLock()
+	all := m.sorted()
+	m.mu.RUnlock()
+	return sortedPage(all, opts)
+}
+
+// GetByAuthor implements BookRepository.
+func (m *MemoryBookRepository) GetByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	books := []*Book{}
+	for _, book := range m.sorted() {
+		if book.Author == author {
+			books = append(books, book)
+		}
+	}
+	return books, nil
+}
+
+// GetByISBN implements BookRepository.
+func (m *MemoryBookRepository) GetByISBN(ctx context.Context, isbn string) (*Book, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, book := range m.books {
+		if isbn != "" && book.ISBN == isbn {
+			return copyBook(book), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// BatchCreate implements BookRepository. It writes no book if one of them
+// has the ISBN of another.
+func (m *MemoryBookRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	overwritten := map[int]bool{}
+	for _, book := range books {
+		overwritten[book.Id] = true
+	}
+	owners := map[string]int{}
+	for _, book := range m.books {
+		if book.ISBN != "" && !overwritten[book.Id] {
+			owners[book.ISBN] = book.Id
+		}
+	}
+	for _, book := range books {
+		if book.ISBN == "" {
+			continue
+		}
+		if id, ok := owners[book.ISBN]; ok && id != book.Id {
+			return fmt.Errorf("book %d: %w", book.Id, ErrISBNTaken)
+		}
+		owners[book.ISBN] = book.Id
+	}
+	for _, book := range books {
+		if book.Version == 0 {
+			book.Version = 1
+		}
+		m.books[book.Id] = copyBook(book)
+	}
+	return nil
+}
+
+// BatchGet implements BookRepository.
+func (m *MemoryBookRepository) BatchGet(ctx context.Context, ids []int) ([]*Book, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	seen := map[int]bool{}
+	books := []*Book{}
+	for _, id := range ids {
+		book, ok := m.books[id]
+		if ok && !seen[id] {
+			seen[id] = true
+			books = append(books, copyBook(book))
+		}
+	}
+	return books, nil
+}
+
+// isbnTaken reports whether another book than book has its ISBN. The caller
+// must hold mu.
+func (m *MemoryBookRepository) isbnTaken(book *Book) bool {
+	if book.ISBN == "" {
+		return false
+	}
+	for _, other := range m.books {
+		if other.Id != book.Id && other.ISBN == book.ISBN {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns copies of all books in id order. The caller must hold mu.
+func (m *MemoryBookRepository) sorted() []*Book {
+	books := make([]*Book, 0, len(m.books))
+	for _, book := range m.books {
+		books = append(books, copyBook(book))
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].Id < books[j].Id })
+	return books
+}
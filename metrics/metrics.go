@@ -0,0 +1,320 @@
+// Package metrics keeps counters and histograms in a registry and serves
+// them in the Prometheus text exposition format, for scraping without an
+// OpenTelemetry collector.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram bucket upper bounds suited to request
+// latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// contentType is the media type of the text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	namePattern  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Registry holds metrics by name. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+// metric is a family of series sharing a name, help and label names.
+type metric interface {
+	describe() (help, kind string, labels []string)
+	write(w *bufio.Writer, name string)
+}
+
+// register adds the metric m returns under name, or returns the metric
+// already registered under name if it has the same kind and labels.
+func register[M metric](r *Registry, name, help string, labels []string, m func() M) (M, error) {
+	var zero M
+	if !namePattern.MatchString(name) {
+		return zero, fmt.Errorf("invalid metric name %q", name)
+	}
+	for _, l := range labels {
+		if !labelPattern.MatchString(l) || strings.HasPrefix(l, "__") || l == "le" {
+			return zero, fmt.Errorf("metric %s: invalid label name %q", name, l)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.metrics[name]; ok {
+		same, ok := existing.(M)
+		if _, _, existingLabels := existing.describe(); !ok || !slices.Equal(existingLabels, labels) {
+			return zero, fmt.Errorf("metric %s already registered with other labels or kind", name)
+		}
+		return same, nil
+	}
+	created := m()
+	r.metrics[name] = created
+	return created, nil
+}
+
+// series holds the series of a metric by their label values.
+type series[S any] struct {
+	mu     sync.Mutex
+	help   string
+	labels []string
+	// values is keyed by the label values joined by labelSeparator.
+	values map[string]*S
+}
+
+// labelSeparator joins label values into the keys of series. It cannot
+// occur in valid UTF-8.
+const labelSeparator = "\xff"
+
+func newSeries[S any](help string, labels []string) series[S] {
+	return series[S]{help: help, labels: slices.Clone(labels), values: map[string]*S{}}
+}
+
+// get returns the series with labelValues, creating it with newValue.
+func (s *series[S]) get(labelValues []string, newValue func() *S) *S {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %d label values for labels %v", len(labelValues), s.labels))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	if !ok {
+		v = newValue()
+		s.values[key] = v
+	}
+	return v
+}
+
+// each calls fn with the label pairs and value of every series, sorted by
+// label values. fn runs with the series locked.
+func (s *series[S]) each(fn func(labels string, v *S)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var values []string
+		if len(s.labels) > 0 {
+			values = strings.Split(key, labelSeparator)
+		}
+		fn(formatLabels(s.labels, values), s.values[key])
+	}
+}
+
+// Counter is a family of counters partitioned by label values.
+type Counter struct {
+	series[float64]
+}
+
+// NewCounter registers a counter. Counters whose values only make sense in
+// total, such as request counts, should be named with a _total suffix.
+func (r *Registry) NewCounter(name, help string, labels ...string) (*Counter, error) {
+	return register(r, name, help, labels, func() *Counter {
+		return &Counter{series: newSeries[float64](help, labels)}
+	})
+}
+
+// Add increases the counter with labelValues, which must match the
+// counter's labels in number, by v. Negative values are ignored, as
+// counters only go up.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 || math.IsNaN(v) {
+		return
+	}
+	n := c.get(labelValues, func() *float64 { return new(float64) })
+	c.mu.Lock()
+	*n += v
+	c.mu.Unlock()
+}
+
+// Inc increases the counter with labelValues by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) describe() (string, string, []string) {
+	return c.help, "counter", c.labels
+}
+
+func (c *Counter) write(w *bufio.Writer, name string) {
+	c.each(func(labels string, v *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(*v))
+	})
+}
+
+// Histogram is a family of histograms partitioned by label values.
+type Histogram struct {
+	series[histogram]
+	buckets []float64
+}
+
+type histogram struct {
+	// counts holds the observations per bucket, not cumulated, with the
+	// +Inf bucket last.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, or
+// DefaultBuckets if there are none.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) (*Histogram, error) {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	if !slices.IsSorted(buckets) {
+		return nil, fmt.Errorf("metric %s: buckets are not sorted", name)
+	}
+	return register(r, name, help, labels, func() *Histogram {
+		return &Histogram{series: newSeries[histogram](help, labels), buckets: slices.Clone(buckets)}
+	})
+}
+
+// Observe records v in the histogram with labelValues, which must match the
+// histogram's labels in number.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	s := h.get(labelValues, func() *histogram {
+		return &histogram{counts: make([]uint64, len(h.buckets)+1)}
+	})
+	i, _ := slices.BinarySearch(h.buckets, v)
+	h.mu.Lock()
+	s.counts[i]++
+	s.sum += v
+	s.count++
+	h.mu.Unlock()
+}
+
+func (h *Histogram) describe() (string, string, []string) {
+	return h.help, "histogram", h.labels
+}
+
+func (h *Histogram) write(w *bufio.Writer, name string) {
+	h.each(func(labels string, s *histogram) {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labels, s.count)
+	})
+}
+
+// WriteTo writes every metric in the text exposition format, sorted by
+// name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for i, m := range metrics {
+		help, kind, _ := m.describe()
+		fmt.Fprintf(bw, "# HELP %s %s\n", names[i], helpEscaper.Replace(help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", names[i], kind)
+		m.write(bw, names[i])
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler returns a handler serving the metrics of r to Prometheus scrapes.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		r.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// formatLabels returns the {name="value",...} part of a series, or nothing
+// for a series without labels.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, valueEscaper.Replace(values[i]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// withLabel adds the label name="value" to formatted labels.
+func withLabel(labels, name, value string) string {
+	pair := fmt.Sprintf(`%s="%s"`, name, value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
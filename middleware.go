@@ -0,0 +1,159 @@
+package main
+
+import "context"
+
+// RepositoryMiddleware wraps a BookRepository to add a cross-cutting concern,
+// such as logging or retries, around its operations.
+type RepositoryMiddleware func(BookRepository) BookRepository
+
+// Chain wraps repo in mws. The first middleware is the outermost one, so it
+// sees every call first and its result last:
+//
+//	Chain(repo, Logging(logger, table), Tracing(table))
+//
+// logs calls whose spans are already finished.
+func Chain(repo BookRepository, mws ...RepositoryMiddleware) BookRepository {
+	for i := len(mws) - 1; i >= 0; i-- {
+		repo = mws[i](repo)
+	}
+	return repo
+}
+
+// AroundFunc runs the repository operation op by calling fn, once or more,
+// with the context fn should use. It returns the operation's error.
+type AroundFunc func(ctx context.Context, op string, fn func(context.Context) error) error
+
+// Around returns a middleware that runs every operation through around,
+// for concerns that do not depend on the operation's arguments. Each call of
+// fn resets the version of the books being written, so an AroundFunc may
+// retry the operation as if it were the first attempt.
+func Around(around AroundFunc) RepositoryMiddleware {
+	return func(next BookRepository) BookRepository {
+		return &aroundRepository{next: next, around: around}
+	}
+}
+
+type aroundRepository struct {
+	next   BookRepository
+	around AroundFunc
+}
+
+// keepVersions returns a function restoring the current versions of books,
+// which writes overwrite.
+func keepVersions(books ...*Book) func() {
+	versions := make([]int, len(books))
+	for i, b := range books {
+		versions[i] = b.Version
+	}
+	return func() {
+		for i, b := range books {
+			b.Version = versions[i]
+		}
+	}
+}
+
+// Create implements BookRepository.
+func (a *aroundRepository) Create(ctx context.Context, book *Book) error {
+	restore := keepVersions(book)
+	return a.around(ctx, "Create", func(ctx context.Context) error {
+		restore()
+		return a.next.Create(ctx, book)
+	})
+}
+
+// Upsert implements BookRepository.
+func (a *aroundRepository) Upsert(ctx context.Context, book *Book) error {
+	restore := keepVersions(book)
+	return a.around(ctx, "Upsert", func(ctx context.Context) error {
+		restore()
+		return a.next.Upsert(ctx, book)
+	})
+}
+
+// GetById implements BookRepository.
+func (a *aroundRepository) GetById(ctx context.Context, id int) (book *Book, err error) {
+	err = a.around(ctx, "GetById", func(ctx context.Context) error {
+		book, err = a.next.GetById(ctx, id)
+		return err
+	})
+	return book, err
+}
+
+// Update implements BookRepository.
+func (a *aroundRepository) Update(ctx context.Context, book *Book) error {
+	restore := keepVersions(book)
+	return a.around(ctx, "Update", func(ctx context.Context) error {
+		restore()
+		return a.next.Update(ctx, book)
+	})
+}
+
+// Delete implements BookRepository.
+func (a *aroundRepository) Delete(ctx context.Context, id int) error {
+	return a.around(ctx, "Delete", func(ctx context.Context) error {
+		return a.next.Delete(ctx, id)
+	})
+}
+
+// List implements BookRepository.
+func (a *aroundRepository) List(ctx context.Context) (books []*Book, err error) {
+	err = a.around(ctx, "List", func(ctx context.Context) error {
+		books, err = a.next.List(ctx)
+		return err
+	})
+	return books, err
+}
+
+// ListPage implements BookRepository.
+func (a *aroundRepository) ListPage(ctx context.Context, limit int, cursor string) (books []*Book, next string, err error) {
+	err = a.around(ctx, "ListPage", func(ctx context.Context) error {
+		books, next, err = a.next.ListPage(ctx, limit, cursor)
+		return err
+	})
+	return books, next, err
+}
+
+// ListSorted implements BookRepository.
+func (a *aroundRepository) ListSorted(ctx context.Context, opts ListOptions) (books []*Book, next string, err error) {
+	err = a.around(ctx, "ListSorted", func(ctx context.Context) error {
+		books, next, err = a.next.ListSorted(ctx, opts)
+		return err
+	})
+	return books, next, err
+}
+
+// GetByAuthor implements BookRepository.
+func (a *aroundRepository) GetByAuthor(ctx context.Context, author string) (books []*Book, err error) {
+	err = a.around(ctx, "GetByAuthor", func(ctx context.Context) error {
+		books, err = a.next.GetByAuthor(ctx, author)
+		return err
+	})
+	return books, err
+}
+
+// GetByISBN implements BookRepository.
+func (a *aroundRepository) GetByISBN(ctx context.Context, isbn string) (book *Book, err error) {
+	err = a.around(ctx, "GetByISBN", func(ctx context.Context) error {
+		book, err = a.next.GetByISBN(ctx, isbn)
+		return err
+	})
+	return book, err
+}
+
+// BatchCreate implements BookRepository.
+func (a *aroundRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	restore := keepVersions(books...)
+	return a.around(ctx, "BatchCreate", func(ctx context.Context) error {
+		restore()
+		return a.next.BatchCreate(ctx, books)
+	})
+}
+
+// BatchGet implements BookRepository.
+func (a *aroundRepository) BatchGet(ctx context.Context, ids []int) (books []*Book, err error) {
+	err = a.around(ctx, "BatchGet", func(ctx context.Context) error {
+		books, err = a.next.BatchGet(ctx, ids)
+		return err
+	})
+	return books, err
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// openAPISpec is the OpenAPI 3 document of the BookHandler API. It is
+// written by hand and must be kept in step with the handler.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPIHandler serves the OpenAPI document of the book API, e.g. at
+// /openapi.json.
+func OpenAPIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPISpec)
+	})
+}
+
+// ValidateRequests returns a handler that checks the parameters and body of
+// requests to next against the OpenAPI document of the book API, replying
+// 400 with the problem of every invalid field instead of calling next:
+//
+//	{"type": "about:blank", "title": "Bad Request", "status": 400,
+//	 "detail": "invalid request", "code": "invalid_request",
+//	 "fields": {"query.limit": "must be at most 1000"}}
+//
+// Fields are named after where they are: path, query, header or body,
+// followed by the parameter name or the path into the body. Requests for
+// paths or methods the document does not describe are passed through.
+func ValidateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errs, err := bookAPI().validate(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_body", "invalid request body: "+err.Error())
+			return
+		}
+		if len(errs) > 0 {
+			writeProblem(w, newProblem(http.StatusBadRequest, "invalid_request", "invalid request").withFields(errs))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// openAPIDoc is the part of an OpenAPI document ValidateRequests uses.
+type openAPIDoc struct {
+	Paths      map[string]*apiPathItem `json:"paths"`
+	Components struct {
+		Parameters map[string]*apiParameter `json:"parameters"`
+		Schemas    map[string]*apiSchema    `json:"schemas"`
+	} `json:"components"`
+
+	// routes are the paths split into segments, most specific first.
+	routes []apiRoute
+}
+
+type apiPathItem struct {
+	Parameters []*apiParameter `json:"parameters"`
+	Get        *apiOperation   `json:"get"`
+	Post       *apiOperation   `json:"post"`
+	Put        *apiOperation   `json:"put"`
+	Delete     *apiOperation   `json:"delete"`
+}
+
+type apiOperation struct {
+	Parameters  []*apiParameter `json:"parameters"`
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema *apiSchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+type apiParameter struct {
+	Ref      string     `json:"$ref"`
+	Name     string     `json:"name"`
+	In       string     `json:"in"`
+	Required bool       `json:"required"`
+	Schema   *apiSchema `json:"schema"`
+}
+
+// apiSchema is the subset of the OpenAPI schema object the document uses.
+type apiSchema struct {
+	Ref         string                `json:"$ref"`
+	Type        string                `json:"type"`
+	Format      string                `json:"format"`
+	Properties  map[string]*apiSchema `json:"properties"`
+	Required    []string              `json:"required"`
+	Items       *apiSchema            `json:"items"`
+	Minimum     *float64              `json:"minimum"`
+	Maximum     *float64              `json:"maximum"`
+	MinLength   *int                  `json:"minLength"`
+	MaxLength   *int                  `json:"maxLength"`
+	Pattern     string                `json:"pattern"`
+	MaxItems    *int                  `json:"maxItems"`
+	UniqueItems bool                  `json:"uniqueItems"`
+	// AnyOf lists alternative schemas, one of which a value must match.
+	AnyOf []*apiSchema `json:"anyOf"`
+	// AdditionalProperties is false or a schema; absent means any.
+	AdditionalProperties json.RawMessage `json:"additionalProperties"`
+}
+
+type apiRoute struct {
+	segments []string
+	item     *apiPathItem
+}
+
+// bookAPI is the parsed openAPISpec.
+var bookAPI = sync.OnceValue(func() *openAPIDoc {
+	doc := new(openAPIDoc)
+	if err := json.Unmarshal(openAPISpec, doc); err != nil {
+		panic(fmt.Sprintf("parse openapi.json: %v", err))
+	}
+	for path, item := range doc.Paths {
+		doc.routes = append(doc.routes, apiRoute{segments: strings.Split(strings.Trim(path, "/"), "/"), item: item})
+	}
+	// Literal segments win over templates, e.g. /books/search over
+	// /books/{id}.
+	literals := func(r apiRoute) int {
+		n := 0
+		for _, s := range r.segments {
+			if !strings.HasPrefix(s, "{") {
+				n++
+			}
+		}
+		return n
+	}
+	sort.Slice(doc.routes, func(i, j int) bool { return literals(doc.routes[i]) > literals(doc.routes[j]) })
+	return doc
+})
+
+// match returns the path item of path and the values of its templated
+// segments, or nil.
+func (d *openAPIDoc) match(path string) (*apiPathItem, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, route := range d.routes {
+		if len(route.segments) != len(segments) {
+			continue
+		}
+		values := map[string]string{}
+		for i, s := range route.segments {
+			if name, ok := strings.CutPrefix(s, "{"); ok {
+				values[strings.TrimSuffix(name, "}")] = segments[i]
+			} else if s != segments[i] {
+				values = nil
+				break
+			}
+		}
+		if values != nil {
+			return route.item, values
+		}
+	}
+	return nil, nil
+}
+
+// validate returns the problems of r, or an error if its body cannot be
+// read. The body is left for the handler to read again.
+func (d *openAPIDoc) validate(r *http.Request) (FieldErrors, error) {
+	item, pathValues := d.match(r.URL.Path)
+	if item == nil {
+		return nil, nil
+	}
+	var op *apiOperation
+	switch r.Method {
+	case http.MethodGet:
+		op = item.Get
+	case http.MethodPost:
+		op = item.Post
+	case http.MethodPut:
+		op = item.Put
+	case http.MethodDelete:
+		op = item.Delete
+	}
+	if op == nil {
+		return nil, nil
+	}
+
+	errs := FieldErrors{}
+	query := r.URL.Query()
+	for _, p := range append(append([]*apiParameter(nil), item.Parameters...), op.Parameters...) {
+		p = d.parameter(p)
+		var raw string
+		var present bool
+		switch p.In {
+		case "path":
+			raw, present = pathValues[p.Name]
+		case "query":
+			raw, present = query.Get(p.Name), query.Has(p.Name)
+		case "header":
+			raw = r.Header.Get(p.Name)
+			present = raw != ""
+		}
+		field := p.In + "." + p.Name
+		switch {
+		case !present && p.Required:
+			errs[field] = "is required"
+		case present:
+			d.validateParameter(errs, field, raw, p.Schema)
+		}
+	}
+
+	if op.RequestBody != nil {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		media, ok := op.RequestBody.Content["application/json"]
+		switch {
+		case len(bytes.TrimSpace(data)) == 0:
+			if op.RequestBody.Required {
+				errs["body"] = "is required"
+			}
+		case ok:
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.UseNumber()
+			var body any
+			if err := dec.Decode(&body); err != nil {
+				errs["body"] = "must be valid JSON"
+				break
+			}
+			d.validateValue(errs, "body", body, media.Schema)
+		}
+	}
+	if len(errs) > 0 {
+		return errs, nil
+	}
+	return nil, nil
+}
+
+func (d *openAPIDoc) parameter(p *apiParameter) *apiParameter {
+	if name, ok := strings.CutPrefix(p.Ref, "#/components/parameters/"); ok {
+		return d.Components.Parameters[name]
+	}
+	return p
+}
+
+func (d *openAPIDoc) schema(s *apiSchema) *apiSchema {
+	if name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/"); ok {
+		return d.Components.Schemas[name]
+	}
+	return s
+}
+
+// validateParameter checks the raw value of a parameter, which is a string
+// that may stand for a number or boolean.
+func (d *openAPIDoc) validateParameter(errs FieldErrors, field, raw string, s *apiSchema) {
+	s = d.schema(s)
+	var value any = raw
+	switch s.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			errs[field] = "must be " + article(s.Type)
+			return
+		}
+		value = json.Number(raw)
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			errs[field] = "must be a boolean"
+			return
+		}
+		value = b
+	}
+	d.validateValue(errs, field, value, s)
+}
+
+// validateValue checks a decoded JSON value against s, recording at most
+// one problem per field.
+func (d *openAPIDoc) validateValue(errs FieldErrors, field string, value any, s *apiSchema) {
+	s = d.schema(s)
+	if len(s.AnyOf) > 0 {
+		var first FieldErrors
+		for _, alt := range s.AnyOf {
+			altErrs := FieldErrors{}
+			d.validateValue(altErrs, field, value, alt)
+			if len(altErrs) == 0 {
+				return
+			}
+			if first == nil {
+				first = altErrs
+			}
+		}
+		// Report why the value fails the first alternative.
+		for name, problem := range first {
+			errs[name] = problem
+		}
+		return
+	}
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			errs[field] = "must be an object"
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				errs[field+"."+name] = "is required"
+			}
+		}
+		for name, v := range obj {
+			if prop, ok := s.Properties[name]; ok {
+				d.validateValue(errs, field+"."+name, v, prop)
+				continue
+			}
+			switch extra := bytes.TrimSpace(s.AdditionalProperties); {
+			case string(extra) == "false":
+				errs[field+"."+name] = "is not allowed"
+			case len(extra) > 0 && extra[0] == '{':
+				var schema apiSchema
+				if err := json.Unmarshal(extra, &schema); err == nil {
+					d.validateValue(errs, field+"."+name, v, &schema)
+				}
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			errs[field] = "must be an array"
+			return
+		}
+		if s.MaxItems != nil && len(items) > *s.MaxItems {
+			errs[field] = fmt.Sprintf("must have at most %d entries", *s.MaxItems)
+			return
+		}
+		seen := map[string]bool{}
+		for i, v := range items {
+			d.validateValue(errs, fmt.Sprintf("%s[%d]", field, i), v, s.Items)
+			if s.UniqueItems {
+				key := fmt.Sprint(v)
+				if seen[key] {
+					errs[field] = fmt.Sprintf("contains %q more than once", key)
+					return
+				}
+				seen[key] = true
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			errs[field] = "must be a string"
+			return
+		}
+		n := utf8.RuneCountInString(str)
+		switch {
+		case s.MinLength != nil && n < *s.MinLength:
+			if *s.MinLength == 1 {
+				errs[field] = "must not be empty"
+			} else {
+				errs[field] = fmt.Sprintf("must be at least %d characters", *s.MinLength)
+			}
+		case s.MaxLength != nil && n > *s.MaxLength:
+			errs[field] = fmt.Sprintf("must be at most %d characters", *s.MaxLength)
+		case s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(str):
+			errs[field] = "must match " + s.Pattern
+		case s.Format == "date-time":
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				errs[field] = "must be an RFC 3339 date-time"
+			}
+		}
+	case "integer", "number":
+		num, ok := value.(json.Number)
+		if !ok {
+			errs[field] = "must be " + article(s.Type)
+			return
+		}
+		f, err := num.Float64()
+		if err != nil || s.Type == "integer" && f != math.Trunc(f) {
+			errs[field] = "must be " + article(s.Type)
+			return
+		}
+		switch {
+		case s.Minimum != nil && f < *s.Minimum:
+			errs[field] = fmt.Sprintf("must be at least %g", *s.Minimum)
+		case s.Maximum != nil && f > *s.Maximum:
+			errs[field] = fmt.Sprintf("must be at most %g", *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs[field] = "must be a boolean"
+		}
+	}
+}
+
+func article(typ string) string {
+	if typ == "integer" {
+		return "an integer"
+	}
+	return "a " + typ
+}
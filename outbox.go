@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"dynamoDBExample/events"
+)
+
+// Attributes of the outbox table, which is keyed by event id.
+const (
+	outboxLockedByAttribute    = "lockedBy"
+	outboxLockedUntilAttribute = "lockedUntil"
+)
+
+// outboxLease is how long a relay has to publish the events it claimed
+// before other relays may claim them again.
+const outboxLease = 30 * time.Second
+
+// outboxPageSize is the number of events a relay claims and publishes at a
+// time.
+const outboxPageSize = 100
+
+// outboxTableName returns the name of the table holding the outbox of the
+// books in bookTable.
+func outboxTableName(bookTable string) string {
+	return bookTable + "-outbox"
+}
+
+// outboxItem is an event waiting in the outbox.
+type outboxItem struct {
+	ID string `dynamodbav:"id"`
+	// Event is the JSON of the events.Event.
+	Event     string    `dynamodbav:"event"`
+	CreatedAt time.Time `dynamodbav:"createdAt"`
+	// LockedBy is the relay that claimed the event, until LockedUntil in
+	// Unix milliseconds.
+	LockedBy    string `dynamodbav:"lockedBy,omitempty"`
+	LockedUntil int64  `dynamodbav:"lockedUntil,omitempty"`
+}
+
+// Outbox is the transactional outbox of a book table. As the
+// ChangeRecorder of a RecordingRepository it stores the event of every
+// change in the transaction of the change, so that an event is published
+// if and only if its write was committed, without depending on the table's
+// stream. An OutboxRelay then publishes the events.
+type Outbox struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// NewOutbox returns the outbox of the table of books.
+func NewOutbox(books *DynamoDbBookRepository) *Outbox {
+	return &Outbox{client: books.client, table: outboxTableName(books.tableName)}
+}
+
+// RecordChange implements ChangeRecorder. The event gets a random ID, which
+// it keeps across redeliveries.
+func (o *Outbox) RecordChange(ctx context.Context, change BookChange) (*types.Put, error) {
+	event, err := changeEvent(change)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	item, err := attributevalue.MarshalMap(outboxItem{ID: event.ID, Event: string(data), CreatedAt: change.Time.UTC()})
+	if err != nil {
+		return nil, err
+	}
+	return &types.Put{TableName: aws.String(o.table), Item: item}, nil
+}
+
+// OutboxRelay publishes the events of an outbox. Each relay claims events
+// with a lease before publishing them and removes them once published, so
+// several relays can share an outbox. Delivery is at least once: events are
+// published again if a relay fails or its lease runs out before it removes
+// them, and consumers should discard duplicates by event ID, which SNS FIFO
+// topics do on their own. Events are published in the order they were
+// written within a page, but not across pages or relays.
+type OutboxRelay struct {
+	outbox    *Outbox
+	publisher events.Publisher
+	// owner identifies the relay in the locks of its claims.
+	owner string
+	now   func() time.Time
+}
+
+// NewOutboxRelay returns a relay publishing the events of outbox to p.
+func NewOutboxRelay(outbox *Outbox, p events.Publisher) (*OutboxRelay, error) {
+	owner, err := randomSuffix()
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxRelay{outbox: outbox, publisher: p, owner: owner, now: time.Now}, nil
+}
+
+// Run relays events every interval until ctx is done. Failures are logged
+// and retried at the next poll.
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.RelayOnce(ctx); err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "relay outbox events", "table", r.outbox.table, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce publishes the events in the outbox that no other relay has
+// claimed and returns how many it published.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	paginator := dynamodb.NewScanPaginator(r.outbox.client, &dynamodb.ScanInput{
+		TableName:                aws.String(r.outbox.table),
+		FilterExpression:         aws.String("attribute_not_exists(#until) OR #until < :now"),
+		ExpressionAttributeNames: map[string]string{"#until": outboxLockedUntilAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": unixMillis(r.now()),
+		},
+		ConsistentRead: aws.Bool(true),
+		Limit:          aws.Int32(outboxPageSize),
+	})
+	published := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return published, translateError(err)
+		}
+		var items []outboxItem
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return published, err
+		}
+		n, err := r.relay(ctx, items)
+		published += n
+		if err != nil {
+			return published, err
+		}
+	}
+	return published, nil
+}
+
+// relay claims, publishes and removes items.
+func (r *OutboxRelay) relay(ctx context.Context, items []outboxItem) (int, error) {
+	var claimed []outboxItem
+	for _, item := range items {
+		ok, err := r.claim(ctx, item.ID)
+		if err != nil {
+			r.release(ctx, claimed)
+			return 0, err
+		}
+		if ok {
+			claimed = append(claimed, item)
+		}
+	}
+	if len(claimed) == 0 {
+		return 0, nil
+	}
+	sort.SliceStable(claimed, func(i, j int) bool { return claimed[i].CreatedAt.Before(claimed[j].CreatedAt) })
+	batch := make([]events.Event, len(claimed))
+	for i, item := range claimed {
+		if err := json.Unmarshal([]byte(item.Event), &batch[i]); err != nil {
+			r.release(ctx, claimed)
+			return 0, fmt.Errorf("outbox event %s: %w", item.ID, err)
+		}
+	}
+	if err := r.publisher.Publish(ctx, batch...); err != nil {
+		// Released events are published again at the next poll, whether
+		// or not this attempt delivered them.
+		r.release(ctx, claimed)
+		return 0, fmt.Errorf("publish outbox events: %w", err)
+	}
+	for _, item := range claimed {
+		if err := r.remove(ctx, item.ID); err != nil {
+			return len(claimed), fmt.Errorf("remove published outbox event %s: %w", item.ID, err)
+		}
+	}
+	return len(claimed), nil
+}
+
+// claim locks the event for the lease, failing if another relay holds it
+// or it was removed.
+func (r *OutboxRelay) claim(ctx context.Context, id string) (bool, error) {
+	now := r.now()
+	_, err := r.outbox.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(r.outbox.table),
+		Key:                 outboxKey(id),
+		UpdateExpression:    aws.String("SET #by = :owner, #until = :until"),
+		ConditionExpression: aws.String("attribute_exists(#id) AND (attribute_not_exists(#until) OR #until < :now)"),
+		ExpressionAttributeNames: map[string]string{
+			"#id":    idAttribute,
+			"#by":    outboxLockedByAttribute,
+			"#until": outboxLockedUntilAttribute,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: r.owner},
+			":until": unixMillis(now.Add(outboxLease)),
+			":now":   unixMillis(now),
+		},
+	})
+	err = translateError(err)
+	if isConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// release unlocks the events the relay still holds, so that the next poll
+// retries them without waiting for the lease to run out. Failures are
+// ignored: the lease runs out anyway.
+func (r *OutboxRelay) release(ctx context.Context, items []outboxItem) {
+	for _, item := range items {
+		r.outbox.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:           aws.String(r.outbox.table),
+			Key:                 outboxKey(item.ID),
+			UpdateExpression:    aws.String("REMOVE #by, #until"),
+			ConditionExpression: aws.String("#by = :owner"),
+			ExpressionAttributeNames: map[string]string{
+				"#by":    outboxLockedByAttribute,
+				"#until": outboxLockedUntilAttribute,
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":owner": &types.AttributeValueMemberS{Value: r.owner},
+			},
+		})
+	}
+}
+
+// remove deletes a published event unless another relay has claimed it
+// since, which then publishes it again.
+func (r *OutboxRelay) remove(ctx context.Context, id string) error {
+	_, err := r.outbox.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(r.outbox.table),
+		Key:                      outboxKey(id),
+		ConditionExpression:      aws.String("#by = :owner"),
+		ExpressionAttributeNames: map[string]string{"#by": outboxLockedByAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: r.owner},
+		},
+	})
+	err = translateError(err)
+	if isConflict(err) {
+		return nil
+	}
+	return err
+}
+
+func outboxKey(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{idAttribute: &types.AttributeValueMemberS{Value: id}}
+}
+
+func unixMillis(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.UnixMilli(), 10)}
+}
+
+// outboxTableDefinition describes the outbox table of bookTable.
+func outboxTableDefinition(bookTable string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(outboxTableName(bookTable)),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(idAttribute), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(idAttribute), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
+
+// MigrateOutbox creates the outbox table of bookTable if needed. It is safe
+// to run repeatedly.
+func MigrateOutbox(ctx context.Context, client *dynamodb.Client, bookTable string) error {
+	return migrateTable(ctx, client, outboxTableDefinition(bookTable))
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"dynamoDBExample/events"
+)
+
+// outboxStub is a dynamoStub of an outbox table that checks the conditions
+// of the relay: claims need an existing event with no lease or an expired
+// one, releases and removals a lease of the relay.
+type outboxStub struct {
+	*dynamoStub
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+}
+
+func newOutboxStub(t *testing.T) *outboxStub {
+	s := &outboxStub{items: map[string]map[string]types.AttributeValue{}}
+	lockedUntil := func(item map[string]types.AttributeValue) (int64, bool) {
+		n, ok := item[outboxLockedUntilAttribute].(*types.AttributeValueMemberN)
+		if !ok {
+			return 0, false
+		}
+		until, _ := strconv.ParseInt(n.Value, 10, 64)
+		return until, true
+	}
+	millis := func(v *encodedAttribute) int64 {
+		n, _ := strconv.ParseInt(*v.N, 10, 64)
+		return n
+	}
+	lockedBy := func(item map[string]types.AttributeValue, owner *encodedAttribute) bool {
+		by, ok := item[outboxLockedByAttribute].(*types.AttributeValueMemberS)
+		return ok && by.Value == *owner.S
+	}
+	failed := &stubError{Type: "ConditionalCheckFailedException", Message: "The conditional request failed"}
+	s.dynamoStub = newDynamoStub(t, func(op string, input []byte) (any, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		in := decodeInput(t, input)
+		switch op {
+		case "Scan":
+			now := millis(in.ExpressionAttributeValues[":now"])
+			page := []map[string]any{}
+			for _, item := range s.items {
+				if until, ok := lockedUntil(item); !ok || until < now {
+					page = append(page, wireAttributes(t, item))
+				}
+			}
+			return map[string]any{"Items": page, "Count": len(page)}, nil
+		case "UpdateItem":
+			id := *in.Key[idAttribute].S
+			item, ok := s.items[id]
+			if strings.HasPrefix(in.UpdateExpression, "SET") {
+				// A claim.
+				until, locked := lockedUntil(item)
+				if !ok || locked && until >= millis(in.ExpressionAttributeValues[":now"]) {
+					return nil, failed
+				}
+			} else if !ok || !lockedBy(item, in.ExpressionAttributeValues[":owner"]) {
+				return nil, failed
+			}
+			s.items[id] = applyUpdate(t, item, in)
+			return nil, nil
+		case "DeleteItem":
+			id := *in.Key[idAttribute].S
+			if !lockedBy(s.items[id], in.ExpressionAttributeValues[":owner"]) {
+				return nil, failed
+			}
+			delete(s.items, id)
+			return nil, nil
+		}
+		return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+	})
+	return s
+}
+
+// record stores the outbox event of a change of book in s.
+func (s *outboxStub) record(t *testing.T, outbox *Outbox, book *Book, at time.Time) string {
+	t.Helper()
+	put, err := outbox.RecordChange(context.Background(), BookChange{Type: ChangeInsert, Id: book.Id, New: book, Time: at})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := put.Item[idAttribute].(*types.AttributeValueMemberS).Value
+	s.items[id] = put.Item
+	return id
+}
+
+// stored returns the outbox item of event id, or nil.
+func (s *outboxStub) stored(id string) map[string]types.AttributeValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.items[id]
+}
+
+// publisherFunc adapts a function to events.Publisher.
+type publisherFunc func(ctx context.Context, batch ...events.Event) error
+
+func (f publisherFunc) Publish(ctx context.Context, batch ...events.Event) error {
+	return f(ctx, batch...)
+}
+
+// testRelay returns a relay of the outbox of stub whose clock is now.
+func testRelay(t *testing.T, stub *outboxStub, now *time.Time, p events.Publisher) *OutboxRelay {
+	t.Helper()
+	relay, err := NewOutboxRelay(NewOutbox(stub.repository()), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay.now = func() time.Time { return *now }
+	return relay
+}
+
+func TestOutboxRelayPublishesAndRemovesEvents(t *testing.T) {
+	stub := newOutboxStub(t)
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	var published []events.Event
+	relay := testRelay(t, stub, &now, publisherFunc(func(ctx context.Context, batch ...events.Event) error {
+		published = append(published, batch...)
+		return nil
+	}))
+	second := stub.record(t, relay.outbox, &Book{Id: 2, Name: "Emma", Author: "Jane Austen"}, now.Add(-time.Minute))
+	first := stub.record(t, relay.outbox, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}, now.Add(-2*time.Minute))
+
+	n, err := relay.RelayOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(published) != 2 || published[0].ID != first || published[1].ID != second {
+		t.Fatalf("published %d events %v, want %s and %s in the order written", n, published, first, second)
+	}
+	if published[0].BookID != 1 || published[0].Type != events.BookCreated {
+		t.Errorf("first event %+v, want the creation of book 1", published[0])
+	}
+	for _, id := range []string{first, second} {
+		if item := stub.stored(id); item != nil {
+			t.Errorf("published event %s left in the outbox: %v", id, item)
+		}
+	}
+}
+
+func TestOutboxRelayReclaimsExpiredLeases(t *testing.T) {
+	stub := newOutboxStub(t)
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	var published []string
+	var other *OutboxRelay
+	// The first relay stalls while publishing until its lease has run out
+	// and the other relay has claimed and published the event again.
+	stalled := testRelay(t, stub, &now, publisherFunc(func(ctx context.Context, batch ...events.Event) error {
+		if n, err := other.RelayOnce(ctx); n != 0 || err != nil {
+			t.Errorf("other relay published %d events, %v, during the lease, want none", n, err)
+		}
+		now = now.Add(outboxLease + time.Second)
+		if n, err := other.RelayOnce(ctx); n != 1 || err != nil {
+			t.Errorf("other relay published %d events, %v, want the one whose lease expired", n, err)
+		}
+		published = append(published, "stalled:"+batch[0].ID)
+		return nil
+	}))
+	other = testRelay(t, stub, &now, publisherFunc(func(ctx context.Context, batch ...events.Event) error {
+		published = append(published, "other:"+batch[0].ID)
+		return nil
+	}))
+	id := stub.record(t, stalled.outbox, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}, now)
+
+	if _, err := stalled.RelayOnce(context.Background()); err != nil {
+		t.Fatalf("stalled relay: %v, want its removal of a reclaimed event to be skipped", err)
+	}
+	// Delivery is at least once: both relays published the event.
+	if want := []string{"other:" + id, "stalled:" + id}; strings.Join(published, ",") != strings.Join(want, ",") {
+		t.Errorf("published %v, want %v", published, want)
+	}
+	if item := stub.stored(id); item != nil {
+		t.Errorf("event left in the outbox: %v", item)
+	}
+}
+
+func TestOutboxRelayReleasesEventsItFailedToPublish(t *testing.T) {
+	stub := newOutboxStub(t)
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	unavailable := errors.New("topic unavailable")
+	publishErr := unavailable
+	relay := testRelay(t, stub, &now, publisherFunc(func(ctx context.Context, batch ...events.Event) error {
+		return publishErr
+	}))
+	id := stub.record(t, relay.outbox, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}, now)
+
+	if n, err := relay.RelayOnce(context.Background()); n != 0 || !errors.Is(err, unavailable) {
+		t.Fatalf("RelayOnce = %d, %v, want 0 and the publish error", n, err)
+	}
+	item := stub.stored(id)
+	if item == nil {
+		t.Fatal("event removed although it was not published")
+	}
+	for _, attr := range []string{outboxLockedByAttribute, outboxLockedUntilAttribute} {
+		if _, ok := item[attr]; ok {
+			t.Errorf("event still has %s = %v, want its lease released", attr, item[attr])
+		}
+	}
+
+	// Released at once, the event is retried by the next poll, before the
+	// lease would have run out.
+	publishErr = nil
+	if n, err := relay.RelayOnce(context.Background()); n != 1 || err != nil {
+		t.Fatalf("retry: RelayOnce = %d, %v, want the event published", n, err)
+	}
+	if stub.stored(id) != nil {
+		t.Error("event left in the outbox after it was published")
+	}
+}
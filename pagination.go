@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ListPage scans at most limit books starting after cursor. An empty cursor
+// starts from the beginning of the table. The returned cursor resumes the
+// scan on the next call and is empty once the last page has been read.
+// Soft-deleted books are dropped after the scan, so a page may hold fewer
+// than limit books even when more follow.
+func (d *DynamoDbBookRepository) ListPage(ctx context.Context, limit int, cursor string) ([]*Book, string, error) {
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	input := &dynamodb.ScanInput{
+		TableName:         aws.String(d.tableName),
+		ExclusiveStartKey: startKey,
+		ConsistentRead:    consistentRead(ctx, d.consistentReads),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int32(int32(limit))
+	}
+	result, err := d.client.Scan(ctx, input)
+	if err != nil {
+		return nil, "", translateError(err)
+	}
+
+	books, err := d.codec.unmarshalList(result.Items)
+	if err != nil {
+		return nil, "", err
+	}
+	next, err := encodeCursor(result.LastEvaluatedKey)
+	return d.visible(books), next, err
+}
+
+// ListAll returns every book in the table, following scan pages until the
+// table is exhausted. Soft-deleted books are skipped.
+func (d *DynamoDbBookRepository) ListAll(ctx context.Context) ([]*Book, error) {
+	return d.List(ctx)
+}
+
+// cursorValue is the JSON form of a key attribute inside a cursor. Key
+// attributes can only be strings, numbers or binary.
+type cursorValue struct {
+	S *string `json:"s,omitempty"`
+	N *string `json:"n,omitempty"`
+	B []byte  `json:"b,omitempty"`
+}
+
+// encodeCursor turns a LastEvaluatedKey into an opaque URL-safe string.
+// Cursors are encrypted and authenticated, so clients can neither read the
+// table's key structure from them nor forge one.
+func encodeCursor(key map[string]types.AttributeValue) (string, error) {
+	return encodeScopedCursor(key, nil)
+}
+
+// encodeScopedCursor is encodeCursor for a cursor that only resumes the
+// read described by scope, e.g. a scan with a given filter:
+// decodeScopedCursor rejects it with any other scope.
+func encodeScopedCursor(key map[string]types.AttributeValue, scope []byte) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	values := make(map[string]cursorValue, len(key))
+	for name, av := range key {
+		switch v := av.(type) {
+		case *types.AttributeValueMemberS:
+			values[name] = cursorValue{S: &v.Value}
+		case *types.AttributeValueMemberN:
+			values[name] = cursorValue{N: &v.Value}
+		case *types.AttributeValueMemberB:
+			values[name] = cursorValue{B: v.Value}
+		default:
+			return "", fmt.Errorf("encode cursor: unsupported key attribute type %T for %q", av, name)
+		}
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return sealCursor(raw, scope)
+}
+
+// decodeCursor is the inverse of encodeCursor. An empty cursor yields a nil
+// key; a malformed or tampered one an error matching ErrValidation.
+func decodeCursor(cursor string) (map[string]types.AttributeValue, error) {
+	return decodeScopedCursor(cursor, nil)
+}
+
+// decodeScopedCursor is the inverse of encodeScopedCursor. A cursor issued
+// for another scope is rejected like a tampered one.
+func decodeScopedCursor(cursor string, scope []byte) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := openCursor(cursor, scope)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]cursorValue{}
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrValidation)
+	}
+	key := make(map[string]types.AttributeValue, len(values))
+	for name, v := range values {
+		switch {
+		case v.S != nil:
+			key[name] = &types.AttributeValueMemberS{Value: *v.S}
+		case v.N != nil:
+			key[name] = &types.AttributeValueMemberN{Value: *v.N}
+		case v.B != nil:
+			key[name] = &types.AttributeValueMemberB{Value: v.B}
+		default:
+			return nil, fmt.Errorf("%w: invalid cursor", ErrValidation)
+		}
+	}
+	return key, nil
+}
+
+// cursorSecretEnvVar names the environment variable holding the secret that
+// cursors are sealed with. Instances serving the same clients must share it;
+// if it is unset, a random secret is used and cursors only work within the
+// process that issued them.
+const cursorSecretEnvVar = "CURSOR_SECRET"
+
+// cursorAEAD seals and opens cursors with AES-256-GCM keyed by the SHA-256
+// of the cursor secret.
+var cursorAEAD = sync.OnceValue(func() cipher.AEAD {
+	secret := []byte(os.Getenv(cursorSecretEnvVar))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(fmt.Sprintf("generate cursor secret: %v", err))
+		}
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+})
+
+// sealCursor encrypts plaintext and returns it, prefixed with a random nonce,
+// as URL-safe base64. scope is authenticated along with it.
+func sealCursor(plaintext, scope []byte) (string, error) {
+	aead := cursorAEAD()
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, scope)), nil
+}
+
+// openCursor reverses sealCursor, rejecting cursors that were not issued
+// with the current secret and scope.
+func openCursor(cursor string, scope []byte) ([]byte, error) {
+	aead := cursorAEAD()
+	sealed, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrValidation)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, scope)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrValidation)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// QueryPartiQL runs a PartiQL statement, such as
+//
+//	SELECT * FROM "books" WHERE author = ?
+//
+// and returns the books it selects, following every page of the result.
+// params fill the statement's ? placeholders in order and are marshalled
+// with attributevalue.Marshal. It is meant for ad-hoc queries the repository has
+// no method for; a statement without a key condition scans the whole table.
+// Soft-deleted books are skipped unless the repository includes them.
+func (d *DynamoDbBookRepository) QueryPartiQL(ctx context.Context, stmt string, params ...any) ([]*Book, error) {
+	books := []*Book{}
+	cursor := ""
+	for {
+		page, next, err := d.QueryPartiQLPage(ctx, stmt, 0, cursor, params...)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, page...)
+		if next == "" {
+			return books, nil
+		}
+		cursor = next
+	}
+}
+
+// QueryPartiQLPage is like QueryPartiQL but runs a single page of the
+// statement: at most limit items, or one response's worth if limit is not
+// positive, starting at cursor. The returned cursor, DynamoDB's NextToken,
+// resumes the statement on the next call with the same statement and
+// params, and is empty once the last page has been read.
+func (d *DynamoDbBookRepository) QueryPartiQLPage(ctx context.Context, stmt string, limit int, cursor string, params ...any) ([]*Book, string, error) {
+	input := &dynamodb.ExecuteStatementInput{
+		Statement:      aws.String(stmt),
+		ConsistentRead: consistentRead(ctx, d.consistentReads),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int32(int32(limit))
+	}
+	if cursor != "" {
+		input.NextToken = aws.String(cursor)
+	}
+	if len(params) > 0 {
+		input.Parameters = make([]types.AttributeValue, len(params))
+		for i, p := range params {
+			av, err := attributevalue.Marshal(p)
+			if err != nil {
+				return nil, "", fmt.Errorf("%w: partiql parameter %d: %v", ErrValidation, i+1, err)
+			}
+			input.Parameters[i] = av
+		}
+	}
+	result, err := d.client.ExecuteStatement(ctx, input)
+	if err != nil {
+		return nil, "", translateError(err)
+	}
+	books, err := d.codec.unmarshalList(result.Items)
+	if err != nil {
+		return nil, "", err
+	}
+	return d.visible(books), aws.ToString(result.NextToken), nil
+}
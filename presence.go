@@ -0,0 +1,35 @@
+package main
+
+import "context"
+
+// Set is a set of comparable values.
+type Set[T comparable] map[T]struct{}
+
+// Has reports whether v is in s.
+func (s Set[T]) Has(v T) bool {
+	_, ok := s[v]
+	return ok
+}
+
+// GetByIdWithPresence is like GetById but also returns the names of the
+// attributes present in the stored item. Books read WithFields have zero
+// values both for attributes left out of the projection and for attributes
+// stored as zero; the set tells them apart.
+func (d *DynamoDbBookRepository) GetByIdWithPresence(ctx context.Context, id int) (*Book, Set[string], error) {
+	item, err := d.items.getItem(ctx, d.key.MarshalKey(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	book := new(Book)
+	if err := d.codec.unmarshal(item, book); err != nil {
+		return nil, nil, err
+	}
+	if book.DeletedAt != nil && !d.includeDeleted {
+		return nil, nil, ErrNotFound
+	}
+	present := make(Set[string], len(item))
+	for name := range item {
+		present[name] = struct{}{}
+	}
+	return book, present, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestGetByIdWithPresenceTellsAbsentFromZero(t *testing.T) {
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		// The projection asks for name, year and author; the item has no
+		// author and a zero year.
+		return map[string]any{"Item": map[string]any{
+			"id":      map[string]any{"N": "1"},
+			"name":    map[string]any{"S": "Dune"},
+			"year":    map[string]any{"N": "0"},
+			"version": map[string]any{"N": "3"},
+		}}, nil
+	})
+	ctx := withReadOptions(context.Background(), []ReadOption{WithFields("name", "year", "author")})
+
+	book, present, err := stub.repository().GetByIdWithPresence(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Name != "Dune" || book.Year != 0 || book.Author != "" {
+		t.Errorf("book = %+v", book)
+	}
+	for attr, want := range map[string]bool{"id": true, "name": true, "year": true, "version": true, "author": false, "tags": false} {
+		if present.Has(attr) != want {
+			t.Errorf("present.Has(%q) = %v, want %v", attr, !want, want)
+		}
+	}
+
+	in := decodeInput(t, stub.callsTo("GetItem")[0])
+	projected := in.projected()
+	for _, attr := range []string{"name", "year", "author"} {
+		if !slices.Contains(projected, attr) {
+			t.Errorf("projection %v misses %s", projected, attr)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// problemContentType is the media type of error responses.
+const problemContentType = "application/problem+json"
+
+// defaultRetryAfter is the Retry-After of throttled requests when the error
+// does not say how long to wait.
+const defaultRetryAfter = time.Second
+
+// Problem is the body of every error response of the HTTP API, an RFC 7807
+// problem details object:
+//
+//	{"type": "about:blank", "title": "Conflict", "status": 409,
+//	 "detail": "book version conflict", "instance": "/books/7",
+//	 "code": "version_conflict"}
+//
+// Code is stable and identifies the kind of error; clients should branch on
+// it rather than on Detail, which is meant for people. Fields, set for
+// invalid requests, holds what is wrong with each field.
+type Problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Code     string      `json:"code"`
+	Fields   FieldErrors `json:"fields,omitempty"`
+}
+
+// errorProblems maps domain errors to the status and code of their
+// responses, most specific first. An empty detail shows the error itself.
+var errorProblems = []struct {
+	err    error
+	status int
+	code   string
+	detail string
+}{
+	{ErrNotFound, http.StatusNotFound, "not_found", "book not found"},
+	{ErrVersionConflict, http.StatusConflict, "version_conflict", ""},
+	{ErrBookAlreadyExists, http.StatusConflict, "book_already_exists", ""},
+	{ErrIdempotencyKeyReused, http.StatusConflict, "idempotency_key_reused", ""},
+	{ErrISBNTaken, http.StatusConflict, "isbn_taken", ""},
+	{ErrNoCopyAvailable, http.StatusConflict, "no_copy_available", ""},
+	{ErrConflict, http.StatusConflict, "conflict", "conflict"},
+	{ErrRateLimited, http.StatusServiceUnavailable, "rate_limited", "rate limit exceeded, retry later"},
+	{ErrCircuitOpen, http.StatusServiceUnavailable, "circuit_open", "the database is failing, retry later"},
+	{ErrThrottled, http.StatusServiceUnavailable, "throttled", "throttled, retry later"},
+	{ErrNoRegionAvailable, http.StatusServiceUnavailable, "region_unavailable", ""},
+	{ErrUnknownRoute, http.StatusBadRequest, "unknown_route", ""},
+	{ErrValidation, http.StatusBadRequest, "invalid_request", "invalid request"},
+	{ErrUnauthenticated, http.StatusUnauthorized, "unauthenticated", ""},
+	{ErrForbidden, http.StatusForbidden, "forbidden", ""},
+	{ErrSearchUnavailable, http.StatusNotImplemented, "search_unavailable", ""},
+	{ErrCheckoutUnavailable, http.StatusNotImplemented, "checkout_unavailable", ""},
+}
+
+// problemFor returns the problem describing err, a 500 for errors without
+// an entry in errorProblems.
+func problemFor(err error) *Problem {
+	var fieldErrs FieldErrors
+	if errors.As(err, &fieldErrs) {
+		return newProblem(http.StatusBadRequest, "invalid_book", "invalid book").withFields(fieldErrs)
+	}
+	for _, p := range errorProblems {
+		if errors.Is(err, p.err) {
+			detail := p.detail
+			if detail == "" {
+				detail = err.Error()
+			}
+			return newProblem(p.status, p.code, detail)
+		}
+	}
+	return newProblem(http.StatusInternalServerError, "internal", "internal error")
+}
+
+func newProblem(status int, code, detail string) *Problem {
+	return &Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail, Code: code}
+}
+
+func (p *Problem) withFields(fields FieldErrors) *Problem {
+	p.Fields = fields
+	return p
+}
+
+// writeProblem replies with p.
+func writeProblem(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Printf("write response: %v", err)
+	}
+}
+
+// writeError replies with a problem of the given status, code and detail.
+func writeError(w http.ResponseWriter, status int, code, detail string) {
+	writeProblem(w, newProblem(status, code, detail))
+}
+
+// writeRepositoryError replies with the problem matching a domain error,
+// falling back to 500 for anything unexpected. Throttled requests are told
+// when to retry with Retry-After. The cause of every 5xx is logged with the
+// request, since the response hides it.
+func writeRepositoryError(w http.ResponseWriter, r *http.Request, err error) {
+	p := problemFor(err)
+	p.Instance = r.URL.Path
+	if errors.Is(err, ErrThrottled) || errors.Is(err, ErrNoRegionAvailable) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(err)))
+	}
+	if p.Status >= http.StatusInternalServerError {
+		// translateError already puts the request id in the message.
+		log.Printf("%s %s: %d %s: %v", r.Method, r.URL.Path, p.Status, p.Code, err)
+	}
+	writeProblem(w, p)
+}
+
+// retryAfterSeconds returns how many seconds a client should wait before
+// retrying after err, at least one.
+func retryAfterSeconds(err error) int {
+	after := defaultRetryAfter
+	var ra interface{ RetryAfter() time.Duration }
+	if errors.As(err, &ra) && ra.RetryAfter() > 0 {
+		after = ra.RetryAfter()
+	}
+	return max(int(math.Ceil(after.Seconds())), 1)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"dynamoDBExample/metrics"
+)
+
+// Prometheus returns a middleware recording, per repository operation on
+// table, the latency, the errors by type, the throttled calls and the
+// consumed capacity in reg. Capacity is only known when the decorated
+// repository is a DynamoDbBookRepository. It fails only if the metrics
+// cannot be registered.
+func Prometheus(reg *metrics.Registry, table string) (RepositoryMiddleware, error) {
+	latency, err := reg.NewHistogram("book_repository_operation_duration_seconds",
+		"Latency of repository operations.", nil, "table", "op")
+	if err != nil {
+		return nil, err
+	}
+	failures, err := reg.NewCounter("book_repository_errors_total",
+		"Number of repository operations that failed, by error type.", "table", "op", "type")
+	if err != nil {
+		return nil, err
+	}
+	throttles, err := reg.NewCounter("book_repository_throttles_total",
+		"Number of repository operations that failed throttled, by DynamoDB or the service's own limits.", "table", "op")
+	if err != nil {
+		return nil, err
+	}
+	capacity, err := reg.NewCounter("book_repository_consumed_capacity_units_total",
+		"Capacity units consumed by repository operations.", "table", "op")
+	if err != nil {
+		return nil, err
+	}
+	return Around(func(ctx context.Context, op string, fn func(context.Context) error) error {
+		ctx, rec := withCapacityRecorder(ctx)
+		start := time.Now()
+		err := fn(ctx)
+		latency.Observe(time.Since(start).Seconds(), table, op)
+		capacity.Add(rec.Units(), table, op)
+		if err != nil {
+			failures.Inc(table, op, errorType(err))
+			if errors.Is(err, ErrThrottled) {
+				throttles.Inc(table, op)
+			}
+		}
+		return err
+	}), nil
+}
+
+// RequestMetrics records the operations of a BookUseCase: how many there
+// were, by outcome, and how long they took.
+type RequestMetrics struct {
+	requests *metrics.Counter
+	latency  *metrics.Histogram
+}
+
+// NewRequestMetrics registers the use case metrics in reg.
+func NewRequestMetrics(reg *metrics.Registry) (*RequestMetrics, error) {
+	requests, err := reg.NewCounter("book_requests_total",
+		"Number of use case operations, by outcome: ok or the error type.", "op", "outcome")
+	if err != nil {
+		return nil, err
+	}
+	latency, err := reg.NewHistogram("book_request_duration_seconds",
+		"Latency of use case operations.", nil, "op")
+	if err != nil {
+		return nil, err
+	}
+	return &RequestMetrics{requests: requests, latency: latency}, nil
+}
+
+func (m *RequestMetrics) observe(op string, d time.Duration, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = errorType(err)
+	}
+	m.requests.Inc(op, outcome)
+	m.latency.Observe(d.Seconds(), op)
+}
+
+// errorType names the kind of err for metric labels, from the most
+// specific sentinel it matches.
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, ErrBookAlreadyExists):
+		return "already_exists"
+	case errors.Is(err, ErrISBNTaken):
+		return "isbn_taken"
+	case errors.Is(err, ErrVersionConflict):
+		return "version_conflict"
+	case errors.Is(err, ErrConflict):
+		return "conflict"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ErrThrottled):
+		return "throttled"
+	case errors.Is(err, ErrValidation):
+		return "validation"
+	case errors.Is(err, ErrUnauthenticated):
+		return "unauthenticated"
+	case errors.Is(err, ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "other"
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// Attribute and index names of the book table.
+const (
+	idAttribute      = "id"
+	authorAttribute  = "author"
+	versionAttribute = "version"
+	nameAttribute    = "name"
+	yearAttribute    = "year"
+	tagsAttribute    = "tags"
+	// deletedAtAttribute marks a book as soft-deleted.
+	deletedAtAttribute = "deletedAt"
+	// ttlAttribute holds the epoch second after which DynamoDB may delete
+	// the item.
+	ttlAttribute       = "expiresAt"
+	createdAtAttribute = "createdAt"
+	authorIndexName    = "author-index"
+	// listingAttribute is the partition key of the sort indexes. Every book
+	// holds listingPartition in it, so one query reads them all in order;
+	// this caps the write rate of the indexes at that of one partition.
+	listingAttribute    = "listing"
+	listingPartition    = "all"
+	nameSortIndexName   = "name-sort-index"
+	authorSortIndexName = "author-sort-index"
+	createdIndexName    = "created-index"
+)
+
+// tableActiveTimeout bounds how long Migrate waits for the table and its
+// indexes to become ACTIVE, unless given WithMaxWait.
+const tableActiveTimeout = 5 * time.Minute
+
+// MigrateOption configures Migrate.
+type MigrateOption func(*migrateConfig)
+
+type migrateConfig struct {
+	maxWait time.Duration
+}
+
+// WithMaxWait bounds how long Migrate waits for the table and its indexes to
+// become ACTIVE, after each change, before failing with ErrTableNotActive.
+// A deadline of the context bounds the wait further.
+func WithMaxWait(d time.Duration) MigrateOption {
+	return func(c *migrateConfig) {
+		c.maxWait = d
+	}
+}
+
+// bookTableDefinition describes the book table: a numeric id partition key,
+// a global secondary index keyed by author for GetByAuthor, one per SortBy
+// for ListSorted and a stream with old and new images for change consumers.
+func bookTableDefinition(tableName string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(idAttribute), AttributeType: types.ScalarAttributeTypeN},
+			{AttributeName: aws.String(authorAttribute), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String(nameAttribute), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String(createdAtAttribute), AttributeType: types.ScalarAttributeTypeN},
+			{AttributeName: aws.String(listingAttribute), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(idAttribute), KeyType: types.KeyTypeHash},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String(authorIndexName),
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String(authorAttribute), KeyType: types.KeyTypeHash},
+				},
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			},
+			sortIndexDefinition(nameSortIndexName, nameAttribute),
+			sortIndexDefinition(authorSortIndexName, authorAttribute),
+			sortIndexDefinition(createdIndexName, createdAtAttribute),
+		},
+		BillingMode: types.BillingModePayPerRequest,
+		StreamSpecification: &types.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: types.StreamViewTypeNewAndOldImages,
+		},
+	}
+}
+
+// sortIndexDefinition describes the index listing every book ordered by
+// sortAttribute.
+func sortIndexDefinition(name, sortAttribute string) types.GlobalSecondaryIndex {
+	return types.GlobalSecondaryIndex{
+		IndexName: aws.String(name),
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(listingAttribute), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(sortAttribute), KeyType: types.KeyTypeRange},
+		},
+		Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+	}
+}
+
+// Migrate brings the book table up to date: it creates the table if it does
+// not exist, adds any missing global secondary index and the stream, waits
+// for the table to become ACTIVE and enables TTL on ttlAttribute. It is safe
+// to run repeatedly.
+func Migrate(ctx context.Context, client *dynamodb.Client, tableName string, opts ...MigrateOption) error {
+	return migrateTable(ctx, client, bookTableDefinition(tableName), opts...)
+}
+
+// migrateTable creates or updates the table described by def.
+func migrateTable(ctx context.Context, client *dynamodb.Client, def *dynamodb.CreateTableInput, opts ...MigrateOption) error {
+	var config migrateConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.maxWait <= 0 {
+		config.maxWait = tableActiveTimeout
+	}
+	tableName := aws.ToString(def.TableName)
+	desc, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: def.TableName})
+	var notFound *types.ResourceNotFoundException
+	switch {
+	case errors.As(err, &notFound):
+		log.Printf("creating table %s", tableName)
+		if _, err := client.CreateTable(ctx, def); err != nil {
+			return fmt.Errorf("create table %s: %w", tableName, translateError(err))
+		}
+	case err != nil:
+		return fmt.Errorf("describe table %s: %w", tableName, translateError(err))
+	default:
+		if err := addMissingIndexes(ctx, client, def, desc.Table, config.maxWait); err != nil {
+			return err
+		}
+		if err := enableStream(ctx, client, def, desc.Table, config.maxWait); err != nil {
+			return err
+		}
+	}
+
+	if err := waitTableActive(ctx, client, tableName, config.maxWait); err != nil {
+		return err
+	}
+	return enableTTL(ctx, client, tableName)
+}
+
+// addMissingIndexes creates the indexes of def that table does not have yet.
+// DynamoDB only allows one index to be created per UpdateTable call, so each
+// is added and waited for in turn.
+func addMissingIndexes(ctx context.Context, client *dynamodb.Client, def *dynamodb.CreateTableInput, table *types.TableDescription, maxWait time.Duration) error {
+	existing := map[string]bool{}
+	for _, gsi := range table.GlobalSecondaryIndexes {
+		existing[aws.ToString(gsi.IndexName)] = true
+	}
+	for _, gsi := range def.GlobalSecondaryIndexes {
+		if existing[aws.ToString(gsi.IndexName)] {
+			continue
+		}
+		log.Printf("adding index %s to table %s", aws.ToString(gsi.IndexName), aws.ToString(def.TableName))
+		_, err := client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName:            def.TableName,
+			AttributeDefinitions: def.AttributeDefinitions,
+			GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+				{Create: &types.CreateGlobalSecondaryIndexAction{
+					IndexName:  gsi.IndexName,
+					KeySchema:  gsi.KeySchema,
+					Projection: gsi.Projection,
+				}},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("add index %s: %w", aws.ToString(gsi.IndexName), translateError(err))
+		}
+		if err := waitTableActive(ctx, client, aws.ToString(def.TableName), maxWait); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enableStream turns on the stream of def if table has none.
+func enableStream(ctx context.Context, client *dynamodb.Client, def *dynamodb.CreateTableInput, table *types.TableDescription, maxWait time.Duration) error {
+	if def.StreamSpecification == nil {
+		return nil
+	}
+	if spec := table.StreamSpecification; spec != nil && aws.ToBool(spec.StreamEnabled) {
+		return nil
+	}
+	log.Printf("enabling stream on table %s", aws.ToString(def.TableName))
+	_, err := client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName:           def.TableName,
+		StreamSpecification: def.StreamSpecification,
+	})
+	if err != nil {
+		return fmt.Errorf("enable stream on %s: %w", aws.ToString(def.TableName), translateError(err))
+	}
+	return waitTableActive(ctx, client, aws.ToString(def.TableName), maxWait)
+}
+
+// waitTableActive blocks until the table and all of its indexes are ACTIVE,
+// for at most maxWait. It fails with ErrTableNotActive once maxWait is over,
+// or with the error of ctx once that is done.
+func waitTableActive(ctx context.Context, client *dynamodb.Client, tableName string, maxWait time.Duration) error {
+	waiter := dynamodb.NewTableExistsWaiter(client, func(o *dynamodb.TableExistsWaiterOptions) {
+		// The waiter gives up rather than sleep past maxWait, so short
+		// waits poll more often than the default 20s.
+		o.MinDelay = min(o.MinDelay, max(maxWait/10, time.Millisecond))
+		retry := o.Retryable
+		o.Retryable = func(ctx context.Context, in *dynamodb.DescribeTableInput, out *dynamodb.DescribeTableOutput, err error) (bool, error) {
+			if err == nil && out.Table != nil {
+				for _, gsi := range out.Table.GlobalSecondaryIndexes {
+					if gsi.IndexStatus != types.IndexStatusActive {
+						return true, nil
+					}
+				}
+			}
+			return retry(ctx, in, out, err)
+		}
+	})
+	err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)}, maxWait)
+	var opErr *smithy.OperationError
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("wait for table %s: %w", tableName, ctx.Err())
+	case !errors.As(err, &opErr) || errors.Is(err, context.DeadlineExceeded):
+		// Past maxWait the waiter either stops polling or cancels the
+		// DescribeTable call in flight.
+		return fmt.Errorf("wait for table %s: %w after %s", tableName, ErrTableNotActive, maxWait)
+	}
+	return fmt.Errorf("wait for table %s: %w", tableName, err)
+}
+
+// enableTTL turns on time-to-live expiry on ttlAttribute unless it already is.
+func enableTTL(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	ttl, err := client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return fmt.Errorf("describe ttl of %s: %w", tableName, translateError(err))
+	}
+	if d := ttl.TimeToLiveDescription; d != nil && aws.ToString(d.AttributeName) == ttlAttribute &&
+		(d.TimeToLiveStatus == types.TimeToLiveStatusEnabled || d.TimeToLiveStatus == types.TimeToLiveStatusEnabling) {
+		return nil
+	}
+	_, err = client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(ttlAttribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("enable ttl on %s: %w", tableName, translateError(err))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// creatingTableStub answers as if the table was just created and never
+// leaves CREATING.
+func creatingTableStub(t *testing.T) *dynamoStub {
+	created := false
+	return newDynamoStub(t, func(op string, input []byte) (any, error) {
+		switch op {
+		case "DescribeTable":
+			if !created {
+				return nil, &stubError{Type: "ResourceNotFoundException", Message: "no table"}
+			}
+			return map[string]any{"Table": map[string]any{"TableName": stubTable, "TableStatus": "CREATING"}}, nil
+		case "CreateTable":
+			created = true
+			return map[string]any{"TableDescription": map[string]any{"TableName": stubTable, "TableStatus": "CREATING"}}, nil
+		}
+		return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+	})
+}
+
+func TestMigrateTimesOutWaitingForTheTable(t *testing.T) {
+	stub := creatingTableStub(t)
+	start := time.Now()
+	err := Migrate(context.Background(), stub.repository().client, stubTable, WithMaxWait(200*time.Millisecond))
+	if !errors.Is(err, ErrTableNotActive) {
+		t.Fatalf("Migrate = %v, want ErrTableNotActive", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Migrate returned after %s, want about the 200ms max wait", elapsed)
+	}
+	if polls := len(stub.callsTo("DescribeTable")); polls < 3 {
+		t.Errorf("%d DescribeTable calls, want the waiter to poll several times within the max wait", polls)
+	}
+}
+
+func TestMigrateHonorsTheContextDeadline(t *testing.T) {
+	stub := creatingTableStub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := Migrate(ctx, stub.repository().client, stubTable)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Migrate = %v, want the deadline of the context", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Migrate returned after %s, want about the 200ms deadline", elapsed)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"dynamoDBExample/config"
+	"dynamoDBExample/events"
+)
+
+// EventPublisher returns a handler publishing every book change to p as a
+// BookCreated, BookUpdated or BookDeleted event; soft deletions count as
+// deletions.
+//
+// Given to a BookUseCase WithChangeHandler, publishing is best effort: the
+// write has happened when the event is sent, and a failure is only logged.
+// Given to the StreamDispatcher of the table's stream, the stream acts as a
+// transactional outbox: an event is published if and only if its write was
+// committed, and failed records are retried, at the cost of duplicates,
+// which carry the same event ID. An Outbox gives the same guarantees
+// without the stream.
+func EventPublisher(p events.Publisher) ChangeHandler {
+	return ChangeHandlerFunc(func(ctx context.Context, change BookChange) error {
+		event, err := changeEvent(change)
+		if err != nil {
+			return err
+		}
+		return p.Publish(ctx, event)
+	})
+}
+
+// changeEvent converts change into an event. Stream records keep their
+// sequence number as event ID so that redeliveries can be recognized.
+func changeEvent(change BookChange) (events.Event, error) {
+	event := events.Event{ID: change.SequenceNumber, Time: time.Now().UTC(), BookID: change.Id}
+	if event.ID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return events.Event{}, fmt.Errorf("generate event id: %w", err)
+		}
+		event.ID = hex.EncodeToString(id)
+	}
+	switch {
+	case change.Type == ChangeRemove, change.New != nil && change.New.DeletedAt != nil:
+		event.Type = events.BookDeleted
+		return event, nil
+	case change.Type == ChangeInsert:
+		event.Type = events.BookCreated
+	default:
+		event.Type = events.BookUpdated
+	}
+	if change.New != nil {
+		book, err := json.Marshal(change.New)
+		if err != nil {
+			return events.Event{}, err
+		}
+		event.Book = book
+	}
+	return event, nil
+}
+
+// newEventPublisher returns the publisher configured by cfg, or nil if
+// events are disabled.
+func newEventPublisher(awsCfg aws.Config, cfg config.Config) events.Publisher {
+	switch {
+	case cfg.EventBus != "":
+		return events.NewEventBridgePublisher(awsCfg, cfg.EventBus, cfg.EventSource)
+	case cfg.EventTopicARN != "":
+		return events.NewSNSPublisher(awsCfg, cfg.EventTopicARN)
+	}
+	return nil
+}
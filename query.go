@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// GetByAuthor returns all books written by author by querying the author
+// index, rather than scanning the whole table. Soft-deleted books are
+// skipped unless the repository includes them.
+func (d *DynamoDbBookRepository) GetByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	keyCond := expression.Key(authorAttribute).Equal(expression.Value(author))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(d.tableName),
+		IndexName:                 aws.String(authorIndexName),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	books := []*Book{}
+	paginator := dynamodb.NewQueryPaginator(d.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		pageBooks, err := d.codec.unmarshalList(page.Items)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, d.visible(pageBooks)...)
+	}
+	return books, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// driveConcurrently runs Create, GetById, List, Update and Delete on repo
+// from several goroutines at once, on books of their own and on a book they
+// all share. Run with -race, it catches data races in the state shared by
+// the calls. NotFound and conflict errors are expected as goroutines race
+// for the shared book; any other error fails the test.
+func driveConcurrently(t *testing.T, repo BookRepository) {
+	const workers, rounds = 8, 20
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				for _, id := range []int{1, 1000 + w*rounds + r} {
+					if err := exercise(ctx, repo, id, fmt.Sprintf("Book %d.%d", w, r)); err != nil {
+						errs <- fmt.Errorf("book %d: %w", id, err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// exercise writes, reads, lists and deletes the book id.
+func exercise(ctx context.Context, repo BookRepository, id int, name string) error {
+	expected := func(err error) error {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) {
+			return nil
+		}
+		return err
+	}
+	if err := repo.Create(ctx, &Book{Id: id, Name: name, Author: "Author", Tags: []string{"race"}}); expected(err) != nil {
+		return err
+	}
+	book, err := repo.GetById(ctx, id)
+	if expected(err) != nil {
+		return err
+	}
+	if book != nil {
+		book.Name += " (revised)"
+		book.Tags = append(book.Tags, "revised")
+		if err := repo.Update(ctx, book); expected(err) != nil {
+			return err
+		}
+	}
+	if _, err := repo.List(ctx); err != nil {
+		return err
+	}
+	return expected(repo.Delete(ctx, id))
+}
+
+func TestRepositoriesAreSafeForConcurrentUse(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		repo func(t *testing.T) BookRepository
+	}{
+		{"memory", func(t *testing.T) BookRepository { return NewMemoryBookRepository() }},
+		{"cached", func(t *testing.T) BookRepository {
+			return NewCachedBookRepository(NewMemoryBookRepository(), NewLRUCache(16, time.Minute), WithListCache(time.Minute))
+		}},
+		{"middleware", func(t *testing.T) BookRepository {
+			return Chain(NewMemoryBookRepository(),
+				RateLimit(RateLimits{ReadsPerSecond: 1e6, WritesPerSecond: 1e6}),
+				Coalescing(time.Millisecond),
+				Caching(NewLRUCache(16, time.Minute)),
+			)
+		}},
+		{"buffered", func(t *testing.T) BookRepository {
+			w := NewBufferedWriter(NewMemoryBookRepository(), time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			t.Cleanup(func() { w.Close(context.Background()) })
+			return w
+		}},
+		{"dynamodb", func(t *testing.T) BookRepository {
+			return newTableStub(t).repository(
+				WithMarshalPooling(),
+				WithKeyTemplates(),
+				WithCapacityCollector(NewCapacityCollector(DefaultPricing)),
+				WithCallTimeout(time.Minute),
+			)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			driveConcurrently(t, tc.repo(t))
+		})
+	}
+}
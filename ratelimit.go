@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimitMode selects what a rate-limited call does when its tokens are
+// not available.
+type RateLimitMode string
+
+const (
+	// RateLimitWait makes calls wait for their tokens.
+	RateLimitWait RateLimitMode = "wait"
+	// RateLimitFailFast rejects calls with ErrRateLimited instead.
+	RateLimitFailFast RateLimitMode = "fail"
+)
+
+// RateLimits configures RateLimit. A rate of zero leaves that kind of
+// operation unlimited.
+type RateLimits struct {
+	// ReadsPerSecond is the rate of books read.
+	ReadsPerSecond float64
+	// WritesPerSecond is the rate of books written or deleted.
+	WritesPerSecond float64
+	// Burst is how long each bucket takes to fill up, so it holds the
+	// tokens of Burst at its rate when idle. Defaults to one second.
+	Burst time.Duration
+	Mode  RateLimitMode
+}
+
+// RateLimit returns a middleware that spends one token per book on a read
+// or write token bucket, so that bulk jobs sharing a table with production
+// traffic cannot exhaust its provisioned throughput. Batches cost their
+// number of books and are admitted whole, leaving the bucket in debt that
+// delays or rejects the calls after them until it is paid back. Lists and
+// queries cost one token up front and the books they return once they
+// complete, as their size is not known before.
+func RateLimit(limits RateLimits) RepositoryMiddleware {
+	burst := limits.Burst
+	if burst <= 0 {
+		burst = time.Second
+	}
+	failFast := limits.Mode == RateLimitFailFast
+	reads := newTokenBucket(limits.ReadsPerSecond, burst, failFast)
+	writes := newTokenBucket(limits.WritesPerSecond, burst, failFast)
+	return func(next BookRepository) BookRepository {
+		return &rateLimitedRepository{next: next, reads: reads, writes: writes}
+	}
+}
+
+// tokenBucket is a token bucket that may go into debt: tokens are taken
+// even if the bucket holds fewer, and the caller waits until the missing
+// ones have accrued. A fail-fast bucket instead refuses requests for more
+// tokens than it holds, but admits a request larger than the whole bucket
+// when the bucket is full.
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	failFast bool
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket refilled at rate tokens per second,
+// or nil, which admits everything, for a rate of zero.
+func newTokenBucket(rate float64, burst time.Duration, failFast bool) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	capacity := max(rate*burst.Seconds(), 1)
+	return &tokenBucket{rate: rate, capacity: capacity, failFast: failFast, tokens: capacity, last: time.Now()}
+}
+
+// refill adds the tokens accrued since the last call. b.mu must be held.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.capacity)
+	b.last = now
+}
+
+// take spends n tokens before a call, waiting for them unless the bucket is
+// fail-fast, in which case it returns ErrRateLimited, with the time until
+// enough tokens accrue, if fewer than n (or, for batches larger than the
+// bucket, a full bucket) are available.
+func (b *tokenBucket) take(ctx context.Context, n int) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	b.refill(time.Now())
+	need := float64(n)
+	if b.failFast && b.tokens < min(need, b.capacity) {
+		wait := time.Duration((min(need, b.capacity) - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		return &retryAfterError{err: ErrRateLimited, after: wait}
+	}
+	b.tokens -= need
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if b.failFast || wait <= 0 {
+		return nil
+	}
+	if err := sleepCtx(ctx, wait); err != nil {
+		// The call is abandoned, so its tokens go back.
+		b.charge(-n)
+		return err
+	}
+	return nil
+}
+
+// charge spends n tokens after a call, without waiting; the calls after it
+// pay for any debt.
+func (b *tokenBucket) charge(n int) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens = min(b.tokens-float64(n), b.capacity)
+}
+
+type rateLimitedRepository struct {
+	next          BookRepository
+	reads, writes *tokenBucket
+}
+
+// Create implements BookRepository.
+func (r *rateLimitedRepository) Create(ctx context.Context, book *Book) error {
+	if err := r.writes.take(ctx, 1); err != nil {
+		return err
+	}
+	return r.next.Create(ctx, book)
+}
+
+// Upsert implements BookRepository.
+func (r *rateLimitedRepository) Upsert(ctx context.Context, book *Book) error {
+	if err := r.writes.take(ctx, 1); err != nil {
+		return err
+	}
+	return r.next.Upsert(ctx, book)
+}
+
+// GetById implements BookRepository.
+func (r *rateLimitedRepository) GetById(ctx context.Context, id int) (*Book, error) {
+	if err := r.reads.take(ctx, 1); err != nil {
+		return nil, err
+	}
+	return r.next.GetById(ctx, id)
+}
+
+// Update implements BookRepository.
+func (r *rateLimitedRepository) Update(ctx context.Context, book *Book) error {
+	if err := r.writes.take(ctx, 1); err != nil {
+		return err
+	}
+	return r.next.Update(ctx, book)
+}
+
+// Delete implements BookRepository.
+func (r *rateLimitedRepository) Delete(ctx context.Context, id int) error {
+	if err := r.writes.take(ctx, 1); err != nil {
+		return err
+	}
+	return r.next.Delete(ctx, id)
+}
+
+// List implements BookRepository.
+func (r *rateLimitedRepository) List(ctx context.Context) ([]*Book, error) {
+	if err := r.reads.take(ctx, 1); err != nil {
+		return nil, err
+	}
+	books, err := r.next.List(ctx)
+	r.reads.charge(max(len(books)-1, 0))
+	return books, err
+}
+
+// ListPage implements BookRepository.
+func (r *rateLimitedRepository) ListPage(ctx context.Context, limit int, cursor string) ([]*Book, string, error) {
+	if err := r.reads.take(ctx, 1); err != nil {
+		return nil, "", err
+	}
+	books, next, err := r.next.ListPage(ctx, limit, cursor)
+	r.reads.charge(max(len(books)-1, 0))
+	return books, next, err
+}
+
+// ListSorted implements BookRepository.
+func (r *rateLimitedRepository) ListSorted(ctx context.Context, opts ListOptions) ([]*Book, string, error) {
+	if err := r.reads.take(ctx, 1); err != nil {
+		return nil, "", err
+	}
+	books, next, err := r.next.ListSorted(ctx, opts)
+	r.reads.charge(max(len(books)-1, 0))
+	return books, next, err
+}
+
+// GetByAuthor implements BookRepository.
+func (r *rateLimitedRepository) GetByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	if err := r.reads.take(ctx, 1); err != nil {
+		return nil, err
+	}
+	books, err := r.next.GetByAuthor(ctx, author)
+	r.reads.charge(max(len(books)-1, 0))
+	return books, err
+}
+
+// GetByISBN implements BookRepository. It reads the claim of the ISBN,
+// then the book.
+func (r *rateLimitedRepository) GetByISBN(ctx context.Context, isbn string) (*Book, error) {
+	if err := r.reads.take(ctx, 2); err != nil {
+		return nil, err
+	}
+	return r.next.GetByISBN(ctx, isbn)
+}
+
+// BatchCreate implements BookRepository.
+func (r *rateLimitedRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	if err := r.writes.take(ctx, len(books)); err != nil {
+		return err
+	}
+	return r.next.BatchCreate(ctx, books)
+}
+
+// BatchGet implements BookRepository.
+func (r *rateLimitedRepository) BatchGet(ctx context.Context, ids []int) ([]*Book, error) {
+	if err := r.reads.take(ctx, len(ids)); err != nil {
+		return nil, err
+	}
+	return r.next.BatchGet(ctx, ids)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxRecordAttempts bounds how often RecordingRepository rereads a book
+// that changed between its read and its recorded write.
+const maxRecordAttempts = 3
+
+// ChangeRecorder keeps a record of every change of a book, such as an audit
+// log or an outbox of events.
+type ChangeRecorder interface {
+	// RecordChange returns the write of the record of change, which a
+	// RecordingRepository commits along with the change. change.Old is nil
+	// for inserts and change.New nil for removals.
+	RecordChange(ctx context.Context, change BookChange) (*types.Put, error)
+}
+
+// RecordingRepository is a BookRepository that writes every change of a
+// book together with its records in a single TransactWriteItems call, so
+// the recorders hold exactly the committed writes. Each write first reads
+// the stored book, strongly consistently, for the records' before image,
+// and is made conditional on its version not having changed since.
+//
+// Writes bypassing the repository, such as idempotent creates and soft
+// deletions, are not recorded; record them from the stream instead.
+type RecordingRepository struct {
+	books     *DynamoDbBookRepository
+	recorders []ChangeRecorder
+}
+
+// NewRecordingRepository returns a repository recording the changes of
+// books with recorders. A transaction holds at most 100 items, one of them
+// the book and up to two its ISBN claim, so there can be up to 97 recorders.
+func NewRecordingRepository(books *DynamoDbBookRepository, recorders ...ChangeRecorder) *RecordingRepository {
+	return &RecordingRepository{books: books, recorders: recorders}
+}
+
+// Create implements BookRepository.
+func (r *RecordingRepository) Create(ctx context.Context, book *Book) error {
+	before, err := r.stored(ctx, book.Id)
+	if err != nil {
+		return err
+	}
+	if before != nil {
+		return ErrBookAlreadyExists
+	}
+	book.Version = 1
+	err = r.commit(ctx, ChangeInsert, book.Id, nil, book)
+	if errors.Is(err, errBookChanged) {
+		return ErrBookAlreadyExists
+	}
+	return err
+}
+
+// Upsert implements BookRepository.
+func (r *RecordingRepository) Upsert(ctx context.Context, book *Book) error {
+	return r.overwrite(ctx, book, book.Version+1)
+}
+
+// Update implements BookRepository. Like DynamoDbBookRepository.Update, it
+// fails with ErrVersionConflict unless book.Version is the stored version.
+func (r *RecordingRepository) Update(ctx context.Context, book *Book) error {
+	before, err := r.stored(ctx, book.Id)
+	if err != nil {
+		return err
+	}
+	if before == nil || before.Version != book.Version {
+		return ErrVersionConflict
+	}
+	after := copyBook(book)
+	after.Version++
+	err = r.commit(ctx, ChangeModify, book.Id, before, after)
+	if errors.Is(err, errBookChanged) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+	book.Version = after.Version
+	return nil
+}
+
+// Delete implements BookRepository. Deleting a missing book is not an error
+// and is not recorded.
+func (r *RecordingRepository) Delete(ctx context.Context, id int) error {
+	for attempt := 1; ; attempt++ {
+		before, err := r.stored(ctx, id)
+		if before == nil || err != nil {
+			return err
+		}
+		err = r.commit(ctx, ChangeRemove, id, before, nil)
+		if !errors.Is(err, errBookChanged) || attempt == maxRecordAttempts {
+			return err
+		}
+	}
+}
+
+// GetById implements BookRepository.
+func (r *RecordingRepository) GetById(ctx context.Context, id int) (*Book, error) {
+	return r.books.GetById(ctx, id)
+}
+
+// List implements BookRepository.
+func (r *RecordingRepository) List(ctx context.Context) ([]*Book, error) {
+	return r.books.List(ctx)
+}
+
+// ListPage implements BookRepository.
+func (r *RecordingRepository) ListPage(ctx context.Context, limit int, cursor string) ([]*Book, string, error) {
+	return r.books.ListPage(ctx, limit, cursor)
+}
+
+// ListSorted implements BookRepository.
+func (r *RecordingRepository) ListSorted(ctx context.Context, opts ListOptions) ([]*Book, string, error) {
+	return r.books.ListSorted(ctx, opts)
+}
+
+// GetByAuthor implements BookRepository.
+func (r *RecordingRepository) GetByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	return r.books.GetByAuthor(ctx, author)
+}
+
+// GetByISBN implements BookRepository.
+func (r *RecordingRepository) GetByISBN(ctx context.Context, isbn string) (*Book, error) {
+	return r.books.GetByISBN(ctx, isbn)
+}
+
+// BatchCreate implements BookRepository. Every book takes a read and a
+// transaction of its own, so it is much slower than the BatchWriteItem of
+// DynamoDbBookRepository's.
+func (r *RecordingRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	return ForEach(ctx, r.books.bulkWorkers, books, func(ctx context.Context, book *Book) (int, error) {
+		return 1, r.overwrite(ctx, book, max(book.Version, 1))
+	})
+}
+
+// BatchGet implements BookRepository.
+func (r *RecordingRepository) BatchGet(ctx context.Context, ids []int) ([]*Book, error) {
+	return r.books.BatchGet(ctx, ids)
+}
+
+// overwrite stores book at version, whatever is stored, and sets
+// book.Version on success.
+func (r *RecordingRepository) overwrite(ctx context.Context, book *Book, version int) error {
+	after := copyBook(book)
+	after.Version = version
+	for attempt := 1; ; attempt++ {
+		before, err := r.stored(ctx, book.Id)
+		if err != nil {
+			return err
+		}
+		action := ChangeModify
+		if before == nil {
+			action = ChangeInsert
+		}
+		err = r.commit(ctx, action, book.Id, before, after)
+		if err == nil {
+			book.Version = version
+			return nil
+		}
+		if !errors.Is(err, errBookChanged) || attempt == maxRecordAttempts {
+			return err
+		}
+	}
+}
+
+// stored returns the stored book, soft-deleted or not, or nil if there is
+// none.
+func (r *RecordingRepository) stored(ctx context.Context, id int) (*Book, error) {
+	ctx = withReadOptions(ctx, []ReadOption{WithConsistentRead()})
+	book, err := r.books.items.Get(ctx, r.books.key.MarshalKey(id))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return book, err
+}
+
+// errBookChanged is returned by commit when the book is no longer before.
+var errBookChanged error = &kindError{msg: "book changed while being recorded", kind: ErrConflict}
+
+// commit writes after, or deletes the book if after is nil, along with the
+// records of the change from before, provided before is still what is
+// stored. The ISBN of after is claimed in the same transaction.
+func (r *RecordingRepository) commit(ctx context.Context, action ChangeType, id int, before, after *Book) error {
+	var (
+		guard  string
+		names  map[string]string
+		values map[string]types.AttributeValue
+	)
+	switch {
+	case before == nil:
+		guard = "attribute_not_exists(#id)"
+		names = map[string]string{"#id": idAttribute}
+	case before.Version == 0:
+		// Books written before versioning was introduced have no version.
+		guard = "attribute_exists(#id) AND attribute_not_exists(#version)"
+		names = map[string]string{"#id": idAttribute, "#version": versionAttribute}
+	default:
+		guard = "#version = :version"
+		names = map[string]string{"#version": versionAttribute}
+		values = map[string]types.AttributeValue{
+			":version": &types.AttributeValueMemberN{Value: strconv.Itoa(before.Version)},
+		}
+	}
+	write := types.TransactWriteItem{}
+	if after != nil {
+		item, err := r.books.codec.marshal(after)
+		if err != nil {
+			return err
+		}
+		write.Put = &types.Put{
+			TableName:                 aws.String(r.books.tableName),
+			Item:                      item,
+			ConditionExpression:       aws.String(guard),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}
+	} else {
+		write.Delete = &types.Delete{
+			TableName:                 aws.String(r.books.tableName),
+			Key:                       r.books.key.MarshalKey(id),
+			ConditionExpression:       aws.String(guard),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}
+	}
+
+	change := BookChange{Type: action, Id: id, Old: before, Time: time.Now()}
+	if after != nil {
+		change.New = copyBook(after)
+	}
+	items := []types.TransactWriteItem{write}
+	for _, recorder := range r.recorders {
+		put, err := recorder.RecordChange(ctx, change)
+		if err != nil {
+			return err
+		}
+		items = append(items, types.TransactWriteItem{Put: put})
+	}
+
+	claimant := after
+	if claimant == nil {
+		claimant = &Book{Id: id}
+	}
+	label := fmt.Sprintf("write book %d", id)
+	err := r.books.transactClaimingISBN(ctx, claimant, items, []string{label})
+	var txErr *TransactionCanceledError
+	if errors.As(err, &txErr) && txErr.rejected(label) {
+		return errBookChanged
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Default application-level retry policy for batch operations.
+const (
+	defaultBatchMaxRetries = 5
+	defaultBatchBaseDelay  = 50 * time.Millisecond
+	defaultBatchMaxDelay   = 5 * time.Second
+)
+
+// RetryEvent describes a batch retry about to happen.
+type RetryEvent struct {
+	// Operation is the DynamoDB operation being retried, e.g. "BatchWriteItem".
+	Operation string
+	// Attempt counts retries, starting at 1.
+	Attempt int
+	// Delay is how long the repository waits before retrying.
+	Delay time.Duration
+	// Unprocessed is the number of items or keys being retried.
+	Unprocessed int
+	// Err is the throttling error that caused the retry, or nil when
+	// DynamoDB returned unprocessed items.
+	Err error
+}
+
+// batchRetryPolicy controls how batch operations retry throttled requests
+// and unprocessed items.
+type batchRetryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	hook       func(RetryEvent)
+}
+
+// WithMaxAttempts sets how many times the SDK tries each request, including
+// the first attempt.
+func WithMaxAttempts(n int) RepositoryOption {
+	return WithClientOptions(func(o *dynamodb.Options) {
+		o.RetryMaxAttempts = n
+	})
+}
+
+// WithAdaptiveRetry switches the SDK to its adaptive retry mode, which also
+// rate-limits the client when DynamoDB throttles it.
+func WithAdaptiveRetry() RepositoryOption {
+	return WithClientOptions(func(o *dynamodb.Options) {
+		o.RetryMode = aws.RetryModeAdaptive
+	})
+}
+
+// WithBatchBackoff configures retries of throttled batch requests and of
+// unprocessed items: up to maxRetries retries with exponential backoff from
+// baseDelay, capped at maxDelay, with full jitter.
+func WithBatchBackoff(maxRetries int, baseDelay, maxDelay time.Duration) RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.batchRetry.maxRetries = maxRetries
+		d.batchRetry.baseDelay = baseDelay
+		d.batchRetry.maxDelay = maxDelay
+	}
+}
+
+// WithRetryHook registers fn to be called before every batch retry, e.g. to
+// log or count retries.
+func WithRetryHook(fn func(RetryEvent)) RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.batchRetry.hook = fn
+	}
+}
+
+// Retry returns a middleware that retries repository operations failing with
+// ErrThrottled, up to maxRetries times with the same backoff as
+// WithBatchBackoff. It suits callers that would rather wait out a burst of
+// throttling than fail once the SDK's own retries are exhausted. Other errors
+// are returned immediately.
+func Retry(maxRetries int, baseDelay, maxDelay time.Duration) RepositoryMiddleware {
+	policy := batchRetryPolicy{maxRetries: maxRetries, baseDelay: baseDelay, maxDelay: maxDelay}
+	return Around(func(ctx context.Context, op string, fn func(context.Context) error) error {
+		return policy.run(ctx, op, func() (int, error) {
+			return 0, fn(ctx)
+		})
+	})
+}
+
+// delay returns the jittered backoff before retry number attempt (from 1).
+func (p batchRetryPolicy) delay(attempt int) time.Duration {
+	ceiling := p.maxDelay
+	if shift := attempt - 1; shift < 32 && p.baseDelay<<shift < ceiling {
+		ceiling = p.baseDelay << shift
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// run calls fn until it reports nothing left to do. fn returns the number of
+// unprocessed items and an error; throttling errors and unprocessed items
+// are retried with backoff, any other error is returned immediately.
+func (p batchRetryPolicy) run(ctx context.Context, op string, fn func() (int, error)) error {
+	for attempt := 1; ; attempt++ {
+		unprocessed, err := fn()
+		if err != nil && !isThrottled(err) {
+			return err
+		}
+		if err == nil && unprocessed == 0 {
+			return nil
+		}
+		if attempt > p.maxRetries {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%s: %d items still unprocessed after %d retries", op, unprocessed, p.maxRetries)
+		}
+		delay := p.delay(attempt)
+		if p.hook != nil {
+			p.hook(RetryEvent{Operation: op, Attempt: attempt, Delay: delay, Unprocessed: unprocessed, Err: err})
+		}
+		if err := sleepCtx(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepCtx waits for d or until ctx is done, whichever comes first.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Ratings a review can give.
+const (
+	minRating = 1
+	maxRating = 5
+)
+
+// maxAddReviewAttempts bounds how often AddReview retries when concurrent
+// reviews of the same book change its aggregate in the meantime.
+const maxAddReviewAttempts = 5
+
+// Attributes of the rating aggregate on a book item.
+const (
+	reviewCountAttribute   = "reviewCount"
+	ratingTotalAttribute   = "ratingTotal"
+	averageRatingAttribute = "averageRating"
+)
+
+// AddReview stores review in the item collection of its book and updates
+// the book's ReviewCount, RatingTotal and AverageRating in the same
+// transaction, so that the aggregate always matches the reviews stored. It
+// returns ErrNotFound if the book does not exist and ErrReviewAlreadyExists
+// if the book already has a review with the same id.
+func (s *SingleTable) AddReview(ctx context.Context, review *Review) error {
+	if review.Rating < minRating || review.Rating > maxRating {
+		return fmt.Errorf("%w: rating must be between %d and %d", ErrValidation, minRating, maxRating)
+	}
+	item, err := s.reviews.Marshal(review)
+	if err != nil {
+		return err
+	}
+	ctx = withReadOptions(ctx, []ReadOption{WithConsistentRead()})
+	for attempt := 1; ; attempt++ {
+		book, err := s.Books.Get(ctx, s.BookKey(review.BookId))
+		if err != nil {
+			return err
+		}
+		err = s.transactReview(ctx, item, book, review.Rating)
+		var canceled *types.TransactionCanceledException
+		if !errors.As(err, &canceled) {
+			return translateError(err)
+		}
+		if cancelled(canceled, 0) {
+			return ErrReviewAlreadyExists
+		}
+		if attempt == maxAddReviewAttempts {
+			return fmt.Errorf("update rating of book %d: %w", review.BookId, ErrConflict)
+		}
+		// Another review changed the aggregate since it was read.
+	}
+}
+
+// transactReview writes the review item and the book's aggregate including
+// rating, on condition that the aggregate is still the one read into book.
+func (s *SingleTable) transactReview(ctx context.Context, item map[string]types.AttributeValue, book *Book, rating int) error {
+	count, total := book.ReviewCount+1, book.RatingTotal+rating
+	unchanged := expression.Name(reviewCountAttribute).Equal(expression.Value(book.ReviewCount))
+	if book.ReviewCount == 0 {
+		unchanged = expression.Name(reviewCountAttribute).AttributeNotExists().Or(unchanged)
+	}
+	cond := expression.Name(partitionKeyAttribute).AttributeExists().And(unchanged)
+	update := expression.
+		Set(expression.Name(reviewCountAttribute), expression.Value(count)).
+		Set(expression.Name(ratingTotalAttribute), expression.Value(total)).
+		Set(expression.Name(averageRatingAttribute), expression.Value(float64(total)/float64(count)))
+	expr, err := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName:                aws.String(s.tableName),
+				Item:                     item,
+				ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+				ExpressionAttributeNames: map[string]string{"#pk": partitionKeyAttribute},
+			}},
+			{Update: &types.Update{
+				TableName:                 aws.String(s.tableName),
+				Key:                       s.BookKey(book.Id),
+				UpdateExpression:          expr.Update(),
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			}},
+		},
+	})
+	return err
+}
+
+// cancelled reports whether item i of a canceled transaction failed.
+func cancelled(err *types.TransactionCanceledException, i int) bool {
+	if i >= len(err.CancellationReasons) {
+		return false
+	}
+	code := aws.ToString(err.CancellationReasons[i].Code)
+	return code != "" && code != "None"
+}
+
+// ListReviews returns at most limit reviews of a book, in sort key order,
+// starting after cursor. It queries the book's item collection for the
+// items whose sort key starts with REVIEW#; ids are compared as strings,
+// so review 10 comes before review 2. An empty cursor starts from the
+// first review; the returned cursor is empty after the last page.
+func (s *SingleTable) ListReviews(ctx context.Context, bookID, limit int, cursor string) ([]*Review, string, error) {
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	keyCond := expression.Key(partitionKeyAttribute).Equal(expression.Value(bookPartitionPrefix + strconv.Itoa(bookID))).
+		And(expression.Key(sortKeyAttribute).BeginsWith(reviewSortPrefix))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, "", err
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(s.tableName),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ExclusiveStartKey:         startKey,
+		ConsistentRead:            consistentRead(ctx, false),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int32(int32(limit))
+	}
+	result, err := s.client.Query(ctx, input)
+	if err != nil {
+		return nil, "", translateError(err)
+	}
+	reviews := make([]*Review, 0, len(result.Items))
+	for _, item := range result.Items {
+		review := new(Review)
+		if err := s.reviews.Unmarshal(item, review); err != nil {
+			return nil, "", err
+		}
+		reviews = append(reviews, review)
+	}
+	next, err := encodeCursor(result.LastEvaluatedKey)
+	return reviews, next, err
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"dynamoDBExample/config"
+)
+
+// routeHeader names the route of a request to BookHandler, e.g. staging.
+// Requests without it take the default route.
+const routeHeader = "X-Book-Route"
+
+// ErrUnknownRoute is returned by RepositoryFactory for a request naming a
+// route the routing does not have. It matches ErrValidation.
+var ErrUnknownRoute error = &kindError{msg: "unknown route", kind: ErrValidation}
+
+type routeKey struct{}
+
+// WithRoute returns a context whose calls a RepositoryFactory sends to the
+// table of route.
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// RouteFromContext returns the route set with WithRoute, if any.
+func RouteFromContext(ctx context.Context) (string, bool) {
+	route, ok := ctx.Value(routeKey{}).(string)
+	return route, ok && route != ""
+}
+
+// RepositoryFactory returns the repository of the route each call names in
+// its context, under a routing that can be replaced while calls are made.
+// Repositories are built once per table and kept while a route uses them.
+type RepositoryFactory struct {
+	build func(config.Route) (BookRepository, error)
+
+	mu      sync.RWMutex
+	routing config.Routing
+	repos   map[config.Route]BookRepository
+}
+
+// NewRepositoryFactory returns a factory for routing that builds the
+// repository of a route with build.
+func NewRepositoryFactory(routing config.Routing, build func(config.Route) (BookRepository, error)) (*RepositoryFactory, error) {
+	f := &RepositoryFactory{build: build, repos: map[config.Route]BookRepository{}}
+	if err := f.Apply(routing); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Apply replaces the routing, building the repositories of new tables
+// first. An invalid routing is rejected and the current one kept. Calls
+// already made carry on with the repository they were given.
+func (f *RepositoryFactory) Apply(routing config.Routing) error {
+	if err := routing.Validate(); err != nil {
+		return err
+	}
+	f.mu.RLock()
+	repos := make(map[config.Route]BookRepository, len(routing.Routes))
+	for _, route := range routing.Routes {
+		repos[route] = f.repos[route]
+	}
+	f.mu.RUnlock()
+	for route, repo := range repos {
+		if repo != nil {
+			continue
+		}
+		repo, err := f.build(route)
+		if err != nil {
+			return err
+		}
+		repos[route] = repo
+	}
+	f.mu.Lock()
+	f.routing = config.Routing{Default: routing.Default, Routes: maps.Clone(routing.Routes)}
+	f.repos = repos
+	f.mu.Unlock()
+	return nil
+}
+
+// Routing returns the current routing.
+func (f *RepositoryFactory) Routing() config.Routing {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return config.Routing{Default: f.routing.Default, Routes: maps.Clone(f.routing.Routes)}
+}
+
+// Repository returns the repository of the route of ctx, or of the default
+// route if ctx names none. It fails with ErrUnknownRoute if the routing has
+// no such route.
+func (f *RepositoryFactory) Repository(ctx context.Context) (BookRepository, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	name, ok := RouteFromContext(ctx)
+	if !ok {
+		name = f.routing.Default
+	}
+	route, ok := f.routing.Routes[name]
+	if !ok {
+		return nil, ErrUnknownRoute
+	}
+	return f.repos[route], nil
+}
+
+// RoutingSource loads the current routing, e.g. from a file or from an SSM
+// parameter holding one, decoded with config.ParseRouting.
+type RoutingSource func(ctx context.Context) (config.Routing, error)
+
+// FileRoutingSource loads the routing file at path.
+func FileRoutingSource(path string) RoutingSource {
+	return func(context.Context) (config.Routing, error) {
+		return config.LoadRouting(path)
+	}
+}
+
+// ParameterRoutingSource loads the routing from the value get returns, in
+// the format of the extension of name, e.g. a GetParameter call of an SSM
+// client for a parameter named /books/routing.json.
+func ParameterRoutingSource(name string, get func(ctx context.Context, name string) (string, error)) RoutingSource {
+	return func(ctx context.Context) (config.Routing, error) {
+		value, err := get(ctx, name)
+		if err != nil {
+			return config.Routing{}, err
+		}
+		return config.ParseRouting([]byte(value), filepath.Ext(name))
+	}
+}
+
+// Watch loads the routing from src every interval until ctx is done and
+// applies it when it changed. A routing that fails to load or apply is
+// logged and the current one kept, so a bad edit does not stop the service.
+func (f *RepositoryFactory) Watch(ctx context.Context, src RoutingSource, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		routing, err := src(ctx)
+		if err == nil && reflect.DeepEqual(routing, f.Routing()) {
+			continue
+		}
+		if err == nil {
+			err = f.Apply(routing)
+		}
+		if err != nil {
+			logger.ErrorContext(ctx, "reload routing", "error", err)
+			continue
+		}
+		logger.InfoContext(ctx, "routing reloaded", "default", routing.Default, "routes", len(routing.Routes))
+	}
+}
+
+// RoutedBookRepository implements BookRepository by passing every call to
+// the repository its RepositoryFactory returns for the call's context.
+type RoutedBookRepository struct {
+	factory *RepositoryFactory
+}
+
+// NewRoutedBookRepository returns a repository routing its calls through
+// factory.
+func NewRoutedBookRepository(factory *RepositoryFactory) *RoutedBookRepository {
+	return &RoutedBookRepository{factory: factory}
+}
+
+func (r *RoutedBookRepository) Create(ctx context.Context, book *Book) error {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.Create(ctx, book)
+}
+
+func (r *RoutedBookRepository) Upsert(ctx context.Context, book *Book) error {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.Upsert(ctx, book)
+}
+
+func (r *RoutedBookRepository) GetById(ctx context.Context, id int) (*Book, error) {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetById(ctx, id)
+}
+
+func (r *RoutedBookRepository) Update(ctx context.Context, book *Book) error {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.Update(ctx, book)
+}
+
+func (r *RoutedBookRepository) Delete(ctx context.Context, id int) error {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.Delete(ctx, id)
+}
+
+func (r *RoutedBookRepository) List(ctx context.Context) ([]*Book, error) {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.List(ctx)
+}
+
+func (r *RoutedBookRepository) ListPage(ctx context.Context, limit int, cursor string) ([]*Book, string, error) {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return repo.ListPage(ctx, limit, cursor)
+}
+
+func (r *RoutedBookRepository) ListSorted(ctx context.Context, opts ListOptions) ([]*Book, string, error) {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return repo.ListSorted(ctx, opts)
+}
+
+func (r *RoutedBookRepository) GetByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetByAuthor(ctx, author)
+}
+
+func (r *RoutedBookRepository) GetByISBN(ctx context.Context, isbn string) (*Book, error) {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetByISBN(ctx, isbn)
+}
+
+func (r *RoutedBookRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.BatchCreate(ctx, books)
+}
+
+func (r *RoutedBookRepository) BatchGet(ctx context.Context, ids []int) ([]*Book, error) {
+	repo, err := r.factory.Repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.BatchGet(ctx, ids)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attribute names of the sagas table, which is keyed by saga id. Finished
+// sagas are removed by TTL on ttlAttribute.
+const (
+	sagaStatusAttribute    = "status"
+	sagaUpdatedAtAttribute = "updatedAt"
+)
+
+// SagaStatus is the state of a Saga. A saga runs its steps in order until
+// it completes or a step fails; it then compensates, undoing the steps done
+// in reverse order, until it is compensated.
+type SagaStatus string
+
+const (
+	SagaRunning      SagaStatus = "running"
+	SagaCompensating SagaStatus = "compensating"
+	SagaCompleted    SagaStatus = "completed"
+	SagaCompensated  SagaStatus = "compensated"
+)
+
+// Saga is the persisted state of a workflow of several writes, saved after
+// every step so that an interrupted workflow can be resumed where it
+// stopped.
+type Saga struct {
+	Id       string     `json:"id" dynamodbav:"id"`
+	Workflow string     `json:"workflow" dynamodbav:"workflow"`
+	Status   SagaStatus `json:"status" dynamodbav:"status"`
+	// Step is the number of steps done while running, and the number of
+	// steps still to undo while compensating.
+	Step int `json:"step" dynamodbav:"step"`
+	// Error is the failure that made the saga compensate.
+	Error string `json:"error,omitempty" dynamodbav:"error,omitempty"`
+	// BookId, Borrower and DueAt are the input of the checkout workflow.
+	BookId   int       `json:"bookId" dynamodbav:"bookId"`
+	Borrower string    `json:"borrower" dynamodbav:"borrower"`
+	DueAt    time.Time `json:"dueAt" dynamodbav:"dueAt"`
+	// Version is incremented by every SaveSaga, so that two processes
+	// cannot both advance a saga.
+	Version   int       `json:"version" dynamodbav:"version"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" dynamodbav:"updatedAt,unixtime"`
+	// ExpiresAt is set once the saga is finished; it is the TTL of the item.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" dynamodbav:"expiresAt,unixtime,omitempty"`
+}
+
+// Finished reports whether the saga completed or was compensated.
+func (s *Saga) Finished() bool {
+	return s.Status == SagaCompleted || s.Status == SagaCompensated
+}
+
+// sagasTableName returns the name of the table holding the sagas of the
+// books in bookTable.
+func sagasTableName(bookTable string) string {
+	return bookTable + "-sagas"
+}
+
+// SaveSaga stores saga, bumping its version and UpdatedAt. It fails with
+// ErrConflict if the stored saga has another version, i.e. another process
+// advanced it since it was read; saga is then left unchanged.
+func (d *DynamoDbBookRepository) SaveSaga(ctx context.Context, saga *Saga) error {
+	expected, updatedAt := saga.Version, saga.UpdatedAt
+	saga.Version++
+	saga.UpdatedAt = time.Now()
+	item, err := attributevalue.MarshalMap(saga)
+	if err != nil {
+		saga.Version, saga.UpdatedAt = expected, updatedAt
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		TableName:                aws.String(sagasTableName(d.tableName)),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]string{"#id": idAttribute},
+	}
+	if expected > 0 {
+		input.ConditionExpression = aws.String("#version = :expected")
+		input.ExpressionAttributeNames = map[string]string{"#version": versionAttribute}
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":expected": &types.AttributeValueMemberN{Value: strconv.Itoa(expected)},
+		}
+	}
+	_, err = d.client.PutItem(ctx, input)
+	if err != nil {
+		saga.Version, saga.UpdatedAt = expected, updatedAt
+	}
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return fmt.Errorf("saga %s changed meanwhile: %w", saga.Id, ErrConflict)
+	}
+	return translateError(err)
+}
+
+// UnfinishedSagas returns the sagas still running or compensating that have
+// not been saved for idleFor, and so were presumably interrupted. It scans
+// the whole sagas table, which TTL keeps small.
+func (d *DynamoDbBookRepository) UnfinishedSagas(ctx context.Context, idleFor time.Duration) ([]*Saga, error) {
+	paginator := dynamodb.NewScanPaginator(d.client, &dynamodb.ScanInput{
+		TableName:        aws.String(sagasTableName(d.tableName)),
+		FilterExpression: aws.String("#status IN (:running, :compensating) AND #updatedAt <= :before"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":    sagaStatusAttribute,
+			"#updatedAt": sagaUpdatedAtAttribute,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":running":      &types.AttributeValueMemberS{Value: string(SagaRunning)},
+			":compensating": &types.AttributeValueMemberS{Value: string(SagaCompensating)},
+			":before":       &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(-idleFor).Unix(), 10)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	var sagas []*Saga
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		for _, item := range page.Items {
+			saga := new(Saga)
+			if err := attributevalue.UnmarshalMap(item, saga); err != nil {
+				return nil, err
+			}
+			sagas = append(sagas, saga)
+		}
+	}
+	return sagas, nil
+}
+
+// sagasTableDefinition describes the sagas table of bookTable: a string id
+// partition key and nothing else.
+func sagasTableDefinition(bookTable string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(sagasTableName(bookTable)),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(idAttribute), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(idAttribute), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
+
+// MigrateSagas creates the sagas table of bookTable if needed and enables
+// TTL on it so finished sagas are cleaned up. It is safe to run repeatedly.
+func MigrateSagas(ctx context.Context, client *dynamodb.Client, bookTable string) error {
+	return migrateTable(ctx, client, sagasTableDefinition(bookTable))
+}
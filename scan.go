@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// ScanAllParallel returns every book in the table using a parallel scan with
+// the given number of segments, read concurrently by the bulk workers. It is faster
+// than List on large tables at the cost of consuming read capacity more
+// quickly. The result is in no particular order. Soft-deleted books are
+// skipped unless the repository includes them.
+func (d *DynamoDbBookRepository) ScanAllParallel(ctx context.Context, segments int) ([]*Book, error) {
+	books := []*Book{}
+	err := d.scanSegments(ctx, segments, func(page []*Book) error {
+		books = append(books, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+// scanSegments scans the table in segments, as many at once as there are
+// bulk workers, and passes each page to emit. emit is called from a single
+// goroutine, so it needs no locking. If emit or any segment fails, the
+// remaining segments are cancelled and the first error is returned.
+func (d *DynamoDbBookRepository) scanSegments(ctx context.Context, segments int, emit func([]*Book) error) error {
+	if segments < 1 {
+		segments = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pool := NewWorkerPool(ctx, min(segments, d.bulkWorkers))
+	pages := make(chan []*Book, segments)
+	done := make(chan error, 1)
+	// Segments are submitted in the background: Go blocks while every worker
+	// is busy, and workers block until their pages are consumed below.
+	go func() {
+		for i := 0; i < segments; i++ {
+			segment := int32(i)
+			pool.Go(func(ctx context.Context) (int, error) {
+				return 0, d.scanSegment(ctx, segment, int32(segments), pages)
+			})
+		}
+		done <- pool.Wait()
+		close(pages)
+	}()
+
+	var emitErr error
+	for page := range pages {
+		if emitErr != nil {
+			continue
+		}
+		if emitErr = emit(page); emitErr != nil {
+			cancel()
+			continue
+		}
+		pool.add(len(page))
+	}
+	if err := <-done; emitErr == nil {
+		return err
+	}
+	return emitErr
+}
+
+// scanSegment scans one segment of the table, sending each page to pages.
+func (d *DynamoDbBookRepository) scanSegment(ctx context.Context, segment, total int32, pages chan<- []*Book) error {
+	paginator := dynamodb.NewScanPaginator(d.client, &dynamodb.ScanInput{
+		TableName:      aws.String(d.tableName),
+		Segment:        aws.Int32(segment),
+		TotalSegments:  aws.Int32(total),
+		ConsistentRead: consistentRead(ctx, d.consistentReads),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return translateError(err)
+		}
+		books, err := d.codec.unmarshalList(page.Items)
+		if err != nil {
+			return err
+		}
+		select {
+		case pages <- d.visible(books):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// BookIterator walks the books of a table one scan page at a time, so that
+// only a page is held in memory however large the table is:
+//
+//	it := repo.Iterate(ctx)
+//	for it.Next() {
+//		process(it.Book())
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type BookIterator struct {
+	ctx       context.Context
+	repo      *DynamoDbBookRepository
+	paginator *dynamodb.ScanPaginator
+	page      []*Book
+	book      *Book
+	err       error
+}
+
+// Iterate returns an iterator over every book in the table, in the order of
+// List and skipping soft-deleted books like it. Pages are read as the
+// iterator reaches them, with the read options of ctx.
+func (d *DynamoDbBookRepository) Iterate(ctx context.Context) *BookIterator {
+	it := &BookIterator{ctx: ctx, repo: d}
+	proj, names, err := projection(ctx, d.items.projected)
+	if err != nil {
+		it.err = err
+		return it
+	}
+	it.paginator = dynamodb.NewScanPaginator(d.client, &dynamodb.ScanInput{
+		TableName:                aws.String(d.tableName),
+		ConsistentRead:           consistentRead(ctx, d.consistentReads),
+		ProjectionExpression:     proj,
+		ExpressionAttributeNames: names,
+	})
+	return it
+}
+
+// Next advances to the next book, reading the next page if needed. It
+// returns false once the table is exhausted or a read failed; see Err.
+func (it *BookIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.err != nil || !it.paginator.HasMorePages() {
+			it.book = nil
+			return false
+		}
+		page, err := it.paginator.NextPage(it.ctx)
+		if err != nil {
+			it.err = translateError(err)
+			continue
+		}
+		books, err := it.repo.codec.unmarshalList(page.Items)
+		if err != nil {
+			it.err = err
+			continue
+		}
+		it.page = it.repo.visible(books)
+	}
+	it.book, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Book returns the current book, set by the last call of Next.
+func (it *BookIterator) Book() *Book {
+	return it.book
+}
+
+// Err returns the error that stopped the iteration, or nil if it ran to
+// the end of the table or is still running.
+func (it *BookIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Search settings the use case enforces regardless of the backend.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchQueryLen  = 256
+)
+
+// SearchHit is a book matching a search query, with the relevance score the
+// search backend assigned it. Hits are returned most relevant first.
+type SearchHit struct {
+	Book  *Book   `json:"book"`
+	Score float64 `json:"score"`
+}
+
+// BookSearcher runs full-text searches over books.
+type BookSearcher interface {
+	SearchBooks(ctx context.Context, query string, limit int) ([]SearchHit, error)
+}
+
+// OpenSearchIndex mirrors books into an OpenSearch or Elasticsearch index
+// and searches them. It is a ChangeHandler, so it can be kept up to date
+// synchronously by a BookUseCase built WithChangeHandler, or asynchronously
+// by a StreamDispatcher consuming the table's stream. Soft-deleted books are
+// removed from the index.
+//
+// Only the REST API is used, so no client library is needed. Credentials
+// for basic authentication can be given in the endpoint URL.
+type OpenSearchIndex struct {
+	endpoint string
+	index    string
+	client   *http.Client
+}
+
+// NewOpenSearchIndex returns an index named index on the cluster at
+// endpoint, e.g. http://localhost:9200.
+func NewOpenSearchIndex(endpoint, index string) *OpenSearchIndex {
+	return &OpenSearchIndex{endpoint: strings.TrimSuffix(endpoint, "/"), index: index, client: http.DefaultClient}
+}
+
+// searchMapping is the index mapping: name and author are analyzed for
+// full-text search, author also as a keyword for exact filtering.
+const searchMapping = `{
+  "mappings": {
+    "properties": {
+      "id":     {"type": "long"},
+      "name":   {"type": "text"},
+      "author": {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+      "tags":   {"type": "keyword"},
+      "year":   {"type": "integer"}
+    }
+  }
+}`
+
+// EnsureIndex creates the index with its mapping unless it exists.
+func (s *OpenSearchIndex) EnsureIndex(ctx context.Context) error {
+	status, body, err := s.do(ctx, http.MethodPut, "", strings.NewReader(searchMapping))
+	if err != nil {
+		return err
+	}
+	if status == http.StatusBadRequest && bytes.Contains(body, []byte("resource_already_exists_exception")) {
+		return nil
+	}
+	return searchStatusError("create index", status, body)
+}
+
+// HandleChange indexes inserted and modified books and removes deleted ones.
+func (s *OpenSearchIndex) HandleChange(ctx context.Context, change BookChange) error {
+	if change.Type == ChangeRemove || (change.New != nil && change.New.DeletedAt != nil) {
+		return s.Remove(ctx, change.Id)
+	}
+	if change.New == nil {
+		return fmt.Errorf("index book %d: change carries no new image", change.Id)
+	}
+	return s.Index(ctx, change.New)
+}
+
+// Index adds or replaces book in the index.
+func (s *OpenSearchIndex) Index(ctx context.Context, book *Book) error {
+	doc, err := json.Marshal(book)
+	if err != nil {
+		return err
+	}
+	status, body, err := s.do(ctx, http.MethodPut, "/_doc/"+strconv.Itoa(book.Id), bytes.NewReader(doc))
+	if err != nil {
+		return err
+	}
+	return searchStatusError(fmt.Sprintf("index book %d", book.Id), status, body)
+}
+
+// Remove deletes the book with the given id from the index, if present.
+func (s *OpenSearchIndex) Remove(ctx context.Context, id int) error {
+	status, body, err := s.do(ctx, http.MethodDelete, "/_doc/"+strconv.Itoa(id), nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return searchStatusError(fmt.Sprintf("remove book %d", id), status, body)
+}
+
+// SearchBooks returns up to limit books matching query, ranked by relevance.
+// Matches in the name weigh more than in the author, and those more than in
+// tags; small typos are tolerated.
+func (s *OpenSearchIndex) SearchBooks(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	req, err := json.Marshal(map[string]any{
+		"size": limit,
+		"query": map[string]any{
+			"multi_match": map[string]any{
+				"query":     query,
+				"fields":    []string{"name^3", "author^2", "tags"},
+				"fuzziness": "AUTO",
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	status, body, err := s.do(ctx, http.MethodPost, "/_search", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	if err := searchStatusError("search", status, body); err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Hits struct {
+			Hits []struct {
+				Score  float64 `json:"_score"`
+				Source Book    `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("decode search response: %w", err)
+	}
+	hits := make([]SearchHit, 0, len(resp.Hits.Hits))
+	for _, h := range resp.Hits.Hits {
+		book := h.Source
+		hits = append(hits, SearchHit{Book: &book, Score: h.Score})
+	}
+	return hits, nil
+}
+
+// do sends a request for path below the index and returns the response
+// status and body.
+func (s *OpenSearchIndex) do(ctx context.Context, method, path string, body io.Reader) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+"/"+url.PathEscape(s.index)+path, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("search backend: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("search backend: %w", err)
+	}
+	return resp.StatusCode, data, nil
+}
+
+// searchStatusError returns an error describing a failed response, or nil
+// for a 2xx status.
+func searchStatusError(op string, status int, body []byte) error {
+	if status >= 200 && status < 300 {
+		return nil
+	}
+	const maxBody = 512
+	if len(body) > maxBody {
+		body = body[:maxBody]
+	}
+	return fmt.Errorf("%s: search backend returned %d: %s", op, status, bytes.TrimSpace(body))
+}
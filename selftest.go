@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+)
+
+// selfTestBookID is the id SelfTest writes, reads and deletes. Negative ids
+// are never handed out to real books.
+const selfTestBookID = -1
+
+// SelfTestReport records which table operations the configured credentials
+// were allowed to perform.
+type SelfTestReport struct {
+	Describe bool
+	Write    bool
+	Read     bool
+	Delete   bool
+	// Denied holds the AccessDeniedException returned by each failed step,
+	// keyed by step name.
+	Denied map[string]error
+}
+
+// SelfTest checks connectivity and permissions by describing the table and
+// writing, reading and deleting a temporary item, in that order. A step
+// rejected with AccessDeniedException is reported as unavailable and the
+// remaining steps still run; any other error aborts the test.
+func (d *DynamoDbBookRepository) SelfTest(ctx context.Context) (SelfTestReport, error) {
+	report := SelfTestReport{Denied: map[string]error{}}
+	key := d.key.MarshalKey(selfTestBookID)
+
+	steps := []struct {
+		name   string
+		result *bool
+		run    func() error
+	}{
+		{"describe", &report.Describe, func() error {
+			_, err := d.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+				TableName: aws.String(d.tableName),
+			})
+			return err
+		}},
+		{"write", &report.Write, func() error {
+			_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{
+				Item:      key,
+				TableName: aws.String(d.tableName),
+			})
+			return err
+		}},
+		{"read", &report.Read, func() error {
+			_, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+				Key:       key,
+				TableName: aws.String(d.tableName),
+			})
+			return err
+		}},
+		{"delete", &report.Delete, func() error {
+			_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				Key:       key,
+				TableName: aws.String(d.tableName),
+			})
+			return err
+		}},
+	}
+	for _, step := range steps {
+		err := step.run()
+		if err == nil {
+			*step.result = true
+			continue
+		}
+		if isAccessDenied(err) {
+			report.Denied[step.name] = err
+			continue
+		}
+		return report, translateError(err)
+	}
+	return report, nil
+}
+
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDeniedException"
+}
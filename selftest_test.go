@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSelfTestReportsDeniedPutItem(t *testing.T) {
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		switch op {
+		case "PutItem":
+			return nil, &stubError{Type: "AccessDeniedException", Message: "not authorized to perform dynamodb:PutItem"}
+		case "DescribeTable":
+			return map[string]any{"Table": map[string]any{"TableName": stubTable, "TableStatus": "ACTIVE"}}, nil
+		}
+		return nil, nil
+	})
+
+	report, err := stub.repository().SelfTest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Write {
+		t.Error("report.Write = true, want false after PutItem was denied")
+	}
+	if !report.Describe || !report.Read || !report.Delete {
+		t.Errorf("report = %+v, want the steps after the denied write to still run and pass", report)
+	}
+	if _, denied := report.Denied["write"]; !denied || len(report.Denied) != 1 {
+		t.Errorf("report.Denied = %v, want only write", report.Denied)
+	}
+}
+
+func TestSelfTestAbortsOnOtherErrors(t *testing.T) {
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		return nil, &stubError{Type: "ResourceNotFoundException", Message: "no table"}
+	})
+	if _, err := stub.repository().SelfTest(context.Background()); err == nil {
+		t.Fatal("SelfTest succeeded, want the error of the missing table")
+	}
+	if got := len(stub.calls); got != 1 {
+		t.Errorf("%d calls, want SelfTest to stop after the failed describe", got)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// shardQueryConcurrency bounds the concurrent per-shard queries of
+// ScatterGather when the caller sets no limit.
+const shardQueryConcurrency = 10
+
+// WriteSharding spreads the items of one logical partition key over several
+// physical ones by suffixing the key with a shard number, so that a hot key,
+// such as a prolific author, is not limited to the throughput of a single
+// partition. Each item's shard is derived from its id, so an item can still
+// be addressed directly; reads of a whole logical partition must query every
+// shard and merge the results.
+type WriteSharding struct {
+	// Shards is the number of physical keys per logical key. Zero or one
+	// disables sharding, leaving keys unchanged.
+	Shards int
+}
+
+// Enabled reports whether keys are sharded.
+func (s WriteSharding) Enabled() bool {
+	return s.Shards > 1
+}
+
+// Key returns the physical partition key holding the item id of partition.
+func (s WriteSharding) Key(partition string, id int) string {
+	if !s.Enabled() {
+		return partition
+	}
+	shard := id % s.Shards
+	if shard < 0 {
+		shard += s.Shards
+	}
+	return partition + "#" + strconv.Itoa(shard)
+}
+
+// Keys returns every physical partition key of partition.
+func (s WriteSharding) Keys(partition string) []string {
+	if !s.Enabled() {
+		return []string{partition}
+	}
+	keys := make([]string, s.Shards)
+	for i := range keys {
+		keys[i] = partition + "#" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// ScatterGather runs query for every key concurrently, at most limit at a
+// time (shardQueryConcurrency if limit is not positive), and concatenates the
+// results in the order of keys. The first error cancels the other queries
+// and is returned.
+func ScatterGather[T any](ctx context.Context, keys []string, limit int, query func(ctx context.Context, key string) ([]T, error)) ([]T, error) {
+	if limit <= 0 {
+		limit = shardQueryConcurrency
+	}
+	results := make([][]T, len(keys))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(limit)
+	for i, key := range keys {
+		i, key := i, key
+		g.Go(func() error {
+			items, err := query(ctx, key)
+			results[i] = items
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	var all []T
+	for _, items := range results {
+		all = append(all, items...)
+	}
+	return all, nil
+}
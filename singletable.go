@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// entityTypeAttribute holds the type discriminator of items in a single
+// table.
+const entityTypeAttribute = "entityType"
+
+// Entity types and key patterns of the single table. A book and its reviews
+// share the BOOK#<id> partition so they can be read with one Query:
+//
+//	entity  PK              SK
+//	Book    BOOK#<id>       BOOK
+//	Review  BOOK#<bookId>   REVIEW#<id>
+//	Author  AUTHOR#<id>     AUTHOR
+const (
+	bookEntityType   = "Book"
+	reviewEntityType = "Review"
+	authorEntityType = "Author"
+
+	bookPartitionPrefix   = "BOOK#"
+	authorPartitionPrefix = "AUTHOR#"
+	reviewSortPrefix      = "REVIEW#"
+)
+
+// ErrEntityMismatch is returned when an item read through a single-table
+// repository has a different entity type than the repository's.
+var ErrEntityMismatch = errors.New("item has a different entity type")
+
+// singleTableKey is the codec of the single table's primary key.
+var singleTableKey = NewCompositeKeyCodec[string, string](StringKey(partitionKeyAttribute), StringKey(sortKeyAttribute))
+
+// ItemMapper encodes entities of type T as single-table items: their own
+// attributes plus PK, SK and entityType. Decoding rejects items of another
+// entity type with ErrEntityMismatch.
+type ItemMapper[T any] struct {
+	EntityType string
+	// Key returns the PK and SK of an entity.
+	Key func(*T) CompositeKey[string, string]
+}
+
+// Schema returns an EntitySchema that stores T through the mapper.
+func (m ItemMapper[T]) Schema() EntitySchema[T] {
+	return EntitySchema[T]{
+		Key:       func(v *T) map[string]types.AttributeValue { return singleTableKey.MarshalKey(m.Key(v)) },
+		Marshal:   m.Marshal,
+		Unmarshal: m.Unmarshal,
+	}
+}
+
+// Marshal implements EntitySchema.Marshal.
+func (m ItemMapper[T]) Marshal(v *T) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(v)
+	if err != nil {
+		return nil, err
+	}
+	for name, av := range singleTableKey.MarshalKey(m.Key(v)) {
+		item[name] = av
+	}
+	item[entityTypeAttribute] = &types.AttributeValueMemberS{Value: m.EntityType}
+	return item, nil
+}
+
+// Unmarshal implements EntitySchema.Unmarshal.
+func (m ItemMapper[T]) Unmarshal(item map[string]types.AttributeValue, v *T) error {
+	got, _ := item[entityTypeAttribute].(*types.AttributeValueMemberS)
+	if got == nil || got.Value != m.EntityType {
+		actual := "none"
+		if got != nil {
+			actual = got.Value
+		}
+		return fmt.Errorf("%w: want %s, got %s", ErrEntityMismatch, m.EntityType, actual)
+	}
+	return attributevalue.UnmarshalMap(item, v)
+}
+
+// Mappers of the entities stored in the single table.
+var (
+	bookMapper = ItemMapper[Book]{
+		EntityType: bookEntityType,
+		Key: func(b *Book) CompositeKey[string, string] {
+			return CompositeKey[string, string]{Partition: bookPartitionPrefix + strconv.Itoa(b.Id), Sort: "BOOK"}
+		},
+	}
+	reviewMapper = ItemMapper[Review]{
+		EntityType: reviewEntityType,
+		Key: func(r *Review) CompositeKey[string, string] {
+			return CompositeKey[string, string]{
+				Partition: bookPartitionPrefix + strconv.Itoa(r.BookId),
+				Sort:      reviewSortPrefix + strconv.Itoa(r.Id),
+			}
+		},
+	}
+	authorMapper = ItemMapper[Author]{
+		EntityType: authorEntityType,
+		Key: func(a *Author) CompositeKey[string, string] {
+			return CompositeKey[string, string]{Partition: authorPartitionPrefix + strconv.Itoa(a.Id), Sort: "AUTHOR"}
+		},
+	}
+)
+
+// SingleTable gives access to books, authors and reviews stored together in
+// one table.
+type SingleTable struct {
+	client    *dynamodb.Client
+	tableName string
+	Books     *Repository[Book]
+	Authors   *Repository[Author]
+	Reviews   *Repository[Review]
+	// reviews encodes the review items of Reviews and of the queries of
+	// the book partitions.
+	reviews EntitySchema[Review]
+}
+
+// NewSingleTable returns the repositories of the single table. Review
+// comments are encrypted with e, e.g. the one of
+// NewFieldEncryptorFromConfig, or stored in plaintext if e is nil.
+func NewSingleTable(cfg aws.Config, tableName string, e *FieldEncryptor, optFns ...func(*dynamodb.Options)) *SingleTable {
+	client := dynamodb.NewFromConfig(cfg, optFns...)
+	reviews := reviewMapper.Schema()
+	if e != nil {
+		reviews = EncryptedSchema(reviews, e)
+	}
+	s := &SingleTable{
+		client:    client,
+		tableName: tableName,
+		Books:     NewRepository(client, tableName, bookMapper.Schema()),
+		Authors:   NewRepository(client, tableName, authorMapper.Schema()),
+		Reviews:   NewRepository(client, tableName, reviews),
+		reviews:   reviews,
+	}
+	// The mappers need the keys and entity type to recognise their items.
+	projected := []string{partitionKeyAttribute, sortKeyAttribute, entityTypeAttribute}
+	s.Books.projected = projected
+	s.Authors.projected = projected
+	s.Reviews.projected = projected
+	return s
+}
+
+// BookKey returns the primary key of a book in the single table.
+func (s *SingleTable) BookKey(id int) map[string]types.AttributeValue {
+	return bookMapper.Schema().Key(&Book{Id: id})
+}
+
+// AuthorKey returns the primary key of an author in the single table.
+func (s *SingleTable) AuthorKey(id int) map[string]types.AttributeValue {
+	return authorMapper.Schema().Key(&Author{Id: id})
+}
+
+// ReviewKey returns the primary key of a review in the single table.
+func (s *SingleTable) ReviewKey(bookID, id int) map[string]types.AttributeValue {
+	return reviewMapper.Schema().Key(&Review{BookId: bookID, Id: id})
+}
+
+// BookWithReviews returns a book and all of its reviews with a single Query
+// over the book's partition. It returns ErrNotFound if the book does not
+// exist.
+func (s *SingleTable) BookWithReviews(ctx context.Context, id int) (*Book, []*Review, error) {
+	keyCond := expression.Key(partitionKeyAttribute).Equal(expression.Value(bookPartitionPrefix + strconv.Itoa(id)))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, nil, err
+	}
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:                 aws.String(s.tableName),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+
+	var book *Book
+	reviews := []*Review{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, nil, translateError(err)
+		}
+		for _, item := range page.Items {
+			typ, _ := item[entityTypeAttribute].(*types.AttributeValueMemberS)
+			switch {
+			case typ != nil && typ.Value == bookEntityType:
+				book = new(Book)
+				if err := bookMapper.Unmarshal(item, book); err != nil {
+					return nil, nil, err
+				}
+			case typ != nil && typ.Value == reviewEntityType:
+				review := new(Review)
+				if err := s.reviews.Unmarshal(item, review); err != nil {
+					return nil, nil, err
+				}
+				reviews = append(reviews, review)
+			}
+		}
+	}
+	if book == nil {
+		return nil, nil, ErrNotFound
+	}
+	return book, reviews, nil
+}
+
+// singleTableDefinition describes the single table: string PK and SK.
+func singleTableDefinition(tableName string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(partitionKeyAttribute), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String(sortKeyAttribute), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(partitionKeyAttribute), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(sortKeyAttribute), KeyType: types.KeyTypeRange},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
+
+// MigrateSingleTable is Migrate for the single table.
+func MigrateSingleTable(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	return migrateTable(ctx, client, singleTableDefinition(tableName))
+}
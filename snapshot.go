@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// defaultSnapshotPollInterval is how often BackupTable and ExportTable ask
+// DynamoDB whether the snapshot is done. Backups take seconds to minutes,
+// exports at least a few minutes.
+const defaultSnapshotPollInterval = 10 * time.Second
+
+// BackupTable takes an on-demand backup named name of the table and waits
+// until it is available. Unlike an export by scan, which sees writes made
+// while it runs, a backup holds the table as it was when requested. The
+// backup stays in DynamoDB; restore it with RestoreTableFromBackup.
+func (d *DynamoDbBookRepository) BackupTable(ctx context.Context, name string, poll time.Duration) (*types.BackupDetails, error) {
+	out, err := d.client.CreateBackup(ctx, &dynamodb.CreateBackupInput{
+		TableName:  aws.String(d.tableName),
+		BackupName: aws.String(name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create backup of %s: %w", d.tableName, translateError(err))
+	}
+	backup := out.BackupDetails
+	err = pollSnapshot(ctx, poll, func() (bool, error) {
+		if backup.BackupStatus != types.BackupStatusCreating {
+			return true, nil
+		}
+		desc, err := d.client.DescribeBackup(ctx, &dynamodb.DescribeBackupInput{BackupArn: backup.BackupArn})
+		if err != nil {
+			return false, fmt.Errorf("describe backup %s: %w", aws.ToString(backup.BackupArn), translateError(err))
+		}
+		backup = desc.BackupDescription.BackupDetails
+		return backup.BackupStatus != types.BackupStatusCreating, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if backup.BackupStatus != types.BackupStatusAvailable {
+		return backup, fmt.Errorf("backup %s is %s", aws.ToString(backup.BackupArn), backup.BackupStatus)
+	}
+	return backup, nil
+}
+
+// ExportTable exports the table as of now to the S3 bucket under prefix, in
+// DynamoDB JSON, and waits until the export completes. Like a backup, the
+// export is consistent; it needs point-in-time recovery enabled on the
+// table and consumes no read capacity. Convert it with ConvertExport.
+func (d *DynamoDbBookRepository) ExportTable(ctx context.Context, bucket, prefix string, poll time.Duration) (*types.ExportDescription, error) {
+	table, err := d.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(d.tableName)})
+	if err != nil {
+		return nil, fmt.Errorf("describe table %s: %w", d.tableName, translateError(err))
+	}
+	input := &dynamodb.ExportTableToPointInTimeInput{
+		TableArn:     table.Table.TableArn,
+		S3Bucket:     aws.String(bucket),
+		ExportFormat: types.ExportFormatDynamodbJson,
+	}
+	if prefix != "" {
+		input.S3Prefix = aws.String(prefix)
+	}
+	out, err := d.client.ExportTableToPointInTime(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("export %s: %w", d.tableName, translateError(err))
+	}
+	export := out.ExportDescription
+	err = pollSnapshot(ctx, poll, func() (bool, error) {
+		if export.ExportStatus != types.ExportStatusInProgress {
+			return true, nil
+		}
+		desc, err := d.client.DescribeExport(ctx, &dynamodb.DescribeExportInput{ExportArn: export.ExportArn})
+		if err != nil {
+			return false, fmt.Errorf("describe export %s: %w", aws.ToString(export.ExportArn), translateError(err))
+		}
+		export = desc.ExportDescription
+		return export.ExportStatus != types.ExportStatusInProgress, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if export.ExportStatus != types.ExportStatusCompleted {
+		return export, fmt.Errorf("export %s failed: %s: %s", aws.ToString(export.ExportArn),
+			aws.ToString(export.FailureCode), aws.ToString(export.FailureMessage))
+	}
+	return export, nil
+}
+
+// pollSnapshot calls done every interval until it reports true or fails, or
+// ctx is done.
+func pollSnapshot(ctx context.Context, interval time.Duration, done func() (bool, error)) error {
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// exportManifestSummary is the part of the manifest-summary.json of an
+// export that leads to its data files.
+type exportManifestSummary struct {
+	ManifestFilesS3Key string `json:"manifestFilesS3Key"`
+}
+
+// exportManifestFile is a line of the manifest-files.json of an export.
+type exportManifestFile struct {
+	DataFileS3Key string `json:"dataFileS3Key"`
+}
+
+// exportRecord is a line of a data file of an export.
+type exportRecord struct {
+	Item map[string]*encodedAttribute `json:"Item"`
+}
+
+// ConvertExport reads the data files of a completed export from store, the
+// bucket the export was written to, and writes its books to w as NDJSON, the
+// format of books export and import. Soft-deleted books are skipped, as by
+// List. It returns the number of books written.
+func (d *DynamoDbBookRepository) ConvertExport(ctx context.Context, store SnapshotStore, export *types.ExportDescription, w io.Writer) (n int, err error) {
+	manifest := aws.ToString(export.ExportManifest)
+	summary, err := readExportLines[exportManifestSummary](ctx, store, manifest, false)
+	if err != nil {
+		return 0, err
+	}
+	if len(summary) != 1 {
+		return 0, fmt.Errorf("export manifest %s: want one summary, got %d", manifest, len(summary))
+	}
+	files, err := readExportLines[exportManifestFile](ctx, store, summary[0].ManifestFilesS3Key, false)
+	if err != nil {
+		return 0, err
+	}
+	enc, err := newFormatEncoder(w, FormatNDJSON)
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		records, err := readExportLines[exportRecord](ctx, store, file.DataFileS3Key, true)
+		if err != nil {
+			return n, err
+		}
+		for i, record := range records {
+			item := make(map[string]types.AttributeValue, len(record.Item))
+			for name, value := range record.Item {
+				item[name] = value.value()
+			}
+			book := new(Book)
+			if err := d.codec.unmarshal(item, book); err != nil {
+				return n, fmt.Errorf("export data file %s, line %d: %w", file.DataFileS3Key, i+1, err)
+			}
+			if book.DeletedAt != nil && !d.includeDeleted {
+				continue
+			}
+			if err := enc.encode(book); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if err := enc.flush(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readExportLines decodes the object key of store, a JSON value per line,
+// gunzipping it first if gzipped.
+func readExportLines[T any](ctx context.Context, store SnapshotStore, key string, gzipped bool) ([]T, error) {
+	r, err := store.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var src io.Reader = bufio.NewReader(r)
+	if gzipped {
+		if src, err = gzip.NewReader(src); err != nil {
+			return nil, fmt.Errorf("export file %s: %w", key, err)
+		}
+	}
+	dec := json.NewDecoder(src)
+	var lines []T
+	for {
+		var line T
+		if err := dec.Decode(&line); errors.Is(err, io.EOF) {
+			return lines, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("export file %s, line %d: %w", key, len(lines)+1, err)
+		}
+		lines = append(lines, line)
+	}
+}
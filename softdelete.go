@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// defaultSoftDeleteRetention is how long a soft-deleted book is kept before
+// its TTL lets DynamoDB remove it.
+const defaultSoftDeleteRetention = 30 * 24 * time.Hour
+
+// WithIncludeDeleted makes GetById and List return soft-deleted books too.
+func WithIncludeDeleted() RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.includeDeleted = true
+	}
+}
+
+// WithSoftDeleteRetention sets how long SoftDelete keeps a book before it
+// expires. TTL deletion is best effort and usually happens within a few days
+// of the expiry time.
+func WithSoftDeleteRetention(retention time.Duration) RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.retention = retention
+	}
+}
+
+// SoftDelete marks a book as deleted instead of removing it: it sets
+// deletedAt to now and expiresAt to now plus the retention, after which the
+// table's TTL removes the item. The version is incremented. It returns
+// ErrNotFound if the book does not exist or is already soft-deleted.
+func (d *DynamoDbBookRepository) SoftDelete(ctx context.Context, id int) error {
+	now := time.Now().UTC()
+	version := expression.Name(versionAttribute)
+	update := expression.
+		Set(expression.Name(deletedAtAttribute), expression.Value(now)).
+		Set(expression.Name(ttlAttribute), expression.Value(now.Add(d.retention).Unix())).
+		Set(version, expression.Plus(version.IfNotExists(expression.Value(0)), expression.Value(1)))
+	cond := expression.AttributeExists(expression.Name(idAttribute)).
+		And(expression.AttributeNotExists(expression.Name(deletedAtAttribute)))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(cond).Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:                       d.key.MarshalKey(id),
+		TableName:                 aws.String(d.tableName),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if err != nil {
+		err = translateError(err)
+		if isConflict(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// visible drops soft-deleted books unless the repository includes them.
+func (d *DynamoDbBookRepository) visible(books []*Book) []*Book {
+	if d.includeDeleted {
+		return books
+	}
+	kept := books[:0]
+	for _, book := range books {
+		if book.DeletedAt == nil {
+			kept = append(kept, book)
+		}
+	}
+	return kept
+}
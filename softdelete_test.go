@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReadPathsSkipSoftDeletedBooks(t *testing.T) {
+	deletedAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	items := []map[string]any{
+		wireItem(t, &Book{Id: 1, Name: "Emma", Author: "Jane Austen", Version: 1}),
+		wireItem(t, &Book{Id: 2, Name: "Sanditon", Author: "Jane Austen", Version: 1, DeletedAt: &deletedAt}),
+		wireItem(t, &Book{Id: 3, Name: "Persuasion", Author: "Jane Austen", Version: 1}),
+	}
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		switch op {
+		case "Query", "Scan":
+			// The books are all in the first of two scan segments.
+			if op == "Scan" && bytes.Contains(input, []byte(`"Segment":1`)) {
+				return map[string]any{"Items": []any{}}, nil
+			}
+			return map[string]any{"Items": items, "Count": len(items)}, nil
+		case "BatchGetItem":
+			return map[string]any{"Responses": map[string]any{stubTable: items}}, nil
+		}
+		return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+	})
+	ctx := context.Background()
+
+	reads := []struct {
+		name string
+		read func(repo *DynamoDbBookRepository) ([]*Book, error)
+	}{
+		{"GetByAuthor", func(repo *DynamoDbBookRepository) ([]*Book, error) {
+			return repo.GetByAuthor(ctx, "Jane Austen")
+		}},
+		{"BatchGet", func(repo *DynamoDbBookRepository) ([]*Book, error) {
+			return repo.BatchGet(ctx, []int{1, 2, 3})
+		}},
+		{"ScanAllParallel", func(repo *DynamoDbBookRepository) ([]*Book, error) {
+			books, err := repo.ScanAllParallel(ctx, 2)
+			slices.SortFunc(books, func(a, b *Book) int { return a.Id - b.Id })
+			return books, err
+		}},
+		{"ExportBooks", func(repo *DynamoDbBookRepository) ([]*Book, error) {
+			var buf bytes.Buffer
+			if _, err := repo.ExportBooks(ctx, &buf, FormatNDJSON, 2, WithSortedExport()); err != nil {
+				return nil, err
+			}
+			var books []*Book
+			for _, id := range exportedIDs(t, FormatNDJSON, buf.Bytes()) {
+				books = append(books, &Book{Id: id})
+			}
+			return books, nil
+		}},
+	}
+	for _, tc := range []struct {
+		name string
+		opts []RepositoryOption
+		want []int
+	}{
+		{"default", nil, []int{1, 3}},
+		{"WithIncludeDeleted", []RepositoryOption{WithIncludeDeleted()}, []int{1, 2, 3}},
+	} {
+		repo := stub.repository(tc.opts...)
+		for _, read := range reads {
+			t.Run(tc.name+"/"+read.name, func(t *testing.T) {
+				books, err := read.read(repo)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := sortedIDs(books); !slices.Equal(got, tc.want) {
+					t.Errorf("got books %v, want %v", got, tc.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SortBy names an order books can be listed in.
+type SortBy string
+
+// Sort orders of ListSorted.
+const (
+	SortByName    SortBy = "name"
+	SortByAuthor  SortBy = "author"
+	SortByCreated SortBy = "created"
+	// SortByYear has no sort index: repositories that have them sort every
+	// book in memory instead.
+	SortByYear SortBy = "year"
+)
+
+// SortOrder is the direction of a sorted listing.
+type SortOrder string
+
+const (
+	Ascending  SortOrder = "asc"
+	Descending SortOrder = "desc"
+)
+
+// ListOptions selects a page of books.
+type ListOptions struct {
+	// SortBy is the order of the books. Without one, books are listed in the
+	// unspecified order of ListPage.
+	SortBy SortBy
+	// Order is Ascending, the default, or Descending.
+	Order SortOrder
+	// Limit caps the number of books returned; 0 returns all that follow
+	// the cursor.
+	Limit int
+	// Cursor resumes the listing after the page it was returned with. It is
+	// only valid with the SortBy and Order of that page.
+	Cursor string
+}
+
+// sortIndex describes how books are sorted by one SortBy: the global
+// secondary index of the book table keeping them in that order, if any, and
+// the attribute it sorts on.
+type sortIndex struct {
+	name      string
+	attribute string
+}
+
+var sortIndexes = map[SortBy]sortIndex{
+	SortByName:    {name: nameSortIndexName, attribute: nameAttribute},
+	SortByAuthor:  {name: authorSortIndexName, attribute: authorAttribute},
+	SortByCreated: {name: createdIndexName, attribute: createdAtAttribute},
+	SortByYear:    {attribute: yearAttribute},
+}
+
+// index returns the sort index of o, or an error matching ErrValidation if
+// o does not name a known sort and order.
+func (o ListOptions) index() (sortIndex, error) {
+	ix, ok := sortIndexes[o.SortBy]
+	switch {
+	case !ok:
+		return sortIndex{}, fmt.Errorf("%w: unknown sort %q", ErrValidation, o.SortBy)
+	case o.Order != "" && o.Order != Ascending && o.Order != Descending:
+		return sortIndex{}, fmt.Errorf("%w: unknown sort order %q", ErrValidation, o.Order)
+	case o.Limit < 0:
+		return sortIndex{}, fmt.Errorf("%w: limit must not be negative", ErrValidation)
+	}
+	return ix, nil
+}
+
+// descending reports whether o lists books from the largest sort key down.
+func (o ListOptions) descending() bool {
+	return o.Order == Descending
+}
+
+// sortKey returns the sort key of book in ix as it is stored: a string, the
+// year, or for the creation time the Unix second, 0 if unknown.
+func (ix sortIndex) sortKey(book *Book) types.AttributeValue {
+	switch ix.attribute {
+	case nameAttribute:
+		return &types.AttributeValueMemberS{Value: book.Name}
+	case authorAttribute:
+		return &types.AttributeValueMemberS{Value: book.Author}
+	case yearAttribute:
+		return &types.AttributeValueMemberN{Value: strconv.Itoa(book.Year)}
+	}
+	return &types.AttributeValueMemberN{Value: createdAtKey(book.CreatedAt)}
+}
+
+// createdAtKey returns the number CreatedAt is stored as.
+func createdAtKey(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// compareSortKeys compares two sort keys of the same type the way DynamoDB
+// orders them: strings by their UTF-8 bytes, numbers by value.
+func compareSortKeys(a, b types.AttributeValue) int {
+	if as, ok := a.(*types.AttributeValueMemberS); ok {
+		return cmp.Compare(as.Value, b.(*types.AttributeValueMemberS).Value)
+	}
+	an, _ := strconv.ParseInt(a.(*types.AttributeValueMemberN).Value, 10, 64)
+	bn, _ := strconv.ParseInt(b.(*types.AttributeValueMemberN).Value, 10, 64)
+	return cmp.Compare(an, bn)
+}
+
+// sortCursor is the position of a sorted listing after book: its sort key
+// and, to order books with equal keys, its id.
+func (ix sortIndex) sortCursor(book *Book) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		ix.attribute: ix.sortKey(book),
+		idAttribute:  &types.AttributeValueMemberN{Value: strconv.Itoa(book.Id)},
+	}
+}
+
+// decodeSortCursor decodes a cursor issued by sortedPage for ix into the
+// sort key and id of the last book returned. An empty cursor yields a nil
+// key.
+func (ix sortIndex) decodeSortCursor(cursor string) (types.AttributeValue, int, error) {
+	key, err := decodeCursor(cursor)
+	if err != nil || key == nil {
+		return nil, 0, err
+	}
+	invalid := fmt.Errorf("%w: cursor does not belong to this sort", ErrValidation)
+	after, ok := key[ix.attribute]
+	if !ok || reflect.TypeOf(after) != reflect.TypeOf(ix.sortKey(&Book{})) {
+		return nil, 0, invalid
+	}
+	id, err := NumberKey(idAttribute).UnmarshalKey(key)
+	if err != nil {
+		return nil, 0, invalid
+	}
+	return after, id, nil
+}
+
+// sortedPage returns the page of books selected by opts, sorting books in
+// memory. It serves repositories without sort indexes.
+func sortedPage(books []*Book, opts ListOptions) ([]*Book, string, error) {
+	ix, err := opts.index()
+	if err != nil {
+		return nil, "", err
+	}
+	afterKey, afterID, err := ix.decodeSortCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	compare := func(key types.AttributeValue, id int, book *Book) int {
+		c := compareSortKeys(key, ix.sortKey(book))
+		if c == 0 {
+			c = cmp.Compare(id, book.Id)
+		}
+		if opts.descending() {
+			return -c
+		}
+		return c
+	}
+	sorted := slices.Clone(books)
+	slices.SortFunc(sorted, func(a, b *Book) int { return compare(ix.sortKey(a), a.Id, b) })
+
+	start := 0
+	if afterKey != nil {
+		start = sort.Search(len(sorted), func(i int) bool { return compare(afterKey, afterID, sorted[i]) < 0 })
+	}
+	end := len(sorted)
+	if opts.Limit > 0 && start+opts.Limit < end {
+		end = start + opts.Limit
+	}
+	page := sorted[start:end]
+	if end == len(sorted) {
+		return page, "", nil
+	}
+	next, err := encodeCursor(ix.sortCursor(page[len(page)-1]))
+	return page, next, err
+}
+
+// ListSorted implements BookRepository by querying the sort index of
+// opts.SortBy, forwards or backwards. Sort indexes are global secondary
+// indexes, so reads are eventually consistent whatever the ReadOption.
+// Soft-deleted books are dropped after the query, so a page may hold fewer
+// than opts.Limit books even when more follow. Sorts without an index, such
+// as SortByYear, read the whole table and sort it in memory.
+func (d *DynamoDbBookRepository) ListSorted(ctx context.Context, opts ListOptions) ([]*Book, string, error) {
+	ix, err := opts.index()
+	if err != nil {
+		return nil, "", err
+	}
+	if ix.name == "" {
+		books, err := d.List(withRequiredFields(ctx, ix.attribute))
+		if err != nil {
+			return nil, "", err
+		}
+		return sortedPage(books, opts)
+	}
+	startKey, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, ok := startKey[ix.attribute]; startKey != nil && !ok {
+		return nil, "", fmt.Errorf("%w: cursor does not belong to this sort", ErrValidation)
+	}
+	keyCond := expression.Key(listingAttribute).Equal(expression.Value(listingPartition))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, "", err
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(d.tableName),
+		IndexName:                 aws.String(ix.name),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ExclusiveStartKey:         startKey,
+		ScanIndexForward:          aws.Bool(!opts.descending()),
+	}
+	if opts.Limit > 0 {
+		input.Limit = aws.Int32(int32(opts.Limit))
+	}
+
+	books := []*Book{}
+	for {
+		result, err := d.client.Query(ctx, input)
+		if err != nil {
+			return nil, "", translateError(err)
+		}
+		page, err := d.codec.unmarshalList(result.Items)
+		if err != nil {
+			return nil, "", err
+		}
+		books = append(books, d.visible(page)...)
+		if opts.Limit > 0 || len(result.LastEvaluatedKey) == 0 {
+			next, err := encodeCursor(result.LastEvaluatedKey)
+			return books, next, err
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func sortedIDs(books []*Book) []int {
+	ids := make([]int, len(books))
+	for i, b := range books {
+		ids[i] = b.Id
+	}
+	return ids
+}
+
+func TestListSortedQueriesTheSortIndex(t *testing.T) {
+	stub := newDynamoStub(t, scanPages(t,
+		[]map[string]any{wireItem(t, &Book{Id: 3, Name: "Anna Karenina", Author: "Leo Tolstoy", Version: 1})},
+		[]map[string]any{wireItem(t, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 1})},
+	))
+
+	books, next, err := stub.repository().ListSorted(context.Background(), ListOptions{SortBy: SortByName, Order: Descending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sortedIDs(books); !slices.Equal(got, []int{3, 1}) || next != "" {
+		t.Errorf("ListSorted = %v, %q; want the books of both pages in index order", got, next)
+	}
+	if scans := len(stub.callsTo("Scan")); scans != 0 {
+		t.Errorf("%d Scan calls, want none for an indexed sort", scans)
+	}
+	queries := stub.callsTo("Query")
+	if len(queries) != 2 {
+		t.Fatalf("%d Query calls, want 2", len(queries))
+	}
+	in := decodeInput(t, queries[0])
+	if in.IndexName != nameSortIndexName || in.ScanIndexForward == nil || *in.ScanIndexForward {
+		t.Errorf("queried index %q forward %v, want %s backwards", in.IndexName, in.ScanIndexForward, nameSortIndexName)
+	}
+}
+
+func TestListSortedSortsUnindexedFieldsInMemory(t *testing.T) {
+	stub := newDynamoStub(t, scanPages(t,
+		[]map[string]any{
+			wireItem(t, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 1, Year: 1965}),
+			wireItem(t, &Book{Id: 2, Name: "Emma", Author: "Jane Austen", Version: 1, Year: 1815}),
+		},
+		[]map[string]any{
+			wireItem(t, &Book{Id: 3, Name: "Ulysses", Author: "James Joyce", Version: 1, Year: 1922}),
+			wireItem(t, &Book{Id: 4, Name: "Persuasion", Author: "Jane Austen", Version: 1, Year: 1815}),
+		},
+	))
+	repo := stub.repository()
+	ctx := context.Background()
+
+	books, next, err := repo.ListSorted(ctx, ListOptions{SortBy: SortByYear, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sortedIDs(books); !slices.Equal(got, []int{2, 4, 3}) || next == "" {
+		t.Fatalf("ListSorted = %v, %q; want books 2, 4 and 3 and a cursor", got, next)
+	}
+	books, next, err = repo.ListSorted(ctx, ListOptions{SortBy: SortByYear, Limit: 3, Cursor: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sortedIDs(books); !slices.Equal(got, []int{1}) || next != "" {
+		t.Errorf("second page = %v, %q; want book 1 and no cursor", got, next)
+	}
+	if queries := len(stub.callsTo("Query")); queries != 0 {
+		t.Errorf("%d Query calls, want a scan for a sort without an index", queries)
+	}
+
+	books, _, err = repo.ListSorted(ctx, ListOptions{SortBy: SortByYear, Order: Descending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sortedIDs(books); !slices.Equal(got, []int{1, 3, 4, 2}) {
+		t.Errorf("descending ListSorted = %v, want 1, 3, 4, 2", got)
+	}
+}
+
+func TestListSortedRejectsUnknownFields(t *testing.T) {
+	stub := newDynamoStub(t, scanPages(t, nil))
+	_, _, err := stub.repository().ListSorted(context.Background(), ListOptions{SortBy: "isbn"})
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("ListSorted by isbn = %v, want ErrValidation", err)
+	}
+	if len(stub.calls) != 0 {
+		t.Errorf("%d calls, want none for an unknown sort", len(stub.calls))
+	}
+}
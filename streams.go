@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ChangeType is the kind of modification recorded in a stream record.
+type ChangeType string
+
+const (
+	ChangeInsert ChangeType = "INSERT"
+	ChangeModify ChangeType = "MODIFY"
+	ChangeRemove ChangeType = "REMOVE"
+)
+
+// BookChange is a decoded stream record. Old is nil for inserts and New is nil
+// for removals; both are only set if the stream view type includes them.
+type BookChange struct {
+	Type           ChangeType
+	Id             int
+	Old            *Book
+	New            *Book
+	SequenceNumber string
+	// Time is when the change was made, to the second for stream records.
+	Time time.Time
+}
+
+// ChangeHandler reacts to book changes, e.g. by invalidating a cache or
+// updating a search index. Returning an error marks the record as failed so
+// Lambda retries it.
+type ChangeHandler interface {
+	HandleChange(ctx context.Context, change BookChange) error
+}
+
+// ChangeHandlerFunc adapts a function to ChangeHandler.
+type ChangeHandlerFunc func(ctx context.Context, change BookChange) error
+
+// HandleChange implements ChangeHandler.
+func (f ChangeHandlerFunc) HandleChange(ctx context.Context, change BookChange) error {
+	return f(ctx, change)
+}
+
+// CacheInvalidator returns a handler that evicts changed books from cache.
+func CacheInvalidator(cache BookCache) ChangeHandler {
+	return ChangeHandlerFunc(func(ctx context.Context, change BookChange) error {
+		cache.Delete(ctx, change.Id)
+		return nil
+	})
+}
+
+// ChangeLogger returns a handler that logs every change.
+func ChangeLogger(logger *slog.Logger) ChangeHandler {
+	return ChangeHandlerFunc(func(ctx context.Context, change BookChange) error {
+		logger.InfoContext(ctx, "book changed", "type", change.Type, "id", change.Id, "sequence", change.SequenceNumber)
+		return nil
+	})
+}
+
+// StreamDispatcher decodes the records of a DynamoDB stream event and passes
+// each change to its handlers in order.
+type StreamDispatcher struct {
+	handlers []ChangeHandler
+}
+
+func NewStreamDispatcher(handlers ...ChangeHandler) *StreamDispatcher {
+	return &StreamDispatcher{handlers: handlers}
+}
+
+// HandleEvent is a Lambda handler for DynamoDB stream events. Records are
+// processed in order and processing stops at the first failure; that record
+// and the ones after it are reported as batch item failures so Lambda retries
+// them (requires ReportBatchItemFailures on the event source mapping).
+func (s *StreamDispatcher) HandleEvent(ctx context.Context, event events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	var resp events.DynamoDBEventResponse
+	for i, record := range event.Records {
+		if err := s.dispatch(ctx, record); err != nil {
+			slog.ErrorContext(ctx, "handle stream record", "event_id", record.EventID, "error", err)
+			for _, failed := range event.Records[i:] {
+				resp.BatchItemFailures = append(resp.BatchItemFailures, events.DynamoDBBatchItemFailure{
+					ItemIdentifier: failed.Change.SequenceNumber,
+				})
+			}
+			break
+		}
+	}
+	return resp, nil
+}
+
+func (s *StreamDispatcher) dispatch(ctx context.Context, record events.DynamoDBEventRecord) error {
+	change, err := decodeChange(record)
+	if err != nil {
+		return err
+	}
+	for _, h := range s.handlers {
+		if err := h.HandleChange(ctx, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeChange converts a stream record into a BookChange.
+func decodeChange(record events.DynamoDBEventRecord) (BookChange, error) {
+	change := BookChange{
+		Type:           ChangeType(record.EventName),
+		SequenceNumber: record.Change.SequenceNumber,
+		Time:           record.Change.ApproximateCreationDateTime.Time,
+	}
+	switch change.Type {
+	case ChangeInsert, ChangeModify, ChangeRemove:
+	default:
+		return change, fmt.Errorf("unknown stream event %q", record.EventName)
+	}
+
+	key, ok := record.Change.Keys[idAttribute]
+	if !ok || key.DataType() != events.DataTypeNumber {
+		return change, errors.New("stream record has no numeric id key")
+	}
+	id, err := strconv.Atoi(key.Number())
+	if err != nil {
+		return change, fmt.Errorf("invalid id %q: %w", key.Number(), err)
+	}
+	change.Id = id
+
+	if change.Old, err = decodeImage(record.Change.OldImage); err != nil {
+		return change, fmt.Errorf("decode old image: %w", err)
+	}
+	if change.New, err = decodeImage(record.Change.NewImage); err != nil {
+		return change, fmt.Errorf("decode new image: %w", err)
+	}
+	return change, nil
+}
+
+// decodeImage unmarshals a stream image into a Book, or returns nil if the
+// image is absent.
+func decodeImage(image map[string]events.DynamoDBAttributeValue) (*Book, error) {
+	if len(image) == 0 {
+		return nil, nil
+	}
+	item, err := toAttributeValueMap(image)
+	if err != nil {
+		return nil, err
+	}
+	var book Book
+	if err := attributevalue.UnmarshalMap(item, &book); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+func toAttributeValueMap(m map[string]events.DynamoDBAttributeValue) (map[string]types.AttributeValue, error) {
+	item := make(map[string]types.AttributeValue, len(m))
+	for name, v := range m {
+		av, err := toAttributeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		item[name] = av
+	}
+	return item, nil
+}
+
+// toAttributeValue converts a Lambda event attribute value into its SDK form.
+func toAttributeValue(v events.DynamoDBAttributeValue) (types.AttributeValue, error) {
+	switch v.DataType() {
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: v.String()}, nil
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: v.Number()}, nil
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: v.Binary()}, nil
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: v.Boolean()}, nil
+	case events.DataTypeNull:
+		return &types.AttributeValueMemberNULL{Value: true}, nil
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: v.StringSet()}, nil
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: v.NumberSet()}, nil
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: v.BinarySet()}, nil
+	case events.DataTypeList:
+		list := make([]types.AttributeValue, 0, len(v.List()))
+		for _, elem := range v.List() {
+			av, err := toAttributeValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, av)
+		}
+		return &types.AttributeValueMemberL{Value: list}, nil
+	case events.DataTypeMap:
+		m, err := toAttributeValueMap(v.Map())
+		if err != nil {
+			return nil, err
+		}
+		return &types.AttributeValueMemberM{Value: m}, nil
+	}
+	return nil, fmt.Errorf("unsupported attribute type %d", v.DataType())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// tagUpdateConcurrency bounds the number of in-flight UpdateItem calls issued
+// by AddTag and RemoveTag.
+const tagUpdateConcurrency = 10
+
+// AddTag adds tag to the tag set of every book in ids. Adding a tag a book
+// already has is a no-op. It fails if any of the books does not exist.
+func (d *DynamoDbBookRepository) AddTag(ctx context.Context, ids []int, tag string) error {
+	return d.updateTags(ctx, ids, "ADD tags :tag", tag, func(id int) error {
+		return fmt.Errorf("add tag %q: book %d does not exist", tag, id)
+	})
+}
+
+// RemoveTag removes tag from the tag set of every book in ids. Books that do
+// not have the tag, or do not exist, are left as they are. DynamoDB drops the
+// attribute entirely when its last tag is removed.
+func (d *DynamoDbBookRepository) RemoveTag(ctx context.Context, ids []int, tag string) error {
+	return d.updateTags(ctx, ids, "DELETE tags :tag", tag, func(int) error {
+		return nil
+	})
+}
+
+// updateTags applies a set update expression to each book concurrently.
+// missing is called for ids that have no item; its result is returned as the
+// error for that id.
+func (d *DynamoDbBookRepository) updateTags(ctx context.Context, ids []int, expr, tag string, missing func(id int) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(tagUpdateConcurrency)
+	for _, id := range ids {
+		id := id
+		g.Go(func() error {
+			_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				Key:                 d.key.MarshalKey(id),
+				TableName:           aws.String(d.tableName),
+				UpdateExpression:    aws.String(expr),
+				ConditionExpression: aws.String("attribute_exists(id)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":tag": &types.AttributeValueMemberSS{Value: []string{tag}},
+				},
+			})
+			var ccf *types.ConditionalCheckFailedException
+			if errors.As(err, &ccf) {
+				return missing(id)
+			}
+			return translateError(err)
+		})
+	}
+	return g.Wait()
+}
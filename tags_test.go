@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tagStore is a dynamoStub holding the tag sets of books, updated as
+// DynamoDB does by the ADD and DELETE set actions of tag updates. Books
+// without an entry in tags do not exist.
+type tagStore struct {
+	*dynamoStub
+
+	mu   sync.Mutex
+	tags map[int][]string
+}
+
+func newTagStore(t *testing.T, tags map[int][]string) *tagStore {
+	s := &tagStore{tags: tags}
+	s.dynamoStub = newDynamoStub(t, func(op string, input []byte) (any, error) {
+		if op != "UpdateItem" {
+			return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+		}
+		in := decodeInput(t, input)
+		id := keyID(t, in.Key)
+		tag := in.ExpressionAttributeValues[":tag"].value().(*types.AttributeValueMemberSS).Value[0]
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		set, exists := s.tags[id]
+		if !exists {
+			return nil, &stubError{Type: "ConditionalCheckFailedException", Message: "The conditional request failed"}
+		}
+		switch {
+		case strings.HasPrefix(in.UpdateExpression, "ADD tags "):
+			if !slices.Contains(set, tag) {
+				set = append(set, tag)
+			}
+		case strings.HasPrefix(in.UpdateExpression, "DELETE tags "):
+			set = slices.DeleteFunc(set, func(s string) bool { return s == tag })
+		default:
+			return nil, &stubError{Type: "ValidationException", Message: "unexpected update " + in.UpdateExpression}
+		}
+		s.tags[id] = set
+		return nil, nil
+	})
+	return s
+}
+
+// tagsOf returns the sorted tags of book id, and whether the book still has
+// a tags attribute: DynamoDB removes sets left empty.
+func (s *tagStore) tagsOf(id int) ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := slices.Clone(s.tags[id])
+	slices.Sort(tags)
+	return tags, len(tags) > 0
+}
+
+func TestAddTagIsIdempotent(t *testing.T) {
+	store := newTagStore(t, map[int][]string{1: {}, 2: {"classic"}, 3: {"scifi"}})
+	repo := store.repository()
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := repo.AddTag(ctx, []int{1, 2, 3}, "classic"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, want := range map[int][]string{1: {"classic"}, 2: {"classic"}, 3: {"classic", "scifi"}} {
+		if got, _ := store.tagsOf(id); !slices.Equal(got, want) {
+			t.Errorf("book %d has tags %v, want %v", id, got, want)
+		}
+	}
+	if calls := len(store.callsTo("UpdateItem")); calls != 6 {
+		t.Errorf("%d UpdateItem calls, want one per book and call", calls)
+	}
+}
+
+func TestAddTagFailsForMissingBooks(t *testing.T) {
+	store := newTagStore(t, map[int][]string{1: {}})
+	err := store.repository().AddTag(context.Background(), []int{1, 2}, "classic")
+	if err == nil || !strings.Contains(err.Error(), "book 2 does not exist") {
+		t.Errorf("AddTag = %v, want an error naming book 2", err)
+	}
+}
+
+func TestRemoveTag(t *testing.T) {
+	store := newTagStore(t, map[int][]string{
+		1: {"classic", "scifi"},
+		2: {"classic"}, // its last tag
+		3: {"scifi"},   // without the tag
+	})
+
+	// Book 4 does not exist.
+	if err := store.repository().RemoveTag(context.Background(), []int{1, 2, 3, 4}, "classic"); err != nil {
+		t.Fatalf("RemoveTag = %v, want books without the tag and missing books ignored", err)
+	}
+	for _, tc := range []struct {
+		id      int
+		want    []string
+		hasTags bool
+	}{
+		{1, []string{"scifi"}, true},
+		{2, nil, false},
+		{3, []string{"scifi"}, true},
+	} {
+		got, hasTags := store.tagsOf(tc.id)
+		if !slices.Equal(got, tc.want) || hasTags != tc.hasTags {
+			t.Errorf("book %d has tags %v (attribute present: %v), want %v (%v)", tc.id, got, hasTags, tc.want, tc.hasTags)
+		}
+	}
+	for _, input := range store.callsTo("UpdateItem") {
+		if in := decodeInput(t, input); in.UpdateExpression != "DELETE tags :tag" || in.ConditionExpression != "attribute_exists(id)" {
+			t.Errorf("update %q if %q, want DELETE tags :tag if the book exists", in.UpdateExpression, in.ConditionExpression)
+		}
+	}
+}
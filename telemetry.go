@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies this module's tracer and meter.
+const instrumentationName = "dynamoDBExample"
+
+// serviceName is reported as the service.name resource attribute.
+const serviceName = "book-service"
+
+// tracer is the global tracer. It delegates to whatever provider is
+// installed, so it is a no-op until setupTelemetry runs.
+var tracer = otel.Tracer(instrumentationName)
+
+// startSpan starts a span named name as a child of the span in ctx.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records *err on span, if set, and ends it. It takes a pointer so
+// it can be deferred before the error is known.
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// setupTelemetry installs OTLP/HTTP trace and metric exporters as the global
+// providers. The exporters are configured through the standard
+// OTEL_EXPORTER_OTLP_* environment variables. The returned function flushes
+// and stops them.
+func setupTelemetry(ctx context.Context) (func(context.Context) error, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithResource(res),
+	)
+
+	metricExporter, err := otlpmetrichttp.New(ctx)
+	if err != nil {
+		return nil, errors.Join(err, tp.Shutdown(ctx))
+	}
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+	return func(ctx context.Context) error {
+		return errors.Join(tp.Shutdown(ctx), mp.Shutdown(ctx))
+	}, nil
+}
+
+// repositoryAttributes describes operation op on table for spans and
+// metrics.
+func repositoryAttributes(op, table string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.DBSystemDynamoDB,
+		semconv.DBOperation(op),
+		semconv.AWSDynamoDBTableNames(table),
+	}
+}
+
+// Tracing returns a middleware that runs every repository operation in a
+// span tagged with the table and operation name.
+func Tracing(table string) RepositoryMiddleware {
+	return Around(func(ctx context.Context, op string, fn func(context.Context) error) (err error) {
+		ctx, span := startSpan(ctx, "BookRepository."+op, repositoryAttributes(op, table)...)
+		defer endSpan(span, &err)
+		return fn(ctx)
+	})
+}
+
+// Metrics returns a middleware recording request count, error count and
+// latency of every repository operation, tagged with the table and
+// operation name. It fails only if the instruments cannot be created.
+func Metrics(table string) (RepositoryMiddleware, error) {
+	meter := otel.Meter(instrumentationName)
+	requests, err := meter.Int64Counter("dynamodb.requests",
+		metric.WithDescription("Number of repository operations."))
+	if err != nil {
+		return nil, err
+	}
+	failures, err := meter.Int64Counter("dynamodb.errors",
+		metric.WithDescription("Number of repository operations that failed."))
+	if err != nil {
+		return nil, err
+	}
+	latency, err := meter.Float64Histogram("dynamodb.latency",
+		metric.WithDescription("Latency of repository operations."),
+		metric.WithUnit("ms"))
+	if err != nil {
+		return nil, err
+	}
+	return Around(func(ctx context.Context, op string, fn func(context.Context) error) error {
+		start := time.Now()
+		err := fn(ctx)
+		set := metric.WithAttributes(repositoryAttributes(op, table)...)
+		requests.Add(ctx, 1, set)
+		latency.Record(ctx, float64(time.Since(start))/float64(time.Millisecond), set)
+		if err != nil {
+			failures.Add(ctx, 1, set)
+		}
+		return err
+	}), nil
+}
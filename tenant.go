@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tenantAttribute is the partition key of the multi-tenant book table. Each
+// tenant's books live in the partition named after the tenant and are
+// sorted by id.
+const tenantAttribute = "tenant"
+
+// tenantBookKey is the codec of the multi-tenant table's primary key.
+var tenantBookKey = NewCompositeKeyCodec[string, int](StringKey(tenantAttribute), NumberKey(idAttribute))
+
+// TenantResolver returns the tenant a request acts for.
+type TenantResolver interface {
+	Tenant(ctx context.Context) (string, error)
+}
+
+// TenantResolverFunc adapts a function to TenantResolver.
+type TenantResolverFunc func(ctx context.Context) (string, error)
+
+// Tenant implements TenantResolver.
+func (f TenantResolverFunc) Tenant(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a context acting for tenant, as resolved by
+// ContextTenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set with WithTenant, if any.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+// ContextTenant resolves the tenant set with WithTenant and fails with
+// ErrNoTenant if there is none.
+var ContextTenant TenantResolver = TenantResolverFunc(func(ctx context.Context) (string, error) {
+	if tenant, ok := TenantFromContext(ctx); ok {
+		return tenant, nil
+	}
+	return "", ErrNoTenant
+})
+
+// TenantBookRepository implements BookRepository over a table shared by
+// several tenants. Every call resolves the tenant of its context and only
+// touches that tenant's partition: a book id names a different book for
+// each tenant, and listing, querying and deleting never reach another
+// tenant's books. A tenant's books form one partition, so a single tenant
+// is bounded by the throughput of a partition.
+type TenantBookRepository struct {
+	client        *dynamodb.Client
+	clientOptions []func(*dynamodb.Options)
+	tableName     string
+	resolver      TenantResolver
+	batchRetry    batchRetryPolicy
+	callTimeout   time.Duration
+	codec         bookCodec
+}
+
+// TenantOption configures a TenantBookRepository.
+type TenantOption func(*TenantBookRepository)
+
+// WithTenantClientOptions adds options applied to the underlying DynamoDB
+// client, e.g. to point it at a local endpoint.
+func WithTenantClientOptions(optFns ...func(*dynamodb.Options)) TenantOption {
+	return func(t *TenantBookRepository) {
+		t.clientOptions = append(t.clientOptions, optFns...)
+	}
+}
+
+// WithTenantCallTimeout is WithCallTimeout for TenantBookRepository.
+func WithTenantCallTimeout(d time.Duration) TenantOption {
+	return func(t *TenantBookRepository) {
+		t.callTimeout = d
+	}
+}
+
+// NewTenantBookRepository returns a repository scoping every call to the
+// tenant that resolver returns for the call's context.
+func NewTenantBookRepository(cfg aws.Config, tableName string, resolver TenantResolver, opts ...TenantOption) *TenantBookRepository {
+	t := &TenantBookRepository{
+		tableName:   tableName,
+		resolver:    resolver,
+		callTimeout: defaultCallTimeout,
+		batchRetry: batchRetryPolicy{
+			maxRetries: defaultBatchMaxRetries,
+			baseDelay:  defaultBatchBaseDelay,
+			maxDelay:   defaultBatchMaxDelay,
+		},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.client = dynamodb.NewFromConfig(cfg, append(t.clientOptions, func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, addConsumedCapacityMiddleware)
+		if t.callTimeout > 0 {
+			o.APIOptions = append(o.APIOptions, addCallTimeoutMiddleware(t.callTimeout))
+		}
+	})...)
+	return t
+}
+
+// tenantBookTableDefinition describes the multi-tenant book table.
+func tenantBookTableDefinition(tableName string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(tenantAttribute), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String(idAttribute), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(tenantAttribute), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(idAttribute), KeyType: types.KeyTypeRange},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+		StreamSpecification: &types.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: types.StreamViewTypeNewAndOldImages,
+		},
+	}
+}
+
+// MigrateTenant is Migrate for the multi-tenant book table.
+func MigrateTenant(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	return migrateTable(ctx, client, tenantBookTableDefinition(tableName))
+}
+
+// tenant resolves the tenant of ctx.
+func (t *TenantBookRepository) tenant(ctx context.Context) (string, error) {
+	tenant, err := t.resolver.Tenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	if tenant == "" {
+		return "", ErrNoTenant
+	}
+	return tenant, nil
+}
+
+// bookKey returns the primary key of the book of tenant with the given id.
+func (t *TenantBookRepository) bookKey(tenant string, id int) map[string]types.AttributeValue {
+	return tenantBookKey.MarshalKey(CompositeKey[string, int]{Partition: tenant, Sort: id})
+}
+
+// marshal encodes a book of tenant together with its key.
+func (t *TenantBookRepository) marshal(tenant string, book *Book) (map[string]types.AttributeValue, error) {
+	if err := rejectISBN(book, "multi-tenant mode"); err != nil {
+		return nil, err
+	}
+	av, err := t.codec.marshal(book)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range t.bookKey(tenant, book.Id) {
+		av[name] = value
+	}
+	return av, nil
+}
+
+// put writes book for the tenant of ctx with an optional condition.
+func (t *TenantBookRepository) put(ctx context.Context, book *Book, cond *expression.ConditionBuilder) error {
+	tenant, err := t.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	av, err := t.marshal(tenant, book)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{Item: av, TableName: aws.String(t.tableName)}
+	if cond != nil {
+		expr, err := expression.NewBuilder().WithCondition(*cond).Build()
+		if err != nil {
+			return err
+		}
+		input.ConditionExpression = expr.Condition()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+	_, err = t.client.PutItem(ctx, input)
+	return translateError(err)
+}
+
+// Create implements BookRepository. It fails with ErrBookAlreadyExists if
+// the tenant already has a book with the same id.
+func (t *TenantBookRepository) Create(ctx context.Context, book *Book) error {
+	book.Version = 1
+	cond := expression.AttributeNotExists(expression.Name(tenantAttribute))
+	err := t.put(ctx, book, &cond)
+	if isConflict(err) {
+		return ErrBookAlreadyExists
+	}
+	return err
+}
+
+// Upsert implements BookRepository.
+func (t *TenantBookRepository) Upsert(ctx context.Context, book *Book) error {
+	book.Version++
+	return t.put(ctx, book, nil)
+}
+
+// GetById implements BookRepository. Books of other tenants are not found.
+func (t *TenantBookRepository) GetById(ctx context.Context, id int) (*Book, error) {
+	tenant, err := t.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := t.client.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:            t.bookKey(tenant, id),
+		TableName:      aws.String(t.tableName),
+		ConsistentRead: consistentRead(ctx, false),
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+	book := new(Book)
+	if err := t.codec.unmarshal(result.Item, book); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// Update implements BookRepository with the same optimistic locking as
+// DynamoDbBookRepository.Update.
+func (t *TenantBookRepository) Update(ctx context.Context, book *Book) error {
+	expected := book.Version
+	book.Version++
+	cond := expression.Name(versionAttribute).Equal(expression.Value(expected))
+	if expected == 0 {
+		// Books written before versioning was introduced have no version.
+		cond = expression.AttributeExists(expression.Name(tenantAttribute)).
+			And(expression.Or(expression.AttributeNotExists(expression.Name(versionAttribute)), cond))
+	}
+	err := t.put(ctx, book, &cond)
+	if err != nil {
+		book.Version = expected
+	}
+	if isConflict(err) {
+		return ErrVersionConflict
+	}
+	return err
+}
+
+// Delete implements BookRepository. Deleting a missing book, including one
+// of another tenant, is not an error and leaves nothing changed.
+func (t *TenantBookRepository) Delete(ctx context.Context, id int) error {
+	tenant, err := t.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = t.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		Key:       t.bookKey(tenant, id),
+		TableName: aws.String(t.tableName),
+	})
+	return translateError(err)
+}
+
+// List implements BookRepository by querying the tenant's partition.
+func (t *TenantBookRepository) List(ctx context.Context) ([]*Book, error) {
+	return t.query(ctx, nil)
+}
+
+// ListPage implements BookRepository. A cursor issued to another tenant is
+// rejected with ErrValidation.
+func (t *TenantBookRepository) ListPage(ctx context.Context, limit int, cursor string) ([]*Book, string, error) {
+	tenant, err := t.tenant(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if startKey != nil {
+		key, err := tenantBookKey.UnmarshalKey(startKey)
+		if err != nil || key.Partition != tenant {
+			return nil, "", fmt.Errorf("%w: cursor does not belong to this tenant", ErrValidation)
+		}
+	}
+	input, err := t.queryInput(tenant, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	input.ExclusiveStartKey = startKey
+	input.ConsistentRead = consistentRead(ctx, false)
+	if limit > 0 {
+		input.Limit = aws.Int32(int32(limit))
+	}
+	result, err := t.client.Query(ctx, input)
+	if err != nil {
+		return nil, "", translateError(err)
+	}
+	books, err := t.codec.unmarshalList(result.Items)
+	if err != nil {
+		return nil, "", err
+	}
+	next, err := encodeCursor(result.LastEvaluatedKey)
+	return books, next, err
+}
+
+// ListSorted implements BookRepository by reading the tenant's partition
+// and sorting its books in memory. Cursors hold no tenant: one presented by
+// another tenant merely positions that tenant's own listing.
+func (t *TenantBookRepository) ListSorted(ctx context.Context, opts ListOptions) ([]*Book, string, error) {
+	books, err := t.List(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return sortedPage(books, opts)
+}
+
+// GetByAuthor implements BookRepository by querying the tenant's partition
+// with a filter on author.
+func (t *TenantBookRepository) GetByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	filter := expression.Name(authorAttribute).Equal(expression.Value(author))
+	return t.query(ctx, &filter)
+}
+
+// GetByISBN implements BookRepository by querying the tenant's partition
+// with a filter on isbn. The table has no ISBN claims to keep ISBNs unique
+// within a tenant, so writes reject books with one, and only books stored
+// with an ISBN by other means are found.
+func (t *TenantBookRepository) GetByISBN(ctx context.Context, isbn string) (*Book, error) {
+	filter := expression.Name(isbnAttribute).Equal(expression.Value(isbn))
+	books, err := t.query(ctx, &filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(books) == 0 {
+		return nil, ErrNotFound
+	}
+	return books[0], nil
+}
+
+// queryInput returns a query of the partition of tenant with an optional
+// filter.
+func (t *TenantBookRepository) queryInput(tenant string, filter *expression.ConditionBuilder) (*dynamodb.QueryInput, error) {
+	builder := expression.NewBuilder().WithKeyCondition(expression.Key(tenantAttribute).Equal(expression.Value(tenant)))
+	if filter != nil {
+		builder = builder.WithFilter(*filter)
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.QueryInput{
+		TableName:                 aws.String(t.tableName),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}, nil
+}
+
+// query reads every page of the tenant's partition, in id order.
+func (t *TenantBookRepository) query(ctx context.Context, filter *expression.ConditionBuilder) ([]*Book, error) {
+	tenant, err := t.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	input, err := t.queryInput(tenant, filter)
+	if err != nil {
+		return nil, err
+	}
+	input.ConsistentRead = consistentRead(ctx, false)
+	books := []*Book{}
+	paginator := dynamodb.NewQueryPaginator(t.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		pageBooks, err := t.codec.unmarshalList(page.Items)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, pageBooks...)
+	}
+	return books, nil
+}
+
+// BatchCreate implements BookRepository with BatchWriteItem in chunks of 25,
+// writing every book for the tenant of ctx.
+func (t *TenantBookRepository) BatchCreate(ctx context.Context, books []*Book) error {
+	tenant, err := t.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(books); start += batchWriteLimit {
+		chunk := books[start:min(start+batchWriteLimit, len(books))]
+		requests := make([]types.WriteRequest, 0, len(chunk))
+		for _, book := range chunk {
+			if book.Version == 0 {
+				book.Version = 1
+			}
+			av, err := t.marshal(tenant, book)
+			if err != nil {
+				return err
+			}
+			requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: av}})
+		}
+		pending := map[string][]types.WriteRequest{t.tableName: requests}
+		err := t.batchRetry.run(ctx, "BatchWriteItem", func() (int, error) {
+			result, err := t.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return len(pending[t.tableName]), translateError(err)
+			}
+			pending = result.UnprocessedItems
+			return len(pending[t.tableName]), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchGet implements BookRepository with BatchGetItem in chunks of 100.
+// Ids without a book of the tenant are skipped; the books are returned in
+// the order of their first id in ids.
+func (t *TenantBookRepository) BatchGet(ctx context.Context, ids []int) ([]*Book, error) {
+	tenant, err := t.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// BatchGetItem rejects requests that contain the same key twice.
+	seen := make(map[int]bool, len(ids))
+	unique := make([]int, 0, len(ids))
+	keys := make([]map[string]types.AttributeValue, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+			keys = append(keys, t.bookKey(tenant, id))
+		}
+	}
+
+	// BatchGetItem returns the items in no particular order.
+	found := make(map[int]*Book, len(keys))
+	for start := 0; start < len(keys); start += batchGetLimit {
+		pending := map[string]types.KeysAndAttributes{t.tableName: {
+			Keys:           keys[start:min(start+batchGetLimit, len(keys))],
+			ConsistentRead: consistentRead(ctx, false),
+		}}
+		err := t.batchRetry.run(ctx, "BatchGetItem", func() (int, error) {
+			result, err := t.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: pending})
+			if err != nil {
+				return len(pending[t.tableName].Keys), translateError(err)
+			}
+			chunk, err := t.codec.unmarshalList(result.Responses[t.tableName])
+			if err != nil {
+				return 0, err
+			}
+			for _, book := range chunk {
+				found[book.Id] = book
+			}
+			pending = result.UnprocessedKeys
+			return len(pending[t.tableName].Keys), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	books := make([]*Book, 0, len(found))
+	for _, id := range unique {
+		if book, ok := found[id]; ok {
+			books = append(books, book)
+		}
+	}
+	return books, nil
+}
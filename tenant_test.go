@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tenantTableStub is a dynamoStub keeping the items of a multi-tenant table
+// by tenant and id. Queries must have a key condition on the tenant and may
+// have a filter comparing one attribute for equality; they return items in
+// id order, honoring Limit and ExclusiveStartKey. A PutItem conditioned on
+// attribute_not_exists fails if the item exists; other conditions are not
+// checked. BatchGetItem returns the items found in the reverse order of
+// their keys.
+type tenantTableStub struct {
+	*dynamoStub
+
+	mu    sync.Mutex
+	items map[string]map[int]map[string]types.AttributeValue
+}
+
+func newTenantTableStub(t *testing.T) *tenantTableStub {
+	s := &tenantTableStub{items: map[string]map[int]map[string]types.AttributeValue{}}
+	s.dynamoStub = newDynamoStub(t, func(op string, input []byte) (any, error) {
+		in := decodeInput(t, input)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch op {
+		case "PutItem":
+			item := attributes(in.Item)
+			key := s.key(t, item)
+			if strings.HasPrefix(in.ConditionExpression, "attribute_not_exists") && s.items[key.Partition][key.Sort] != nil {
+				return nil, &stubError{Type: "ConditionalCheckFailedException", Message: "The conditional request failed"}
+			}
+			s.put(key, item)
+			return nil, nil
+		case "GetItem":
+			key := s.key(t, attributes(in.Key))
+			if item := s.items[key.Partition][key.Sort]; item != nil {
+				return map[string]any{"Item": wireAttributes(t, item)}, nil
+			}
+			return map[string]any{}, nil
+		case "DeleteItem":
+			key := s.key(t, attributes(in.Key))
+			delete(s.items[key.Partition], key.Sort)
+			return nil, nil
+		case "Query":
+			return s.query(t, in), nil
+		case "BatchGetItem":
+			var batch struct {
+				RequestItems map[string]struct {
+					Keys []map[string]*encodedAttribute
+				}
+			}
+			if err := json.Unmarshal(input, &batch); err != nil {
+				return nil, err
+			}
+			items := []map[string]any{}
+			keys := batch.RequestItems[stubTable].Keys
+			for i := len(keys) - 1; i >= 0; i-- {
+				key := s.key(t, attributes(keys[i]))
+				if item := s.items[key.Partition][key.Sort]; item != nil {
+					items = append(items, wireAttributes(t, item))
+				}
+			}
+			return map[string]any{"Responses": map[string]any{stubTable: items}}, nil
+		}
+		return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+	})
+	return s
+}
+
+func (s *tenantTableStub) key(t *testing.T, item map[string]types.AttributeValue) CompositeKey[string, int] {
+	t.Helper()
+	key, err := tenantBookKey.UnmarshalKey(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (s *tenantTableStub) put(key CompositeKey[string, int], item map[string]types.AttributeValue) {
+	if s.items[key.Partition] == nil {
+		s.items[key.Partition] = map[int]map[string]types.AttributeValue{}
+	}
+	s.items[key.Partition][key.Sort] = item
+}
+
+// seed stores book for tenant directly, bypassing the repository.
+func (s *tenantTableStub) seed(t *testing.T, tenant string, book *Book) {
+	t.Helper()
+	var codec bookCodec
+	item, err := codec.marshal(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := CompositeKey[string, int]{Partition: tenant, Sort: book.Id}
+	for name, value := range tenantBookKey.MarshalKey(key) {
+		item[name] = value
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, item)
+}
+
+func (s *tenantTableStub) query(t *testing.T, in wireInput) map[string]any {
+	// Conditions built by the expression package read "#0 = :0".
+	operands := func(cond string) (string, types.AttributeValue) {
+		fields := strings.Fields(cond)
+		if len(fields) != 3 || fields[1] != "=" {
+			t.Fatalf("unexpected condition %q", cond)
+		}
+		return in.ExpressionAttributeNames[fields[0]], in.ExpressionAttributeValues[fields[2]].value()
+	}
+	name, partition := operands(in.KeyConditionExpression)
+	if name != tenantAttribute {
+		t.Fatalf("query on %s, want a key condition on %s", name, tenantAttribute)
+	}
+	tenant := partition.(*types.AttributeValueMemberS).Value
+	ids := []int{}
+	for id := range s.items[tenant] {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	if in.ExclusiveStartKey != nil {
+		start := s.key(t, attributes(in.ExclusiveStartKey))
+		ids = slices.DeleteFunc(ids, func(id int) bool { return id <= start.Sort })
+	}
+	output := map[string]any{}
+	if in.Limit != nil && *in.Limit < len(ids) {
+		ids = ids[:*in.Limit]
+		last := CompositeKey[string, int]{Partition: tenant, Sort: ids[len(ids)-1]}
+		output["LastEvaluatedKey"] = wireAttributes(t, tenantBookKey.MarshalKey(last))
+	}
+	items := []map[string]any{}
+	for _, id := range ids {
+		item := s.items[tenant][id]
+		if in.FilterExpression != "" {
+			name, want := operands(in.FilterExpression)
+			if got, ok := item[name].(*types.AttributeValueMemberS); !ok || got.Value != want.(*types.AttributeValueMemberS).Value {
+				continue
+			}
+		}
+		items = append(items, wireAttributes(t, item))
+	}
+	output["Items"], output["Count"] = items, len(items)
+	return output
+}
+
+// repository returns a TenantBookRepository of the stub resolving tenants
+// with ContextTenant.
+func (s *tenantTableStub) repository() *TenantBookRepository {
+	return NewTenantBookRepository(aws.Config{Region: "us-east-1"}, stubTable, ContextTenant,
+		WithTenantClientOptions(endpointOption(s.url)))
+}
+
+func TestTenantsDoNotSeeEachOthersBooks(t *testing.T) {
+	stub := newTenantTableStub(t)
+	repo := stub.repository()
+	a, b := WithTenant(context.Background(), "a"), WithTenant(context.Background(), "b")
+	if err := repo.Create(a, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+		t.Fatal(err)
+	}
+	stub.seed(t, "a", &Book{Id: 2, Name: "Emma", Author: "Jane Austen", ISBN: "9780141439587", Version: 1})
+
+	if _, err := repo.GetById(b, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetById of tenant a's book by tenant b: got %v, want ErrNotFound", err)
+	}
+	if _, err := repo.GetByISBN(b, "9780141439587"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByISBN of tenant a's book by tenant b: got %v, want ErrNotFound", err)
+	}
+	if book, err := repo.GetByISBN(a, "9780141439587"); err != nil || book.Id != 2 {
+		t.Errorf("GetByISBN by tenant a: got %v, %v, want book 2", book, err)
+	}
+	if books, err := repo.List(b); err != nil || len(books) != 0 {
+		t.Errorf("List by tenant b: got %v, %v, want no books", books, err)
+	}
+	stub.seed(t, "a", &Book{Id: 3, Name: "Ulysses", Author: "James Joyce", Version: 1})
+	if books, err := repo.BatchGet(a, []int{3, 1, 4, 2, 1}); err != nil || len(books) != 3 ||
+		books[0].Id != 3 || books[1].Id != 1 || books[2].Id != 2 {
+		t.Errorf("BatchGet by tenant a: got %v, %v, want books 3, 1 and 2 in the order of the ids", books, err)
+	}
+	if books, err := repo.BatchGet(b, []int{1, 2}); err != nil || len(books) != 0 {
+		t.Errorf("BatchGet by tenant b: got %v, %v, want no books", books, err)
+	}
+
+	// Tenant b's book 1 is another book than tenant a's.
+	if err := repo.Create(b, &Book{Id: 1, Name: "Ulysses", Author: "James Joyce"}); err != nil {
+		t.Fatalf("Create of book 1 by tenant b: %v", err)
+	}
+	if err := repo.Delete(b, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(b, 2); err != nil {
+		t.Errorf("Delete of a book only tenant a has: %v", err)
+	}
+	for _, id := range []int{1, 2} {
+		if _, err := repo.GetById(a, id); err != nil {
+			t.Errorf("tenant a's book %d after deletes by tenant b: %v", id, err)
+		}
+	}
+	if _, err := repo.GetById(b, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("tenant b's deleted book 1: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestTenantListPageRejectsCursorsOfOtherTenants(t *testing.T) {
+	stub := newTenantTableStub(t)
+	repo := stub.repository()
+	a, b := WithTenant(context.Background(), "a"), WithTenant(context.Background(), "b")
+	for id := 1; id <= 3; id++ {
+		stub.seed(t, "a", &Book{Id: id, Name: "Book", Author: "Author", Version: 1})
+		stub.seed(t, "b", &Book{Id: id, Name: "Book", Author: "Author", Version: 1})
+	}
+
+	page, cursor, err := repo.ListPage(a, 2, "")
+	if err != nil || len(page) != 2 || cursor == "" {
+		t.Fatalf("first page of tenant a: got %d books, cursor %q, %v", len(page), cursor, err)
+	}
+	if _, _, err := repo.ListPage(b, 2, cursor); !errors.Is(err, ErrValidation) {
+		t.Errorf("tenant a's cursor used by tenant b: got %v, want ErrValidation", err)
+	}
+	page, cursor, err = repo.ListPage(a, 2, cursor)
+	if err != nil || len(page) != 1 || page[0].Id != 3 || cursor != "" {
+		t.Errorf("second page of tenant a: got %v, cursor %q, %v, want book 3 and no cursor", page, cursor, err)
+	}
+}
+
+func TestTenantRepositoryRequiresATenant(t *testing.T) {
+	stub := newTenantTableStub(t)
+	repo := stub.repository()
+	ctx := context.Background()
+	if _, err := repo.GetById(ctx, 1); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("GetById without tenant: got %v, want ErrNoTenant", err)
+	}
+	if err := repo.Delete(ctx, 1); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("Delete without tenant: got %v, want ErrNoTenant", err)
+	}
+	if calls := len(stub.calls); calls != 0 {
+		t.Errorf("%d calls reached DynamoDB, want none", calls)
+	}
+}
+
+func TestWritesWithoutISBNClaimsRejectISBNs(t *testing.T) {
+	stub := newDynamoStub(t, func(op string, input []byte) (any, error) {
+		return nil, &stubError{Type: "ValidationException", Message: "unexpected " + op}
+	})
+	cfg := aws.Config{Region: "us-east-1"}
+	ctx := WithTenant(context.Background(), "a")
+	for name, repo := range map[string]BookRepository{
+		"composite": NewCompositeBookRepository(cfg, stubTable, WithCompositeClientOptions(endpointOption(stub.url))),
+		"tenant":    NewTenantBookRepository(cfg, stubTable, ContextTenant, WithTenantClientOptions(endpointOption(stub.url))),
+	} {
+		t.Run(name, func(t *testing.T) {
+			book := func() *Book { return &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", ISBN: "9780441172719"} }
+			for op, err := range map[string]error{
+				"Create":      repo.Create(ctx, book()),
+				"Upsert":      repo.Upsert(ctx, book()),
+				"BatchCreate": repo.BatchCreate(ctx, []*Book{book()}),
+			} {
+				var fields FieldErrors
+				if !errors.As(err, &fields) || fields["isbn"] == "" {
+					t.Errorf("%s of a book with an ISBN: got %v, want a FieldErrors for isbn", op, err)
+				}
+			}
+		})
+	}
+	if calls := len(stub.calls); calls != 0 {
+		t.Errorf("%d calls reached DynamoDB, want none", calls)
+	}
+}
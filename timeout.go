@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/smithy-go/middleware"
+)
+
+// defaultCallTimeout bounds each DynamoDB call, including the SDK's retries,
+// whose context has no deadline of its own.
+const defaultCallTimeout = 2 * time.Second
+
+// WithCallTimeout sets the timeout applied to DynamoDB calls made with a
+// context without deadline, so a hung connection fails the call instead of
+// blocking it indefinitely. Zero or a negative value disables the default;
+// callers' own deadlines are always respected.
+func WithCallTimeout(d time.Duration) RepositoryOption {
+	return func(r *DynamoDbBookRepository) {
+		r.callTimeout = d
+	}
+}
+
+// WithCompositeCallTimeout is WithCallTimeout for CompositeBookRepository.
+func WithCompositeCallTimeout(d time.Duration) CompositeOption {
+	return func(c *CompositeBookRepository) {
+		c.callTimeout = d
+	}
+}
+
+// addCallTimeoutMiddleware returns a stack option installing a middleware
+// that gives calls without deadline a timeout of d. It runs first in the
+// stack, so the timeout covers the SDK's retries.
+func addCallTimeoutMiddleware(d time.Duration) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CallTimeout",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				if _, ok := ctx.Deadline(); ok {
+					return next.HandleInitialize(ctx, in)
+				}
+				ctx, cancel := context.WithTimeout(ctx, d)
+				defer cancel()
+				return next.HandleInitialize(ctx, in)
+			}), middleware.Before)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attribute names of the author stats table, which is keyed by author.
+const (
+	statsAuthorAttribute    = "author"
+	statsBookCountAttribute = "bookCount"
+)
+
+// AuthorStats holds per-author counters kept in step with the book table.
+type AuthorStats struct {
+	Author    string `json:"author" dynamodbav:"author"`
+	BookCount int    `json:"bookCount" dynamodbav:"bookCount"`
+}
+
+// TransactionalRepository writes books together with their author's stats in
+// a single TransactWriteItems call, so the counters can never drift from the
+// book table.
+type TransactionalRepository struct {
+	books      *DynamoDbBookRepository
+	statsTable string
+}
+
+func NewTransactionalRepository(books *DynamoDbBookRepository, statsTable string) *TransactionalRepository {
+	return &TransactionalRepository{books: books, statsTable: statsTable}
+}
+
+// CancellationReason explains why one item of a transaction was rejected.
+type CancellationReason struct {
+	// Item describes the transaction item, e.g. "put book 7".
+	Item    string
+	Code    string
+	Message string
+}
+
+// TransactionCanceledError reports a cancelled transaction along with the
+// reason for every item that caused it. It matches ErrConflict when an item
+// failed its condition check and ErrThrottled when DynamoDB throttled it.
+type TransactionCanceledError struct {
+	Reasons []CancellationReason
+	err     error
+}
+
+func (e *TransactionCanceledError) Error() string {
+	parts := make([]string, 0, len(e.Reasons))
+	for _, r := range e.Reasons {
+		parts = append(parts, fmt.Sprintf("%s: %s", r.Item, r.Code))
+	}
+	return "transaction canceled: " + strings.Join(parts, ", ")
+}
+
+func (e *TransactionCanceledError) Unwrap() error {
+	return e.err
+}
+
+// rejected reports whether the item labelled item failed its condition.
+func (e *TransactionCanceledError) rejected(item string) bool {
+	for _, r := range e.Reasons {
+		if r.Item == item && r.Code == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *TransactionCanceledError) Is(target error) bool {
+	for _, r := range e.Reasons {
+		switch {
+		case target == ErrConflict && r.Code == "ConditionalCheckFailed":
+			return true
+		case target == ErrThrottled && r.Code == "ThrottlingError":
+			return true
+		}
+	}
+	return false
+}
+
+// CreateBook creates book and increments its author's book count atomically,
+// claiming its ISBN in the same transaction. The whole transaction fails if
+// the book already exists.
+func (t *TransactionalRepository) CreateBook(ctx context.Context, book *Book) error {
+	book.Version = 1
+	av, err := t.books.codec.marshal(book)
+	if err != nil {
+		return err
+	}
+	items := []types.TransactWriteItem{
+		{Put: &types.Put{
+			TableName:                aws.String(t.books.tableName),
+			Item:                     av,
+			ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+			ExpressionAttributeNames: map[string]string{"#id": idAttribute},
+		}},
+		t.adjustBookCount(book.Author, 1),
+	}
+	labels := []string{fmt.Sprintf("put book %d", book.Id), "increment stats of " + book.Author}
+	return t.books.transactClaimingISBN(ctx, book, items, labels)
+}
+
+// DeleteBook deletes the book and decrements its author's book count
+// atomically. It fails with an error matching ErrConflict if the book does
+// not exist.
+func (t *TransactionalRepository) DeleteBook(ctx context.Context, id int) error {
+	book, err := t.books.GetById(withReadOptions(ctx, []ReadOption{WithConsistentRead()}), id)
+	if err != nil {
+		return err
+	}
+	items := []types.TransactWriteItem{
+		{Delete: &types.Delete{
+			TableName: aws.String(t.books.tableName),
+			Key:       t.books.key.MarshalKey(id),
+			// Guard against the book changing author or being deleted
+			// since it was read.
+			ConditionExpression:      aws.String("#author = :author"),
+			ExpressionAttributeNames: map[string]string{"#author": authorAttribute},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":author": &types.AttributeValueMemberS{Value: book.Author},
+			},
+		}},
+		t.adjustBookCount(book.Author, -1),
+	}
+	labels := []string{fmt.Sprintf("delete book %d", id), "decrement stats of " + book.Author}
+	return t.write(ctx, items, labels)
+}
+
+// GetAuthorStats returns the counters of author, or ErrNotFound if the
+// author has never had a book.
+func (t *TransactionalRepository) GetAuthorStats(ctx context.Context, author string) (*AuthorStats, error) {
+	result, err := t.books.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(t.statsTable),
+		Key: map[string]types.AttributeValue{
+			statsAuthorAttribute: &types.AttributeValueMemberS{Value: author},
+		},
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+	stats := new(AuthorStats)
+	if err := attributevalue.UnmarshalMap(result.Item, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// adjustBookCount returns a transaction item adding delta to the author's
+// book count. Decrements are conditional so the count never goes negative.
+func (t *TransactionalRepository) adjustBookCount(author string, delta int) types.TransactWriteItem {
+	update := &types.Update{
+		TableName: aws.String(t.statsTable),
+		Key: map[string]types.AttributeValue{
+			statsAuthorAttribute: &types.AttributeValueMemberS{Value: author},
+		},
+		UpdateExpression:         aws.String("ADD #count :delta"),
+		ExpressionAttributeNames: map[string]string{"#count": statsBookCountAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":delta": &types.AttributeValueMemberN{Value: strconv.Itoa(delta)},
+		},
+	}
+	if delta < 0 {
+		update.ConditionExpression = aws.String("#count >= :min")
+		update.ExpressionAttributeValues[":min"] = &types.AttributeValueMemberN{Value: strconv.Itoa(-delta)}
+	}
+	return types.TransactWriteItem{Update: update}
+}
+
+// write runs a transaction. labels describe items[i] in cancellation reasons.
+func (t *TransactionalRepository) write(ctx context.Context, items []types.TransactWriteItem, labels []string) error {
+	return t.books.transact(ctx, items, labels)
+}
+
+// transact runs a transaction, reporting a cancellation as a
+// TransactionCanceledError. labels describe items[i] in its reasons.
+func (d *DynamoDbBookRepository) transact(ctx context.Context, items []types.TransactWriteItem, labels []string) error {
+	_, err := d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return translateError(err)
+	}
+
+	txErr := &TransactionCanceledError{err: translateError(err)}
+	for i, r := range canceled.CancellationReasons {
+		code := aws.ToString(r.Code)
+		if code == "" || code == "None" {
+			continue
+		}
+		label := fmt.Sprintf("item %d", i)
+		if i < len(labels) {
+			label = labels[i]
+		}
+		txErr.Reasons = append(txErr.Reasons, CancellationReason{
+			Item:    label,
+			Code:    code,
+			Message: aws.ToString(r.Message),
+		})
+	}
+	return txErr
+}
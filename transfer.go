@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is a serialization format for bulk import and export.
+type Format string
+
+// Supported bulk formats. CSV files start with a header row naming the
+// columns; NDJSON holds one JSON book per line.
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+// exportPageSize is the number of books read per scan page while exporting.
+const exportPageSize = 100
+
+// csvColumns are the columns written by ExportBooks in CSV format. Tags are
+// joined with csvTagSeparator, and times are in RFC 3339, empty if zero.
+// Imports read columns by name, so files written before a column was added
+// still import, leaving its field empty.
+var csvColumns = []string{"id", "name", "author", "version", "tags", "year", "isbn", "publishedAt", "createdAt"}
+
+const csvTagSeparator = ";"
+
+// TransferOption adjusts a single import or export.
+type TransferOption func(*transferConfig)
+
+// transferConfig is the configuration of an import or export.
+type transferConfig struct {
+	sorted    bool
+	flushSize int
+}
+
+// WithSortedExport makes an export buffer every book and write them sorted
+// by id, so that exports of the same data are byte-identical. The whole
+// table is then held in memory until it is written; use it for tables that
+// fit, such as fixtures and snapshots compared in review.
+func WithSortedExport() TransferOption {
+	return func(c *transferConfig) {
+		c.sorted = true
+	}
+}
+
+// WithFlushSize makes an import write its books in batches of n instead of
+// batchWriteLimit, the most a BatchWriteItem call takes, which is also used
+// when n is outside 1 to batchWriteLimit. Smaller batches hold fewer books
+// in memory and report progress, given a ProgressFunc with WithProgress,
+// after every n books.
+func WithFlushSize(n int) TransferOption {
+	return func(c *transferConfig) {
+		c.flushSize = n
+	}
+}
+
+func newTransferConfig(opts []TransferOption) transferConfig {
+	c := transferConfig{flushSize: batchWriteLimit}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.flushSize < 1 || c.flushSize > batchWriteLimit {
+		c.flushSize = batchWriteLimit
+	}
+	return c
+}
+
+// ParseFormat returns the Format named s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatCSV, FormatNDJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unsupported format %q", s)
+}
+
+// ImportBooks reads books from r and writes them with BatchCreate in chunks
+// of 25, or of the WithFlushSize, written concurrently by the import
+// workers, so only a few chunks are held in memory at a time. Existing books
+// with the same id are overwritten. It returns the number of books written;
+// on error, books from other chunks may already have been stored.
+func (uc *BookUseCase) ImportBooks(ctx context.Context, r io.Reader, format Format, opts ...TransferOption) (n int, err error) {
+	ctx, end := uc.begin(ctx, "ImportBooks")
+	defer end(&err)
+
+	next, err := newBookDecoder(r, format)
+	if err != nil {
+		return 0, err
+	}
+	config := newTransferConfig(opts)
+	pool := NewWorkerPool(ctx, uc.importWorkers)
+	submit := func(chunk []*Book) {
+		pool.Go(func(ctx context.Context) (int, error) {
+			if err := uc.BatchCreate(ctx, chunk); err != nil {
+				return 0, err
+			}
+			return len(chunk), nil
+		})
+	}
+	var decodeErr error
+	chunk := make([]*Book, 0, config.flushSize)
+	for {
+		book, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			decodeErr = err
+			break
+		}
+		chunk = append(chunk, book)
+		if len(chunk) == config.flushSize {
+			submit(chunk)
+			chunk = make([]*Book, 0, config.flushSize)
+		}
+	}
+	if len(chunk) > 0 && decodeErr == nil {
+		submit(chunk)
+	}
+	err = pool.Wait()
+	if decodeErr != nil {
+		err = decodeErr
+	}
+	return pool.Done(), err
+}
+
+// ExportBooks writes every book to w, reading the table one page at a time.
+// It returns the number of books written.
+func (uc *BookUseCase) ExportBooks(ctx context.Context, w io.Writer, format Format, opts ...TransferOption) (n int, err error) {
+	ctx, end := uc.begin(ctx, "ExportBooks")
+	defer end(&err)
+
+	enc, err := newBookEncoder(w, format, newTransferConfig(opts))
+	if err != nil {
+		return 0, err
+	}
+	cursor := ""
+	for {
+		books, next, err := uc.ListPage(ctx, exportPageSize, cursor)
+		if err != nil {
+			return n, err
+		}
+		for _, book := range books {
+			if err := enc.encode(book); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if err := enc.flush(); err != nil {
+			return n, err
+		}
+		if progress := progressFrom(ctx); progress != nil {
+			progress(n)
+		}
+		if next == "" {
+			return n, enc.close()
+		}
+		cursor = next
+	}
+}
+
+// newBookDecoder returns a function yielding the books in r one at a time.
+// It returns io.EOF once the input is exhausted.
+func newBookDecoder(r io.Reader, format Format) (func() (*Book, error), error) {
+	switch format {
+	case FormatNDJSON:
+		dec := json.NewDecoder(r)
+		line := 0
+		return func() (*Book, error) {
+			line++
+			var raw json.RawMessage
+			var book Book
+			err := dec.Decode(&raw)
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			if err == nil {
+				err = decodeBookJSON(raw, &book, true)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("record %d: %w", line, err)
+			}
+			return &book, nil
+		}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return func() (*Book, error) { return nil, io.EOF }, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read csv header: %w", err)
+		}
+		columns := make(map[string]int, len(header))
+		for i, name := range header {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		if _, ok := columns["id"]; !ok {
+			return nil, errors.New("csv header has no id column")
+		}
+		return func() (*Book, error) {
+			record, err := cr.Read()
+			if err != nil {
+				return nil, err
+			}
+			line, _ := cr.FieldPos(0)
+			book, err := bookFromCSV(columns, record)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			return book, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+func bookFromCSV(columns map[string]int, record []string) (*Book, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	var book Book
+	var err error
+	if book.Id, err = strconv.Atoi(field("id")); err != nil {
+		return nil, fmt.Errorf("invalid id %q", field("id"))
+	}
+	if v := field("version"); v != "" {
+		if book.Version, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid version %q", v)
+		}
+	}
+	if v := field("year"); v != "" {
+		if book.Year, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid year %q", v)
+		}
+	}
+	if v := field("publishedat"); v != "" {
+		if book.PublishedAt, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("invalid publishedAt %q", v)
+		}
+	}
+	if v := field("createdat"); v != "" {
+		if book.CreatedAt, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("invalid createdAt %q", v)
+		}
+	}
+	book.Name = field("name")
+	book.Author = field("author")
+	book.ISBN = field("isbn")
+	if tags := field("tags"); tags != "" {
+		book.Tags = strings.Split(tags, csvTagSeparator)
+	}
+	return &book, nil
+}
+
+// bookEncoder writes books in one of the bulk formats.
+type bookEncoder struct {
+	encode func(*Book) error
+	flush  func() error
+	// close writes what encode and flush held back, if anything.
+	close func() error
+}
+
+func newBookEncoder(w io.Writer, format Format, config transferConfig) (*bookEncoder, error) {
+	enc, err := newFormatEncoder(w, format)
+	if err != nil || !config.sorted {
+		return enc, err
+	}
+	var books []*Book
+	return &bookEncoder{
+		encode: func(b *Book) error {
+			books = append(books, b)
+			return nil
+		},
+		flush: func() error { return nil },
+		close: func() error {
+			slices.SortFunc(books, func(a, b *Book) int { return cmp.Compare(a.Id, b.Id) })
+			for _, book := range books {
+				if err := enc.encode(book); err != nil {
+					return err
+				}
+			}
+			return enc.flush()
+		},
+	}, nil
+}
+
+func newFormatEncoder(w io.Writer, format Format) (*bookEncoder, error) {
+	switch format {
+	case FormatNDJSON:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		return &bookEncoder{encode: func(b *Book) error { return enc.Encode(b) }, flush: bw.Flush, close: bw.Flush}, nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvColumns); err != nil {
+			return nil, err
+		}
+		flush := func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		return &bookEncoder{
+			encode: func(b *Book) error {
+				return cw.Write([]string{
+					strconv.Itoa(b.Id), b.Name, b.Author, strconv.Itoa(b.Version),
+					strings.Join(b.Tags, csvTagSeparator), strconv.Itoa(b.Year),
+					b.ISBN, csvTime(b.PublishedAt), csvTime(b.CreatedAt),
+				})
+			},
+			flush: flush,
+			close: flush,
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// csvTime formats t for a CSV column, empty if t is zero.
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// ExportBooks writes every book to w using a parallel scan with the given
+// number of segments. Pages are encoded as they arrive, so memory use stays
+// bounded however large the table is; the output is in no particular order
+// unless WithSortedExport is given. Like List, it skips soft-deleted books.
+func (d *DynamoDbBookRepository) ExportBooks(ctx context.Context, w io.Writer, format Format, segments int, opts ...TransferOption) (n int, err error) {
+	ctx, span := startSpan(ctx, "DynamoDbBookRepository.ExportBooks")
+	defer endSpan(span, &err)
+
+	enc, err := newBookEncoder(w, format, newTransferConfig(opts))
+	if err != nil {
+		return 0, err
+	}
+	err = d.scanSegments(ctx, segments, func(page []*Book) error {
+		for _, book := range page {
+			if err := enc.encode(book); err != nil {
+				return err
+			}
+			n++
+		}
+		return enc.flush()
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, enc.close()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// segmentedStub answers parallel scans of 4 segments, each holding 5 books,
+// with random delays so that segments finish in a different order each run.
+func segmentedStub(t *testing.T) *dynamoStub {
+	return newDynamoStub(t, func(op string, input []byte) (any, error) {
+		var in struct{ Segment int }
+		if err := json.Unmarshal(input, &in); err != nil {
+			return nil, err
+		}
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		var items []map[string]any
+		for k := 0; k < 5; k++ {
+			id := 1 + in.Segment + 4*k
+			items = append(items, wireItem(t, &Book{Id: id, Name: "Book " + strconv.Itoa(id), Author: "Author", Version: 1}))
+		}
+		return map[string]any{"Items": items, "Count": len(items)}, nil
+	})
+}
+
+func TestSortedExportsAreByteIdentical(t *testing.T) {
+	repo := segmentedStub(t).repository(WithBulkWorkers(4))
+	ctx := context.Background()
+
+	for _, format := range []Format{FormatCSV, FormatNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			var first []byte
+			for run := 0; run < 5; run++ {
+				var buf bytes.Buffer
+				n, err := repo.ExportBooks(ctx, &buf, format, 4, WithSortedExport())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != 20 {
+					t.Fatalf("exported %d books, want 20", n)
+				}
+				if run == 0 {
+					first = buf.Bytes()
+					continue
+				}
+				if !bytes.Equal(buf.Bytes(), first) {
+					t.Fatalf("export %d differs from the first:\n%s\nwant:\n%s", run, buf.Bytes(), first)
+				}
+			}
+
+			ids := exportedIDs(t, format, first)
+			for i, id := range ids {
+				if id != i+1 {
+					t.Fatalf("exported ids %v, want 1 to 20 in order", ids)
+				}
+			}
+		})
+	}
+}
+
+// exportedIDs returns the ids of the books in an export, in order.
+func exportedIDs(t *testing.T, format Format, export []byte) []int {
+	t.Helper()
+	next, err := newBookDecoder(bytes.NewReader(export), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for {
+		book, err := next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatal(err)
+			}
+			return ids
+		}
+		ids = append(ids, book.Id)
+	}
+}
+
+func TestImportBooksFlushesBatchesOfFlushSize(t *testing.T) {
+	var input bytes.Buffer
+	for id := 1; id <= 35; id++ {
+		fmt.Fprintf(&input, `{"id":%d,"name":"Book %d","author":"Author"}`+"\n", id, id)
+	}
+	repo := NewMemoryBookRepository()
+	uc := NewBookUseCase(repo, WithImportWorkers(1))
+	var progress []int
+	ctx := WithProgress(context.Background(), func(done int) { progress = append(progress, done) })
+
+	n, err := uc.ImportBooks(ctx, &input, FormatNDJSON, WithFlushSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 35 {
+		t.Errorf("imported %d books, want 35", n)
+	}
+	if want := []int{10, 20, 30, 35}; !slices.Equal(progress, want) {
+		t.Errorf("progress reported %v, want %v", progress, want)
+	}
+	books, err := repo.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 35 {
+		t.Errorf("%d books stored, want 35", len(books))
+	}
+}
+
+func TestWithFlushSizeStaysWithinTheBatchWriteLimit(t *testing.T) {
+	for _, tc := range []struct{ n, want int }{{0, 25}, {1, 1}, {10, 10}, {25, 25}, {100, 25}} {
+		if got := newTransferConfig([]TransferOption{WithFlushSize(tc.n)}).flushSize; got != tc.want {
+			t.Errorf("WithFlushSize(%d) flushes %d books, want %d", tc.n, got, tc.want)
+		}
+	}
+	if got := newTransferConfig(nil).flushSize; got != batchWriteLimit {
+		t.Errorf("default flush size %d, want %d", got, batchWriteLimit)
+	}
+}
+
+func TestCSVRoundTripsEveryColumn(t *testing.T) {
+	book := &Book{
+		Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 3, Tags: []string{"sf", "classic"},
+		Year: 1965, ISBN: "9780441172719",
+		PublishedAt: time.Date(1965, 8, 1, 0, 0, 0, 0, time.UTC),
+		CreatedAt:   time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC),
+	}
+	var buf bytes.Buffer
+	enc, err := newFormatEncoder(&buf, FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.encode(book); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.close(); err != nil {
+		t.Fatal(err)
+	}
+	next, err := newBookDecoder(&buf, FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, book) {
+		t.Errorf("read back %+v, want %+v", got, book)
+	}
+}
+
+func TestCSVImportReadsFilesWithoutTheNewerColumns(t *testing.T) {
+	next, err := newBookDecoder(strings.NewReader("id,name,author,version,tags,year\n1,Dune,Frank Herbert,2,sf,1965\n"), FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Version: 2, Tags: []string{"sf"}, Year: 1965}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %+v, want %+v", got, want)
+	}
+	if _, err := next(); !errors.Is(err, io.EOF) {
+		t.Errorf("after the last record: got %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// WithConnectionPool sends requests through an HTTP client that keeps up to
+// size idle connections to DynamoDB open. The SDK keeps only 10 per host, so
+// with more requests in flight, e.g. with many bulk workers, connections are
+// closed and opened again all the time. The client is chosen before other
+// client options, so a dry run still replaces it.
+func WithConnectionPool(size int) RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		pool := func(o *dynamodb.Options) {
+			o.HTTPClient = awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+				t.MaxIdleConns = size
+				t.MaxIdleConnsPerHost = size
+			})
+		}
+		d.clientOptions = append([]func(*dynamodb.Options){pool}, d.clientOptions...)
+	}
+}
+
+// WithMarshalPooling reuses attributevalue encoders and decoders between
+// calls instead of building one, and applying the encoder and decoder
+// options, for every book written or read.
+func WithMarshalPooling() RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.marshalPooling = true
+	}
+}
+
+// WithKeyTemplates builds the condition expressions and attribute names of
+// Create and Update once, from the key attributes, instead of on every
+// call. The templates are shared by concurrent calls and never modified.
+func WithKeyTemplates() RepositoryOption {
+	return func(d *DynamoDbBookRepository) {
+		d.keyTemplates = true
+	}
+}
+
+// codecPool holds the encoders and decoders of a bookCodec with
+// WithMarshalPooling.
+type codecPool struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+func newCodecPool(c *bookCodec) *codecPool {
+	encoderOptions, decoderOptions := c.encoderOptions, c.decoderOptions
+	return &codecPool{
+		encoders: sync.Pool{New: func() any { return attributevalue.NewEncoder(encoderOptions...) }},
+		decoders: sync.Pool{New: func() any { return attributevalue.NewDecoder(decoderOptions...) }},
+	}
+}
+
+// marshalMap is attributevalue.MarshalMapWithOptions with the encoder
+// options of c, using a pooled encoder if c has a pool. Unlike
+// MarshalMapWithOptions, it fails instead of returning an empty item if
+// book does not encode to a map, e.g. if it is nil.
+func (c *bookCodec) marshalMap(book *Book) (map[string]types.AttributeValue, error) {
+	var av types.AttributeValue
+	var err error
+	if c.pool == nil {
+		av, err = attributevalue.MarshalWithOptions(book, c.encoderOptions...)
+	} else {
+		enc := c.pool.encoders.Get().(*attributevalue.Encoder)
+		defer c.pool.encoders.Put(enc)
+		av, err = enc.Encode(book)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m, ok := av.(*types.AttributeValueMemberM)
+	if !ok {
+		return nil, fmt.Errorf("book encoded as %T, want a map", av)
+	}
+	return m.Value, nil
+}
+
+// unmarshalMap is attributevalue.UnmarshalMapWithOptions with the decoder
+// options of c, using a pooled decoder if c has a pool.
+func (c *bookCodec) unmarshalMap(item map[string]types.AttributeValue, book *Book) error {
+	if c.pool == nil {
+		return attributevalue.UnmarshalMapWithOptions(item, book, c.decoderOptions...)
+	}
+	dec := c.pool.decoders.Get().(*attributevalue.Decoder)
+	defer c.pool.decoders.Put(dec)
+	return dec.Decode(&types.AttributeValueMemberM{Value: item}, book)
+}
+
+// bookTemplates are the attribute names of the writes of a book repository
+// with WithKeyTemplates.
+type bookTemplates struct {
+	// create is used by writes that must not overwrite a book.
+	create map[string]string
+	// update and legacyUpdate are used by Update of books with and without
+	// a version.
+	update, legacyUpdate map[string]string
+}
+
+var defaultBookTemplates = bookTemplates{
+	create:       map[string]string{"#id": idAttribute},
+	update:       newUpdateNames(false),
+	legacyUpdate: newUpdateNames(true),
+}
+
+// createNames returns the attribute names of the condition of Create.
+func (d *DynamoDbBookRepository) createNames() map[string]string {
+	if d.keyTemplates {
+		return defaultBookTemplates.create
+	}
+	return map[string]string{"#id": idAttribute}
+}
+
+// updateNames returns the attribute names of the update expression and the
+// condition of Update, for a book without a version if legacy.
+func (d *DynamoDbBookRepository) updateNames(legacy bool) map[string]string {
+	if d.keyTemplates {
+		if legacy {
+			return defaultBookTemplates.legacyUpdate
+		}
+		return defaultBookTemplates.update
+	}
+	return newUpdateNames(legacy)
+}
+
+func newUpdateNames(legacy bool) map[string]string {
+	names := map[string]string{"#version": versionAttribute}
+	if legacy {
+		names["#id"] = idAttribute
+	}
+	for i, name := range bookAttributes {
+		names[bookAttributeRef(i)] = name
+	}
+	return names
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// uiPageLimit is the number of books on a page of the web UI.
+const uiPageLimit = 20
+
+//go:embed ui/*.html
+var uiFiles embed.FS
+
+// uiTemplates holds one template per page, each the layout around the
+// page's content.
+var uiTemplates = func() map[string]*template.Template {
+	funcs := template.FuncMap{"join": strings.Join}
+	pages := map[string]*template.Template{}
+	for _, page := range []string{"list", "form", "error"} {
+		pages[page] = template.Must(template.New(page).Funcs(funcs).ParseFS(uiFiles, "ui/layout.html", "ui/"+page+".html"))
+	}
+	return pages
+}()
+
+// UIHandler serves a web UI for browsing and editing books through the use
+// case:
+//
+//	GET  /ui                    list books, a page at a time (?cursor=C)
+//	GET  /ui/new                form for a new book
+//	POST /ui/new                create a book
+//	GET  /ui/books/{id}         form for editing a book
+//	POST /ui/books/{id}         save a book
+//	POST /ui/books/{id}/delete  delete a book
+//
+// Forms post back to the page that served them and redirect to the list on
+// success. Posts from other origins are rejected, so other sites cannot
+// submit the forms on behalf of a visitor. Writes are audited like those of
+// the REST API.
+type UIHandler struct {
+	uc *BookUseCase
+}
+
+func NewUIHandler(uc *BookUseCase) *UIHandler {
+	return &UIHandler{uc: uc}
+}
+
+// uiPage is the data of every template. Only the fields of the page being
+// rendered are set.
+type uiPage struct {
+	Title string
+	// list
+	Books  []*Book
+	Cursor string
+	Next   string
+	// form
+	Book    *Book
+	New     bool
+	Errors  FieldErrors
+	Message string
+}
+
+func (h *UIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if actor := r.Header.Get(actorHeader); actor != "" {
+		r = r.WithContext(WithActor(r.Context(), actor))
+	}
+	if r.Method == http.MethodPost && !sameOrigin(r) {
+		h.fail(w, http.StatusForbidden, "Cross-origin form submissions are not allowed.")
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/ui"), "/")
+	switch {
+	case path == "":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		h.list(w, r)
+		return
+	case path == "new":
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.render(w, http.StatusOK, "form", uiPage{Title: "New book", Book: &Book{}, New: true})
+		case http.MethodPost:
+			h.create(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPost)
+		}
+		return
+	}
+
+	rest, ok := strings.CutPrefix(path, "books/")
+	if !ok {
+		h.fail(w, http.StatusNotFound, "Page not found.")
+		return
+	}
+	rawID, action, _ := strings.Cut(rest, "/")
+	id, err := strconv.Atoi(rawID)
+	if err != nil || id <= 0 {
+		h.fail(w, http.StatusNotFound, "Page not found.")
+		return
+	}
+	switch {
+	case action == "delete" && r.Method == http.MethodPost:
+		h.delete(w, r, id)
+	case action == "delete":
+		methodNotAllowed(w, http.MethodPost)
+	case action != "":
+		h.fail(w, http.StatusNotFound, "Page not found.")
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		h.edit(w, r, id)
+	case r.Method == http.MethodPost:
+		h.save(w, r, id)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPost)
+	}
+}
+
+func (h *UIHandler) list(w http.ResponseWriter, r *http.Request) {
+	cursor := r.URL.Query().Get("cursor")
+	books, next, err := h.uc.ListPage(r.Context(), uiPageLimit, cursor)
+	if err != nil {
+		h.failWith(w, err)
+		return
+	}
+	h.render(w, http.StatusOK, "list", uiPage{Title: "Books", Books: books, Cursor: cursor, Next: next})
+}
+
+func (h *UIHandler) create(w http.ResponseWriter, r *http.Request) {
+	book := new(Book)
+	errs, err := parseBookForm(r, book, true)
+	if err != nil {
+		h.fail(w, http.StatusBadRequest, "Invalid form: "+err.Error()+".")
+		return
+	}
+	if len(errs) == 0 {
+		err := h.uc.createBook(r.Context(), book, "")
+		if err == nil {
+			http.Redirect(w, r, "/ui", http.StatusSeeOther)
+			return
+		}
+		if !errors.As(err, &errs) {
+			h.formError(w, "New book", book, true, err)
+			return
+		}
+	}
+	h.render(w, http.StatusBadRequest, "form", uiPage{Title: "New book", Book: book, New: true, Errors: errs})
+}
+
+func (h *UIHandler) edit(w http.ResponseWriter, r *http.Request, id int) {
+	book, err := h.uc.GetById(r.Context(), id, WithConsistentRead())
+	if err != nil {
+		h.failWith(w, err)
+		return
+	}
+	h.render(w, http.StatusOK, "form", uiPage{Title: "Edit book", Book: book})
+}
+
+// save applies the form to the stored book, so that attributes the form
+// does not show are kept, and updates it if it still has the version the
+// form was served with.
+func (h *UIHandler) save(w http.ResponseWriter, r *http.Request, id int) {
+	book, err := h.uc.GetById(r.Context(), id, WithConsistentRead())
+	if err != nil {
+		h.failWith(w, err)
+		return
+	}
+	errs, err := parseBookForm(r, book, false)
+	if err != nil {
+		h.fail(w, http.StatusBadRequest, "Invalid form: "+err.Error()+".")
+		return
+	}
+	if len(errs) == 0 {
+		err := h.uc.Update(r.Context(), book)
+		if err == nil {
+			http.Redirect(w, r, "/ui", http.StatusSeeOther)
+			return
+		}
+		if !errors.As(err, &errs) {
+			h.formError(w, "Edit book", book, false, err)
+			return
+		}
+	}
+	h.render(w, http.StatusBadRequest, "form", uiPage{Title: "Edit book", Book: book, Errors: errs})
+}
+
+func (h *UIHandler) delete(w http.ResponseWriter, r *http.Request, id int) {
+	if err := h.uc.Delete(r.Context(), id); err != nil {
+		h.failWith(w, err)
+		return
+	}
+	http.Redirect(w, r, "/ui", http.StatusSeeOther)
+}
+
+// parseBookForm sets the fields of book from the posted form, returning the
+// fields that could not be parsed. The id is only read for new books, which
+// may leave it empty to have one assigned, and the version only for existing
+// ones; an error means the form was not one the UI served.
+func parseBookForm(r *http.Request, book *Book, isNew bool) (FieldErrors, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, errors.New("unreadable form")
+	}
+	errs := FieldErrors{}
+	if id := strings.TrimSpace(r.PostForm.Get("id")); isNew && id != "" {
+		if n, err := strconv.Atoi(id); err != nil {
+			errs["id"] = "must be a positive integer"
+		} else {
+			book.Id = n
+		}
+	} else if !isNew {
+		n, err := strconv.Atoi(r.PostForm.Get("version"))
+		if err != nil {
+			return nil, errors.New("missing book version")
+		}
+		book.Version = n
+	}
+	book.Name = r.PostForm.Get("name")
+	book.Author = r.PostForm.Get("author")
+	book.ISBN = strings.TrimSpace(r.PostForm.Get("isbn"))
+	book.Year = 0
+	if raw := strings.TrimSpace(r.PostForm.Get("year")); raw != "" {
+		if n, err := strconv.Atoi(raw); err != nil {
+			errs["year"] = "must be a number"
+		} else {
+			book.Year = n
+		}
+	}
+	book.Tags = nil
+	for _, tag := range strings.Split(r.PostForm.Get("tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			book.Tags = append(book.Tags, tag)
+		}
+	}
+	if len(errs) > 0 {
+		return errs, nil
+	}
+	return nil, nil
+}
+
+// formError shows the form again with the message of a failed write.
+func (h *UIHandler) formError(w http.ResponseWriter, title string, book *Book, isNew bool, err error) {
+	status, msg := uiError(err)
+	if errors.Is(err, ErrVersionConflict) {
+		msg = "The book was changed by someone else since you opened it. Reload it to see the changes."
+	}
+	h.render(w, status, "form", uiPage{Title: title, Book: book, New: isNew, Message: msg})
+}
+
+func (h *UIHandler) failWith(w http.ResponseWriter, err error) {
+	status, msg := uiError(err)
+	h.fail(w, status, msg)
+}
+
+func (h *UIHandler) fail(w http.ResponseWriter, status int, msg string) {
+	h.render(w, status, "error", uiPage{Title: http.StatusText(status), Message: msg})
+}
+
+// uiError returns the status and message shown for err, mirroring
+// writeRepositoryError.
+func uiError(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound, "There is no such book."
+	case errors.Is(err, ErrBookAlreadyExists):
+		return http.StatusConflict, "A book with this id already exists."
+	case errors.Is(err, ErrISBNTaken):
+		return http.StatusConflict, "Another book already has this ISBN."
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict, "The book was changed at the same time. Try again."
+	case errors.Is(err, ErrThrottled):
+		return http.StatusServiceUnavailable, "The service is busy. Try again later."
+	case errors.Is(err, ErrValidation):
+		return http.StatusBadRequest, "The request is invalid."
+	case errors.Is(err, ErrUnauthenticated):
+		return http.StatusUnauthorized, "Sign in to do this."
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden, "You are not allowed to do this."
+	}
+	log.Printf("ui request failed: %v", err)
+	return http.StatusInternalServerError, "Something went wrong."
+}
+
+func (h *UIHandler) render(w http.ResponseWriter, status int, page string, data uiPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := uiTemplates[page].ExecuteTemplate(w, "layout", data); err != nil {
+		log.Printf("render %s: %v", page, err)
+	}
+}
+
+// sameOrigin reports whether a form post comes from a page of this host.
+// Browsers send Origin with every post; requests without it, from tools
+// such as curl, are let through.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
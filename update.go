@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// UpdatePartial changes only the given attributes of a book with UpdateItem,
+// leaving every other attribute, including ones written by other writers,
+// untouched. A nil value removes the attribute. Values of attributes Book
+// stores must have the type of their field, e.g. []string for tags, and are
+// encoded like the field; a value the field would not store, such as empty
+// tags, removes the attribute as well. The version is incremented
+// and the updated book is returned. It returns ErrNotFound if the book does
+// not exist and ErrValidation if changes tries to modify the key, version or
+// ISBN, whose claim only Update can move.
+func (d *DynamoDbBookRepository) UpdatePartial(ctx context.Context, id int, changes map[string]any) (*Book, error) {
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("%w: no changes given", ErrValidation)
+	}
+
+	// Sort so the same changes always produce the same expression.
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		if name == idAttribute || name == versionAttribute || name == isbnAttribute {
+			return nil, fmt.Errorf("%w: attribute %q cannot be updated", ErrValidation, name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	version := expression.Name(versionAttribute)
+	update := expression.Set(version, expression.Plus(version.IfNotExists(expression.Value(0)), expression.Value(1)))
+	for _, name := range names {
+		var av types.AttributeValue
+		if value := changes[name]; value != nil {
+			var err error
+			if av, err = d.codec.marshalAttribute(name, value); err != nil {
+				return nil, err
+			}
+		}
+		if av == nil {
+			update = update.Remove(expression.Name(name))
+		} else {
+			update = update.Set(expression.Name(name), expression.Value(av))
+		}
+	}
+	expr, err := expression.NewBuilder().
+		WithUpdate(update).
+		WithCondition(expression.AttributeExists(expression.Name(idAttribute))).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:                       d.key.MarshalKey(id),
+		TableName:                 aws.String(d.tableName),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err != nil {
+		err = translateError(err)
+		if isConflict(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	book := new(Book)
+	if err := d.codec.unmarshal(result.Attributes, book); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// bookFields maps the attributes Book fields are stored in to the index of
+// their field.
+var bookFields = func() map[string]int {
+	fields := map[string]int{}
+	typ := reflect.TypeOf(Book{})
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("dynamodbav"), ",")
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}()
+
+// bookAttributes are the attributes bookCodec writes, other than the key,
+// sorted. Update sets or removes every one of them.
+var bookAttributes = func() []string {
+	names := []string{listingAttribute, itemVersionAttribute}
+	for name := range bookFields {
+		if name != idAttribute {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}()
+
+// bookAttributeRef returns the placeholder of bookAttributes[i] in the
+// expressions of Update, e.g. #a3, or :a3 for its value.
+func bookAttributeRef(i int) string {
+	return "#a" + strconv.Itoa(i)
+}
+
+// bookUpdateExpression returns the update expression of Update writing the
+// item av, which sets the attributes in av and removes the others of
+// bookAttributes, and the values it refers to.
+func bookUpdateExpression(av map[string]types.AttributeValue) (string, map[string]types.AttributeValue) {
+	var set, remove []string
+	values := make(map[string]types.AttributeValue, len(av))
+	for i, name := range bookAttributes {
+		ref := bookAttributeRef(i)
+		value, ok := av[name]
+		if !ok {
+			remove = append(remove, ref)
+			continue
+		}
+		values[":"+ref[1:]] = value
+		set = append(set, ref+" = :"+ref[1:])
+	}
+	update := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		update += " REMOVE " + strings.Join(remove, ", ")
+	}
+	return update, values
+}
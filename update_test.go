@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// storedItem returns the item of book id as stored in the table of repo.
+func storedItem(t *testing.T, repo *DynamoDbBookRepository, id int) map[string]types.AttributeValue {
+	t.Helper()
+	out, err := repo.client.GetItem(context.Background(), &dynamodb.GetItemInput{TableName: aws.String(stubTable), Key: repo.key.MarshalKey(id)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Item
+}
+
+func TestUpdatePartialRemovesAttributes(t *testing.T) {
+	ctx := context.Background()
+	repo := newTableStub(t).repository()
+	if err := repo.Create(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", Year: 1965, Tags: []string{"sf"}}); err != nil {
+		t.Fatal(err)
+	}
+	book, err := repo.UpdatePartial(ctx, 1, map[string]any{"year": nil, "tags": []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Year != 0 || book.Tags != nil || book.Version != 2 {
+		t.Errorf("updated book %+v, want no year or tags at version 2", book)
+	}
+	item := storedItem(t, repo, 1)
+	for _, attr := range []string{yearAttribute, "tags"} {
+		if _, ok := item[attr]; ok {
+			t.Errorf("stored %s = %v, want it removed", attr, item[attr])
+		}
+	}
+	if _, ok := item[nameAttribute]; !ok {
+		t.Error("name was removed, want it untouched")
+	}
+}
+
+func TestUpdatePartialEncodesLikeTheBookFields(t *testing.T) {
+	ctx := context.Background()
+	repo := newTableStub(t).repository()
+	if err := repo.Create(ctx, &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2020, 5, 1, 12, 30, 0, 0, time.UTC)
+	if _, err := repo.UpdatePartial(ctx, 1, map[string]any{"tags": []string{"sf", "classic"}, createdAtAttribute: created}); err != nil {
+		t.Fatal(err)
+	}
+
+	item := storedItem(t, repo, 1)
+	if tags, ok := item["tags"].(*types.AttributeValueMemberSS); !ok || len(tags.Value) != 2 {
+		t.Errorf("stored tags %#v, want a string set", item["tags"])
+	}
+	if at, ok := item[createdAtAttribute].(*types.AttributeValueMemberN); !ok || at.Value != createdAtKey(created) {
+		t.Errorf("stored createdAt %#v, want Unix seconds %s", item[createdAtAttribute], createdAtKey(created))
+	}
+	// ADD only works on a string set.
+	if err := repo.AddTag(ctx, []int{1}, "desert"); err != nil {
+		t.Fatal(err)
+	}
+	book, err := repo.GetById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(book.Tags)
+	if !slices.Equal(book.Tags, []string{"classic", "desert", "sf"}) || !book.CreatedAt.Equal(created) {
+		t.Errorf("read back tags %v, created %s, want the partial update and the added tag", book.Tags, book.CreatedAt)
+	}
+
+	if _, err := repo.UpdatePartial(ctx, 1, map[string]any{"tags": "sf"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("tags given as a string: got %v, want ErrValidation", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits enforced by validateBook.
+const (
+	maxNameLength   = 256
+	maxAuthorLength = 256
+	maxTags         = 50
+	maxTagLength    = 64
+)
+
+// FieldErrors maps field names (as in the JSON representation) to what is
+// wrong with them. It matches ErrValidation.
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	msgs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		msgs = append(msgs, field+": "+e[field])
+	}
+	return fmt.Sprintf("%v: %s", ErrValidation, strings.Join(msgs, "; "))
+}
+
+// Is reports whether target is ErrValidation.
+func (e FieldErrors) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// validateBook checks the rules a book must satisfy before it is written. It
+// returns nil or a FieldErrors describing every violated rule.
+func validateBook(book *Book) error {
+	errs := FieldErrors{}
+	if book.Id <= 0 {
+		errs["id"] = "must be a positive integer"
+	}
+	switch {
+	case book.Name == "":
+		errs["name"] = "is required"
+	case utf8.RuneCountInString(book.Name) > maxNameLength:
+		errs["name"] = fmt.Sprintf("must be at most %d characters", maxNameLength)
+	}
+	// author is the key of the author index, which rejects empty strings.
+	switch {
+	case book.Author == "":
+		errs["author"] = "is required"
+	case utf8.RuneCountInString(book.Author) > maxAuthorLength:
+		errs["author"] = fmt.Sprintf("must be at most %d characters", maxAuthorLength)
+	}
+	if _, ok := canonicalISBN(book.ISBN); book.ISBN != "" && !ok {
+		errs["isbn"] = "must be a valid ISBN-10 or ISBN-13"
+	}
+	if book.Year < 0 {
+		errs["year"] = "must not be negative"
+	}
+	if msg := validateTags(book.Tags); msg != "" {
+		errs["tags"] = msg
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateAuthor checks an author before it is created. Fields are reported
+// under "author." as authors are written along with a book.
+func validateAuthor(author *Author) error {
+	errs := FieldErrors{}
+	if author.Id <= 0 {
+		errs["author.id"] = "must be a positive integer"
+	}
+	switch {
+	case author.Name == "":
+		errs["author.name"] = "is required"
+	case utf8.RuneCountInString(author.Name) > maxAuthorLength:
+		errs["author.name"] = fmt.Sprintf("must be at most %d characters", maxAuthorLength)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateTags checks that tags can be stored as a string set.
+func validateTags(tags []string) string {
+	if len(tags) > maxTags {
+		return fmt.Sprintf("must have at most %d entries", maxTags)
+	}
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		switch {
+		case strings.TrimSpace(tag) == "":
+			return "must not contain empty tags"
+		case utf8.RuneCountInString(tag) > maxTagLength:
+			return fmt.Sprintf("must be at most %d characters each", maxTagLength)
+		case seen[tag]:
+			return fmt.Sprintf("contains %q more than once", tag)
+		}
+		seen[tag] = true
+	}
+	return ""
+}
+
+// validateBooks validates every book, prefixing field names with the book's
+// position, e.g. "[3].name". Books with different ids must not share an
+// ISBN.
+func validateBooks(books []*Book) error {
+	errs := FieldErrors{}
+	isbns := map[string]int{}
+	for i, book := range books {
+		var fieldErrs FieldErrors
+		if errors.As(validateBook(book), &fieldErrs) {
+			for field, msg := range fieldErrs {
+				errs[fmt.Sprintf("[%d].%s", i, field)] = msg
+			}
+		}
+		if book.ISBN == "" {
+			continue
+		}
+		if j, ok := isbns[book.ISBN]; ok && books[j].Id != book.Id {
+			errs[fmt.Sprintf("[%d].isbn", i)] = fmt.Sprintf("is also the ISBN of [%d]", j)
+		} else {
+			isbns[book.ISBN] = i
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateBook(t *testing.T) {
+	valid := func(edit func(*Book)) *Book {
+		book := &Book{Id: 1, Name: "Dune", Author: "Frank Herbert", ISBN: "9780441172719", Year: 1965, Tags: []string{"scifi"}}
+		edit(book)
+		return book
+	}
+	manyTags := make([]string, maxTags+1)
+	for i := range manyTags {
+		manyTags[i] = fmt.Sprintf("tag%d", i)
+	}
+
+	for _, tc := range []struct {
+		name string
+		book *Book
+		want []string // the fields reported
+	}{
+		{"valid", valid(func(b *Book) {}), nil},
+		{"minimal", &Book{Id: 1, Name: "Dune", Author: "Frank Herbert"}, nil},
+		{"zero id", valid(func(b *Book) { b.Id = 0 }), []string{"id"}},
+		{"negative id", valid(func(b *Book) { b.Id = -3 }), []string{"id"}},
+		{"empty name", valid(func(b *Book) { b.Name = "" }), []string{"name"}},
+		{"longest name", valid(func(b *Book) { b.Name = strings.Repeat("é", maxNameLength) }), nil},
+		{"name too long", valid(func(b *Book) { b.Name = strings.Repeat("a", maxNameLength+1) }), []string{"name"}},
+		{"empty author", valid(func(b *Book) { b.Author = "" }), []string{"author"}},
+		{"author too long", valid(func(b *Book) { b.Author = strings.Repeat("a", maxAuthorLength+1) }), []string{"author"}},
+		{"ISBN-10", valid(func(b *Book) { b.ISBN = "0-306-40615-2" }), nil},
+		{"bad ISBN checksum", valid(func(b *Book) { b.ISBN = "9780441172710" }), []string{"isbn"}},
+		{"negative year", valid(func(b *Book) { b.Year = -1 }), []string{"year"}},
+		{"too many tags", valid(func(b *Book) { b.Tags = manyTags }), []string{"tags"}},
+		{"blank tag", valid(func(b *Book) { b.Tags = []string{"scifi", " "} }), []string{"tags"}},
+		{"tag too long", valid(func(b *Book) { b.Tags = []string{strings.Repeat("t", maxTagLength+1)} }), []string{"tags"}},
+		{"duplicate tag", valid(func(b *Book) { b.Tags = []string{"scifi", "scifi"} }), []string{"tags"}},
+		{"everything wrong", &Book{Year: -1, ISBN: "x"}, []string{"author", "id", "isbn", "name", "year"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateBook(tc.book)
+			if tc.want == nil {
+				if err != nil {
+					t.Fatalf("validateBook = %v, want no error", err)
+				}
+				return
+			}
+			var fieldErrs FieldErrors
+			if !errors.As(err, &fieldErrs) || !errors.Is(err, ErrValidation) {
+				t.Fatalf("validateBook = %v, want FieldErrors matching ErrValidation", err)
+			}
+			var got []string
+			for field := range fieldErrs {
+				got = append(got, field)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("fields %v, want %v: %v", got, tc.want, err)
+			}
+		})
+	}
+}
+
+func TestFieldErrorsListFieldsInOrder(t *testing.T) {
+	err := FieldErrors{"name": "is required", "id": "must be a positive integer"}
+	want := "validation failed: id: must be a positive integer; name: is required"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestValidateBooksPrefixesPositions(t *testing.T) {
+	err := validateBooks([]*Book{
+		{Id: 1, Name: "Dune", Author: "Frank Herbert", ISBN: "9780441172719"},
+		{Id: 2, Name: "", Author: "Frank Herbert"},
+		{Id: 3, Name: "Dune (copy)", Author: "Frank Herbert", ISBN: "9780441172719"},
+		{Id: 1, Name: "Dune", Author: "Frank Herbert", ISBN: "9780441172719"},
+	})
+	want := FieldErrors{"[1].name": "is required", "[2].isbn": "is also the ISBN of [0]"}
+	if !maps.Equal(err.(FieldErrors), want) {
+		t.Errorf("validateBooks = %v, want %v", err, want)
+	}
+}
+
+func TestCreateRejectsInvalidBooksBeforeWriting(t *testing.T) {
+	repo := NewMemoryBookRepository()
+	uc := NewBookUseCase(repo)
+	err := uc.createBook(context.Background(), &Book{Id: 1, Name: "   ", Author: "Frank Herbert"}, "")
+	var fieldErrs FieldErrors
+	if !errors.As(err, &fieldErrs) || fieldErrs["name"] != "is required" {
+		t.Fatalf("createBook = %v, want the blank name, normalized away, reported", err)
+	}
+	if books, _ := repo.List(context.Background()); len(books) != 0 {
+		t.Errorf("%d books stored, want none", len(books))
+	}
+}
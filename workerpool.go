@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultBulkWorkers is the number of concurrent requests bulk operations
+// issue unless configured otherwise.
+const defaultBulkWorkers = 4
+
+// ProgressFunc is told the number of items a bulk operation has processed
+// so far. Calls are serialized, so it needs no locking, but it should return
+// quickly as workers wait for it.
+type ProgressFunc func(done int)
+
+type progressKey struct{}
+
+// WithProgress returns a context making the bulk operations run with it,
+// such as BatchCreate, ImportBooks and parallel scans, report their progress
+// to fn.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// WorkerPool runs tasks on a bounded number of goroutines. The first task to
+// fail cancels the pool's context, tasks submitted afterwards are skipped,
+// and Wait returns that error.
+type WorkerPool struct {
+	g        *errgroup.Group
+	ctx      context.Context
+	progress ProgressFunc
+
+	mu   sync.Mutex
+	done int
+}
+
+// NewWorkerPool returns a pool of at most workers concurrent tasks (one if
+// workers is not positive) reporting to the ProgressFunc of ctx, if any.
+func NewWorkerPool(ctx context.Context, workers int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(workers)
+	// Tasks get no ProgressFunc, so that nested bulk operations do not
+	// report their share of the work as if it were the whole.
+	return &WorkerPool{g: g, ctx: WithProgress(ctx, nil), progress: progressFrom(ctx)}
+}
+
+// progressFrom returns the ProgressFunc of ctx, or nil.
+func progressFrom(ctx context.Context) ProgressFunc {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return fn
+}
+
+// Go runs task once a worker is free, blocking until then. The task returns
+// the number of items it processed, which is added to the progress.
+func (p *WorkerPool) Go(task func(ctx context.Context) (int, error)) {
+	p.g.Go(func() error {
+		if err := p.ctx.Err(); err != nil {
+			return err
+		}
+		n, err := task(p.ctx)
+		p.add(n)
+		return err
+	})
+}
+
+// add records n more processed items.
+func (p *WorkerPool) add(n int) {
+	if n <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	if p.progress != nil {
+		p.progress(p.done)
+	}
+}
+
+// Wait waits for every submitted task and returns the first error.
+func (p *WorkerPool) Wait() error {
+	return p.g.Wait()
+}
+
+// Done returns the number of items processed so far.
+func (p *WorkerPool) Done() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done
+}
+
+// ForEach runs task for every item on a pool of workers and waits for them.
+func ForEach[T any](ctx context.Context, workers int, items []T, task func(ctx context.Context, item T) (int, error)) error {
+	pool := NewWorkerPool(ctx, workers)
+	for _, item := range items {
+		item := item
+		pool.Go(func(ctx context.Context) (int, error) {
+			return task(ctx, item)
+		})
+	}
+	return pool.Wait()
+}
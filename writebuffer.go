@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BufferedWriter is a BookRepository decorator that collects the Create and
+// Update calls made within a short window and writes them with one
+// BatchCreate, so that ingestion costs a BatchWriteItem per 25 books
+// instead of a PutItem per book. Methods not overridden here pass through.
+//
+// Buffered calls return before the book is stored: they trade the usual
+// acknowledgment for throughput. BatchWriteItem takes no conditions, so a
+// buffered Create overwrites an existing book and a buffered Update does
+// not check the version; both set the version as the unbuffered call would.
+// Failed batches are logged and reported by the next Flush. GetById sees
+// books still in the buffer, other reads do not. Upsert, Delete and
+// BatchCreate wait for the buffer to be written first, so they apply after
+// the buffered writes.
+type BufferedWriter struct {
+	BookRepository
+	window   time.Duration
+	maxBatch int
+	logger   *slog.Logger
+	writes   atomic.Int64
+	batches  atomic.Int64
+
+	mu      sync.Mutex
+	pending *writeBatch
+	// last is the batch sent most recently. Batches are written one after
+	// the other, so that a later write of a book always wins.
+	last *writeBatch
+	// buffered holds the books not yet written, by id.
+	buffered map[int]*Book
+	errs     []error
+	closed   bool
+}
+
+// writeBatch is a BatchCreate being collected or run. done is closed once it
+// has been written or has failed.
+type writeBatch struct {
+	ctx   context.Context
+	books []*Book
+	// index is the position of each book id in books: BatchWriteItem
+	// rejects requests writing the same key twice.
+	index map[int]int
+	after *writeBatch
+	done  chan struct{}
+}
+
+// NewBufferedWriter returns a writer that waits up to window after the
+// first Create or Update of a batch for others to join it. A batch is sent
+// early once it holds the 25 books of a BatchWriteItem. Failed batches are
+// logged to logger.
+func NewBufferedWriter(next BookRepository, window time.Duration, logger *slog.Logger) *BufferedWriter {
+	return &BufferedWriter{
+		BookRepository: next,
+		window:         window,
+		maxBatch:       batchWriteLimit,
+		logger:         logger,
+		buffered:       map[int]*Book{},
+	}
+}
+
+// Stats returns the number of writes buffered and of batches sent so far.
+func (w *BufferedWriter) Stats() (writes, batches int64) {
+	return w.writes.Load(), w.batches.Load()
+}
+
+// Create implements BookRepository; see BufferedWriter for how it differs
+// from an unbuffered Create.
+func (w *BufferedWriter) Create(ctx context.Context, book *Book) error {
+	if !w.enqueue(ctx, book, 1) {
+		return w.BookRepository.Create(ctx, book)
+	}
+	return nil
+}
+
+// Update implements BookRepository; see BufferedWriter for how it differs
+// from an unbuffered Update.
+func (w *BufferedWriter) Update(ctx context.Context, book *Book) error {
+	if !w.enqueue(ctx, book, book.Version+1) {
+		return w.BookRepository.Update(ctx, book)
+	}
+	return nil
+}
+
+// Upsert implements BookRepository once the buffer has been written.
+func (w *BufferedWriter) Upsert(ctx context.Context, book *Book) error {
+	if err := w.drain(ctx); err != nil {
+		return err
+	}
+	return w.BookRepository.Upsert(ctx, book)
+}
+
+// Delete implements BookRepository once the buffer has been written.
+func (w *BufferedWriter) Delete(ctx context.Context, id int) error {
+	if err := w.drain(ctx); err != nil {
+		return err
+	}
+	return w.BookRepository.Delete(ctx, id)
+}
+
+// BatchCreate implements BookRepository once the buffer has been written.
+func (w *BufferedWriter) BatchCreate(ctx context.Context, books []*Book) error {
+	if err := w.drain(ctx); err != nil {
+		return err
+	}
+	return w.BookRepository.BatchCreate(ctx, books)
+}
+
+// GetById implements BookRepository, answering from the buffer for books
+// not written yet.
+func (w *BufferedWriter) GetById(ctx context.Context, id int) (*Book, error) {
+	w.mu.Lock()
+	book, ok := w.buffered[id]
+	w.mu.Unlock()
+	if ok {
+		return copyBook(book), nil
+	}
+	return w.BookRepository.GetById(ctx, id)
+}
+
+// Flush writes the buffer and waits until every batch sent so far has been
+// written or ctx is done. It returns the errors of the batches that failed
+// since the previous Flush.
+func (w *BufferedWriter) Flush(ctx context.Context) error {
+	if err := w.drain(ctx); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	errs := w.errs
+	w.errs = nil
+	w.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// Close flushes the buffer, like Flush, and makes later creates and updates
+// bypass it. Call it on shutdown so that no buffered write is lost.
+func (w *BufferedWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	return w.Flush(ctx)
+}
+
+// enqueue adds a copy of book at version to the pending batch, setting the
+// version of book, and reports whether it did; it does not once the writer
+// is closed.
+func (w *BufferedWriter) enqueue(ctx context.Context, book *Book, version int) bool {
+	w.mu.Lock()
+	if w.closed || w.window <= 0 {
+		w.mu.Unlock()
+		return false
+	}
+	book.Version = version
+	stored := copyBook(book)
+	b := w.pending
+	if b == nil {
+		b = &writeBatch{ctx: context.WithoutCancel(ctx), index: map[int]int{}, done: make(chan struct{})}
+		w.pending = b
+		time.AfterFunc(w.window, func() { w.send(b) })
+	}
+	if i, ok := b.index[book.Id]; ok {
+		b.books[i] = stored
+	} else {
+		b.index[book.Id] = len(b.books)
+		b.books = append(b.books, stored)
+	}
+	w.buffered[book.Id] = stored
+	full := len(b.books) >= w.maxBatch
+	w.mu.Unlock()
+	w.writes.Add(1)
+	if full {
+		w.send(b)
+	}
+	return true
+}
+
+// send starts writing b unless it has already been sent, which happens when
+// it fills up or is flushed before its window ends.
+func (w *BufferedWriter) send(b *writeBatch) {
+	w.mu.Lock()
+	if w.pending != b {
+		w.mu.Unlock()
+		return
+	}
+	w.pending = nil
+	b.after, w.last = w.last, b
+	w.mu.Unlock()
+
+	go func() {
+		if b.after != nil {
+			<-b.after.done
+		}
+		w.batches.Add(1)
+		err := w.BookRepository.BatchCreate(b.ctx, b.books)
+		w.mu.Lock()
+		for _, book := range b.books {
+			if w.buffered[book.Id] == book {
+				delete(w.buffered, book.Id)
+			}
+		}
+		if err != nil {
+			w.errs = append(w.errs, fmt.Errorf("write %d buffered books: %w", len(b.books), err))
+		}
+		w.mu.Unlock()
+		if err != nil {
+			w.logger.ErrorContext(b.ctx, "write buffered books", "books", len(b.books), "error", err)
+		}
+		close(b.done)
+	}()
+}
+
+// drain sends the pending batch and waits until the last batch sent, and so
+// every batch before it, has been written or ctx is done.
+func (w *BufferedWriter) drain(ctx context.Context) error {
+	w.mu.Lock()
+	pending := w.pending
+	w.mu.Unlock()
+	if pending != nil {
+		w.send(pending)
+	}
+	w.mu.Lock()
+	last := w.last
+	w.mu.Unlock()
+	if last == nil {
+		return nil
+	}
+	select {
+	case <-last.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}